@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"syscall"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh/terminal"
+
+	"github.com/wisherd/Pis/modules"
+	"github.com/wisherd/Pis/node/api"
+	"github.com/wisherd/Pis/types"
+)
+
+var (
+	// coldAddressCount is the number of seed addresses that are exported or
+	// searched while signing.
+	coldAddressCount uint64
+
+	// pstFee is the miner fee of a partially signed transaction, in
+	// hastings.
+	pstFee string
+
+	// pstInputs is a comma-separated list of the outputs that fund a
+	// partially signed transaction.
+	pstInputs string
+
+	// pstStrategy is the coin selection strategy that funds a partially
+	// signed transaction.
+	pstStrategy string
+)
+
+// addColdSigningCmds adds the commands of the cold signing workflow to the
+// wallet command. The commands marked as offline never contact the daemon:
+//
+//	offline: pisc wallet export-addresses addrs.json
+//	online:  pisc wallet watch addrs.json
+//	online:  pisc wallet pst create [destination] [amount] txn.pst
+//	offline: pisc wallet sign txn.pst
+//	online:  pisc wallet pst broadcast txn.pst
+func addColdSigningCmds(wallet *cobra.Command) {
+	exportCmd := &cobra.Command{
+		Use:   "export-addresses [file]",
+		Short: "Export the addresses of a seed (offline)",
+		Long: `Derive the addresses of a seed and write their unlock conditions to [file].
+The file contains no secrets. Import it on the online node with
+'pisc wallet watch'. This command does not contact the daemon.`,
+		Run: wrap(walletexportaddressescmd),
+	}
+	exportCmd.Flags().Uint64VarP(&coldAddressCount, "count", "", modules.PublicKeysPerSeed, "number of addresses to export")
+	wallet.AddCommand(exportCmd)
+
+	signCmd := &cobra.Command{
+		Use:   "sign [file]",
+		Short: "Sign a partially signed transaction with a seed (offline)",
+		Long: `Sign the partially signed transaction in [file] with the keys of a seed and
+write it back. Only the seed and the file are used; the command does not
+contact the daemon and needs no network or blockchain data.`,
+		Run: wrap(walletsigncmd),
+	}
+	signCmd.Flags().Uint64VarP(&coldAddressCount, "count", "", modules.PublicKeysPerSeed, "number of seed addresses to search for keys")
+	wallet.AddCommand(signCmd)
+
+	pst := &cobra.Command{
+		Use:   "pst",
+		Short: "Create, inspect and broadcast partially signed transactions",
+		Long:  "Create, inspect and broadcast partially signed transactions.",
+	}
+	createCmd := &cobra.Command{
+		Use:   "create [destination] [amount] [file]",
+		Short: "Create an unsigned transaction",
+		Long: `Create a transaction that sends [amount] hastings to [destination], funded by
+the wallet, and write it unsigned to [file].`,
+		Run: wrap(walletpstcreatecmd),
+	}
+	createCmd.Flags().StringVarP(&pstFee, "fee", "", "0", "miner fee in hastings")
+	createCmd.Flags().StringVarP(&pstInputs, "inputs", "", "", "comma-separated IDs of the outputs to spend")
+	createCmd.Flags().StringVarP(&pstStrategy, "strategy", "", "", "coin selection strategy: largest-first, smallest-first, privacy or minimal-change")
+	pst.AddCommand(createCmd)
+	pst.AddCommand(&cobra.Command{
+		Use:   "inspect [file]",
+		Short: "Show a partially signed transaction (offline)",
+		Long:  "Show the inputs, outputs, fees and signatures of the transaction in [file]. This command does not contact the daemon.",
+		Run:   wrap(walletpstinspectcmd),
+	})
+	pst.AddCommand(&cobra.Command{
+		Use:   "broadcast [file]",
+		Short: "Broadcast a fully signed transaction",
+		Long:  "Submit the fully signed transaction in [file] and its parents to the transaction pool.",
+		Run:   wrap(walletpstbroadcastcmd),
+	})
+	wallet.AddCommand(pst)
+}
+
+// readSeed prompts for a seed without echoing it.
+func readSeed() modules.Seed {
+	fmt.Print("Seed: ")
+	phrase, err := terminal.ReadPassword(int(syscall.Stdin))
+	fmt.Println()
+	if err != nil {
+		die("Could not read seed:", err)
+	}
+	seed, err := modules.StringToSeed(string(phrase), "english")
+	if err != nil {
+		die("Could not decode seed:", err)
+	}
+	return seed
+}
+
+// readPartialTransaction reads a partially signed transaction file.
+func readPartialTransaction(filename string) modules.PartiallySignedTransaction {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		die("Could not read transaction file:", err)
+	}
+	var pst modules.PartiallySignedTransaction
+	if err := pst.UnmarshalPis(bytes.NewReader(data)); err != nil {
+		die("Could not decode transaction file:", err)
+	}
+	if err := pst.Validate(); err != nil {
+		die("Invalid transaction file:", err)
+	}
+	return pst
+}
+
+// writePartialTransaction writes a partially signed transaction file.
+func writePartialTransaction(filename string, pst modules.PartiallySignedTransaction) {
+	var buf bytes.Buffer
+	if err := pst.MarshalPis(&buf); err != nil {
+		die("Could not encode transaction:", err)
+	}
+	if err := ioutil.WriteFile(filename, buf.Bytes(), 0600); err != nil {
+		die("Could not write transaction file:", err)
+	}
+}
+
+// printPartialTransaction prints the summary of a partially signed
+// transaction.
+func printPartialTransaction(pst modules.PartiallySignedTransaction) {
+	s := pst.Summary()
+	fmt.Println("Transaction:", s.TransactionID)
+	fmt.Printf("Inputs:      %v H", s.PiscoinInputs)
+	if !s.PisfundInputs.IsZero() {
+		fmt.Printf(", %v SF", s.PisfundInputs)
+	}
+	fmt.Println()
+	for _, o := range s.Outputs {
+		fmt.Printf("Output:      %v H to %v\n", o.Value, o.UnlockHash)
+	}
+	fmt.Printf("Miner fees:  %v H\n", s.MinerFees)
+	for _, status := range s.Status {
+		fmt.Printf("Input %v: %v of %v signatures\n", status.ParentID, status.Signed, status.Required)
+	}
+	if s.Complete {
+		fmt.Println("The transaction is fully signed and can be broadcast.")
+	}
+}
+
+// walletexportaddressescmd writes the unlock conditions of a seed to a file.
+func walletexportaddressescmd(filename string) {
+	seed := readSeed()
+	data, err := json.MarshalIndent(modules.SeedUnlockConditions(seed, coldAddressCount), "", "\t")
+	if err != nil {
+		die("Could not encode addresses:", err)
+	}
+	if err := ioutil.WriteFile(filename, data, 0600); err != nil {
+		die("Could not write addresses:", err)
+	}
+	fmt.Printf("Exported %v addresses to %v\n", coldAddressCount, filename)
+}
+
+// walletsigncmd signs a partially signed transaction file with a seed.
+func walletsigncmd(filename string) {
+	pst := readPartialTransaction(filename)
+	printPartialTransaction(pst)
+	seed := readSeed()
+	signed, err := modules.SignWithSeed(&pst, seed, coldAddressCount)
+	if err != nil {
+		die("Could not sign transaction:", err)
+	}
+	if signed == 0 {
+		fmt.Fprintln(os.Stderr, "The seed holds none of the missing keys.")
+		os.Exit(exitCodeGeneral)
+	}
+	writePartialTransaction(filename, pst)
+	fmt.Printf("Added %v signatures\n", signed)
+	printPartialTransaction(pst)
+}
+
+// walletpstcreatecmd creates an unsigned transaction file.
+func walletpstcreatecmd(destination, amount, filename string) {
+	var dest types.UnlockHash
+	if err := dest.LoadString(destination); err != nil {
+		die("Could not parse destination:", err)
+	}
+	var value types.Currency
+	if _, err := fmt.Sscan(amount, &value); err != nil {
+		die("Could not parse amount:", err)
+	}
+	outputs, _ := json.Marshal([]types.PiscoinOutput{{Value: value, UnlockHash: dest}})
+	vals := url.Values{}
+	vals.Set("outputs", string(outputs))
+	vals.Set("fee", pstFee)
+	vals.Set("strategy", pstStrategy)
+	if pstInputs != "" {
+		vals.Set("inputs", outputIDList(pstInputs))
+	}
+	var wpt api.WalletPartialTransaction
+	if err := postAPI("/wallet/pst/create", vals, &wpt); err != nil {
+		die("Could not create transaction:", err)
+	}
+	writePartialTransaction(filename, wpt.PartialTransaction)
+	printPartialTransaction(wpt.PartialTransaction)
+}
+
+// walletpstinspectcmd prints a partially signed transaction file.
+func walletpstinspectcmd(filename string) {
+	printPartialTransaction(readPartialTransaction(filename))
+}
+
+// walletpstbroadcastcmd broadcasts a fully signed transaction file.
+func walletpstbroadcastcmd(filename string) {
+	pst := readPartialTransaction(filename)
+	var buf bytes.Buffer
+	if err := pst.MarshalPis(&buf); err != nil {
+		die("Could not encode transaction:", err)
+	}
+	vals := url.Values{}
+	vals.Set("pst", base64.StdEncoding.EncodeToString(buf.Bytes()))
+	vals.Set("broadcast", "true")
+	if err := postAPI("/wallet/pst/finalize", vals, nil); err != nil {
+		die("Could not broadcast transaction:", err)
+	}
+	fmt.Println("Broadcast transaction", pst.Transaction.ID())
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/wisherd/Pis/node/api"
+)
+
+var (
+	// historyFormat is the format of a history export, "csv" or "json".
+	historyFormat string
+
+	// historyStart and historyEnd are the height range of a history export.
+	historyStart string
+	historyEnd   string
+)
+
+// addHistoryCmds adds the history export command to the wallet command.
+func addHistoryCmds(wallet *cobra.Command) {
+	exportCmd := &cobra.Command{
+		Use:   "export [file]",
+		Short: "Export the transaction history",
+		Long: `Write the confirmed transaction history of the wallet to [file] as CSV or
+JSON. Each entry has the timestamp, confirmation height, transaction ID, fund
+type, net change of the balance, fee, counterparty addresses and note. The
+format is taken from the file extension unless --format is given.`,
+		Run: wrap(walletexportcmd),
+	}
+	exportCmd.Flags().StringVarP(&historyFormat, "format", "", "", "export format: csv or json")
+	exportCmd.Flags().StringVarP(&historyStart, "start", "", "", "first block height to export")
+	exportCmd.Flags().StringVarP(&historyEnd, "end", "", "", "last block height to export (default: current height)")
+	wallet.AddCommand(exportCmd)
+}
+
+// walletexportcmd exports the transaction history of the wallet to a file.
+func walletexportcmd(filename string) {
+	format := historyFormat
+	if format == "" {
+		format = "json"
+		if strings.EqualFold(filepath.Ext(filename), ".csv") {
+			format = "csv"
+		}
+	}
+	vals := url.Values{}
+	vals.Set("format", format)
+	if historyStart != "" {
+		vals.Set("start", historyStart)
+	}
+	if historyEnd != "" {
+		vals.Set("end", historyEnd)
+	}
+	resp, err := api.HttpGETAuthenticated("http://"+addr+"/wallet/history?"+vals.Encode(), apiPassword)
+	if err != nil {
+		die("Could not export history: no response from daemon:", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		die("Could not export history:", readAPIResponse(resp, nil))
+	}
+	defer resp.Body.Close()
+
+	f, err := os.Create(filename)
+	if err != nil {
+		die("Could not create export file:", err)
+	}
+	if _, err := io.Copy(f, resp.Body); err != nil {
+		f.Close()
+		die("Could not write export file:", err)
+	}
+	if err := f.Close(); err != nil {
+		die("Could not write export file:", err)
+	}
+	fmt.Println("Exported history to", filename)
+}
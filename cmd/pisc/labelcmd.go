@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"github.com/wisherd/Pis/modules"
+)
+
+// labelSearch restricts the listed labels and notes to those that contain
+// it.
+var labelSearch string
+
+// addLabelCmds adds the commands that manage address labels and transaction
+// notes to the wallet command.
+func addLabelCmds(wallet *cobra.Command) {
+	wallet.AddCommand(&cobra.Command{
+		Use:   "label [address] [label]",
+		Short: "Label an address",
+		Long:  `Set the label of [address]. An empty label ("") removes it.`,
+		Run:   wrap(walletlabelcmd),
+	})
+	wallet.AddCommand(&cobra.Command{
+		Use:   "note [txid] [note]",
+		Short: "Attach a note to a transaction",
+		Long:  `Set the note of transaction [txid]. An empty note ("") removes it.`,
+		Run:   wrap(walletnotecmd),
+	})
+	labelsCmd := &cobra.Command{
+		Use:   "labels",
+		Short: "List address labels and transaction notes",
+		Long:  "List the address labels and transaction notes of the wallet.",
+		Run:   wrap(walletlabelscmd),
+	}
+	labelsCmd.Flags().StringVarP(&labelSearch, "search", "s", "", "only list labels and notes that contain this text")
+	wallet.AddCommand(labelsCmd)
+}
+
+// walletlabelcmd sets the label of an address.
+func walletlabelcmd(address, label string) {
+	vals := url.Values{}
+	vals.Set("address", address)
+	vals.Set("label", label)
+	if err := postAPI("/wallet/labels/address", vals, nil); err != nil {
+		die("Could not set label:", err)
+	}
+	fmt.Println("Label set")
+}
+
+// walletnotecmd sets the note of a transaction.
+func walletnotecmd(txid, note string) {
+	vals := url.Values{}
+	vals.Set("id", txid)
+	vals.Set("note", note)
+	if err := postAPI("/wallet/labels/transaction", vals, nil); err != nil {
+		die("Could not set note:", err)
+	}
+	fmt.Println("Note set")
+}
+
+// walletlabelscmd lists the labels and notes of the wallet.
+func walletlabelscmd() {
+	var labels modules.WalletLabels
+	if err := getAPI("/wallet/labels?search="+url.QueryEscape(labelSearch), &labels); err != nil {
+		die("Could not get labels:", err)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, al := range labels.Addresses {
+		fmt.Fprintf(w, "address\t%v\t%v\n", al.Address, al.Label)
+	}
+	for _, tn := range labels.Transactions {
+		fmt.Fprintf(w, "transaction\t%v\t%v\n", tn.TransactionID, tn.Note)
+	}
+	for _, on := range labels.Outputs {
+		fmt.Fprintf(w, "output\t%v\t%v\n", on.WalletTransactionID, on.Note)
+	}
+	w.Flush()
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+
+	"github.com/spf13/cobra"
+
+	"github.com/wisherd/Pis/build"
+	"github.com/wisherd/Pis/node/api"
+)
+
+var (
+	// addr is the address of the API of the daemon, set by the --addr flag.
+	addr string
+
+	// apiPassword is read from the SIA_API_PASSWORD environment variable.
+	apiPassword = os.Getenv("SIA_API_PASSWORD")
+)
+
+// exit codes
+// inspired by sysexits.h
+const (
+	exitCodeGeneral = 1  // Not in sysexits.h, but is standard practice.
+	exitCodeUsage   = 64 // EX_USAGE in sysexits.h
+)
+
+// die prints its arguments to stderr, then exits the program with the default
+// error code.
+func die(args ...interface{}) {
+	fmt.Fprintln(os.Stderr, args...)
+	os.Exit(exitCodeGeneral)
+}
+
+// readAPIResponse decodes the response of an API call into obj, or returns
+// the error reported by the daemon. obj may be nil if the call returns no
+// content.
+func readAPIResponse(resp *http.Response, obj interface{}) error {
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var apiErr api.Error
+		body, _ := ioutil.ReadAll(resp.Body)
+		if json.Unmarshal(body, &apiErr) != nil || apiErr.Message == "" {
+			return fmt.Errorf("API call failed with status %v", resp.Status)
+		}
+		return apiErr
+	}
+	if obj == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(obj)
+}
+
+// getAPI makes a GET call to the daemon and decodes the response into obj.
+func getAPI(call string, obj interface{}) error {
+	resp, err := api.HttpGETAuthenticated("http://"+addr+call, apiPassword)
+	if err != nil {
+		return fmt.Errorf("no response from daemon: %v", err)
+	}
+	return readAPIResponse(resp, obj)
+}
+
+// postAPI makes a POST call to the daemon and decodes the response into obj.
+func postAPI(call string, vals url.Values, obj interface{}) error {
+	resp, err := api.HttpPOSTAuthenticated("http://"+addr+call, vals.Encode(), apiPassword)
+	if err != nil {
+		return fmt.Errorf("no response from daemon: %v", err)
+	}
+	return readAPIResponse(resp, obj)
+}
+
+// wrap wraps a generic command with a check that the command has been
+// passed the correct number of arguments. The command must take only strings
+// as arguments.
+func wrap(fn interface{}) func(*cobra.Command, []string) {
+	fnVal, fnType := reflect.ValueOf(fn), reflect.TypeOf(fn)
+	if fnType.Kind() != reflect.Func {
+		panic("wrapped function has wrong type signature")
+	}
+	for i := 0; i < fnType.NumIn(); i++ {
+		if fnType.In(i).Kind() != reflect.String {
+			panic("wrapped function has wrong type signature")
+		}
+	}
+
+	return func(cmd *cobra.Command, args []string) {
+		if len(args) != fnType.NumIn() {
+			cmd.UsageFunc()(cmd)
+			os.Exit(exitCodeUsage)
+		}
+		argVals := make([]reflect.Value, fnType.NumIn())
+		for i := range args {
+			argVals[i] = reflect.ValueOf(args[i])
+		}
+		fnVal.Call(argVals)
+	}
+}
+
+// versionCmd is a cobra command that prints the version of pisc.
+func versionCmd(*cobra.Command, []string) {
+	fmt.Println("Pis Client v" + build.Version)
+}
+
+func main() {
+	root := &cobra.Command{
+		Use:   os.Args[0],
+		Short: "Pis Client v" + build.Version,
+		Long:  "Pis Client v" + build.Version,
+	}
+	root.PersistentFlags().StringVarP(&addr, "addr", "a", "localhost:9980", "which host:port the API server of pisd listens on")
+
+	root.AddCommand(&cobra.Command{
+		Use:   "version",
+		Short: "Print version information",
+		Long:  "Print version information about the Pis Client",
+		Run:   versionCmd,
+	})
+	root.AddCommand(walletCmd())
+
+	if err := root.Execute(); err != nil {
+		os.Exit(exitCodeUsage)
+	}
+}
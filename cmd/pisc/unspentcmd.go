@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"github.com/wisherd/Pis/node/api"
+	"github.com/wisherd/Pis/types"
+)
+
+// addCoinControlCmds adds the commands that list and lock unspent outputs to
+// the wallet command.
+func addCoinControlCmds(wallet *cobra.Command) {
+	wallet.AddCommand(&cobra.Command{
+		Use:   "unspent",
+		Short: "List unspent outputs",
+		Long:  "List the unspent outputs of the wallet with their maturity height, confirmations and lock status.",
+		Run:   wrap(walletunspentcmd),
+	})
+	wallet.AddCommand(&cobra.Command{
+		Use:   "lock [ids]",
+		Short: "Lock unspent outputs",
+		Long: `Prevent the comma-separated outputs [ids] from being used to fund
+transactions until they are unlocked or the daemon restarts.`,
+		Run: wrap(walletlockcmd),
+	})
+	wallet.AddCommand(&cobra.Command{
+		Use:   "unlock [ids]",
+		Short: "Unlock unspent outputs",
+		Long:  "Release the locks of the comma-separated outputs [ids].",
+		Run:   wrap(walletunlockcmd),
+	})
+}
+
+// outputIDList converts a comma-separated list of output IDs into the JSON
+// array expected by the API.
+func outputIDList(s string) string {
+	var ids []types.OutputID
+	for _, idStr := range strings.Split(s, ",") {
+		var id types.OutputID
+		if err := json.Unmarshal([]byte(`"`+strings.TrimSpace(idStr)+`"`), &id); err != nil {
+			die("Could not parse output ID:", err)
+		}
+		ids = append(ids, id)
+	}
+	js, _ := json.Marshal(ids)
+	return string(js)
+}
+
+// walletunspentcmd lists the unspent outputs of the wallet.
+func walletunspentcmd() {
+	var wu api.WalletUnspentGET
+	if err := getAPI("/wallet/unspent", &wu); err != nil {
+		die("Could not get unspent outputs:", err)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tType\tValue\tMaturity\tConfirmations\tStatus")
+	for _, uo := range wu.Outputs {
+		status := "spendable"
+		if uo.Locked {
+			status = "locked"
+		} else if uo.WatchOnly {
+			status = "watch-only"
+		}
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\n", uo.ID, uo.FundType, uo.Value, uo.MaturityHeight, uo.Confirmations, status)
+	}
+	w.Flush()
+}
+
+// walletlockcmd locks unspent outputs.
+func walletlockcmd(ids string) {
+	vals := url.Values{}
+	vals.Set("ids", outputIDList(ids))
+	if err := postAPI("/wallet/unspent/lock", vals, nil); err != nil {
+		die("Could not lock outputs:", err)
+	}
+	fmt.Println("Locked outputs")
+}
+
+// walletunlockcmd unlocks unspent outputs.
+func walletunlockcmd(ids string) {
+	vals := url.Values{}
+	vals.Set("ids", outputIDList(ids))
+	if err := postAPI("/wallet/unspent/unlock", vals, nil); err != nil {
+		die("Could not unlock outputs:", err)
+	}
+	fmt.Println("Unlocked outputs")
+}
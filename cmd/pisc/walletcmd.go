@@ -0,0 +1,235 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+
+	"github.com/spf13/cobra"
+
+	"github.com/wisherd/Pis/node/api"
+	"github.com/wisherd/Pis/types"
+)
+
+var (
+	// multisigFee is the miner fee of a multisig transaction, in hastings.
+	multisigFee string
+)
+
+// walletCmd returns the wallet command and its subcommands.
+func walletCmd() *cobra.Command {
+	wallet := &cobra.Command{
+		Use:   "wallet",
+		Short: "Perform wallet actions",
+		Long:  "Create multisig addresses, sign transactions offline, and build and send transactions.",
+	}
+
+	multisig := &cobra.Command{
+		Use:   "multisig",
+		Short: "List the multisig addresses of the wallet",
+		Long:  "List the M-of-N addresses tracked by the wallet, or manage them with the subcommands.",
+		Run:   wrap(walletmultisigcmd),
+	}
+	multisig.AddCommand(&cobra.Command{
+		Use:   "create [required] [publickey1,publickey2,...]",
+		Short: "Create a multisig address",
+		Long: `Create an address that needs [required] signatures from the provided public
+keys, and start tracking its outputs. Public keys have the form ed25519:<hex>.
+Every co-signer gets the same address, regardless of the order of the keys.`,
+		Run: wrap(walletmultisigcreatecmd),
+	})
+	multisig.AddCommand(&cobra.Command{
+		Use:   "outputs [address]",
+		Short: "List the unspent outputs of a multisig address",
+		Long:  "List the unspent outputs of a multisig address tracked by the wallet.",
+		Run:   wrap(walletmultisigoutputscmd),
+	})
+	build := &cobra.Command{
+		Use:   "build [address] [destination] [amount] [file]",
+		Short: "Build an unsigned multisig transaction",
+		Long: `Build a transaction that sends [amount] hastings from the multisig [address] to
+[destination] and write it to [file]. The change goes back to [address]. The
+file is passed to the co-signers, who sign it with 'pisc wallet multisig sign'.`,
+		Run: wrap(walletmultisigbuildcmd),
+	}
+	build.Flags().StringVarP(&multisigFee, "fee", "", "0", "miner fee in hastings")
+	multisig.AddCommand(build)
+	multisig.AddCommand(&cobra.Command{
+		Use:   "sign [file]",
+		Short: "Sign a multisig transaction",
+		Long:  "Add the signatures of the wallet to the transaction in [file] and write it back.",
+		Run:   wrap(walletmultisigsigncmd),
+	})
+	multisig.AddCommand(&cobra.Command{
+		Use:   "combine [file] [signed1] [signed2] ...",
+		Short: "Combine the signatures of several co-signers",
+		Long:  "Merge the signatures of copies of the same transaction that were signed in parallel and write the result to [file].",
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(args) < 2 {
+				cmd.Usage()
+				return
+			}
+			walletmultisigcombinecmd(args[0], args[1:])
+		},
+	})
+	multisig.AddCommand(&cobra.Command{
+		Use:   "broadcast [file]",
+		Short: "Broadcast a fully signed multisig transaction",
+		Long:  "Submit the transaction in [file] and its parents to the transaction pool.",
+		Run:   wrap(walletmultisigbroadcastcmd),
+	})
+	wallet.AddCommand(multisig)
+	addColdSigningCmds(wallet)
+	addWatchCmds(wallet)
+	addCoinControlCmds(wallet)
+	addLabelCmds(wallet)
+	addHistoryCmds(wallet)
+	return wallet
+}
+
+// readMultisigTransaction reads a transaction file written by pisc.
+func readMultisigTransaction(filename string) api.WalletMultisigTransaction {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		die("Could not read transaction file:", err)
+	}
+	var wmt api.WalletMultisigTransaction
+	if err := json.Unmarshal(data, &wmt); err != nil {
+		die("Could not decode transaction file:", err)
+	}
+	return wmt
+}
+
+// writeMultisigTransaction writes a transaction file and prints how many
+// signatures are still missing.
+func writeMultisigTransaction(filename string, wmt api.WalletMultisigTransaction) {
+	data, err := json.MarshalIndent(wmt, "", "\t")
+	if err != nil {
+		die("Could not encode transaction:", err)
+	}
+	if err := ioutil.WriteFile(filename, data, 0600); err != nil {
+		die("Could not write transaction file:", err)
+	}
+	for _, s := range wmt.Status {
+		fmt.Printf("Input %v: %v of %v signatures\n", s.ParentID, s.Signed, s.Required)
+	}
+	if wmt.Complete {
+		fmt.Println("The transaction is fully signed and can be broadcast.")
+	}
+}
+
+// walletmultisigcmd lists the multisig addresses of the wallet.
+func walletmultisigcmd() {
+	var wmg api.WalletMultisigGET
+	if err := getAPI("/wallet/multisig", &wmg); err != nil {
+		die("Could not get multisig addresses:", err)
+	}
+	if len(wmg.Addresses) == 0 {
+		fmt.Println("No multisig addresses.")
+		return
+	}
+	for _, addr := range wmg.Addresses {
+		fmt.Printf("%v  %v-of-%v\n", addr.Address, addr.UnlockConditions.SignaturesRequired, len(addr.UnlockConditions.PublicKeys))
+	}
+}
+
+// walletmultisigcreatecmd creates a multisig address.
+func walletmultisigcreatecmd(required, publicKeys string) {
+	vals := url.Values{}
+	vals.Set("required", required)
+	vals.Set("publickeys", publicKeys)
+	var addr api.WalletMultisigAddress
+	if err := postAPI("/wallet/multisig", vals, &addr); err != nil {
+		die("Could not create multisig address:", err)
+	}
+	fmt.Println("Created multisig address", addr.Address)
+}
+
+// walletmultisigoutputscmd lists the unspent outputs of a multisig address.
+func walletmultisigoutputscmd(address string) {
+	var wmog api.WalletMultisigOutputsGET
+	if err := getAPI("/wallet/multisig/outputs/"+address, &wmog); err != nil {
+		die("Could not get outputs:", err)
+	}
+	total := types.ZeroCurrency
+	for _, o := range wmog.Outputs {
+		fmt.Printf("%v  %v H\n", o.ID, o.Value)
+		total = total.Add(o.Value)
+	}
+	fmt.Printf("%v outputs, %v H in total\n", len(wmog.Outputs), total)
+}
+
+// walletmultisigbuildcmd builds an unsigned multisig transaction.
+func walletmultisigbuildcmd(address, destination, amount, filename string) {
+	var dest types.UnlockHash
+	if err := dest.LoadString(destination); err != nil {
+		die("Could not parse destination:", err)
+	}
+	var value types.Currency
+	if _, err := fmt.Sscan(amount, &value); err != nil {
+		die("Could not parse amount:", err)
+	}
+	outputs, _ := json.Marshal([]types.PiscoinOutput{{Value: value, UnlockHash: dest}})
+	vals := url.Values{}
+	vals.Set("address", address)
+	vals.Set("outputs", string(outputs))
+	vals.Set("fee", multisigFee)
+	var wmt api.WalletMultisigTransaction
+	if err := postAPI("/wallet/multisig/build", vals, &wmt); err != nil {
+		die("Could not build transaction:", err)
+	}
+	writeMultisigTransaction(filename, wmt)
+}
+
+// walletmultisigsigncmd adds the signatures of the wallet to a transaction
+// file.
+func walletmultisigsigncmd(filename string) {
+	wmt := readMultisigTransaction(filename)
+	txn, _ := json.Marshal(wmt.Transaction)
+	vals := url.Values{}
+	vals.Set("transaction", string(txn))
+	var signed api.WalletMultisigTransaction
+	if err := postAPI("/wallet/multisig/sign", vals, &signed); err != nil {
+		die("Could not sign transaction:", err)
+	}
+	signed.Parents = wmt.Parents
+	writeMultisigTransaction(filename, signed)
+}
+
+// walletmultisigcombinecmd merges the signatures of several transaction
+// files.
+func walletmultisigcombinecmd(filename string, signedFiles []string) {
+	var txns []types.Transaction
+	var parents []types.Transaction
+	for _, f := range signedFiles {
+		wmt := readMultisigTransaction(f)
+		txns = append(txns, wmt.Transaction)
+		if parents == nil {
+			parents = wmt.Parents
+		}
+	}
+	data, _ := json.Marshal(txns)
+	vals := url.Values{}
+	vals.Set("transactions", string(data))
+	var combined api.WalletMultisigTransaction
+	if err := postAPI("/wallet/multisig/combine", vals, &combined); err != nil {
+		die("Could not combine signatures:", err)
+	}
+	combined.Parents = parents
+	writeMultisigTransaction(filename, combined)
+}
+
+// walletmultisigbroadcastcmd broadcasts a fully signed transaction file.
+func walletmultisigbroadcastcmd(filename string) {
+	wmt := readMultisigTransaction(filename)
+	txn, _ := json.Marshal(wmt.Transaction)
+	parents, _ := json.Marshal(wmt.Parents)
+	vals := url.Values{}
+	vals.Set("transaction", string(txn))
+	vals.Set("parents", string(parents))
+	if err := postAPI("/wallet/multisig/broadcast", vals, nil); err != nil {
+		die("Could not broadcast transaction:", err)
+	}
+	fmt.Println("Broadcast transaction", wmt.Transaction.ID())
+}
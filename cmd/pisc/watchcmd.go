@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+
+	"github.com/spf13/cobra"
+
+	"github.com/wisherd/Pis/node/api"
+	"github.com/wisherd/Pis/types"
+)
+
+// addWatchCmds adds the commands that manage watch-only addresses to the
+// wallet command.
+func addWatchCmds(wallet *cobra.Command) {
+	wallet.AddCommand(&cobra.Command{
+		Use:   "watch [file]",
+		Short: "Track the addresses of an offline seed",
+		Long:  "Add the addresses written by 'pisc wallet export-addresses' to the wallet as watch-only addresses.",
+		Run:   wrap(walletwatchcmd),
+	})
+	wallet.AddCommand(&cobra.Command{
+		Use:   "watch-address [address]",
+		Short: "Track an address without its keys",
+		Long: `Add [address] to the wallet as a watch-only address. Its transactions and
+balance are tracked, but its outputs cannot be spent or used to fund
+transactions.`,
+		Run: wrap(walletwatchaddresscmd),
+	})
+	wallet.AddCommand(&cobra.Command{
+		Use:   "watched",
+		Short: "List the watch-only addresses",
+		Long:  "List the watch-only addresses of the wallet and their balance, which is not part of the spendable balance.",
+		Run:   wrap(walletwatchedcmd),
+	})
+}
+
+// walletwatchcmd adds the addresses in a file to the wallet.
+func walletwatchcmd(filename string) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		die("Could not read addresses:", err)
+	}
+	var ucs []types.UnlockConditions
+	if err := json.Unmarshal(data, &ucs); err != nil {
+		die("Could not decode addresses:", err)
+	}
+	vals := url.Values{}
+	vals.Set("unlockconditions", string(data))
+	if err := postAPI("/wallet/watch", vals, nil); err != nil {
+		die("Could not add addresses:", err)
+	}
+	fmt.Printf("Watching %v addresses\n", len(ucs))
+}
+
+// walletwatchaddresscmd adds a bare address to the wallet.
+func walletwatchaddresscmd(address string) {
+	var uh types.UnlockHash
+	if err := uh.LoadString(address); err != nil {
+		die("Could not parse address:", err)
+	}
+	addrs, _ := json.Marshal([]types.UnlockHash{uh})
+	vals := url.Values{}
+	vals.Set("addresses", string(addrs))
+	if err := postAPI("/wallet/watch", vals, nil); err != nil {
+		die("Could not add address:", err)
+	}
+	fmt.Println("Watching", uh)
+}
+
+// walletwatchedcmd lists the watch-only addresses and their balance.
+func walletwatchedcmd() {
+	var wg api.WalletWatchGET
+	if err := getAPI("/wallet/watch", &wg); err != nil {
+		die("Could not get watch-only addresses:", err)
+	}
+	for _, addr := range wg.Addresses {
+		if addr.UnlockConditions.UnlockHash() == addr.UnlockHash {
+			fmt.Println(addr.UnlockHash)
+		} else {
+			fmt.Println(addr.UnlockHash, "(address only)")
+		}
+	}
+	fmt.Printf(`Watch-only balance (not spendable):
+  Piscoins: %v H
+  Pisfunds: %v SF
+  Claim:    %v H
+`, wg.ConfirmedPiscoinBalance, wg.PisfundBalance, wg.PiscoinClaimBalance)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/spf13/cobra"
+
+	"github.com/wisherd/Pis/modules"
+	"github.com/wisherd/Pis/types"
+)
+
+var (
+	// consensusCheckRepair is set by the --repair flag of the consensus
+	// check command.
+	consensusCheckRepair bool
+)
+
+// loadConsensusSet opens the consensus set in the configured Pis directory
+// without connecting to the network.
+func loadConsensusSet() (modules.ConsensusSet, error) {
+	var cs modules.ConsensusSet
+	/*cs, err := consensus.New(nil, false, filepath.Join(globalConfig.Pisd.SiaDir, modules.ConsensusDir))
+	if err != nil {
+		return nil, err
+	}*/
+	if cs == nil {
+		return nil, errors.New("consensus set could not be loaded")
+	}
+	return cs, nil
+}
+
+// consensusCheckCmd is a cobra command that rebuilds the consensus state from
+// the stored blocks and compares it with the stored state. With --repair, the
+// stored state is replaced if a mismatch is found. The daemon must not be
+// running while the check is performed.
+func consensusCheckCmd(*cobra.Command, []string) {
+	cs, err := loadConsensusSet()
+	if err != nil {
+		die("Could not open consensus set:", err)
+	}
+	defer cs.Close()
+
+	fmt.Println("Checking consensus database, this may take a while...")
+	report, err := modules.CheckConsensusConsistency(cs, true, consensusCheckRepair)
+	if err != nil {
+		die("Consistency check failed:", err)
+	}
+	if report.Consistent {
+		fmt.Printf("Consensus database is consistent at height %v.\n", report.Height)
+		return
+	}
+	fmt.Printf("Found %v inconsistencies at height %v:\n", len(report.Mismatches), report.Height)
+	for _, m := range report.Mismatches {
+		fmt.Println("\t" + m)
+	}
+	if report.Repaired {
+		fmt.Println("The stored consensus state has been rebuilt from the stored blocks.")
+		return
+	}
+	fmt.Println("Run 'pisd consensus check --repair' to rebuild the stored consensus state.")
+	die()
+}
+
+// consensusSnapshotCmd is a cobra command that exports the state at a height
+// to a file and prints its Merkle root. The daemon must not be running while
+// the snapshot is taken.
+func consensusSnapshotCmd(cmd *cobra.Command, args []string) {
+	if len(args) != 2 {
+		cmd.Usage()
+		os.Exit(exitCodeUsage)
+	}
+	height, err := strconv.ParseUint(args[0], 10, 64)
+	if err != nil {
+		die("Could not parse height:", err)
+	}
+	cs, err := loadConsensusSet()
+	if err != nil {
+		die("Could not open consensus set:", err)
+	}
+	defer cs.Close()
+
+	fmt.Println("Building snapshot, this may take a while...")
+	ss, err := modules.SnapshotAtHeight(cs, types.BlockHeight(height))
+	if err != nil {
+		die("Could not build snapshot:", err)
+	}
+	f, err := os.Create(args[1])
+	if err != nil {
+		die("Could not create snapshot file:", err)
+	}
+	if err := ss.MarshalPis(f); err != nil {
+		f.Close()
+		die("Could not write snapshot:", err)
+	}
+	if err := f.Close(); err != nil {
+		die("Could not write snapshot:", err)
+	}
+
+	fmt.Printf("Snapshot of block %v at height %v written to %v.\n", ss.BlockID, ss.Height, args[1])
+	fmt.Printf("Merkle root: %v\n", ss.MerkleRoot())
+	fmt.Printf("%v piscoin outputs, %v pisfund outputs, %v file contracts, %v delayed piscoin outputs\n",
+		len(ss.PiscoinOutputs), len(ss.PisfundOutputs), len(ss.FileContracts), len(ss.DelayedPiscoinOutputs))
+	if mismatches := ss.CheckInvariants(); len(mismatches) > 0 {
+		fmt.Printf("Found %v violated invariants:\n", len(mismatches))
+		for _, m := range mismatches {
+			fmt.Println("\t" + m)
+		}
+		die()
+	}
+	fmt.Println("Supply checks passed.")
+}
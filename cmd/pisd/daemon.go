@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"syscall"
@@ -15,6 +16,7 @@ import (
 	"github.com/wisherd/Pis/modules"
 	"github.com/wisherd/Pis/crypto"
 	"github.com/wisherd/Pis/build"
+	"github.com/wisherd/Pis/types"
 	mnemonics2 "gitlab.com/NebulousLabs/entropy-mnemonics"
 	"gitlab.com/NebulousLabs/Sia/profile"
 )
@@ -66,7 +68,7 @@ func processNetAddr(addr string) string {
 // invalid module character.
 func processModules(modules string) (string, error) {
 	modules = strings.ToLower(modules)
-	validModules := "cghmrtwe"
+	validModules := "cghmrtwel"
 	invalidModules := modules
 	for _, m := range validModules {
 		invalidModules = strings.Replace(invalidModules, string(m), "", 1)
@@ -74,6 +76,11 @@ func processModules(modules string) (string, error) {
 	if len(invalidModules) > 0 {
 		return "", errors.New("Unable to parse --modules flag, unrecognized or duplicate modules: " + invalidModules)
 	}
+	// The light consensus set does not store full blocks, which the
+	// consensus set, the explorer and the miner depend on.
+	if strings.Contains(modules, "l") && strings.ContainsAny(modules, "cem") {
+		return "", errors.New("Unable to parse --modules flag, the light consensus set (l) cannot be used with the consensus set (c), the explorer (e) or the miner (m)")
+	}
 	return modules, nil
 }
 
@@ -92,6 +99,22 @@ func processProfileFlags(profile string) (string, error) {
 	return profile, nil
 }
 
+// parseAssumeValid parses the --assume-valid flag. An empty string selects
+// the default assume-valid block and "none" disables the optimization.
+func parseAssumeValid(assumeValid string) (types.BlockID, error) {
+	switch assumeValid {
+	case "":
+		return modules.AssumeValidBlock, nil
+	case "none":
+		return types.BlockID{}, nil
+	}
+	var id types.BlockID
+	if err := id.LoadString(assumeValid); err != nil {
+		return types.BlockID{}, errors.New("Unable to parse --assume-valid flag: " + err.Error())
+	}
+	return id, nil
+}
+
 // processConfig checks the configuration values and performs cleanup on
 // incorrect-but-allowed values.
 func processConfig(config Config) (Config, error) {
@@ -102,7 +125,13 @@ func processConfig(config Config) (Config, error) {
 	config.Pisd.Modules, err1 = processModules(config.Pisd.Modules)
 	config.Pisd.Profile, err2 = processProfileFlags(config.Pisd.Profile)
 	err3 := verifyAPISecurity(config)
-	err := build.JoinErrors([]error{err1, err2, err3}, ", and ")
+	_, err4 := parseAssumeValid(config.Pisd.AssumeValid)
+	err5 := modules.CheckPruneDepth(types.BlockHeight(config.Pisd.PruneDepth))
+	var err6 error
+	if config.Pisd.Regtest && config.Pisd.Network != "" {
+		err6 = errors.New("cannot use --regtest together with --network")
+	}
+	err := build.JoinErrors([]error{err1, err2, err3, err4, err5, err6}, ", and ")
 	if err != nil {
 		return Config{}, err
 	}
@@ -121,13 +150,20 @@ func unlockWallet(w modules.Wallet, password string) error {
 		}
 		validKeys = append(validKeys, crypto.TwofishKey(crypto.HashObject(seed)))
 	}
-	validKeys = append(validKeys, crypto.TwofishKey(crypto.HashObject(password)))
 	for _, key := range validKeys {
 		if err := w.Unlock(key); err == nil {
 			return nil
 		}
 	}
-	return modules.ErrBadEncryptionKey
+
+	// Passwords are stretched with Argon2id. Wallets that still use the
+	// legacy key are migrated once they are unlocked.
+	err := modules.UnlockWithPassword(w, password)
+	if unlocked, _ := w.Unlocked(); err != nil && unlocked {
+		fmt.Println("Warning:", err)
+		return nil
+	}
+	return err
 }
 
 // startDaemon uses the config parameters to initialize Pis modules and start
@@ -173,6 +209,24 @@ func startDaemon(config Config) (err error) {
 		os.Exit(1)
 	}()
 
+	// Switch to the regtest network or to a custom network before any module
+	// is created. Their data is kept apart from the data of the main network.
+	if config.Pisd.Network != "" {
+		np, err := modules.LoadNetworkParams(config.Pisd.Network)
+		if err != nil {
+			return err
+		}
+		if err := modules.ActivateNetwork(np); err != nil {
+			return err
+		}
+		config.Pisd.SiaDir = filepath.Join(config.Pisd.SiaDir, modules.NetworksDir, types.GenesisID.String())
+		fmt.Printf("Using network %q, genesis block %v\n", np.Name, types.GenesisID)
+	} else if config.Pisd.Regtest {
+		modules.ActivateRegtest()
+		config.Pisd.SiaDir = filepath.Join(config.Pisd.SiaDir, modules.RegtestDir)
+		fmt.Println("Using the regtest network, genesis block", types.GenesisID)
+	}
+
 	// Print a startup message.
 	fmt.Println("Loading...")
 	loadStart := time.Now()
@@ -37,11 +37,16 @@ type Config struct {
 		HostAddr     string
 		AllowAPIBind bool
 
+		AssumeValid       string
 		Modules           string
+		Network           string
 		NoBootstrap       bool
+		Regtest           bool
 		RequiredUserAgent string
 		AuthenticateAPI   bool
 
+		PruneDepth uint64
+
 		Profile    string
 		ProfileDir string
 		SiaDir     string
@@ -93,6 +98,15 @@ Consensus Set (c):
 	The consensus set requires the gateway.
 	Example:
 		siad -M gc
+Light Consensus Set (l):
+	The light consensus set replaces the consensus set on machines that
+	cannot store the full blockchain. It downloads only the block headers
+	and the transactions of the wallet's addresses, along with proofs that
+	they are part of the blockchain. It cannot be used with the consensus
+	set, the explorer or the miner.
+	The light consensus set requires the gateway.
+	Example:
+		siad -M gltw
 Transaction Pool (t):
 	The transaction pool manages unconfirmed transactions.
 	The transaction pool requires the consensus set.
@@ -144,6 +158,32 @@ func main() {
 		Run:   modulesCmd,
 	})
 
+	consensusCmd := &cobra.Command{
+		Use:   "consensus",
+		Short: "Perform actions on the consensus database",
+		Long:  "Perform actions on the consensus database while the daemon is not running.",
+	}
+	checkCmd := &cobra.Command{
+		Use:   "check",
+		Short: "Check the consistency of the consensus database",
+		Long:  "Rebuild the consensus state from the stored blocks and compare it with the stored state.",
+		Run:   consensusCheckCmd,
+	}
+	checkCmd.Flags().BoolVarP(&consensusCheckRepair, "repair", "", false, "rebuild the stored state if an inconsistency is found")
+	checkCmd.Flags().StringVarP(&globalConfig.Pisd.SiaDir, "Pis-directory", "d", "", "location of the Pis directory")
+	consensusCmd.AddCommand(checkCmd)
+	snapshotCmd := &cobra.Command{
+		Use:   "snapshot [height] [file]",
+		Short: "Export the state at a height",
+		Long: `Export the unspent outputs and open file contracts at the provided height to a
+file in the Pis encoding, and print the Merkle root of the state along with the
+result of the supply checks. Two nodes with the same state print the same root.`,
+		Run: consensusSnapshotCmd,
+	}
+	snapshotCmd.Flags().StringVarP(&globalConfig.Pisd.SiaDir, "Pis-directory", "d", "", "location of the Pis directory")
+	consensusCmd.AddCommand(snapshotCmd)
+	root.AddCommand(consensusCmd)
+
 	// Set default values, which have the lowest priority.
 	root.Flags().StringVarP(&globalConfig.Pisd.RequiredUserAgent, "agent", "", "Pis-Agent", "required substring for the user agent")
 	root.Flags().StringVarP(&globalConfig.Pisd.HostAddr, "host-addr", "", ":9982", "which port the host listens on")
@@ -151,10 +191,14 @@ func main() {
 	root.Flags().StringVarP(&globalConfig.Pisd.APIaddr, "api-addr", "", "localhost:9980", "which host:port the API server listens on")
 	root.Flags().StringVarP(&globalConfig.Pisd.SiaDir, "Pis-directory", "d", "", "location of the Pis directory")
 	root.Flags().BoolVarP(&globalConfig.Pisd.NoBootstrap, "no-bootstrap", "", false, "disable bootstrapping on this run")
+	root.Flags().StringVarP(&globalConfig.Pisd.Network, "network", "", "", "load the consensus and network parameters of a custom network from this file")
+	root.Flags().BoolVarP(&globalConfig.Pisd.Regtest, "regtest", "", false, "run a private regression test network with its own genesis block")
 	root.Flags().StringVarP(&globalConfig.Pisd.Profile, "profile", "", "", "enable profiling with flags 'cmt' for CPU, memory, trace")
 	root.Flags().StringVarP(&globalConfig.Pisd.RPCaddr, "rpc-addr", "", ":9981", "which port the gateway listens on")
 	root.Flags().StringVarP(&globalConfig.Pisd.Modules, "modules", "M", "cghrtw", "enabled modules, see 'siad modules' for more info")
 	root.Flags().BoolVarP(&globalConfig.Pisd.AuthenticateAPI, "authenticate-api", "", false, "enable API password protection")
+	root.Flags().StringVarP(&globalConfig.Pisd.AssumeValid, "assume-valid", "", "", "skip signature checks for ancestors of this block ID, or 'none' to verify all signatures")
+	root.Flags().Uint64VarP(&globalConfig.Pisd.PruneDepth, "prune", "", 0, "only keep the bodies of this many recent blocks, 0 keeps every block")
 	root.Flags().BoolVarP(&globalConfig.Pisd.AllowAPIBind, "disable-api-security", "", false, "allow siad to listen on a non-localhost address (DANGEROUS)")
 
 	// Parse cmdline flags, overwriting both the default values and the config
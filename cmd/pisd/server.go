@@ -23,6 +23,7 @@ import (
 
 	"github.com/wisherd/Pis/build"
 	"github.com/wisherd/Pis/modules"
+	"github.com/wisherd/Pis/modules/lightconsensus"
 	"github.com/wisherd/Pis/node/api"
 	"github.com/wisherd/Pis/types"
 
@@ -493,12 +494,32 @@ func (srv *Server) loadModules() error {
 	if strings.Contains(srv.config.Pisd.Modules, "c") {
 		i++
 		fmt.Printf("(%d/%d) Loading consensus...\n", i, len(srv.config.Pisd.Modules))
+		assumeValid, err := parseAssumeValid(srv.config.Pisd.AssumeValid)
+		if err != nil {
+			return err
+		}
+		modules.AssumeValidBlock = assumeValid
+		modules.PruneDepth = types.BlockHeight(srv.config.Pisd.PruneDepth)
 		/*cs, err = consensus.New(g, !srv.config.Pisd.NoBootstrap, filepath.Join(srv.config.Pisd.PisDir, modules.ConsensusDir))
 		if err != nil {
 			return err
 		}*/
 		srv.moduleClosers = append(srv.moduleClosers, moduleCloser{name: "consensus", Closer: cs})
 	}
+	var lcs *lightconsensus.ConsensusSet
+	if strings.Contains(srv.config.Pisd.Modules, "l") {
+		i++
+		fmt.Printf("(%d/%d) Loading light consensus...\n", i, len(srv.config.Pisd.Modules))
+		// Peers should not ask a light node for headers or blocks.
+		modules.LocalFeatures = modules.LightFeatures
+		var err error
+		lcs, err = lightconsensus.New(g, filepath.Join(srv.config.Pisd.SiaDir, modules.LightConsensusDir))
+		if err != nil {
+			return err
+		}
+		cs = lcs
+		srv.moduleClosers = append(srv.moduleClosers, moduleCloser{name: "light consensus", Closer: lcs})
+	}
 	var e modules.Explorer
 	if strings.Contains(srv.config.Pisd.Modules, "e") {
 		i++
@@ -528,6 +549,9 @@ func (srv *Server) loadModules() error {
 			return err
 		}*/
 		srv.moduleClosers = append(srv.moduleClosers, moduleCloser{name: "wallet", Closer: w})
+		if lcs != nil && w != nil {
+			lcs.SetAddressSource(w.AllAddresses)
+		}
 	}
 	var m modules.Miner
 	if strings.Contains(srv.config.Pisd.Modules, "m") {
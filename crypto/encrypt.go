@@ -2,6 +2,13 @@ package crypto
 
 // encrypt.go contains functions for encrypting and decrypting data byte slices
 // and readers.
+//
+// Byte slices are encrypted into a versioned envelope: a version byte, an
+// algorithm byte and a nonce, followed by the sealed data. Ciphertexts
+// written before the envelope was introduced have no header and consist of a
+// Twofish-GCM nonce followed by the sealed data. They can still be decrypted,
+// and since EncryptBytes always writes the current format, they are upgraded
+// whenever their owner saves them again.
 
 import (
 	"crypto/cipher"
@@ -9,15 +16,35 @@ import (
 	"errors"
 	"io"
 
+	"golang.org/x/crypto/chacha20poly1305"
 	"golang.org/x/crypto/twofish"
 	"gitlab.com/NebulousLabs/fastrand"
 )
 
 const (
-	// TwofishOverhead is the number of bytes added by EncryptBytes
+	// CiphertextVersion is the version of the ciphertext envelope written by
+	// EncryptBytes.
+	CiphertextVersion = 1
+
+	// CiphertextOverhead is the number of bytes added by EncryptBytes: the
+	// version and algorithm bytes, the nonce and the authentication tag.
+	CiphertextOverhead = 2 + chacha20poly1305.NonceSizeX + chacha20poly1305.Overhead
+
+	// TwofishOverhead is the number of bytes added by the legacy Twofish-GCM
+	// encryption.
 	TwofishOverhead = 28
 )
 
+const (
+	// CipherTwofishGCM is Twofish in GCM mode with a 12 byte nonce. It is
+	// only used to decrypt legacy ciphertexts.
+	CipherTwofishGCM CipherAlgorithm = iota
+
+	// CipherXChaCha20Poly1305 is XChaCha20-Poly1305 with a 24 byte nonce,
+	// the default algorithm.
+	CipherXChaCha20Poly1305
+)
+
 var (
 	// ErrInsufficientLen is an error when supplied ciphertext is not
 	// long enough to contain a nonce.
@@ -25,9 +52,14 @@ var (
 )
 
 type (
+	// CipherAlgorithm identifies the algorithm of a ciphertext envelope.
+	CipherAlgorithm byte
+
 	// Ciphertext is an encrypted []byte.
 	Ciphertext []byte
-	// TwofishKey is a key used for encrypting and decrypting data.
+
+	// TwofishKey is a key used for encrypting and decrypting data. Despite
+	// its name it is used with every CipherAlgorithm.
 	TwofishKey [EntropySize]byte
 )
 
@@ -45,47 +77,86 @@ func (key TwofishKey) NewCipher() cipher.Block {
 	return cipher
 }
 
-// EncryptBytes encrypts a []byte using the key. EncryptBytes uses GCM and
-// prepends the nonce (12 bytes) to the ciphertext.
+// aead returns the AEAD of the given algorithm, keyed with key.
+func (key TwofishKey) aead(alg CipherAlgorithm) (cipher.AEAD, bool) {
+	switch alg {
+	case CipherTwofishGCM:
+		// NOTE: NewGCM only returns an error if twofishCipher.BlockSize != 16.
+		aead, _ := cipher.NewGCM(key.NewCipher())
+		return aead, true
+	case CipherXChaCha20Poly1305:
+		// NOTE: NewX only returns an error if len(key) != 32.
+		aead, _ := chacha20poly1305.NewX(key[:])
+		return aead, true
+	}
+	return nil, false
+}
+
+// EncryptBytes encrypts a []byte using the key. EncryptBytes uses
+// XChaCha20-Poly1305 and returns the ciphertext in the versioned envelope.
 func (key TwofishKey) EncryptBytes(plaintext []byte) Ciphertext {
-	// Create the cipher.
-	// NOTE: NewGCM only returns an error if twofishCipher.BlockSize != 16.
-	aead, _ := cipher.NewGCM(key.NewCipher())
+	aead, _ := key.aead(CipherXChaCha20Poly1305)
 
-	// Create the nonce.
+	// Create the header and the nonce.
+	header := make([]byte, 2, CiphertextOverhead+len(plaintext))
+	header[0] = CiphertextVersion
+	header[1] = byte(CipherXChaCha20Poly1305)
 	nonce := fastrand.Bytes(aead.NonceSize())
 
 	// Encrypt the data. No authenticated data is provided, as EncryptBytes is
 	// meant for file encryption.
-	return aead.Seal(nonce, nonce, plaintext, nil)
+	return aead.Seal(append(header, nonce...), nonce, plaintext, nil)
 }
 
-// DecryptBytes decrypts the ciphertext created by EncryptBytes. The nonce is
-// expected to be the first 12 bytes of the ciphertext.
-func (key TwofishKey) DecryptBytes(ct Ciphertext) ([]byte, error) {
-	// Create the cipher.
-	// NOTE: NewGCM only returns an error if twofishCipher.BlockSize != 16.
-	aead, _ := cipher.NewGCM(key.NewCipher())
-
-	// Check for a nonce.
-	if len(ct) < aead.NonceSize() {
-		return nil, ErrInsufficientLen
+// envelope returns the AEAD, nonce and sealed data of a ciphertext in the
+// versioned envelope. The bool is false if ct does not start with a known
+// envelope header.
+func (key TwofishKey) envelope(ct Ciphertext) (cipher.AEAD, []byte, []byte, bool) {
+	if len(ct) < 2 || ct[0] != CiphertextVersion {
+		return nil, nil, nil, false
+	}
+	aead, ok := key.aead(CipherAlgorithm(ct[1]))
+	if !ok || len(ct) < 2+aead.NonceSize() {
+		return nil, nil, nil, false
 	}
+	return aead, ct[2 : 2+aead.NonceSize()], ct[2+aead.NonceSize():], true
+}
 
-	// Decrypt the data.
-	nonce := ct[:aead.NonceSize()]
-	ciphertext := ct[aead.NonceSize():]
-	return aead.Open(nil, nonce, ciphertext, nil)
+// DecryptBytes decrypts the ciphertext created by EncryptBytes. Legacy
+// Twofish-GCM ciphertexts, which start with their 12 byte nonce, are also
+// accepted.
+func (key TwofishKey) DecryptBytes(ct Ciphertext) ([]byte, error) {
+	// A legacy ciphertext can start with bytes that look like an envelope
+	// header, so it is tried as a legacy ciphertext if opening the envelope
+	// fails.
+	if aead, nonce, sealed, ok := key.envelope(ct); ok {
+		if plaintext, err := aead.Open(nil, nonce, sealed, nil); err == nil {
+			return plaintext, nil
+		}
+	}
+	return key.decryptLegacy(ct, nil)
 }
 
-// DecryptBytesInPlace decrypts the ciphertext created by EncryptBytes. The
-// nonce is expected to be the first 12 bytes of the ciphertext.
-// DecryptBytesInPlace reuses the memory of ct to be able to operate in-place.
-// This means that ct can't be reused after calling DecryptBytesInPlace.
+// DecryptBytesInPlace decrypts the ciphertext created by EncryptBytes like
+// DecryptBytes. DecryptBytesInPlace reuses the memory of ct to be able to
+// operate in-place. This means that ct can't be reused after calling
+// DecryptBytesInPlace.
 func (key TwofishKey) DecryptBytesInPlace(ct Ciphertext) ([]byte, error) {
-	// Create the cipher.
-	// NOTE: NewGCM only returns an error if twofishCipher.BlockSize != 16.
-	aead, _ := cipher.NewGCM(key.NewCipher())
+	// A failed Open clears its output, which would destroy a legacy
+	// ciphertext that looks like an envelope. The envelope is therefore
+	// opened into new memory and the plaintext copied back into ct.
+	if aead, nonce, sealed, ok := key.envelope(ct); ok {
+		if plaintext, err := aead.Open(nil, nonce, sealed, nil); err == nil {
+			return ct[:copy(ct, plaintext)], nil
+		}
+	}
+	return key.decryptLegacy(ct, ct)
+}
+
+// decryptLegacy decrypts a legacy Twofish-GCM ciphertext. If dst is not nil,
+// the plaintext overwrites it.
+func (key TwofishKey) decryptLegacy(ct Ciphertext, dst []byte) ([]byte, error) {
+	aead, _ := key.aead(CipherTwofishGCM)
 
 	// Check for a nonce.
 	if len(ct) < aead.NonceSize() {
@@ -95,7 +166,23 @@ func (key TwofishKey) DecryptBytesInPlace(ct Ciphertext) ([]byte, error) {
 	// Decrypt the data.
 	nonce := ct[:aead.NonceSize()]
 	ciphertext := ct[aead.NonceSize():]
-	return aead.Open(ciphertext[:0], nonce, ciphertext, nil)
+	if dst != nil {
+		dst = ciphertext[:0]
+	}
+	return aead.Open(dst, nonce, ciphertext, nil)
+}
+
+// Legacy reports whether c does not start with a known envelope header and
+// therefore was encrypted before the versioned envelope was introduced.
+// Owners of legacy ciphertexts should encrypt them again with EncryptBytes on
+// their next save. In rare cases the nonce of a legacy ciphertext looks like a
+// header, and such ciphertexts are not reported.
+func (c Ciphertext) Legacy() bool {
+	if len(c) < 2 || c[0] != CiphertextVersion {
+		return true
+	}
+	_, ok := TwofishKey{}.aead(CipherAlgorithm(c[1]))
+	return !ok
 }
 
 // NewWriter returns a writer that encrypts or decrypts its input stream.
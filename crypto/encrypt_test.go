@@ -0,0 +1,59 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"testing"
+
+	"gitlab.com/NebulousLabs/fastrand"
+)
+
+// legacyEncrypt encrypts plaintext the way EncryptBytes did before the
+// versioned envelope was introduced.
+func legacyEncrypt(key TwofishKey, nonce, plaintext []byte) Ciphertext {
+	aead, _ := cipher.NewGCM(key.NewCipher())
+	return aead.Seal(append([]byte(nil), nonce...), nonce, plaintext, nil)
+}
+
+// TestEncryptBytes checks that ciphertexts use the versioned envelope and
+// that legacy ciphertexts can still be decrypted.
+func TestEncryptBytes(t *testing.T) {
+	key := GenerateTwofishKey()
+	plaintext := fastrand.Bytes(100)
+
+	ct := key.EncryptBytes(plaintext)
+	if len(ct) != len(plaintext)+CiphertextOverhead || ct[0] != CiphertextVersion || CipherAlgorithm(ct[1]) != CipherXChaCha20Poly1305 || ct.Legacy() {
+		t.Fatal("ciphertext is not in the versioned envelope")
+	}
+	if pt, err := key.DecryptBytes(ct); err != nil || !bytes.Equal(pt, plaintext) {
+		t.Fatal("decryption failed:", err)
+	}
+	if _, err := GenerateTwofishKey().DecryptBytes(ct); err == nil {
+		t.Fatal("decrypted with the wrong key")
+	}
+	if pt, err := key.DecryptBytesInPlace(ct); err != nil || !bytes.Equal(pt, plaintext) {
+		t.Fatal("in-place decryption failed:", err)
+	}
+
+	// Legacy ciphertexts, including those whose nonce looks like an
+	// envelope header.
+	for _, nonce := range [][]byte{fastrand.Bytes(12), append([]byte{CiphertextVersion, byte(CipherXChaCha20Poly1305)}, fastrand.Bytes(10)...)} {
+		legacy := legacyEncrypt(key, nonce, plaintext)
+		if len(legacy) != len(plaintext)+TwofishOverhead {
+			t.Fatal("wrong legacy overhead")
+		}
+		if pt, err := key.DecryptBytes(legacy); err != nil || !bytes.Equal(pt, plaintext) {
+			t.Fatal("legacy decryption failed:", err)
+		}
+		if pt, err := key.DecryptBytesInPlace(legacy); err != nil || !bytes.Equal(pt, plaintext) {
+			t.Fatal("legacy in-place decryption failed:", err)
+		}
+	}
+	if !legacyEncrypt(key, []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11}, plaintext).Legacy() {
+		t.Fatal("legacy ciphertext was not detected")
+	}
+
+	if _, err := key.DecryptBytes(Ciphertext{1}); err != ErrInsufficientLen {
+		t.Fatal("expected ErrInsufficientLen, got", err)
+	}
+}
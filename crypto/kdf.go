@@ -0,0 +1,90 @@
+package crypto
+
+// kdf.go derives encryption keys from passwords with Argon2id, a memory-hard
+// key derivation function that makes brute-forcing weak passwords expensive.
+
+import (
+	"errors"
+
+	"gitlab.com/NebulousLabs/fastrand"
+	"golang.org/x/crypto/argon2"
+)
+
+const (
+	// KDFSaltSize is the size of the salt of a password-based key, in bytes.
+	KDFSaltSize = 16
+
+	// DefaultKDFTime is the default number of Argon2id passes.
+	DefaultKDFTime = 3
+
+	// DefaultKDFMemory is the default amount of memory used by Argon2id, in
+	// KiB.
+	DefaultKDFMemory = 64 * 1024
+
+	// DefaultKDFThreads is the default Argon2id parallelism.
+	DefaultKDFThreads = 4
+
+	// MaxKDFTime is the largest number of Argon2id passes that is accepted.
+	MaxKDFTime = 64
+
+	// MaxKDFMemory is the largest amount of memory, in KiB, that Argon2id is
+	// allowed to use. Parameters are read from disk, so without a limit a
+	// corrupted file could make the node allocate all of its memory.
+	MaxKDFMemory = 4 * 1024 * 1024
+
+	// MaxKDFThreads is the largest Argon2id parallelism that is accepted.
+	MaxKDFThreads = 64
+)
+
+var (
+	// ErrInvalidKDFParams is returned when key derivation parameters are
+	// unusable.
+	ErrInvalidKDFParams = errors.New("invalid key derivation parameters")
+)
+
+// KDFParams are the parameters with which a key is derived from a password.
+// They are not secret and are stored next to the data that the key
+// encrypts.
+type KDFParams struct {
+	Salt    [KDFSaltSize]byte `json:"salt"`
+	Time    uint32            `json:"time"`
+	Memory  uint32            `json:"memory"`
+	Threads uint8             `json:"threads"`
+}
+
+// NewKDFParams returns the default parameters with a fresh random salt.
+func NewKDFParams() KDFParams {
+	p := KDFParams{
+		Time:    DefaultKDFTime,
+		Memory:  DefaultKDFMemory,
+		Threads: DefaultKDFThreads,
+	}
+	fastrand.Read(p.Salt[:])
+	return p
+}
+
+// Validate checks that the parameters can be used to derive a key within
+// the MaxKDFTime, MaxKDFMemory and MaxKDFThreads limits.
+func (p KDFParams) Validate() error {
+	if p.Time == 0 || p.Threads == 0 || p.Memory < 8*uint32(p.Threads) {
+		return ErrInvalidKDFParams
+	}
+	if p.Time > MaxKDFTime || p.Memory > MaxKDFMemory || p.Threads > MaxKDFThreads {
+		return ErrInvalidKDFParams
+	}
+	return nil
+}
+
+// Weaker reports whether p is cheaper to brute-force than the default
+// parameters, in which case the key should be re-derived with
+// NewKDFParams.
+func (p KDFParams) Weaker() bool {
+	return p.Time < DefaultKDFTime || p.Memory < DefaultKDFMemory
+}
+
+// DeriveKey derives a key from password with Argon2id. The parameters must
+// have been checked with Validate.
+func (p KDFParams) DeriveKey(password string) (key TwofishKey) {
+	copy(key[:], argon2.IDKey([]byte(password), p.Salt[:], p.Time, p.Memory, p.Threads, uint32(len(key))))
+	return
+}
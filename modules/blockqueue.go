@@ -0,0 +1,312 @@
+package modules
+
+import (
+	"container/heap"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/wisherd/Pis/build"
+	"github.com/wisherd/Pis/types"
+)
+
+var (
+	// ErrExtremeFutureTimestamp is returned when a block's timestamp is more
+	// than ExtremeFutureThreshold ahead of the current time. Such blocks are
+	// discarded.
+	ErrExtremeFutureTimestamp = errors.New("block timestamp too far in future, discarded")
+
+	// ErrFutureTimestamp is returned when a block's timestamp is more than
+	// FutureThreshold but less than ExtremeFutureThreshold ahead of the
+	// current time. Such blocks are held and rechecked later.
+	ErrFutureTimestamp = errors.New("block timestamp too far in future, but saved for later use")
+
+	// ErrOrphan is returned when a block's parent is not known. Orphans are
+	// held until their parent arrives.
+	ErrOrphan = errors.New("block has no known parent")
+
+	// FutureBlockRecheckInterval is how often the blocks held by a
+	// FutureBlockQueue are checked for readiness.
+	FutureBlockRecheckInterval = build.Select(build.Var{
+		Standard: 10 * time.Second,
+		Dev:      2 * time.Second,
+		Testing:  500 * time.Millisecond,
+	}).(time.Duration)
+
+	// MaxFutureBlocks is the maximum number of blocks with a future
+	// timestamp that are held in memory.
+	MaxFutureBlocks = build.Select(build.Var{
+		Standard: int(50),
+		Dev:      int(20),
+		Testing:  int(5),
+	}).(int)
+
+	// MaxOrphanBlocks is the maximum number of orphan blocks that are held in
+	// memory.
+	MaxOrphanBlocks = build.Select(build.Var{
+		Standard: int(100),
+		Dev:      int(50),
+		Testing:  int(5),
+	}).(int)
+)
+
+type (
+	// An OrphanPool holds blocks whose parent is not known yet, keyed by the
+	// ID of the missing parent. The pool is bounded by MaxOrphanBlocks; when
+	// it is full, the oldest orphan is evicted to make room.
+	OrphanPool struct {
+		byParent map[types.BlockID][]types.Block
+		known    map[types.BlockID]struct{}
+		order    []orphanEntry
+		mu       sync.Mutex
+	}
+
+	// orphanEntry records the insertion order of the orphans so that the
+	// oldest one can be evicted.
+	orphanEntry struct {
+		id       types.BlockID
+		parentID types.BlockID
+	}
+
+	// A FutureBlockQueue holds blocks whose timestamp is too far in the
+	// future to be accepted, and releases them once their timestamp is
+	// within FutureThreshold of the current time. The queue is bounded by
+	// MaxFutureBlocks; when it is full, the block furthest in the future is
+	// dropped.
+	FutureBlockQueue struct {
+		clock  types.Clock
+		blocks futureBlockHeap
+		known  map[types.BlockID]struct{}
+		mu     sync.Mutex
+	}
+
+	// futureBlockHeap is a min-heap of blocks ordered by timestamp.
+	futureBlockHeap []types.Block
+)
+
+// NewOrphanPool returns an empty OrphanPool.
+func NewOrphanPool() *OrphanPool {
+	return &OrphanPool{
+		byParent: make(map[types.BlockID][]types.Block),
+		known:    make(map[types.BlockID]struct{}),
+	}
+}
+
+// Add stores an orphan block. False is returned if the block was already in
+// the pool.
+func (op *OrphanPool) Add(b types.Block) bool {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+	id := b.ID()
+	if _, exists := op.known[id]; exists {
+		return false
+	}
+	for len(op.known) >= MaxOrphanBlocks && len(op.order) > 0 {
+		op.evictOldest()
+	}
+	op.byParent[b.ParentID] = append(op.byParent[b.ParentID], b)
+	op.known[id] = struct{}{}
+	op.order = append(op.order, orphanEntry{id: id, parentID: b.ParentID})
+
+	// Entries of orphans that were taken by their parent are only removed
+	// lazily. Compact the order once it has grown too large.
+	if len(op.order) > 2*MaxOrphanBlocks {
+		var order []orphanEntry
+		for _, e := range op.order {
+			if _, exists := op.known[e.id]; exists {
+				order = append(order, e)
+			}
+		}
+		op.order = order
+	}
+	return true
+}
+
+// evictOldest removes the oldest orphan that is still in the pool.
+func (op *OrphanPool) evictOldest() {
+	for len(op.order) > 0 {
+		oldest := op.order[0]
+		op.order = op.order[1:]
+		if _, exists := op.known[oldest.id]; !exists {
+			// The orphan was already taken by its parent.
+			continue
+		}
+		delete(op.known, oldest.id)
+		siblings := op.byParent[oldest.parentID]
+		for i := range siblings {
+			if siblings[i].ID() == oldest.id {
+				siblings = append(siblings[:i], siblings[i+1:]...)
+				break
+			}
+		}
+		if len(siblings) == 0 {
+			delete(op.byParent, oldest.parentID)
+		} else {
+			op.byParent[oldest.parentID] = siblings
+		}
+		return
+	}
+}
+
+// Has returns true if the block with the provided ID is in the pool.
+func (op *OrphanPool) Has(id types.BlockID) bool {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+	_, exists := op.known[id]
+	return exists
+}
+
+// Len returns the number of orphans in the pool.
+func (op *OrphanPool) Len() int {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+	return len(op.known)
+}
+
+// TakeChildren removes and returns every orphan whose parent is the block
+// with the provided ID.
+func (op *OrphanPool) TakeChildren(parentID types.BlockID) []types.Block {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+	children := op.byParent[parentID]
+	delete(op.byParent, parentID)
+	for _, child := range children {
+		delete(op.known, child.ID())
+	}
+	return children
+}
+
+// Resolve retries the orphans of the block with the provided ID, which has
+// just been accepted. Every orphan that is accepted in turn has its own
+// orphans retried. ErrNonExtendingBlock is treated as acceptance, since the
+// block was still added to the block tree.
+func (op *OrphanPool) Resolve(parentID types.BlockID, accept func(types.Block) error) {
+	queue := []types.BlockID{parentID}
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		for _, child := range op.TakeChildren(id) {
+			err := accept(child)
+			if err == nil || err == ErrNonExtendingBlock {
+				queue = append(queue, child.ID())
+			}
+		}
+	}
+}
+
+// NewFutureBlockQueue returns an empty FutureBlockQueue that uses the
+// provided clock to determine the current time.
+func NewFutureBlockQueue(clock types.Clock) *FutureBlockQueue {
+	return &FutureBlockQueue{
+		clock: clock,
+		known: make(map[types.BlockID]struct{}),
+	}
+}
+
+// CheckTimestamp compares the timestamp of b with the current time. It
+// returns ErrExtremeFutureTimestamp if the block should be discarded,
+// ErrFutureTimestamp if the block should be held, and nil otherwise.
+func (fq *FutureBlockQueue) CheckTimestamp(b types.Block) error {
+	now := fq.clock.Now()
+	if b.Timestamp > now+types.ExtremeFutureThreshold {
+		return ErrExtremeFutureTimestamp
+	}
+	if b.Timestamp > now+types.FutureThreshold {
+		return ErrFutureTimestamp
+	}
+	return nil
+}
+
+// Add checks the timestamp of b and holds the block if it is too far in the
+// future. The result of CheckTimestamp is returned, so ErrFutureTimestamp
+// indicates that the block was queued.
+func (fq *FutureBlockQueue) Add(b types.Block) error {
+	err := fq.CheckTimestamp(b)
+	if err != ErrFutureTimestamp {
+		return err
+	}
+
+	fq.mu.Lock()
+	defer fq.mu.Unlock()
+	id := b.ID()
+	if _, exists := fq.known[id]; exists {
+		return ErrFutureTimestamp
+	}
+	if len(fq.blocks) >= MaxFutureBlocks {
+		// Drop whichever block is furthest in the future, which may be the
+		// new block itself.
+		furthest := 0
+		for i := range fq.blocks {
+			if fq.blocks[i].Timestamp > fq.blocks[furthest].Timestamp {
+				furthest = i
+			}
+		}
+		if fq.blocks[furthest].Timestamp <= b.Timestamp {
+			return ErrFutureTimestamp
+		}
+		delete(fq.known, fq.blocks[furthest].ID())
+		heap.Remove(&fq.blocks, furthest)
+	}
+	heap.Push(&fq.blocks, b)
+	fq.known[id] = struct{}{}
+	return ErrFutureTimestamp
+}
+
+// Len returns the number of blocks in the queue.
+func (fq *FutureBlockQueue) Len() int {
+	fq.mu.Lock()
+	defer fq.mu.Unlock()
+	return len(fq.blocks)
+}
+
+// Ready removes and returns every block whose timestamp is now within
+// FutureThreshold of the current time, in timestamp order.
+func (fq *FutureBlockQueue) Ready() []types.Block {
+	fq.mu.Lock()
+	defer fq.mu.Unlock()
+	now := fq.clock.Now()
+	var ready []types.Block
+	for len(fq.blocks) > 0 && fq.blocks[0].Timestamp <= now+types.FutureThreshold {
+		b := heap.Pop(&fq.blocks).(types.Block)
+		delete(fq.known, b.ID())
+		ready = append(ready, b)
+	}
+	return ready
+}
+
+// ThreadedRecheck hands the blocks that become ready to accept every
+// FutureBlockRecheckInterval until stop is closed.
+func (fq *FutureBlockQueue) ThreadedRecheck(accept func(types.Block) error, stop <-chan struct{}) {
+	ticker := time.NewTicker(FutureBlockRecheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		for _, b := range fq.Ready() {
+			accept(b)
+		}
+	}
+}
+
+// Len is part of heap.Interface.
+func (h futureBlockHeap) Len() int { return len(h) }
+
+// Less is part of heap.Interface.
+func (h futureBlockHeap) Less(i, j int) bool { return h[i].Timestamp < h[j].Timestamp }
+
+// Swap is part of heap.Interface.
+func (h futureBlockHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+// Push is part of heap.Interface.
+func (h *futureBlockHeap) Push(x interface{}) { *h = append(*h, x.(types.Block)) }
+
+// Pop is part of heap.Interface.
+func (h *futureBlockHeap) Pop() interface{} {
+	old := *h
+	b := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return b
+}
@@ -0,0 +1,102 @@
+package modules
+
+import (
+	"testing"
+
+	"github.com/wisherd/Pis/types"
+)
+
+// mockClock is a types.Clock that returns a fixed time.
+type mockClock struct {
+	now types.Timestamp
+}
+
+// Now implements types.Clock.
+func (mc *mockClock) Now() types.Timestamp {
+	return mc.now
+}
+
+// TestOrphanPool checks that orphans are resolved recursively once their
+// parent arrives and that the pool does not exceed MaxOrphanBlocks.
+func TestOrphanPool(t *testing.T) {
+	op := NewOrphanPool()
+	root := types.Block{Nonce: types.BlockNonce{1}}
+	child := types.Block{ParentID: root.ID()}
+	grandchild := types.Block{ParentID: child.ID()}
+	if !op.Add(grandchild) || !op.Add(child) {
+		t.Fatal("orphans were not added")
+	}
+	if op.Add(child) {
+		t.Fatal("duplicate orphan was added")
+	}
+
+	var accepted []types.BlockID
+	op.Resolve(root.ID(), func(b types.Block) error {
+		accepted = append(accepted, b.ID())
+		return nil
+	})
+	if len(accepted) != 2 || accepted[0] != child.ID() || accepted[1] != grandchild.ID() {
+		t.Fatal("orphans were not resolved in order:", accepted)
+	}
+	if op.Len() != 0 {
+		t.Fatal("resolved orphans are still in the pool")
+	}
+
+	// Fill the pool past its limit; the oldest orphans are evicted.
+	var orphans []types.Block
+	for i := 0; i < MaxOrphanBlocks+2; i++ {
+		b := types.Block{ParentID: types.BlockID{byte(i % 2)}, Nonce: types.BlockNonce{byte(i)}}
+		orphans = append(orphans, b)
+		op.Add(b)
+	}
+	if op.Len() != MaxOrphanBlocks {
+		t.Fatal("pool exceeded its limit:", op.Len())
+	}
+	if op.Has(orphans[0].ID()) || op.Has(orphans[1].ID()) || !op.Has(orphans[len(orphans)-1].ID()) {
+		t.Fatal("the wrong orphans were evicted")
+	}
+}
+
+// TestFutureBlockQueue checks that future blocks are held until their
+// timestamp is close enough to the current time.
+func TestFutureBlockQueue(t *testing.T) {
+	clock := &mockClock{now: 1000}
+	fq := NewFutureBlockQueue(clock)
+
+	current := types.Block{Timestamp: clock.now}
+	if err := fq.Add(current); err != nil {
+		t.Fatal("current block should not be held:", err)
+	}
+	extreme := types.Block{Timestamp: clock.now + types.ExtremeFutureThreshold + 1}
+	if err := fq.Add(extreme); err != ErrExtremeFutureTimestamp {
+		t.Fatal("expected ErrExtremeFutureTimestamp, got", err)
+	}
+	future := types.Block{Timestamp: clock.now + types.FutureThreshold + 1}
+	if err := fq.Add(future); err != ErrFutureTimestamp {
+		t.Fatal("expected ErrFutureTimestamp, got", err)
+	}
+	if fq.Len() != 1 {
+		t.Fatal("future block was not queued")
+	}
+	if ready := fq.Ready(); len(ready) != 0 {
+		t.Fatal("block was released too early")
+	}
+	clock.now++
+	if ready := fq.Ready(); len(ready) != 1 || ready[0].ID() != future.ID() {
+		t.Fatal("block was not released:", ready)
+	}
+
+	// When the queue is full, the block furthest in the future is dropped.
+	for i := 0; i < MaxFutureBlocks; i++ {
+		fq.Add(types.Block{Timestamp: clock.now + types.FutureThreshold + 2, Nonce: types.BlockNonce{byte(i)}})
+	}
+	earliest := types.Block{Timestamp: clock.now + types.FutureThreshold + 1}
+	fq.Add(earliest)
+	if fq.Len() != MaxFutureBlocks {
+		t.Fatal("queue exceeded its limit:", fq.Len())
+	}
+	clock.now++
+	if ready := fq.Ready(); len(ready) != 1 || ready[0].ID() != earliest.ID() {
+		t.Fatal("earliest block was dropped instead of the latest")
+	}
+}
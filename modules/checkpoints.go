@@ -0,0 +1,91 @@
+package modules
+
+import (
+	"errors"
+
+	"github.com/wisherd/Pis/build"
+	"github.com/wisherd/Pis/types"
+)
+
+type (
+	// A Checkpoint is a block that is known to be part of the canonical
+	// chain. The consensus set rejects any block at the checkpoint's height
+	// with a different ID, which prevents reorgs below the checkpoint.
+	Checkpoint struct {
+		Height types.BlockHeight `json:"height"`
+		ID     types.BlockID     `json:"id"`
+	}
+)
+
+var (
+	// ErrCheckpointConflict is returned when a block conflicts with one of
+	// the hard-coded checkpoints.
+	ErrCheckpointConflict = errors.New("block conflicts with a checkpoint")
+
+	// Checkpoints is the table of hard-coded checkpoints, ordered by height.
+	// Checkpoints are only added for blocks that are buried deep enough that
+	// a reorg past them is not a realistic concern.
+	Checkpoints = build.Select(build.Var{
+		Standard: []Checkpoint{
+			{Height: 0, ID: types.GenesisID},
+		},
+		Dev:     []Checkpoint(nil),
+		Testing: []Checkpoint(nil),
+	}).([]Checkpoint)
+
+	// AssumeValidBlock is a block whose ancestors are trusted to have valid
+	// signatures. The consensus set skips signature verification for blocks
+	// that are ancestors of AssumeValidBlock on the current chain; every
+	// other consensus rule is still enforced. The zero BlockID disables the
+	// optimization. It can be overridden with the --assume-valid flag of
+	// pisd.
+	AssumeValidBlock = build.Select(build.Var{
+		Standard: types.BlockID{},
+		Dev:      types.BlockID{},
+		Testing:  types.BlockID{},
+	}).(types.BlockID)
+)
+
+// CheckCheckpoint returns ErrCheckpointConflict if a checkpoint exists at the
+// provided height and its ID differs from id.
+func CheckCheckpoint(height types.BlockHeight, id types.BlockID) error {
+	for _, cp := range Checkpoints {
+		if cp.Height == height && cp.ID != id {
+			return ErrCheckpointConflict
+		}
+	}
+	return nil
+}
+
+// LastCheckpointHeight returns the height of the highest checkpoint. Forks
+// that diverge from the current chain below this height are rejected without
+// further validation.
+func LastCheckpointHeight() types.BlockHeight {
+	var height types.BlockHeight
+	for _, cp := range Checkpoints {
+		if cp.Height > height {
+			height = cp.Height
+		}
+	}
+	return height
+}
+
+// SkipSignatures returns true if the signatures of the block at the provided
+// height can be skipped. assumeValidHeight is the height of AssumeValidBlock,
+// and onChain indicates whether AssumeValidBlock is part of the chain that
+// the block belongs to. A block is only assumed valid if it is buried beneath
+// AssumeValidBlock.
+func SkipSignatures(height, assumeValidHeight types.BlockHeight, onChain bool) bool {
+	if AssumeValidBlock == (types.BlockID{}) || !onChain {
+		return false
+	}
+	return height <= assumeValidHeight
+}
+
+// ValidateBlockTransactions performs the standalone checks on every
+// transaction in b in parallel. Signature verification is skipped if
+// skipSignatures is set, which should only be the case if SkipSignatures
+// returned true for the block.
+func ValidateBlockTransactions(b types.Block, height types.BlockHeight, skipSignatures bool) error {
+	return types.StandaloneValidTransactions(b.Transactions, height, skipSignatures)
+}
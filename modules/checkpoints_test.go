@@ -0,0 +1,45 @@
+package modules
+
+import (
+	"testing"
+
+	"github.com/wisherd/Pis/types"
+)
+
+// TestCheckpoints probes CheckCheckpoint, LastCheckpointHeight and
+// SkipSignatures.
+func TestCheckpoints(t *testing.T) {
+	oldCheckpoints, oldAssumeValid := Checkpoints, AssumeValidBlock
+	defer func() {
+		Checkpoints, AssumeValidBlock = oldCheckpoints, oldAssumeValid
+	}()
+	Checkpoints = []Checkpoint{
+		{Height: 0, ID: types.BlockID{1}},
+		{Height: 10, ID: types.BlockID{2}},
+	}
+
+	if err := CheckCheckpoint(10, types.BlockID{2}); err != nil {
+		t.Error("matching block was rejected:", err)
+	}
+	if err := CheckCheckpoint(10, types.BlockID{3}); err != ErrCheckpointConflict {
+		t.Error("expected ErrCheckpointConflict, got", err)
+	}
+	if err := CheckCheckpoint(5, types.BlockID{3}); err != nil {
+		t.Error("block without a checkpoint was rejected:", err)
+	}
+	if h := LastCheckpointHeight(); h != 10 {
+		t.Error("wrong last checkpoint height:", h)
+	}
+
+	AssumeValidBlock = types.BlockID{}
+	if SkipSignatures(5, 10, true) {
+		t.Error("signatures skipped without an assume-valid block")
+	}
+	AssumeValidBlock = types.BlockID{2}
+	if !SkipSignatures(5, 10, true) || !SkipSignatures(10, 10, true) {
+		t.Error("signatures not skipped beneath the assume-valid block")
+	}
+	if SkipSignatures(11, 10, true) || SkipSignatures(5, 10, false) {
+		t.Error("signatures skipped for a block that is not beneath the assume-valid block")
+	}
+}
@@ -0,0 +1,307 @@
+package modules
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+
+	"github.com/wisherd/Pis/types"
+)
+
+const (
+	// CoinSelectionLargestFirst spends the largest outputs first, which
+	// keeps the number of inputs low.
+	CoinSelectionLargestFirst CoinSelectionStrategy = "largest-first"
+
+	// CoinSelectionSmallestFirst spends the smallest outputs first, which
+	// consolidates dust.
+	CoinSelectionSmallestFirst CoinSelectionStrategy = "smallest-first"
+
+	// CoinSelectionPrivacy spends all outputs of an address together and
+	// prefers funding from a single address, so that a transaction links as
+	// few addresses as possible.
+	CoinSelectionPrivacy CoinSelectionStrategy = "privacy"
+
+	// CoinSelectionMinimalChange selects the outputs that leave the least
+	// change. The search is bounded, so for wallets with many outputs the
+	// change may only be close to the minimum.
+	CoinSelectionMinimalChange CoinSelectionStrategy = "minimal-change"
+)
+
+const (
+	// maxMinimalChangeTries is the number of steps after which the search
+	// of the minimal-change strategy gives up and returns the best
+	// selection found so far.
+	maxMinimalChangeTries = 100e3
+)
+
+var (
+	// ErrOutputLocked is returned when an output that is locked or spent by
+	// another transaction builder is selected explicitly.
+	ErrOutputLocked = errors.New("output is locked")
+
+	// ErrOutputNotSpendable is returned when an immature or watch-only
+	// output is selected explicitly.
+	ErrOutputNotSpendable = errors.New("output is immature or watch-only")
+
+	// ErrUnknownCoinSelectionStrategy is returned for an unknown coin
+	// selection strategy.
+	ErrUnknownCoinSelectionStrategy = errors.New("unknown coin selection strategy")
+
+	// ErrUnknownOutput is returned when an output that is not an unspent
+	// output of the wallet is selected explicitly.
+	ErrUnknownOutput = errors.New("output is not an unspent output of the wallet")
+)
+
+type (
+	// A CoinSelectionStrategy decides which unspent outputs fund a
+	// transaction.
+	CoinSelectionStrategy string
+
+	// CoinSelection controls how a transaction is funded. If Outputs is not
+	// empty, exactly those outputs are spent. Otherwise the outputs are
+	// chosen by Strategy, and the empty strategy is largest-first.
+	CoinSelection struct {
+		Strategy CoinSelectionStrategy `json:"strategy"`
+		Outputs  []types.OutputID      `json:"outputs"`
+	}
+
+	// An UnspentOutput is an output of the wallet that has not been spent.
+	// Locked is set if the output was locked with LockOutputs or is being
+	// spent by a transaction builder.
+	UnspentOutput struct {
+		ID                 types.OutputID    `json:"id"`
+		FundType           types.Specifier   `json:"fundtype"`
+		UnlockHash         types.UnlockHash  `json:"unlockhash"`
+		Value              types.Currency    `json:"value"`
+		ConfirmationHeight types.BlockHeight `json:"confirmationheight"`
+		MaturityHeight     types.BlockHeight `json:"maturityheight"`
+		Confirmations      types.BlockHeight `json:"confirmations"`
+		Locked             bool              `json:"locked"`
+		WatchOnly          bool              `json:"watchonly"`
+	}
+
+	// CoinController lists the unspent outputs of the wallet and locks them
+	// so that they are not used to fund transactions.
+	CoinController interface {
+		// UnspentOutputs returns the unspent piscoin and pisfund outputs of
+		// the wallet, including immature and locked outputs.
+		UnspentOutputs() ([]UnspentOutput, error)
+
+		// LockOutputs prevents the given outputs from being selected by any
+		// transaction builder until they are unlocked. Locks are kept in
+		// memory and are released when the wallet is closed.
+		LockOutputs([]types.OutputID) error
+
+		// UnlockOutputs releases locks placed with LockOutputs.
+		UnlockOutputs([]types.OutputID) error
+	}
+)
+
+// Spendable reports whether uo can be used to fund a transaction at the given
+// height.
+func (uo UnspentOutput) Spendable(height types.BlockHeight) bool {
+	return !uo.Locked && !uo.WatchOnly && uo.MaturityHeight <= height
+}
+
+// SelectOutputs returns outputs whose total value is at least amount, chosen
+// according to cs. Only outputs of fundType that are spendable at height are
+// considered. ErrLowBalance is returned if they do not add up to amount.
+func SelectOutputs(outputs []UnspentOutput, fundType types.Specifier, amount types.Currency, height types.BlockHeight, cs CoinSelection) ([]UnspentOutput, error) {
+	if len(cs.Outputs) > 0 {
+		return selectExplicit(outputs, fundType, amount, height, cs.Outputs)
+	} else if amount.IsZero() {
+		return nil, nil
+	}
+
+	var candidates []UnspentOutput
+	for _, uo := range outputs {
+		if uo.FundType == fundType && uo.Spendable(height) {
+			candidates = append(candidates, uo)
+		}
+	}
+	// Sort by value, largest first, and by ID so that the selection is
+	// deterministic.
+	sort.Slice(candidates, func(i, j int) bool {
+		if cmp := candidates[i].Value.Cmp(candidates[j].Value); cmp != 0 {
+			return cmp > 0
+		}
+		return bytes.Compare(candidates[i].ID[:], candidates[j].ID[:]) < 0
+	})
+
+	var selected []UnspentOutput
+	switch cs.Strategy {
+	case "", CoinSelectionLargestFirst:
+		selected = accumulateOutputs(candidates, amount)
+	case CoinSelectionSmallestFirst:
+		for i, j := 0, len(candidates)-1; i < j; i, j = i+1, j-1 {
+			candidates[i], candidates[j] = candidates[j], candidates[i]
+		}
+		selected = accumulateOutputs(candidates, amount)
+	case CoinSelectionMinimalChange:
+		selected = selectMinimalChange(candidates, amount)
+	case CoinSelectionPrivacy:
+		selected = selectPrivacy(candidates, amount)
+	default:
+		return nil, ErrUnknownCoinSelectionStrategy
+	}
+	if selected == nil {
+		return nil, ErrLowBalance
+	}
+	return selected, nil
+}
+
+// selectExplicit returns the outputs with the given IDs.
+func selectExplicit(outputs []UnspentOutput, fundType types.Specifier, amount types.Currency, height types.BlockHeight, ids []types.OutputID) ([]UnspentOutput, error) {
+	known := make(map[types.OutputID]UnspentOutput)
+	for _, uo := range outputs {
+		if uo.FundType == fundType {
+			known[uo.ID] = uo
+		}
+	}
+	var selected []UnspentOutput
+	total := types.ZeroCurrency
+	for _, id := range ids {
+		uo, exists := known[id]
+		if !exists {
+			return nil, ErrUnknownOutput
+		} else if uo.Locked {
+			return nil, ErrOutputLocked
+		} else if !uo.Spendable(height) {
+			return nil, ErrOutputNotSpendable
+		}
+		delete(known, id)
+		selected = append(selected, uo)
+		total = total.Add(uo.Value)
+	}
+	if total.Cmp(amount) < 0 {
+		return nil, ErrLowBalance
+	}
+	return selected, nil
+}
+
+// accumulateOutputs returns the shortest prefix of outputs that adds up to
+// amount, or nil if all of them do not.
+func accumulateOutputs(outputs []UnspentOutput, amount types.Currency) []UnspentOutput {
+	total := types.ZeroCurrency
+	for i, uo := range outputs {
+		total = total.Add(uo.Value)
+		if total.Cmp(amount) >= 0 {
+			return outputs[:i+1]
+		}
+	}
+	return nil
+}
+
+// selectMinimalChange searches for the outputs whose total exceeds amount by
+// the least. The search is a depth-first branch and bound over the outputs,
+// trying to include each output before excluding it, and stops after
+// maxMinimalChangeTries steps or once a selection without change is found.
+// It starts from the selection of selectGreedyChange, so the result is never
+// worse than that. outputs must be sorted largest-first.
+func selectMinimalChange(outputs []UnspentOutput, amount types.Currency) []UnspentOutput {
+	best := selectGreedyChange(outputs, amount)
+	if best == nil {
+		return nil
+	}
+	bestChange := types.ZeroCurrency
+	for _, uo := range best {
+		bestChange = bestChange.Add(uo.Value)
+	}
+	bestChange = bestChange.Sub(amount)
+
+	// remaining[i] is the total value of outputs[i:], which bounds the total
+	// that can still be reached.
+	remaining := make([]types.Currency, len(outputs)+1)
+	remaining[len(outputs)] = types.ZeroCurrency
+	for i := len(outputs) - 1; i >= 0; i-- {
+		remaining[i] = remaining[i+1].Add(outputs[i].Value)
+	}
+
+	var selected []UnspentOutput
+	tries := 0
+	var search func(i int, total types.Currency)
+	search = func(i int, total types.Currency) {
+		tries++
+		if tries > maxMinimalChangeTries || bestChange.IsZero() {
+			return
+		}
+		if total.Cmp(amount) >= 0 {
+			// Adding more outputs only increases the change.
+			if change := total.Sub(amount); change.Cmp(bestChange) < 0 {
+				best = append([]UnspentOutput(nil), selected...)
+				bestChange = change
+			}
+			return
+		}
+		if i == len(outputs) || total.Add(remaining[i]).Cmp(amount) < 0 {
+			return
+		}
+		selected = append(selected, outputs[i])
+		search(i+1, total.Add(outputs[i].Value))
+		selected = selected[:len(selected)-1]
+		search(i+1, total)
+	}
+	search(0, types.ZeroCurrency)
+	return best
+}
+
+// selectGreedyChange takes the largest outputs until the remainder can be
+// covered by a single output, and then adds the smallest output that covers
+// it. outputs must be sorted largest-first.
+func selectGreedyChange(outputs []UnspentOutput, amount types.Currency) []UnspentOutput {
+	var selected []UnspentOutput
+	remaining := amount
+	for len(outputs) > 0 {
+		for i := len(outputs) - 1; i >= 0; i-- {
+			if outputs[i].Value.Cmp(remaining) >= 0 {
+				return append(selected, outputs[i])
+			}
+		}
+		selected = append(selected, outputs[0])
+		remaining = remaining.Sub(outputs[0].Value)
+		outputs = outputs[1:]
+	}
+	return nil
+}
+
+// selectPrivacy spends the outputs of an address together. If one address
+// covers amount, the one with the smallest total is used; otherwise addresses
+// are added largest total first. outputs must be sorted largest-first.
+func selectPrivacy(outputs []UnspentOutput, amount types.Currency) []UnspentOutput {
+	type group struct {
+		outputs []UnspentOutput
+		total   types.Currency
+	}
+	var groups []*group
+	byAddress := make(map[types.UnlockHash]*group)
+	for _, uo := range outputs {
+		g, exists := byAddress[uo.UnlockHash]
+		if !exists {
+			g = &group{total: types.ZeroCurrency}
+			byAddress[uo.UnlockHash] = g
+			groups = append(groups, g)
+		}
+		g.outputs = append(g.outputs, uo)
+		g.total = g.total.Add(uo.Value)
+	}
+	sort.SliceStable(groups, func(i, j int) bool {
+		return groups[i].total.Cmp(groups[j].total) > 0
+	})
+
+	for i := len(groups) - 1; i >= 0; i-- {
+		if groups[i].total.Cmp(amount) >= 0 {
+			return groups[i].outputs
+		}
+	}
+	var selected []UnspentOutput
+	total := types.ZeroCurrency
+	for _, g := range groups {
+		selected = append(selected, g.outputs...)
+		total = total.Add(g.total)
+		if total.Cmp(amount) >= 0 {
+			return selected
+		}
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package modules
+
+import (
+	"testing"
+
+	"github.com/wisherd/Pis/types"
+)
+
+// TestSelectOutputs checks the coin selection strategies.
+func TestSelectOutputs(t *testing.T) {
+	output := func(id byte, addr byte, value uint64) UnspentOutput {
+		return UnspentOutput{
+			ID:         types.OutputID{id},
+			FundType:   types.SpecifierPiscoinOutput,
+			UnlockHash: types.UnlockHash{addr},
+			Value:      types.NewCurrency64(value),
+		}
+	}
+	locked := output(5, 3, 100)
+	locked.Locked = true
+	immature := output(6, 3, 100)
+	immature.MaturityHeight = 10
+	outputs := []UnspentOutput{
+		output(1, 1, 5),
+		output(2, 1, 8),
+		output(3, 2, 10),
+		output(4, 2, 4),
+		locked,
+		immature,
+	}
+	ids := func(selected []UnspentOutput) (s []byte) {
+		for _, uo := range selected {
+			s = append(s, uo.ID[0])
+		}
+		return s
+	}
+
+	tests := []struct {
+		strategy CoinSelectionStrategy
+		amount   uint64
+		want     string
+	}{
+		{CoinSelectionLargestFirst, 15, "\x03\x02"},
+		{"", 9, "\x03"},
+		{CoinSelectionSmallestFirst, 7, "\x04\x01"},
+		{CoinSelectionMinimalChange, 6, "\x02"},
+		{CoinSelectionMinimalChange, 13, "\x02\x01"},
+		{CoinSelectionMinimalChange, 16, "\x02\x01\x04"},
+		{CoinSelectionPrivacy, 12, "\x02\x01"},
+		{CoinSelectionPrivacy, 14, "\x03\x04"},
+		{CoinSelectionPrivacy, 20, "\x03\x04\x02\x01"},
+	}
+	for _, test := range tests {
+		selected, err := SelectOutputs(outputs, types.SpecifierPiscoinOutput, types.NewCurrency64(test.amount), 0, CoinSelection{Strategy: test.strategy})
+		if err != nil {
+			t.Fatal(test.strategy, test.amount, err)
+		} else if string(ids(selected)) != test.want {
+			t.Errorf("%v %v: selected %v, expected %v", test.strategy, test.amount, ids(selected), []byte(test.want))
+		}
+	}
+
+	// Locked and immature outputs are never selected.
+	if _, err := SelectOutputs(outputs, types.SpecifierPiscoinOutput, types.NewCurrency64(28), 0, CoinSelection{}); err != ErrLowBalance {
+		t.Fatal("expected ErrLowBalance, got", err)
+	}
+	if _, err := SelectOutputs(outputs, types.SpecifierPiscoinOutput, types.NewCurrency64(1), 0, CoinSelection{Strategy: "random"}); err != ErrUnknownCoinSelectionStrategy {
+		t.Fatal("expected ErrUnknownCoinSelectionStrategy, got", err)
+	}
+
+	// Explicit selection.
+	cs := CoinSelection{Outputs: []types.OutputID{{4}, {1}}}
+	if selected, err := SelectOutputs(outputs, types.SpecifierPiscoinOutput, types.NewCurrency64(8), 0, cs); err != nil || string(ids(selected)) != "\x04\x01" {
+		t.Fatal("explicit selection failed:", ids(selected), err)
+	}
+	if _, err := SelectOutputs(outputs, types.SpecifierPiscoinOutput, types.NewCurrency64(10), 0, cs); err != ErrLowBalance {
+		t.Fatal("expected ErrLowBalance, got", err)
+	}
+	cs.Outputs = []types.OutputID{{5}}
+	if _, err := SelectOutputs(outputs, types.SpecifierPiscoinOutput, types.NewCurrency64(1), 0, cs); err != ErrOutputLocked {
+		t.Fatal("expected ErrOutputLocked, got", err)
+	}
+	cs.Outputs = []types.OutputID{{6}}
+	if _, err := SelectOutputs(outputs, types.SpecifierPiscoinOutput, types.NewCurrency64(1), 0, cs); err != ErrOutputNotSpendable {
+		t.Fatal("expected ErrOutputNotSpendable, got", err)
+	}
+	if _, err := SelectOutputs(outputs, types.SpecifierPiscoinOutput, types.NewCurrency64(1), 10, cs); err != nil {
+		t.Fatal("matured output was rejected:", err)
+	}
+	cs.Outputs = []types.OutputID{{7}}
+	if _, err := SelectOutputs(outputs, types.SpecifierPiscoinOutput, types.NewCurrency64(1), 0, cs); err != ErrUnknownOutput {
+		t.Fatal("expected ErrUnknownOutput, got", err)
+	}
+}
@@ -0,0 +1,62 @@
+package modules
+
+import (
+	"github.com/wisherd/Pis/crypto"
+	"github.com/wisherd/Pis/types"
+)
+
+// SeedKey returns the secret key and the UnlockConditions of the address at
+// the given index of seed. The derivation is the one used by the wallet, so
+// the keys of a wallet can be recreated from its seed alone.
+func SeedKey(seed Seed, index uint64) (crypto.SecretKey, types.UnlockConditions) {
+	sk, pk := crypto.GenerateKeyPairDeterministic(crypto.HashAll(seed, index))
+	return sk, types.UnlockConditions{
+		PublicKeys:         []types.PisPublicKey{types.Ed25519PublicKey(pk)},
+		SignaturesRequired: 1,
+	}
+}
+
+// SeedUnlockConditions returns the UnlockConditions of the first n addresses
+// of seed. They contain no secrets, so they can be exported from an offline
+// machine to a watch-only wallet.
+func SeedUnlockConditions(seed Seed, n uint64) []types.UnlockConditions {
+	ucs := make([]types.UnlockConditions, n)
+	for i := range ucs {
+		_, ucs[i] = SeedKey(seed, uint64(i))
+	}
+	return ucs
+}
+
+// SignWithSeed signs every input of pst that can be signed with one of the
+// first n keys of seed. It returns the number of signatures that were added.
+// SignWithSeed only needs the seed and pst, so it can run on a machine that
+// has no network connection and no copy of the blockchain.
+func SignWithSeed(pst *PartiallySignedTransaction, seed Seed, n uint64) (int, error) {
+	if err := pst.Validate(); err != nil {
+		return 0, err
+	}
+	needed := make(map[string]struct{})
+	for _, pi := range pst.Inputs {
+		for _, key := range pi.UnlockConditions.PublicKeys {
+			if key.Algorithm == types.SignatureEd25519 {
+				needed[string(key.Key)] = struct{}{}
+			}
+		}
+	}
+
+	var signed int
+	for i := uint64(0); i < n && len(needed) > 0; i++ {
+		sk, _ := SeedKey(seed, i)
+		pk := sk.PublicKey()
+		if _, exists := needed[string(pk[:])]; !exists {
+			continue
+		}
+		delete(needed, string(pk[:]))
+		added, err := pst.Sign(sk)
+		signed += added
+		if err != nil {
+			return signed, err
+		}
+	}
+	return signed, nil
+}
@@ -0,0 +1,56 @@
+package modules
+
+import (
+	"testing"
+
+	"github.com/wisherd/Pis/crypto"
+	"github.com/wisherd/Pis/types"
+	"gitlab.com/NebulousLabs/fastrand"
+)
+
+// TestSignWithSeed checks that a transaction built for a watch-only address
+// can be signed with nothing but the seed of that address.
+func TestSignWithSeed(t *testing.T) {
+	var seed Seed
+	fastrand.Read(seed[:])
+	ucs := SeedUnlockConditions(seed, 5)
+	uc := ucs[3]
+
+	parent := types.Transaction{
+		PiscoinOutputs: []types.PiscoinOutput{{Value: types.NewCurrency64(10), UnlockHash: uc.UnlockHash()}},
+	}
+	txn := types.Transaction{
+		PiscoinInputs:  []types.PiscoinInput{{ParentID: parent.PiscoinOutputID(0), UnlockConditions: uc}},
+		PiscoinOutputs: []types.PiscoinOutput{{Value: types.NewCurrency64(9), UnlockHash: types.UnlockHash{1}}},
+		MinerFees:      []types.Currency{types.NewCurrency64(1)},
+	}
+	values := map[crypto.Hash]types.Currency{crypto.Hash(parent.PiscoinOutputID(0)): types.NewCurrency64(10)}
+	pst, err := NewPartiallySignedTransaction(txn, []types.Transaction{parent}, values)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A seed that does not own the address adds nothing.
+	var other Seed
+	fastrand.Read(other[:])
+	unsigned := pst
+	if n, err := SignWithSeed(&unsigned, other, 5); err != nil || n != 0 {
+		t.Fatal("foreign seed signed the transaction:", n, err)
+	}
+
+	// Too few addresses are searched.
+	if n, err := SignWithSeed(&unsigned, seed, 3); err != nil || n != 0 {
+		t.Fatal("key beyond the search window was used:", n, err)
+	}
+
+	if n, err := SignWithSeed(&pst, seed, 5); err != nil || n != 1 {
+		t.Fatal("seed did not sign the transaction:", n, err)
+	}
+	txns, err := pst.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := txns[1].StandaloneValid(0); err != nil {
+		t.Fatal(err)
+	}
+}
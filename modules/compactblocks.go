@@ -0,0 +1,230 @@
+package modules
+
+import (
+	"errors"
+
+	"github.com/wisherd/Pis/crypto"
+	"github.com/wisherd/Pis/encoding"
+	"github.com/wisherd/Pis/types"
+)
+
+const (
+	// RelayBlockRPC is the name of the RPC used to relay full blocks to
+	// peers that do not support compact blocks.
+	RelayBlockRPC = "RelayBlock"
+
+	// RelayCompactBlockRPC is the name of the RPC used to relay compact
+	// blocks to peers that advertise FeatureCompactBlocks.
+	RelayCompactBlockRPC = "RelayCompactBlock"
+
+	// SendCompactTransactionsRPC is the name of the RPC used to request the
+	// transactions of a compact block that could not be found in the
+	// transaction pool.
+	SendCompactTransactionsRPC = "SendCompactTransactions"
+
+	// ShortTransactionIDSize is the number of bytes in a ShortTransactionID.
+	ShortTransactionIDSize = 6
+)
+
+var (
+	// ErrInvalidMissingIndex is returned when a peer requests a transaction
+	// of a compact block that does not exist.
+	ErrInvalidMissingIndex = errors.New("requested transaction index is out of range")
+
+	// ErrUnknownCompactBlock is returned when the transactions of a block are
+	// requested that the peer does not know about.
+	ErrUnknownCompactBlock = errors.New("block of compact block request is unknown")
+)
+
+type (
+	// A ShortTransactionID is a truncated, salted hash of a transaction ID.
+	// The salt is the ID of the block that the transaction appears in, which
+	// prevents an attacker from crafting transactions whose short IDs collide
+	// across all blocks.
+	ShortTransactionID [ShortTransactionIDSize]byte
+
+	// A CompactBlock is a block in which every transaction has been replaced
+	// by its ShortTransactionID. Peers usually know most of the transactions
+	// of a new block already, so relaying compact blocks saves a significant
+	// amount of bandwidth.
+	CompactBlock struct {
+		Header       types.BlockHeader     `json:"header"`
+		MinerPayouts []types.PiscoinOutput `json:"minerpayouts"`
+		ShortIDs     []ShortTransactionID  `json:"shortids"`
+	}
+
+	// A CompactTransactionsRequest asks a peer for the transactions at the
+	// provided indices of a block.
+	CompactTransactionsRequest struct {
+		BlockID types.BlockID `json:"blockid"`
+		Indices []uint64      `json:"indices"`
+	}
+)
+
+// NewShortTransactionID returns the ShortTransactionID of the transaction
+// with the provided ID in the block with the provided ID.
+func NewShortTransactionID(blockID types.BlockID, txid types.TransactionID) ShortTransactionID {
+	var sid ShortTransactionID
+	h := crypto.HashAll(blockID, txid)
+	copy(sid[:], h[:])
+	return sid
+}
+
+// NewCompactBlock returns the compact representation of b.
+func NewCompactBlock(b types.Block) CompactBlock {
+	header := b.Header()
+	id := header.ID()
+	cb := CompactBlock{
+		Header:       header,
+		MinerPayouts: b.MinerPayouts,
+		ShortIDs:     make([]ShortTransactionID, len(b.Transactions)),
+	}
+	for i, txn := range b.Transactions {
+		cb.ShortIDs[i] = NewShortTransactionID(id, txn.ID())
+	}
+	return cb
+}
+
+// Reconstruct fills in the transactions of the compact block using the
+// provided pool of transactions, which is usually the output of
+// TransactionPool.TransactionList. The indices of the transactions that could
+// not be found are returned; if there are none, the block is complete.
+// Transactions whose short IDs collide within the pool are treated as missing.
+func (cb CompactBlock) Reconstruct(pool []types.Transaction) (types.Block, []uint64) {
+	id := cb.Header.ID()
+	candidates := make(map[ShortTransactionID]int, len(pool))
+	for i, txn := range pool {
+		sid := NewShortTransactionID(id, txn.ID())
+		if _, exists := candidates[sid]; exists {
+			candidates[sid] = -1
+			continue
+		}
+		candidates[sid] = i
+	}
+
+	b := types.Block{
+		ParentID:     cb.Header.ParentID,
+		Nonce:        cb.Header.Nonce,
+		Timestamp:    cb.Header.Timestamp,
+		MinerPayouts: cb.MinerPayouts,
+		Transactions: make([]types.Transaction, len(cb.ShortIDs)),
+	}
+	var missing []uint64
+	for i, sid := range cb.ShortIDs {
+		j, exists := candidates[sid]
+		if !exists || j < 0 {
+			missing = append(missing, uint64(i))
+			continue
+		}
+		b.Transactions[i] = pool[j]
+	}
+	return b, missing
+}
+
+// Complete inserts the transactions that were missing after Reconstruct into
+// the partial block and checks the result against the header of the compact
+// block.
+func (cb CompactBlock) Complete(partial types.Block, missing []uint64, txns []types.Transaction) (types.Block, error) {
+	if len(missing) != len(txns) {
+		return types.Block{}, errors.New("wrong number of missing transactions supplied")
+	}
+	for i, index := range missing {
+		if index >= uint64(len(partial.Transactions)) {
+			return types.Block{}, ErrInvalidMissingIndex
+		}
+		partial.Transactions[index] = txns[i]
+	}
+	if err := VerifyBlockBody(cb.Header, partial); err != nil {
+		return types.Block{}, err
+	}
+	return partial, nil
+}
+
+// ReconstructCompactBlock rebuilds the block described by cb from the
+// transaction pool and requests any missing transactions from the peer at
+// addr, which is usually the peer that relayed the compact block.
+func ReconstructCompactBlock(g Gateway, tp TransactionPool, addr NetAddress, cb CompactBlock) (types.Block, error) {
+	partial, missing := cb.Reconstruct(tp.TransactionList())
+	if len(missing) == 0 {
+		if err := VerifyBlockBody(cb.Header, partial); err == nil {
+			return partial, nil
+		}
+		// A short ID collision between the pool and the block caused the
+		// wrong transaction to be used. Fall back to requesting every
+		// transaction.
+		missing = make([]uint64, len(cb.ShortIDs))
+		for i := range missing {
+			missing[i] = uint64(i)
+		}
+	}
+
+	var txns []types.Transaction
+	err := FeatureRPC(g, addr, FeatureCompactBlocks, SendCompactTransactionsRPC, func(conn PeerConn) error {
+		req := CompactTransactionsRequest{
+			BlockID: cb.Header.ID(),
+			Indices: missing,
+		}
+		if err := encoding.WriteObject(conn, req); err != nil {
+			return err
+		}
+		return encoding.ReadObject(conn, &txns, types.BlockSizeLimit)
+	})
+	if err != nil {
+		return types.Block{}, err
+	}
+	return cb.Complete(partial, missing, txns)
+}
+
+// ReceiveCompactBlock handles a call to RelayCompactBlockRPC. It reads the
+// compact block from conn and rebuilds it with ReconstructCompactBlock,
+// requesting missing transactions from the peer that relayed it. The caller
+// is responsible for submitting the block to the consensus set.
+func ReceiveCompactBlock(conn PeerConn, g Gateway, tp TransactionPool) (types.Block, error) {
+	var cb CompactBlock
+	if err := encoding.ReadObject(conn, &cb, types.BlockSizeLimit); err != nil {
+		return types.Block{}, err
+	}
+	return ReconstructCompactBlock(g, tp, conn.RPCAddr(), cb)
+}
+
+// ServeCompactTransactions handles a call to SendCompactTransactionsRPC. The
+// lookup function returns the full block with the requested ID, for example
+// from the consensus set or from a cache of recently relayed blocks.
+func ServeCompactTransactions(conn PeerConn, lookup func(types.BlockID) (types.Block, bool)) error {
+	var req CompactTransactionsRequest
+	if err := encoding.ReadObject(conn, &req, types.BlockSizeLimit); err != nil {
+		return err
+	}
+	b, exists := lookup(req.BlockID)
+	if !exists {
+		return ErrUnknownCompactBlock
+	}
+	txns := make([]types.Transaction, 0, len(req.Indices))
+	for _, index := range req.Indices {
+		if index >= uint64(len(b.Transactions)) {
+			return ErrInvalidMissingIndex
+		}
+		txns = append(txns, b.Transactions[index])
+	}
+	return encoding.WriteObject(conn, txns)
+}
+
+// RelayBlock broadcasts b to the provided peers. Peers that advertise
+// FeatureCompactBlocks receive a compact block, all other peers receive the
+// full block.
+func RelayBlock(g Gateway, b types.Block, peers []Peer) {
+	var compactPeers, fullPeers []Peer
+	for _, p := range peers {
+		if p.Features.Has(FeatureCompactBlocks) {
+			compactPeers = append(compactPeers, p)
+		} else {
+			fullPeers = append(fullPeers, p)
+		}
+	}
+	if len(compactPeers) > 0 {
+		g.Broadcast(RelayCompactBlockRPC, NewCompactBlock(b), compactPeers)
+	}
+	if len(fullPeers) > 0 {
+		g.Broadcast(RelayBlockRPC, b, fullPeers)
+	}
+}
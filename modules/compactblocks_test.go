@@ -0,0 +1,134 @@
+package modules
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/wisherd/Pis/encoding"
+	"github.com/wisherd/Pis/types"
+)
+
+// TestCompactBlockReconstruct checks that a compact block can be rebuilt from
+// a transaction pool and completed with the transactions that were missing.
+func TestCompactBlockReconstruct(t *testing.T) {
+	var txns []types.Transaction
+	for i := 0; i < 5; i++ {
+		txns = append(txns, types.Transaction{
+			ArbitraryData: [][]byte{{byte(i)}},
+		})
+	}
+	b := types.Block{
+		ParentID:     types.BlockID{1},
+		MinerPayouts: []types.PiscoinOutput{{Value: types.NewCurrency64(1)}},
+		Transactions: txns,
+	}
+	cb := NewCompactBlock(b)
+	if len(cb.ShortIDs) != len(txns) {
+		t.Fatal("wrong number of short ids")
+	}
+
+	// Every transaction is in the pool, in a different order, alongside
+	// unrelated transactions.
+	pool := []types.Transaction{txns[3], {ArbitraryData: [][]byte{{9}}}, txns[0], txns[4], txns[1], txns[2]}
+	partial, missing := cb.Reconstruct(pool)
+	if len(missing) != 0 {
+		t.Fatal("expected no missing transactions, got", missing)
+	}
+	full, err := cb.Complete(partial, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if full.ID() != b.ID() {
+		t.Fatal("reconstructed block has the wrong ID")
+	}
+
+	// Two transactions are missing from the pool.
+	partial, missing = cb.Reconstruct([]types.Transaction{txns[0], txns[2], txns[4]})
+	if len(missing) != 2 || missing[0] != 1 || missing[1] != 3 {
+		t.Fatal("wrong missing indices:", missing)
+	}
+	full, err = cb.Complete(partial, missing, []types.Transaction{txns[1], txns[3]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if full.ID() != b.ID() {
+		t.Fatal("completed block has the wrong ID")
+	}
+
+	// Supplying the wrong transactions results in a Merkle root mismatch.
+	partial, missing = cb.Reconstruct([]types.Transaction{txns[0], txns[2], txns[4]})
+	if _, err := cb.Complete(partial, missing, []types.Transaction{txns[3], txns[1]}); err != ErrBodyMismatch {
+		t.Fatal("expected ErrBodyMismatch, got", err)
+	}
+}
+
+// compactTestGateway is a Gateway that is connected to a single peer, whose
+// RPCs are handled by serve.
+type compactTestGateway struct {
+	Gateway
+	peer  Peer
+	serve RPCFunc
+}
+
+// Peers implements Gateway.
+func (g compactTestGateway) Peers() []Peer { return []Peer{g.peer} }
+
+// RPC implements Gateway.
+func (g compactTestGateway) RPC(addr NetAddress, name string, fn RPCFunc) error {
+	if addr != g.peer.NetAddress || name != SendCompactTransactionsRPC {
+		return errors.New("unexpected RPC " + name)
+	}
+	serveErr, err := callPipe(g.serve, fn)
+	if serveErr != nil {
+		return serveErr
+	}
+	return err
+}
+
+// compactTestPool is a TransactionPool that only lists transactions.
+type compactTestPool struct {
+	TransactionPool
+	txns []types.Transaction
+}
+
+// TransactionList implements TransactionPool.
+func (tp compactTestPool) TransactionList() []types.Transaction { return tp.txns }
+
+// TestReceiveCompactBlock checks that a relayed compact block is rebuilt from
+// the transaction pool and that missing transactions are fetched from the
+// relaying peer.
+func TestReceiveCompactBlock(t *testing.T) {
+	var txns []types.Transaction
+	for i := 0; i < 3; i++ {
+		txns = append(txns, types.Transaction{
+			ArbitraryData: [][]byte{{byte(i)}},
+		})
+	}
+	b := types.Block{
+		ParentID:     types.BlockID{1},
+		Transactions: txns,
+	}
+	g := compactTestGateway{
+		peer: Peer{NetAddress: pipeConn{}.RPCAddr(), Features: FeatureCompactBlocks},
+		serve: func(conn PeerConn) error {
+			return ServeCompactTransactions(conn, func(id types.BlockID) (types.Block, bool) {
+				return b, id == b.ID()
+			})
+		},
+	}
+	tp := compactTestPool{txns: []types.Transaction{txns[0], txns[2]}}
+
+	var received types.Block
+	serveErr, err := callPipe(func(conn PeerConn) error {
+		return encoding.WriteObject(conn, NewCompactBlock(b))
+	}, func(conn PeerConn) (err error) {
+		received, err = ReceiveCompactBlock(conn, g, tp)
+		return err
+	})
+	if serveErr != nil || err != nil {
+		t.Fatal(serveErr, err)
+	}
+	if received.ID() != b.ID() || len(received.Transactions) != len(txns) {
+		t.Fatal("received block does not match the relayed block")
+	}
+}
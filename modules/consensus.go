@@ -50,6 +50,14 @@ var (
 	// in a fork that is the heaviest known fork - the consensus set has not
 	// changed as a result of seeing the block.
 	ErrNonExtendingBlock = errors.New("block does not extend the longest fork")
+
+	// ErrNotInBlock is returned when a Merkle proof is requested for a
+	// transaction or miner payout that is not part of the block.
+	ErrNotInBlock = types.ErrNotInBlock
+
+	// ErrUnknownBlock is returned when a block is requested that is not part
+	// of the current path.
+	ErrUnknownBlock = errors.New("block is not in the current path")
 )
 
 type (
@@ -82,6 +90,12 @@ type (
 		// were reverted.
 		RevertedBlocks []types.Block
 
+		// RevertedPrunedHeaders contains the headers of reverted blocks whose
+		// bodies had been pruned. Pruned blocks are always deeper than the
+		// blocks in RevertedBlocks, so they are reverted after all of the
+		// RevertedBlocks, in the order presented.
+		RevertedPrunedHeaders []types.BlockHeader
+
 		// AppliedBlocks is the list of blocks that were applied by the change. The
 		// applied blocks are always all applied after all the reverted blocks were
 		// reverted. The applied blocks are presented in the order that they were
@@ -184,16 +198,29 @@ type (
 		// does not become the head of the heaviest known fork but is otherwise
 		// valid, it will be remembered by the consensus set but an error will
 		// still be returned.
+		//
+		// Orphans are held in an OrphanPool and retried once their parent is
+		// accepted, in which case ErrOrphan is returned. Blocks with a
+		// timestamp too far in the future are held in a FutureBlockQueue and
+		// retried once their timestamp is close enough to the current time,
+		// in which case ErrFutureTimestamp is returned.
 		AcceptBlock(types.Block) error
 
 		// BlockAtHeight returns the block found at the input height, with a
-		// bool to indicate whether that block exists.
+		// bool to indicate whether that block exists. False is also returned
+		// if the body of the block has been pruned; PrunedHeight and
+		// BlockHeaderByID can be used to tell the two cases apart.
 		BlockAtHeight(types.BlockHeight) (types.Block, bool)
 
 		// BlocksByID returns a block found for a given ID and its height, with
-		// a bool to indicate whether that block exists.
+		// a bool to indicate whether that block exists. False is also
+		// returned if the body of the block has been pruned.
 		BlockByID(types.BlockID) (types.Block, types.BlockHeight, bool)
 
+		// BlockHeaderByID returns the header and height of the block with the
+		// given ID. Headers are available even for pruned blocks.
+		BlockHeaderByID(types.BlockID) (types.BlockHeader, types.BlockHeight, bool)
+
 		// ChildTarget returns the target required to extend the current heaviest
 		// fork. This function is typically used by miners looking to extend the
 		// heaviest fork.
@@ -207,9 +234,17 @@ type (
 		// and gives them every consensus change that has occurred since the
 		// change with the provided id. There are a few special cases,
 		// described by the ConsensusChangeX variables in this package.
-		// A channel can be provided to abort the subscription process.
+		// A channel can be provided to abort the subscription process. On a
+		// pruned node, ErrPrunedHistory is returned if the requested changes
+		// include pruned blocks.
 		ConsensusSetSubscribe(ConsensusSetSubscriber, ConsensusChangeID, <-chan struct{}) error
 
+		// ConsensusSetFilteredSubscribe is like ConsensusSetSubscribe, but
+		// the subscriber only receives the diffs and transactions that match
+		// the provided filter. The headers of every block are still
+		// delivered.
+		ConsensusSetFilteredSubscribe(FilteredConsensusSetSubscriber, ConsensusChangeID, ConsensusChangeFilter, <-chan struct{}) error
+
 		// CurrentBlock returns the latest block in the heaviest known
 		// blockchain.
 		CurrentBlock() types.Block
@@ -218,12 +253,23 @@ type (
 		// routines.
 		Flush() error
 
+		// Forks returns the current path followed by the known side chains,
+		// heaviest first. The entry of the current path has Active set.
+		Forks() []ForkInfo
+
 		// Height returns the current height of consensus.
 		Height() types.BlockHeight
 
 		// Synced returns true if the consensus set is synced with the network.
+		// The consensus set is only considered synced once the heaviest known
+		// header chain has been downloaded and the bodies of all of its blocks
+		// have been accepted.
 		Synced() bool
 
+		// SyncProgress reports how far the header and block downloads of the
+		// initial blockchain synchronization have progressed.
+		SyncProgress() SyncProgress
+
 		// InCurrentPath returns true if the block id presented is found in the
 		// current path, false otherwise.
 		InCurrentPath(types.BlockID) bool
@@ -234,10 +280,44 @@ type (
 		// risk of mining invalid blocks.
 		MinimumValidChildTimestamp(types.BlockID) (types.Timestamp, bool)
 
+		// MinerPayoutProof returns a proof that the miner payout at the
+		// provided index is part of the block with the provided ID. The proof
+		// can be checked with types.VerifyMinerPayoutProof. ErrUnknownBlock
+		// and ErrNotInBlock are returned if the block or the payout does not
+		// exist.
+		MinerPayoutProof(types.BlockID, uint64) (types.MerkleInclusionProof, error)
+
+		// PrunedHeight returns the height below which the bodies of blocks
+		// have been pruned. Zero is returned if no blocks have been pruned.
+		PrunedHeight() types.BlockHeight
+
+		// RebuildState replaces the stored unspent outputs, file contracts,
+		// delayed outputs and pisfund pool with the provided state. It is used
+		// to repair the consensus database after a consistency check found a
+		// mismatch.
+		RebuildState(*ConsensusState) error
+
+		// Reorgs returns the most recent reorgs of the current path, oldest
+		// first. At most MaxReorgLogEntries reorgs are kept.
+		Reorgs() []ReorgEvent
+
+		// StoredState returns a copy of the unspent outputs, file contracts,
+		// delayed outputs and pisfund pool as stored in the consensus
+		// database.
+		StoredState() (*ConsensusState, error)
+
 		// StorageProofSegment returns the segment to be used in the storage proof for
 		// a given file contract.
 		StorageProofSegment(types.FileContractID) (uint64, error)
 
+		// TransactionProof returns a proof that the transaction with the
+		// provided ID is part of the block with the provided ID. The proof can
+		// be checked with types.VerifyTransactionProof. ErrBlockPruned is
+		// returned if the body of the block has been pruned, and
+		// ErrUnknownBlock or ErrNotInBlock if the block or the transaction
+		// does not exist.
+		TransactionProof(types.BlockID, types.TransactionID) (types.MerkleInclusionProof, error)
+
 		// TryTransactionSet checks whether the transaction set would be valid if
 		// it were added in the next block. A consensus change is returned
 		// detailing the diffs that would result from the application of the
@@ -248,6 +328,10 @@ type (
 		// allowing for garbage collection and rescanning. If the subscriber is
 		// not found in the subscriber database, no action is taken.
 		Unsubscribe(ConsensusSetSubscriber)
+
+		// UnsubscribeFiltered removes a subscriber that was added with
+		// ConsensusSetFilteredSubscribe.
+		UnsubscribeFiltered(FilteredConsensusSetSubscriber)
 	}
 )
 
@@ -258,6 +342,7 @@ type (
 func (cc ConsensusChange) Append(cc2 ConsensusChange) ConsensusChange {
 	return ConsensusChange{
 		RevertedBlocks:            append(cc.RevertedBlocks, cc2.RevertedBlocks...),
+		RevertedPrunedHeaders:     append(cc.RevertedPrunedHeaders, cc2.RevertedPrunedHeaders...),
 		AppliedBlocks:             append(cc.AppliedBlocks, cc2.AppliedBlocks...),
 		PiscoinOutputDiffs:        append(cc.PiscoinOutputDiffs, cc2.PiscoinOutputDiffs...),
 		FileContractDiffs:         append(cc.FileContractDiffs, cc2.FileContractDiffs...),
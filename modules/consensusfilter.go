@@ -0,0 +1,246 @@
+package modules
+
+import (
+	"github.com/wisherd/Pis/types"
+)
+
+type (
+	// A ConsensusChangeFilter selects the parts of a ConsensusChange that a
+	// subscriber is interested in. A diff or transaction matches the filter if
+	// it involves any of the unlock hashes, output IDs or file contract IDs in
+	// the filter. The filter must not be modified after it has been passed to
+	// the consensus set.
+	ConsensusChangeFilter struct {
+		UnlockHashes     map[types.UnlockHash]struct{}
+		PiscoinOutputIDs map[types.PiscoinOutputID]struct{}
+		PisfundOutputIDs map[types.PisfundOutputID]struct{}
+		FileContractIDs  map[types.FileContractID]struct{}
+	}
+
+	// A FilteredConsensusChange is a ConsensusChange that has been reduced to
+	// the diffs and transactions matching a ConsensusChangeFilter. The headers
+	// of every reverted and applied block are kept so that subscribers can
+	// track the tip of the chain.
+	FilteredConsensusChange struct {
+		// ID is the ID of the unfiltered consensus change.
+		ID ConsensusChangeID
+
+		// RevertedHeaders contains the headers of the reverted blocks,
+		// including the blocks whose bodies had been pruned, in the order
+		// that they were reverted.
+		RevertedHeaders []types.BlockHeader
+
+		// AppliedHeaders contains the headers of the applied blocks, in the
+		// order that they were applied.
+		AppliedHeaders []types.BlockHeader
+
+		// RevertedTransactions and AppliedTransactions contain the matching
+		// transactions of the reverted and applied blocks, in block order.
+		RevertedTransactions []types.Transaction
+		AppliedTransactions  []types.Transaction
+
+		PiscoinOutputDiffs        []PiscoinOutputDiff
+		FileContractDiffs         []FileContractDiff
+		PisfundOutputDiffs        []PisfundOutputDiff
+		DelayedPiscoinOutputDiffs []DelayedPiscoinOutputDiff
+
+		ChildTarget                types.Target
+		MinimumValidChildTimestamp types.Timestamp
+		Synced                     bool
+	}
+
+	// A FilteredConsensusSetSubscriber receives the consensus changes that
+	// match the filter it subscribed with.
+	FilteredConsensusSetSubscriber interface {
+		// ProcessFilteredConsensusChange is called with every consensus
+		// change, even if nothing in the change matched the filter, so that
+		// the subscriber always learns about new blocks.
+		ProcessFilteredConsensusChange(FilteredConsensusChange)
+	}
+
+	// filteredSubscriber adapts a FilteredConsensusSetSubscriber to the
+	// ConsensusSetSubscriber interface.
+	filteredSubscriber struct {
+		filter ConsensusChangeFilter
+		sub    FilteredConsensusSetSubscriber
+	}
+)
+
+// NewConsensusChangeFilter returns a filter that matches the provided unlock
+// hashes.
+func NewConsensusChangeFilter(uhs ...types.UnlockHash) ConsensusChangeFilter {
+	f := ConsensusChangeFilter{
+		UnlockHashes:     make(map[types.UnlockHash]struct{}),
+		PiscoinOutputIDs: make(map[types.PiscoinOutputID]struct{}),
+		PisfundOutputIDs: make(map[types.PisfundOutputID]struct{}),
+		FileContractIDs:  make(map[types.FileContractID]struct{}),
+	}
+	for _, uh := range uhs {
+		f.UnlockHashes[uh] = struct{}{}
+	}
+	return f
+}
+
+// hasUnlockHash returns true if uh is in the filter.
+func (f ConsensusChangeFilter) hasUnlockHash(uh types.UnlockHash) bool {
+	_, exists := f.UnlockHashes[uh]
+	return exists
+}
+
+// hasPiscoinOutput returns true if the piscoin output matches the filter.
+func (f ConsensusChangeFilter) hasPiscoinOutput(id types.PiscoinOutputID, sco types.PiscoinOutput) bool {
+	_, exists := f.PiscoinOutputIDs[id]
+	return exists || f.hasUnlockHash(sco.UnlockHash)
+}
+
+// hasPisfundOutput returns true if the pisfund output matches the filter.
+func (f ConsensusChangeFilter) hasPisfundOutput(id types.PisfundOutputID, sfo types.PisfundOutput) bool {
+	_, exists := f.PisfundOutputIDs[id]
+	return exists || f.hasUnlockHash(sfo.UnlockHash)
+}
+
+// hasFileContract returns true if the file contract matches the filter. A
+// contract matches if its ID is in the filter or if any of its unlock hashes,
+// including those of its proof outputs, is in the filter.
+func (f ConsensusChangeFilter) hasFileContract(id types.FileContractID, fc types.FileContract) bool {
+	if _, exists := f.FileContractIDs[id]; exists {
+		return true
+	}
+	if f.hasUnlockHash(fc.UnlockHash) {
+		return true
+	}
+	for _, sco := range fc.ValidProofOutputs {
+		if f.hasUnlockHash(sco.UnlockHash) {
+			return true
+		}
+	}
+	for _, sco := range fc.MissedProofOutputs {
+		if f.hasUnlockHash(sco.UnlockHash) {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchTransaction returns true if any input, output, file contract, file
+// contract revision or storage proof of txn matches the filter.
+func (f ConsensusChangeFilter) MatchTransaction(txn types.Transaction) bool {
+	for _, sci := range txn.PiscoinInputs {
+		if _, exists := f.PiscoinOutputIDs[sci.ParentID]; exists {
+			return true
+		}
+		if f.hasUnlockHash(sci.UnlockConditions.UnlockHash()) {
+			return true
+		}
+	}
+	for i, sco := range txn.PiscoinOutputs {
+		if f.hasPiscoinOutput(txn.PiscoinOutputID(uint64(i)), sco) {
+			return true
+		}
+	}
+	for i, fc := range txn.FileContracts {
+		if f.hasFileContract(txn.FileContractID(uint64(i)), fc) {
+			return true
+		}
+	}
+	for _, fcr := range txn.FileContractRevisions {
+		if _, exists := f.FileContractIDs[fcr.ParentID]; exists {
+			return true
+		}
+		if f.hasUnlockHash(fcr.UnlockConditions.UnlockHash()) || f.hasUnlockHash(fcr.NewUnlockHash) {
+			return true
+		}
+	}
+	for _, sp := range txn.StorageProofs {
+		if _, exists := f.FileContractIDs[sp.ParentID]; exists {
+			return true
+		}
+	}
+	for _, sfi := range txn.PisfundInputs {
+		if _, exists := f.PisfundOutputIDs[sfi.ParentID]; exists {
+			return true
+		}
+		if f.hasUnlockHash(sfi.UnlockConditions.UnlockHash()) || f.hasUnlockHash(sfi.ClaimUnlockHash) {
+			return true
+		}
+	}
+	for i, sfo := range txn.PisfundOutputs {
+		if f.hasPisfundOutput(txn.PisfundOutputID(uint64(i)), sfo) {
+			return true
+		}
+	}
+	return false
+}
+
+// filterTransactions returns the transactions of the blocks that match the
+// filter.
+func (f ConsensusChangeFilter) filterTransactions(blocks []types.Block) []types.Transaction {
+	var txns []types.Transaction
+	for _, b := range blocks {
+		for _, txn := range b.Transactions {
+			if f.MatchTransaction(txn) {
+				txns = append(txns, txn)
+			}
+		}
+	}
+	return txns
+}
+
+// Filter reduces cc to the diffs and transactions that match the filter.
+// The headers of all reverted and applied blocks are always included.
+// PisfundPoolDiffs are not associated with any address and are dropped.
+func (f ConsensusChangeFilter) Filter(cc ConsensusChange) FilteredConsensusChange {
+	fcc := FilteredConsensusChange{
+		ID:                         cc.ID,
+		RevertedTransactions:       f.filterTransactions(cc.RevertedBlocks),
+		AppliedTransactions:        f.filterTransactions(cc.AppliedBlocks),
+		ChildTarget:                cc.ChildTarget,
+		MinimumValidChildTimestamp: cc.MinimumValidChildTimestamp,
+		Synced:                     cc.Synced,
+	}
+	for _, b := range cc.RevertedBlocks {
+		fcc.RevertedHeaders = append(fcc.RevertedHeaders, b.Header())
+	}
+	fcc.RevertedHeaders = append(fcc.RevertedHeaders, cc.RevertedPrunedHeaders...)
+	for _, b := range cc.AppliedBlocks {
+		fcc.AppliedHeaders = append(fcc.AppliedHeaders, b.Header())
+	}
+
+	for _, diff := range cc.PiscoinOutputDiffs {
+		if f.hasPiscoinOutput(diff.ID, diff.PiscoinOutput) {
+			fcc.PiscoinOutputDiffs = append(fcc.PiscoinOutputDiffs, diff)
+		}
+	}
+	for _, diff := range cc.FileContractDiffs {
+		if f.hasFileContract(diff.ID, diff.FileContract) {
+			fcc.FileContractDiffs = append(fcc.FileContractDiffs, diff)
+		}
+	}
+	for _, diff := range cc.PisfundOutputDiffs {
+		if f.hasPisfundOutput(diff.ID, diff.PisfundOutput) {
+			fcc.PisfundOutputDiffs = append(fcc.PisfundOutputDiffs, diff)
+		}
+	}
+	for _, diff := range cc.DelayedPiscoinOutputDiffs {
+		if f.hasPiscoinOutput(diff.ID, diff.PiscoinOutput) {
+			fcc.DelayedPiscoinOutputDiffs = append(fcc.DelayedPiscoinOutputDiffs, diff)
+		}
+	}
+	return fcc
+}
+
+// NewFilteredSubscriber wraps sub in a ConsensusSetSubscriber that passes
+// every consensus change through the filter before handing it to sub.
+// Consensus sets can use it to implement ConsensusSetFilteredSubscribe on top
+// of their regular subscriber list.
+func NewFilteredSubscriber(f ConsensusChangeFilter, sub FilteredConsensusSetSubscriber) ConsensusSetSubscriber {
+	return &filteredSubscriber{
+		filter: f,
+		sub:    sub,
+	}
+}
+
+// ProcessConsensusChange implements ConsensusSetSubscriber.
+func (fs *filteredSubscriber) ProcessConsensusChange(cc ConsensusChange) {
+	fs.sub.ProcessFilteredConsensusChange(fs.filter.Filter(cc))
+}
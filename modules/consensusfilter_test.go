@@ -0,0 +1,92 @@
+package modules
+
+import (
+	"testing"
+
+	"github.com/wisherd/Pis/types"
+)
+
+// filterRecorder is a FilteredConsensusSetSubscriber that records every
+// change it receives.
+type filterRecorder struct {
+	changes []FilteredConsensusChange
+}
+
+func (fr *filterRecorder) ProcessFilteredConsensusChange(fcc FilteredConsensusChange) {
+	fr.changes = append(fr.changes, fcc)
+}
+
+// TestConsensusChangeFilter checks that only matching diffs and transactions
+// pass the filter and that all headers are kept.
+func TestConsensusChangeFilter(t *testing.T) {
+	ours := types.UnlockConditions{SignaturesRequired: 1}.UnlockHash()
+	theirs := types.UnlockHash{2}
+
+	incoming := types.Transaction{
+		PiscoinOutputs: []types.PiscoinOutput{{UnlockHash: ours, Value: types.NewCurrency64(1)}},
+	}
+	unrelated := types.Transaction{
+		PiscoinOutputs: []types.PiscoinOutput{{UnlockHash: theirs, Value: types.NewCurrency64(2)}},
+	}
+	outgoing := types.Transaction{
+		PiscoinInputs: []types.PiscoinInput{{UnlockConditions: types.UnlockConditions{SignaturesRequired: 1}}},
+	}
+	proof := types.Transaction{
+		StorageProofs: []types.StorageProof{{ParentID: types.FileContractID{7}}},
+	}
+	b1 := types.Block{Transactions: []types.Transaction{incoming, unrelated}}
+	b2 := types.Block{ParentID: b1.ID(), Transactions: []types.Transaction{outgoing, proof}}
+
+	cc := ConsensusChange{
+		RevertedPrunedHeaders: []types.BlockHeader{{Nonce: types.BlockNonce{1}}},
+		AppliedBlocks:         []types.Block{b1, b2},
+		PiscoinOutputDiffs: []PiscoinOutputDiff{
+			{Direction: DiffApply, ID: incoming.PiscoinOutputID(0), PiscoinOutput: incoming.PiscoinOutputs[0]},
+			{Direction: DiffApply, ID: unrelated.PiscoinOutputID(0), PiscoinOutput: unrelated.PiscoinOutputs[0]},
+		},
+		FileContractDiffs: []FileContractDiff{
+			{Direction: DiffRevert, ID: types.FileContractID{7}},
+			{Direction: DiffApply, ID: types.FileContractID{8}},
+		},
+		PisfundPoolDiffs: []PisfundPoolDiff{{Direction: DiffApply}},
+		Synced:           true,
+	}
+
+	f := NewConsensusChangeFilter(ours)
+	f.FileContractIDs[types.FileContractID{7}] = struct{}{}
+
+	var fr filterRecorder
+	NewFilteredSubscriber(f, &fr).ProcessConsensusChange(cc)
+	if len(fr.changes) != 1 {
+		t.Fatal("expected one change, got", len(fr.changes))
+	}
+	fcc := fr.changes[0]
+
+	if len(fcc.AppliedHeaders) != 2 || fcc.AppliedHeaders[1].ID() != b2.ID() {
+		t.Error("applied headers were not kept")
+	}
+	if len(fcc.RevertedHeaders) != 1 || fcc.RevertedHeaders[0] != cc.RevertedPrunedHeaders[0] {
+		t.Error("pruned headers were not kept")
+	}
+	if len(fcc.AppliedTransactions) != 3 {
+		t.Fatal("expected 3 matching transactions, got", len(fcc.AppliedTransactions))
+	}
+	if fcc.AppliedTransactions[0].ID() != incoming.ID() || fcc.AppliedTransactions[1].ID() != outgoing.ID() || fcc.AppliedTransactions[2].ID() != proof.ID() {
+		t.Error("wrong transactions passed the filter")
+	}
+	if len(fcc.PiscoinOutputDiffs) != 1 || fcc.PiscoinOutputDiffs[0].ID != incoming.PiscoinOutputID(0) {
+		t.Error("wrong piscoin output diffs passed the filter")
+	}
+	if len(fcc.FileContractDiffs) != 1 || fcc.FileContractDiffs[0].ID != (types.FileContractID{7}) {
+		t.Error("wrong file contract diffs passed the filter")
+	}
+	if !fcc.Synced || fcc.ID != cc.ID {
+		t.Error("metadata of the change was not kept")
+	}
+
+	// An empty filter still delivers the headers.
+	fcc = NewConsensusChangeFilter().Filter(cc)
+	if len(fcc.AppliedHeaders) != 2 || len(fcc.AppliedTransactions) != 0 || len(fcc.PiscoinOutputDiffs) != 0 {
+		t.Error("empty filter should only deliver headers")
+	}
+}
@@ -0,0 +1,292 @@
+package modules
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/wisherd/Pis/types"
+)
+
+var (
+	// ErrInconsistentDiff is returned when a diff cannot be applied to a
+	// ConsensusState, for example because it removes an output that does
+	// not exist.
+	ErrInconsistentDiff = errors.New("diff is inconsistent with the consensus state")
+)
+
+type (
+	// A ConsensusState holds the parts of the consensus state that are
+	// affected by diffs: the unspent piscoin and pisfund outputs, the open
+	// file contracts, the delayed piscoin outputs and the pisfund pool.
+	ConsensusState struct {
+		Height                types.BlockHeight
+		PiscoinOutputs        map[types.PiscoinOutputID]types.PiscoinOutput
+		PisfundOutputs        map[types.PisfundOutputID]types.PisfundOutput
+		FileContracts         map[types.FileContractID]types.FileContract
+		DelayedPiscoinOutputs map[types.BlockHeight]map[types.PiscoinOutputID]types.PiscoinOutput
+		PisfundPool           types.Currency
+
+		// pathLength is the number of blocks in the current path, which is
+		// used to track the height while the state is rebuilt from consensus
+		// changes. applyErr is the first error encountered while doing so.
+		pathLength uint64
+		applyErr   error
+	}
+
+	// A ConsistencyReport describes the result of a consistency check of the
+	// consensus set.
+	ConsistencyReport struct {
+		Height     types.BlockHeight `json:"height"`
+		Full       bool              `json:"full"`
+		Consistent bool              `json:"consistent"`
+		Mismatches []string          `json:"mismatches"`
+		Repaired   bool              `json:"repaired"`
+	}
+)
+
+// NewConsensusState returns an empty ConsensusState.
+func NewConsensusState() *ConsensusState {
+	return &ConsensusState{
+		PiscoinOutputs:        make(map[types.PiscoinOutputID]types.PiscoinOutput),
+		PisfundOutputs:        make(map[types.PisfundOutputID]types.PisfundOutput),
+		FileContracts:         make(map[types.FileContractID]types.FileContract),
+		DelayedPiscoinOutputs: make(map[types.BlockHeight]map[types.PiscoinOutputID]types.PiscoinOutput),
+	}
+}
+
+// Apply applies the diffs of a consensus change to the state. An error is
+// returned if a diff creates an object that already exists or removes an
+// object that does not exist.
+func (s *ConsensusState) Apply(cc ConsensusChange) error {
+	s.pathLength -= uint64(len(cc.RevertedBlocks) + len(cc.RevertedPrunedHeaders))
+	s.pathLength += uint64(len(cc.AppliedBlocks))
+	if s.pathLength > 0 {
+		s.Height = types.BlockHeight(s.pathLength - 1)
+	}
+
+	for _, diff := range cc.PiscoinOutputDiffs {
+		_, exists := s.PiscoinOutputs[diff.ID]
+		if exists == (diff.Direction == DiffApply) {
+			return fmt.Errorf("%v: piscoin output %v", ErrInconsistentDiff, diff.ID)
+		}
+		if diff.Direction == DiffApply {
+			s.PiscoinOutputs[diff.ID] = diff.PiscoinOutput
+		} else {
+			delete(s.PiscoinOutputs, diff.ID)
+		}
+	}
+	for _, diff := range cc.FileContractDiffs {
+		_, exists := s.FileContracts[diff.ID]
+		if exists == (diff.Direction == DiffApply) {
+			return fmt.Errorf("%v: file contract %v", ErrInconsistentDiff, diff.ID)
+		}
+		if diff.Direction == DiffApply {
+			s.FileContracts[diff.ID] = diff.FileContract
+		} else {
+			delete(s.FileContracts, diff.ID)
+		}
+	}
+	for _, diff := range cc.PisfundOutputDiffs {
+		_, exists := s.PisfundOutputs[diff.ID]
+		if exists == (diff.Direction == DiffApply) {
+			return fmt.Errorf("%v: pisfund output %v", ErrInconsistentDiff, diff.ID)
+		}
+		if diff.Direction == DiffApply {
+			s.PisfundOutputs[diff.ID] = diff.PisfundOutput
+		} else {
+			delete(s.PisfundOutputs, diff.ID)
+		}
+	}
+	for _, diff := range cc.DelayedPiscoinOutputDiffs {
+		dscos := s.DelayedPiscoinOutputs[diff.MaturityHeight]
+		_, exists := dscos[diff.ID]
+		if exists == (diff.Direction == DiffApply) {
+			return fmt.Errorf("%v: delayed piscoin output %v", ErrInconsistentDiff, diff.ID)
+		}
+		if diff.Direction == DiffApply {
+			if dscos == nil {
+				dscos = make(map[types.PiscoinOutputID]types.PiscoinOutput)
+				s.DelayedPiscoinOutputs[diff.MaturityHeight] = dscos
+			}
+			dscos[diff.ID] = diff.PiscoinOutput
+		} else {
+			delete(dscos, diff.ID)
+			if len(dscos) == 0 {
+				delete(s.DelayedPiscoinOutputs, diff.MaturityHeight)
+			}
+		}
+	}
+	for _, diff := range cc.PisfundPoolDiffs {
+		if diff.Direction == DiffApply {
+			s.PisfundPool = diff.Adjusted
+		} else {
+			s.PisfundPool = diff.Previous
+		}
+	}
+	return nil
+}
+
+// ProcessConsensusChange implements ConsensusSetSubscriber, allowing a
+// ConsensusState to be rebuilt by subscribing to the consensus set from
+// ConsensusChangeBeginning. Once a change fails to apply, all further changes
+// are ignored and the error is reported by Err.
+func (s *ConsensusState) ProcessConsensusChange(cc ConsensusChange) {
+	if s.applyErr != nil {
+		return
+	}
+	s.applyErr = s.Apply(cc)
+}
+
+// Err returns the first error that occurred while processing consensus
+// changes.
+func (s *ConsensusState) Err() error {
+	return s.applyErr
+}
+
+// CheckInvariants performs the inexpensive checks that can be done on a
+// single ConsensusState: the number of pisfunds must be constant, the number
+// of piscoins must match the coinbase schedule, and delayed outputs must not
+// have matured yet. A description of every violated invariant is returned.
+func (s *ConsensusState) CheckInvariants() []string {
+	var mismatches []string
+
+	// Check the pisfund count.
+	pisfunds := types.ZeroCurrency
+	for _, sfo := range s.PisfundOutputs {
+		pisfunds = pisfunds.Add(sfo.Value)
+	}
+	if !pisfunds.Equals(types.PisfundCount) {
+		mismatches = append(mismatches, fmt.Sprintf("expected %v pisfunds, found %v", types.PisfundCount, pisfunds))
+	}
+
+	// Check the piscoin count. Unclaimed pisfund dividends are counted as
+	// well; each claim is rounded down, so the total may fall short by up to
+	// one hasting per pisfund output.
+	piscoins := types.ZeroCurrency
+	for _, sco := range s.PiscoinOutputs {
+		piscoins = piscoins.Add(sco.Value)
+	}
+	for height, dscos := range s.DelayedPiscoinOutputs {
+		if height <= s.Height {
+			mismatches = append(mismatches, fmt.Sprintf("delayed outputs with maturity height %v have not matured at height %v", height, s.Height))
+		}
+		for _, dsco := range dscos {
+			piscoins = piscoins.Add(dsco.Value)
+		}
+	}
+	for _, fc := range s.FileContracts {
+		for _, output := range fc.ValidProofOutputs {
+			piscoins = piscoins.Add(output.Value)
+		}
+	}
+	for _, sfo := range s.PisfundOutputs {
+		if s.PisfundPool.Cmp(sfo.ClaimStart) < 0 {
+			mismatches = append(mismatches, "pisfund output has a claim start above the pisfund pool")
+			continue
+		}
+		claim := s.PisfundPool.Sub(sfo.ClaimStart).Mul(sfo.Value).Div(types.PisfundCount)
+		piscoins = piscoins.Add(claim)
+	}
+	expected := types.CalculateNumPiscoins(s.Height)
+	tolerance := types.NewCurrency64(uint64(len(s.PisfundOutputs)))
+	if piscoins.Cmp(expected) > 0 || expected.Sub(piscoins).Cmp(tolerance) > 0 {
+		mismatches = append(mismatches, fmt.Sprintf("expected %v piscoins at height %v, found %v", expected, s.Height, piscoins))
+	}
+	return mismatches
+}
+
+// Compare returns a description of every difference between s and other.
+func (s *ConsensusState) Compare(other *ConsensusState) []string {
+	var mismatches []string
+	if s.Height != other.Height {
+		mismatches = append(mismatches, fmt.Sprintf("height: %v != %v", s.Height, other.Height))
+	}
+	if !s.PisfundPool.Equals(other.PisfundPool) {
+		mismatches = append(mismatches, fmt.Sprintf("pisfund pool: %v != %v", s.PisfundPool, other.PisfundPool))
+	}
+	for id, sco := range s.PiscoinOutputs {
+		if osco, exists := other.PiscoinOutputs[id]; !exists || !osco.Value.Equals(sco.Value) || osco.UnlockHash != sco.UnlockHash {
+			mismatches = append(mismatches, fmt.Sprintf("piscoin output %v differs", id))
+		}
+	}
+	for id := range other.PiscoinOutputs {
+		if _, exists := s.PiscoinOutputs[id]; !exists {
+			mismatches = append(mismatches, fmt.Sprintf("unexpected piscoin output %v", id))
+		}
+	}
+	for id, sfo := range s.PisfundOutputs {
+		if osfo, exists := other.PisfundOutputs[id]; !exists || !osfo.Value.Equals(sfo.Value) || osfo.UnlockHash != sfo.UnlockHash || !osfo.ClaimStart.Equals(sfo.ClaimStart) {
+			mismatches = append(mismatches, fmt.Sprintf("pisfund output %v differs", id))
+		}
+	}
+	for id := range other.PisfundOutputs {
+		if _, exists := s.PisfundOutputs[id]; !exists {
+			mismatches = append(mismatches, fmt.Sprintf("unexpected pisfund output %v", id))
+		}
+	}
+	for id, fc := range s.FileContracts {
+		if ofc, exists := other.FileContracts[id]; !exists || ofc.FileMerkleRoot != fc.FileMerkleRoot || ofc.RevisionNumber != fc.RevisionNumber || !ofc.Payout.Equals(fc.Payout) {
+			mismatches = append(mismatches, fmt.Sprintf("file contract %v differs", id))
+		}
+	}
+	for id := range other.FileContracts {
+		if _, exists := s.FileContracts[id]; !exists {
+			mismatches = append(mismatches, fmt.Sprintf("unexpected file contract %v", id))
+		}
+	}
+	for height, dscos := range s.DelayedPiscoinOutputs {
+		for id, dsco := range dscos {
+			if odsco, exists := other.DelayedPiscoinOutputs[height][id]; !exists || !odsco.Value.Equals(dsco.Value) || odsco.UnlockHash != dsco.UnlockHash {
+				mismatches = append(mismatches, fmt.Sprintf("delayed piscoin output %v differs", id))
+			}
+		}
+	}
+	for height, dscos := range other.DelayedPiscoinOutputs {
+		for id := range dscos {
+			if _, exists := s.DelayedPiscoinOutputs[height][id]; !exists {
+				mismatches = append(mismatches, fmt.Sprintf("unexpected delayed piscoin output %v", id))
+			}
+		}
+	}
+	return mismatches
+}
+
+// CheckConsensusConsistency checks the state stored by the consensus set. The
+// light check only verifies the invariants of the stored state. The full
+// check also rebuilds the state from the diffs of every stored block and
+// compares the result with the stored state. If repair is set and the full
+// check found a mismatch, the stored state is replaced with the rebuilt one.
+func CheckConsensusConsistency(cs ConsensusSet, full, repair bool) (ConsistencyReport, error) {
+	stored, err := cs.StoredState()
+	if err != nil {
+		return ConsistencyReport{}, err
+	}
+	report := ConsistencyReport{
+		Height:     stored.Height,
+		Full:       full,
+		Mismatches: stored.CheckInvariants(),
+	}
+	if full {
+		rebuilt := NewConsensusState()
+		err := cs.ConsensusSetSubscribe(rebuilt, ConsensusChangeBeginning, nil)
+		cs.Unsubscribe(rebuilt)
+		if err != nil {
+			return ConsistencyReport{}, err
+		}
+		if err := rebuilt.Err(); err != nil {
+			return ConsistencyReport{}, fmt.Errorf("stored blocks contain inconsistent diffs, the consensus database must be rebuilt from scratch: %v", err)
+		}
+		report.Mismatches = append(report.Mismatches, rebuilt.Compare(stored)...)
+		if repair && len(report.Mismatches) > 0 {
+			if err := cs.RebuildState(rebuilt); err != nil {
+				return report, err
+			}
+			report.Repaired = true
+		}
+	}
+	report.Consistent = len(report.Mismatches) == 0
+	if report.Mismatches == nil {
+		report.Mismatches = make([]string, 0)
+	}
+	return report, nil
+}
@@ -0,0 +1,52 @@
+package modules
+
+import (
+	"testing"
+
+	"github.com/wisherd/Pis/types"
+)
+
+// TestConsensusStateApply checks that consensus changes are applied and
+// reverted correctly, and that inconsistent diffs are detected.
+func TestConsensusStateApply(t *testing.T) {
+	sco := types.PiscoinOutput{Value: types.NewCurrency64(5)}
+	apply := ConsensusChange{
+		AppliedBlocks: []types.Block{{}, {}},
+		PiscoinOutputDiffs: []PiscoinOutputDiff{
+			{Direction: DiffApply, ID: types.PiscoinOutputID{1}, PiscoinOutput: sco},
+		},
+		DelayedPiscoinOutputDiffs: []DelayedPiscoinOutputDiff{
+			{Direction: DiffApply, ID: types.PiscoinOutputID{2}, PiscoinOutput: sco, MaturityHeight: 10},
+		},
+		PisfundPoolDiffs: []PisfundPoolDiff{
+			{Direction: DiffApply, Previous: types.ZeroCurrency, Adjusted: types.NewCurrency64(3)},
+		},
+	}
+	s := NewConsensusState()
+	s.ProcessConsensusChange(apply)
+	if err := s.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if s.Height != 1 || len(s.PiscoinOutputs) != 1 || len(s.DelayedPiscoinOutputs[10]) != 1 || !s.PisfundPool.Equals64(3) {
+		t.Fatal("change was not applied correctly:", s)
+	}
+
+	// Compare against a state that is missing the output.
+	other := NewConsensusState()
+	other.Height = 1
+	other.PisfundPool = types.NewCurrency64(3)
+	other.DelayedPiscoinOutputs[10] = map[types.PiscoinOutputID]types.PiscoinOutput{{2}: sco}
+	if mismatches := s.Compare(other); len(mismatches) != 1 {
+		t.Fatal("expected a single mismatch, got", mismatches)
+	}
+	other.PiscoinOutputs[types.PiscoinOutputID{1}] = sco
+	if mismatches := s.Compare(other); len(mismatches) != 0 {
+		t.Fatal("expected no mismatches, got", mismatches)
+	}
+
+	// Applying the same change twice is inconsistent.
+	s.ProcessConsensusChange(apply)
+	if s.Err() == nil {
+		t.Fatal("inconsistent diff was not detected")
+	}
+}
@@ -41,7 +41,8 @@ type (
 		Destruct()
 
 		// DialTimeout tries to create a tcp connection to the specified
-		// address with a certain timeout.
+		// address with a certain timeout. In production, the connection is
+		// subject to the global and per-peer bandwidth limits.
 		DialTimeout(NetAddress, time.Duration) (net.Conn, error)
 
 		// Disrupt can be inserted in the code as a way to inject problems,
@@ -51,6 +52,8 @@ type (
 		Disrupt(string) bool
 
 		// Listen gives the host the ability to receive incoming connections.
+		// In production, accepted connections are subject to the global and
+		// per-peer bandwidth limits.
 		Listen(string, string) (net.Listener, error)
 
 		// LoadFile allows the host to load a persistence structure form disk.
@@ -208,9 +211,13 @@ func (pd *ProductionDependencies) Destruct() {
 }
 
 // DialTimeout creates a tcp connection to a certain address with the specified
-// timeout.
+// timeout. The connection obeys the global and per-peer bandwidth limits.
 func (*ProductionDependencies) DialTimeout(addr NetAddress, timeout time.Duration) (net.Conn, error) {
-	return net.DialTimeout("tcp", string(addr), timeout)
+	conn, err := net.DialTimeout("tcp", string(addr), timeout)
+	if err != nil {
+		return nil, err
+	}
+	return NewRLConn(conn, GlobalRateLimits, PeerRateLimits.Fork()), nil
 }
 
 // Disrupt can be used to inject specific behavior into a module by overwriting
@@ -219,9 +226,14 @@ func (*ProductionDependencies) Disrupt(string) bool {
 	return false
 }
 
-// Listen gives the host the ability to receive incoming connections.
+// Listen gives the host the ability to receive incoming connections. Accepted
+// connections obey the global and per-peer bandwidth limits.
 func (*ProductionDependencies) Listen(s1, s2 string) (net.Listener, error) {
-	return net.Listen(s1, s2)
+	l, err := net.Listen(s1, s2)
+	if err != nil {
+		return nil, err
+	}
+	return rlListener{l}, nil
 }
 
 // LoadFile loads JSON encoded data from a file.
@@ -1,6 +1,8 @@
 package modules
 
 import (
+	"errors"
+
 	"github.com/wisherd/Pis/types"
 )
 
@@ -10,6 +12,12 @@ const (
 	ExplorerDir = "explorer"
 )
 
+var (
+	// ErrUnknownTransaction is returned when a transaction is requested that
+	// is not part of the consensus set.
+	ErrUnknownTransaction = errors.New("transaction not found")
+)
+
 type (
 	// BlockFacts returns a bunch of statistics about the consensus set as they
 	// were at a specific block.
@@ -67,6 +75,12 @@ type (
 		// consensus set.
 		Transaction(types.TransactionID) (types.Block, types.BlockHeight, bool)
 
+		// TransactionProof returns a proof that the transaction with the
+		// provided id is part of the block that contains it, along with the
+		// height of that block. ErrUnknownTransaction is returned if the
+		// transaction is not found in the consensus set.
+		TransactionProof(types.TransactionID) (types.MerkleInclusionProof, types.BlockHeight, error)
+
 		// UnlockHash returns all of the transaction ids associated with the
 		// provided unlock hash.
 		UnlockHash(types.UnlockHash) []types.TransactionID
@@ -0,0 +1,200 @@
+package modules
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/wisherd/Pis/build"
+	"github.com/wisherd/Pis/types"
+)
+
+var (
+	// MaxTrackedForks is the maximum number of side chains that are tracked
+	// by a ForkTracker. When the limit is reached, the fork that was seen
+	// first is forgotten.
+	MaxTrackedForks = build.Select(build.Var{
+		Standard: int(100),
+		Dev:      int(50),
+		Testing:  int(5),
+	}).(int)
+
+	// MaxReorgLogEntries is the maximum number of reorgs that are kept in
+	// the reorg log of a ForkTracker.
+	MaxReorgLogEntries = build.Select(build.Var{
+		Standard: int(1000),
+		Dev:      int(100),
+		Testing:  int(5),
+	}).(int)
+)
+
+type (
+	// A ForkInfo describes a chain of blocks. Depth is the cumulative
+	// target of the chain, computed with Target.AddDifficulties; a lower
+	// depth means that more work went into the chain. The fork point is the
+	// last block that the chain shares with the current path.
+	ForkInfo struct {
+		TipID                types.BlockID     `json:"tipid"`
+		TipHeight            types.BlockHeight `json:"tipheight"`
+		Depth                types.Target      `json:"-"`
+		CumulativeDifficulty types.Currency    `json:"cumulativedifficulty"`
+		ForkPointID          types.BlockID     `json:"forkpointid"`
+		ForkPointHeight      types.BlockHeight `json:"forkpointheight"`
+		FirstSeen            types.Timestamp   `json:"firstseen"`
+		Active               bool              `json:"active"`
+	}
+
+	// A ReorgEvent describes a reorg of the current path. Depth is the
+	// number of blocks that were reverted.
+	ReorgEvent struct {
+		Timestamp            types.Timestamp       `json:"timestamp"`
+		ForkPointID          types.BlockID         `json:"forkpointid"`
+		ForkPointHeight      types.BlockHeight     `json:"forkpointheight"`
+		OldTipID             types.BlockID         `json:"oldtipid"`
+		NewTipID             types.BlockID         `json:"newtipid"`
+		Depth                uint64                `json:"depth"`
+		AppliedBlocks        uint64                `json:"appliedblocks"`
+		RevertedBlocks       []types.BlockID       `json:"revertedblocks"`
+		RevertedTransactions []types.TransactionID `json:"revertedtransactions"`
+	}
+
+	// A ForkTracker keeps track of the side chains known to a consensus set
+	// and of the reorgs of its current path. The consensus set reports side
+	// blocks with AddSideBlock and reorgs with AddReorg.
+	ForkTracker struct {
+		clock  types.Clock
+		forks  map[types.BlockID]*ForkInfo
+		reorgs []ReorgEvent
+		mu     sync.Mutex
+	}
+)
+
+// NewForkTracker returns an empty ForkTracker.
+func NewForkTracker(clock types.Clock) *ForkTracker {
+	return &ForkTracker{
+		clock: clock,
+		forks: make(map[types.BlockID]*ForkInfo),
+	}
+}
+
+// AddSideBlock records a valid block that does not extend the current path.
+// If the parent of the block is the tip of a known side chain, that chain is
+// extended. Otherwise, a new side chain is started at the provided fork
+// point.
+func (ft *ForkTracker) AddSideBlock(id, parentID types.BlockID, height types.BlockHeight, depth types.Target, forkPointID types.BlockID, forkPointHeight types.BlockHeight) {
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	if _, exists := ft.forks[id]; exists {
+		return
+	}
+	fork, exists := ft.forks[parentID]
+	if exists {
+		delete(ft.forks, parentID)
+	} else {
+		for len(ft.forks) >= MaxTrackedForks {
+			ft.evictOldest()
+		}
+		fork = &ForkInfo{
+			ForkPointID:     forkPointID,
+			ForkPointHeight: forkPointHeight,
+			FirstSeen:       ft.clock.Now(),
+		}
+	}
+	fork.TipID = id
+	fork.TipHeight = height
+	fork.Depth = depth
+	fork.CumulativeDifficulty = depth.Difficulty()
+	ft.forks[id] = fork
+}
+
+// evictOldest forgets the side chain that was seen first.
+func (ft *ForkTracker) evictOldest() {
+	var oldest *ForkInfo
+	for _, fork := range ft.forks {
+		if oldest == nil || fork.FirstSeen < oldest.FirstSeen {
+			oldest = fork
+		}
+	}
+	if oldest != nil {
+		delete(ft.forks, oldest.TipID)
+	}
+}
+
+// AddReorg records a change of the current path. The reverted blocks are in
+// the order in which they were reverted, starting with the old tip, and
+// oldDepth is the depth of the old tip. The reverted blocks become a side
+// chain, and side chains whose tip has been applied are forgotten. Changes
+// that only apply blocks may be reported as well; they are not logged.
+func (ft *ForkTracker) AddReorg(forkPointID types.BlockID, forkPointHeight types.BlockHeight, oldDepth types.Target, reverted []types.Block, applied []types.BlockID) {
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	for _, id := range applied {
+		delete(ft.forks, id)
+	}
+	if len(reverted) == 0 {
+		return
+	}
+
+	now := ft.clock.Now()
+	event := ReorgEvent{
+		Timestamp:            now,
+		ForkPointID:          forkPointID,
+		ForkPointHeight:      forkPointHeight,
+		OldTipID:             reverted[0].ID(),
+		Depth:                uint64(len(reverted)),
+		AppliedBlocks:        uint64(len(applied)),
+		RevertedBlocks:       make([]types.BlockID, 0, len(reverted)),
+		RevertedTransactions: make([]types.TransactionID, 0),
+	}
+	if len(applied) > 0 {
+		event.NewTipID = applied[len(applied)-1]
+	}
+	for _, b := range reverted {
+		event.RevertedBlocks = append(event.RevertedBlocks, b.ID())
+		for _, txn := range b.Transactions {
+			event.RevertedTransactions = append(event.RevertedTransactions, txn.ID())
+		}
+	}
+	ft.reorgs = append(ft.reorgs, event)
+	if len(ft.reorgs) > MaxReorgLogEntries {
+		ft.reorgs = ft.reorgs[len(ft.reorgs)-MaxReorgLogEntries:]
+	}
+
+	for len(ft.forks) >= MaxTrackedForks {
+		ft.evictOldest()
+	}
+	ft.forks[event.OldTipID] = &ForkInfo{
+		TipID:                event.OldTipID,
+		TipHeight:            forkPointHeight + types.BlockHeight(len(reverted)),
+		Depth:                oldDepth,
+		CumulativeDifficulty: oldDepth.Difficulty(),
+		ForkPointID:          forkPointID,
+		ForkPointHeight:      forkPointHeight,
+		FirstSeen:            now,
+	}
+}
+
+// Forks returns the known side chains, heaviest first. The fork points are
+// the ones that were recorded when each side chain was first seen; a side
+// chain whose fork point has been reverted since is still reported with the
+// old fork point.
+func (ft *ForkTracker) Forks() []ForkInfo {
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	forks := make([]ForkInfo, 0, len(ft.forks))
+	for _, fork := range ft.forks {
+		forks = append(forks, *fork)
+	}
+	sort.Slice(forks, func(i, j int) bool {
+		return forks[i].Depth.Cmp(forks[j].Depth) < 0
+	})
+	return forks
+}
+
+// Reorgs returns the logged reorgs, oldest first.
+func (ft *ForkTracker) Reorgs() []ReorgEvent {
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	reorgs := make([]ReorgEvent, len(ft.reorgs))
+	copy(reorgs, ft.reorgs)
+	return reorgs
+}
@@ -0,0 +1,75 @@
+package modules
+
+import (
+	"testing"
+
+	"github.com/wisherd/Pis/types"
+)
+
+// TestForkTracker checks that side chains are tracked and extended, and that
+// reorgs are logged and turn the old current path into a side chain.
+func TestForkTracker(t *testing.T) {
+	clock := &mockClock{now: 1000}
+	ft := NewForkTracker(clock)
+	genesis := types.BlockID{0}
+	heavy := types.RootDepth.AddDifficulties(types.RootTarget).AddDifficulties(types.RootTarget)
+	light := types.RootDepth.AddDifficulties(types.RootTarget)
+
+	// Start a side chain at the genesis block and extend it.
+	ft.AddSideBlock(types.BlockID{1}, genesis, 1, light, genesis, 0)
+	clock.now++
+	ft.AddSideBlock(types.BlockID{2}, types.BlockID{1}, 2, heavy, genesis, 0)
+	forks := ft.Forks()
+	if len(forks) != 1 {
+		t.Fatal("expected one side chain, got", forks)
+	}
+	if f := forks[0]; f.TipID != (types.BlockID{2}) || f.TipHeight != 2 || f.FirstSeen != 1000 || f.ForkPointID != genesis {
+		t.Fatal("side chain was not extended:", f)
+	}
+	if !forks[0].CumulativeDifficulty.Equals(heavy.Difficulty()) {
+		t.Fatal("wrong cumulative difficulty")
+	}
+
+	// A second side chain is sorted behind the heavier one.
+	ft.AddSideBlock(types.BlockID{3}, genesis, 1, light, genesis, 0)
+	if forks := ft.Forks(); len(forks) != 2 || forks[0].TipID != (types.BlockID{2}) {
+		t.Fatal("side chains are not sorted by difficulty:", forks)
+	}
+
+	// Reorg to the first side chain.
+	txn := types.Transaction{ArbitraryData: [][]byte{{1}}}
+	old := types.Block{ParentID: genesis, Transactions: []types.Transaction{txn}}
+	ft.AddReorg(genesis, 0, light, []types.Block{old}, []types.BlockID{{1}, {2}})
+	reorgs := ft.Reorgs()
+	if len(reorgs) != 1 {
+		t.Fatal("expected one reorg, got", reorgs)
+	}
+	r := reorgs[0]
+	if r.Depth != 1 || r.AppliedBlocks != 2 || r.OldTipID != old.ID() || r.NewTipID != (types.BlockID{2}) {
+		t.Fatal("wrong reorg:", r)
+	}
+	if len(r.RevertedTransactions) != 1 || r.RevertedTransactions[0] != txn.ID() {
+		t.Fatal("reverted transaction was not logged:", r.RevertedTransactions)
+	}
+	forks = ft.Forks()
+	if len(forks) != 2 {
+		t.Fatal("expected two side chains, got", forks)
+	}
+	for _, f := range forks {
+		if f.TipID == (types.BlockID{2}) {
+			t.Fatal("applied side chain is still tracked")
+		}
+	}
+
+	// The log and the number of side chains are bounded.
+	for i := 0; i < 2*MaxReorgLogEntries; i++ {
+		clock.now++
+		ft.AddReorg(genesis, 0, light, []types.Block{{Timestamp: types.Timestamp(i)}}, nil)
+	}
+	if len(ft.Reorgs()) != MaxReorgLogEntries {
+		t.Fatal("reorg log is not bounded")
+	}
+	if len(ft.Forks()) > MaxTrackedForks {
+		t.Fatal("side chains are not bounded")
+	}
+}
@@ -37,6 +37,15 @@ type (
 		Local      bool       `json:"local"`
 		NetAddress NetAddress `json:"netaddress"`
 		Version    string     `json:"version"`
+
+		// Features are the protocol features that the peer advertised during
+		// the handshake.
+		Features FeatureBits `json:"features"`
+
+		// Downloaded and Uploaded are the number of bytes that have been
+		// received from and sent to the peer over its current connection.
+		Downloaded uint64 `json:"downloaded"`
+		Uploaded   uint64 `json:"uploaded"`
 	}
 
 	// A PeerConn is the connection type used when communicating with peers during
@@ -110,6 +119,15 @@ type (
 		// Online returns true if the gateway is connected to remote hosts
 		Online() bool
 
+		// RateLimits returns the global and per-peer bandwidth limits of the
+		// gateway.
+		RateLimits() BandwidthLimits
+
+		// SetRateLimits changes the global and per-peer bandwidth limits of
+		// the gateway. The new limits are applied to existing connections as
+		// well as new ones.
+		SetRateLimits(BandwidthLimits) error
+
 		// Close safely stops the Gateway's listener process.
 		Close() error
 	}
@@ -0,0 +1,238 @@
+package modules
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/wisherd/Pis/build"
+	"github.com/wisherd/Pis/encoding"
+	"github.com/wisherd/Pis/types"
+)
+
+const (
+	// FeatureEncryptedTransport indicates that the peer is able to encrypt
+	// the connection after the handshake has completed. The bit is reserved;
+	// it is not advertised until transport encryption is implemented.
+	FeatureEncryptedTransport FeatureBits = 1 << iota
+
+	// FeatureHeaderSync indicates that the peer serves block headers
+	// separately from block bodies, allowing headers-first synchronization.
+	// The RPCs are served by ServeHeaders and ServeBlockBodies.
+	FeatureHeaderSync
+
+	// FeatureCompactBlocks indicates that the peer is able to relay blocks
+	// as compact blocks that reference transactions by their short IDs.
+	// Missing transactions are served by ServeCompactTransactions.
+	FeatureCompactBlocks
+
+	// FeaturePeerExchange indicates that the peer shares the addresses of the
+	// nodes it knows about. The bit is reserved; it is not advertised until
+	// peer exchange is implemented.
+	FeaturePeerExchange
+
+	// FeatureProvenBlocks indicates that the peer serves the transactions
+	// that involve a set of addresses along with Merkle proofs, which is
+	// what light clients use to follow the chain. The RPC is served by
+	// ServeProvenBlocks.
+	FeatureProvenBlocks
+)
+
+const (
+	// HandshakeTimeout is the amount of time that two peers have to complete
+	// the handshake before the connection is dropped.
+	HandshakeTimeout = 30 * time.Second
+
+	// MaxEncodedHandshakeHeaderLength is the maximum length of an encoded
+	// HandshakeHeader. The limit leaves plenty of room for the version string
+	// and the net address.
+	MaxEncodedHandshakeHeaderLength = 1e3
+)
+
+var (
+	// ErrPeerGenesisID is returned when a peer reports a genesis block that
+	// differs from ours, i.e. the peer is part of a different network.
+	ErrPeerGenesisID = errors.New("peer has different genesis ID")
+
+	// ErrPeerMissingFeature is returned when an RPC is called on a peer that
+	// does not advertise the feature required by the RPC.
+	ErrPeerMissingFeature = errors.New("peer does not support the required feature")
+
+	// ErrPeerRejectedHandshake is returned when the remote peer did not
+	// accept our handshake.
+	ErrPeerRejectedHandshake = errors.New("peer rejected the handshake")
+
+	// ErrPeerVersion is returned when a peer reports an invalid version.
+	ErrPeerVersion = errors.New("peer has an invalid version")
+
+	// LightFeatures is the set of features advertised by a node running a
+	// light consensus set, which cannot serve headers or blocks to others.
+	LightFeatures = FeatureBits(0)
+
+	// LocalFeatures is the set of features that this node advertises to its
+	// peers during the handshake. Only features whose RPCs are served are
+	// advertised. It is replaced by LightFeatures in light mode.
+	LocalFeatures = FeatureHeaderSync | FeatureCompactBlocks | FeatureProvenBlocks
+)
+
+type (
+	// FeatureBits is a bit set of the optional protocol features that a peer
+	// supports. New RPCs should be gated behind a feature bit rather than a
+	// minimum version, so that peers can determine whether an RPC is
+	// available without comparing version strings.
+	FeatureBits uint64
+
+	// A HandshakeHeader is exchanged by both peers when a connection is
+	// established. It identifies the network the peer belongs to and the
+	// features it supports.
+	HandshakeHeader struct {
+		Version    string        `json:"version"`
+		Features   FeatureBits   `json:"features"`
+		GenesisID  types.BlockID `json:"genesisid"`
+		UniqueID   [8]byte       `json:"uniqueid"`
+		NetAddress NetAddress    `json:"netaddress"`
+	}
+)
+
+// Has returns true if all of the bits in f are also set in fb.
+func (fb FeatureBits) Has(f FeatureBits) bool {
+	return fb&f == f
+}
+
+// String implements fmt.Stringer.
+func (fb FeatureBits) String() string {
+	names := []struct {
+		f    FeatureBits
+		name string
+	}{
+		{FeatureEncryptedTransport, "encrypted-transport"},
+		{FeatureHeaderSync, "header-sync"},
+		{FeatureCompactBlocks, "compact-blocks"},
+		{FeaturePeerExchange, "peer-exchange"},
+		{FeatureProvenBlocks, "proven-blocks"},
+	}
+	var s string
+	for _, n := range names {
+		if !fb.Has(n.f) {
+			continue
+		}
+		if s != "" {
+			s += ","
+		}
+		s += n.name
+		fb &^= n.f
+	}
+	if fb != 0 {
+		if s != "" {
+			s += ","
+		}
+		s += fmt.Sprintf("unknown(%#x)", uint64(fb))
+	}
+	if s == "" {
+		return "none"
+	}
+	return s
+}
+
+// LocalHandshakeHeader returns the header that this node sends during the
+// handshake.
+func LocalHandshakeHeader(uniqueID [8]byte, addr NetAddress) HandshakeHeader {
+	return HandshakeHeader{
+		Version:    build.Version,
+		Features:   LocalFeatures,
+		GenesisID:  types.GenesisID,
+		UniqueID:   uniqueID,
+		NetAddress: addr,
+	}
+}
+
+// Validate checks whether the remote header is compatible with ours. Peers
+// with a different genesis ID are on a different network and are refused.
+// Unknown feature bits are ignored so that newer peers can advertise features
+// that this node does not know about yet.
+func (h HandshakeHeader) Validate(ours HandshakeHeader) error {
+	if h.GenesisID != ours.GenesisID {
+		return ErrPeerGenesisID
+	}
+	if !build.IsVersion(h.Version) {
+		return ErrPeerVersion
+	}
+	return nil
+}
+
+// PerformHandshake exchanges HandshakeHeaders with the peer on the other end
+// of conn. Both peers send their header, validate the header of the other
+// side, and then send either AcceptResponse or the reason for rejecting the
+// peer. The remote header is returned if both peers accepted the handshake.
+func PerformHandshake(conn net.Conn, ours HandshakeHeader) (HandshakeHeader, error) {
+	conn.SetDeadline(time.Now().Add(HandshakeTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	// Writes happen in the background so that peers on synchronous
+	// connections do not deadlock waiting for each other to read.
+	writeErr := make(chan error, 1)
+	go func() {
+		writeErr <- encoding.WriteObject(conn, ours)
+	}()
+	var theirs HandshakeHeader
+	if err := encoding.ReadObject(conn, &theirs, MaxEncodedHandshakeHeaderLength); err != nil {
+		return HandshakeHeader{}, fmt.Errorf("failed to read handshake header: %v", err)
+	}
+	if err := <-writeErr; err != nil {
+		return HandshakeHeader{}, fmt.Errorf("failed to write handshake header: %v", err)
+	}
+
+	// Tell the peer whether its header was accepted.
+	validateErr := theirs.Validate(ours)
+	response := AcceptResponse
+	if validateErr != nil {
+		response = validateErr.Error()
+	}
+	go func() {
+		writeErr <- encoding.WriteObject(conn, response)
+	}()
+	var remoteResponse string
+	if err := encoding.ReadObject(conn, &remoteResponse, NegotiateMaxErrorSize); err != nil {
+		return HandshakeHeader{}, fmt.Errorf("failed to read handshake response: %v", err)
+	}
+	if err := <-writeErr; err != nil {
+		return HandshakeHeader{}, fmt.Errorf("failed to write handshake response: %v", err)
+	}
+	if validateErr != nil {
+		return HandshakeHeader{}, validateErr
+	}
+	if remoteResponse != AcceptResponse {
+		return HandshakeHeader{}, fmt.Errorf("%v: %v", ErrPeerRejectedHandshake, remoteResponse)
+	}
+	return theirs, nil
+}
+
+// PeersWithFeature returns the subset of peers that advertise all of the
+// features in f.
+func PeersWithFeature(peers []Peer, f FeatureBits) []Peer {
+	var supported []Peer
+	for _, p := range peers {
+		if p.Features.Has(f) {
+			supported = append(supported, p)
+		}
+	}
+	return supported
+}
+
+// FeatureRPC calls an RPC on the given address, but only if the peer
+// advertised all of the features in f during the handshake. Modules should
+// use FeatureRPC instead of Gateway.RPC for any RPC that is not supported by
+// every peer.
+func FeatureRPC(g Gateway, addr NetAddress, f FeatureBits, name string, fn RPCFunc) error {
+	for _, p := range g.Peers() {
+		if p.NetAddress != addr {
+			continue
+		}
+		if !p.Features.Has(f) {
+			return ErrPeerMissingFeature
+		}
+		return g.RPC(addr, name, fn)
+	}
+	return errors.New("not connected to peer " + string(addr))
+}
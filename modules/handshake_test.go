@@ -0,0 +1,79 @@
+package modules
+
+import (
+	"net"
+	"testing"
+
+	"github.com/wisherd/Pis/types"
+)
+
+// TestFeatureBits probes the Has and String methods of FeatureBits.
+func TestFeatureBits(t *testing.T) {
+	fb := FeatureHeaderSync | FeatureCompactBlocks
+	if !fb.Has(FeatureHeaderSync) || !fb.Has(FeatureHeaderSync|FeatureCompactBlocks) {
+		t.Error("feature bits are missing a feature that was set")
+	}
+	if fb.Has(FeatureProvenBlocks) || fb.Has(FeatureHeaderSync|FeatureProvenBlocks) {
+		t.Error("feature bits report a feature that was not set")
+	}
+	if s := fb.String(); s != "header-sync,compact-blocks" {
+		t.Error("unexpected string:", s)
+	}
+	if s := FeatureBits(0).String(); s != "none" {
+		t.Error("unexpected string:", s)
+	}
+	if s := (FeatureProvenBlocks | 1<<40).String(); s != "proven-blocks,unknown(0x10000000000)" {
+		t.Error("unexpected string:", s)
+	}
+
+	// Reserved features are not advertised.
+	if LocalFeatures.Has(FeatureEncryptedTransport) || LocalFeatures.Has(FeaturePeerExchange) || LightFeatures != 0 {
+		t.Error("unimplemented features are advertised:", LocalFeatures, LightFeatures)
+	}
+
+	peers := []Peer{
+		{NetAddress: "1.2.3.4:1234", Features: fb},
+		{NetAddress: "1.2.3.5:1234", Features: FeatureProvenBlocks},
+	}
+	if supported := PeersWithFeature(peers, FeatureCompactBlocks); len(supported) != 1 || supported[0].NetAddress != peers[0].NetAddress {
+		t.Error("PeersWithFeature returned the wrong peers:", supported)
+	}
+}
+
+// TestPerformHandshake checks that peers on the same network complete the
+// handshake and that peers on different networks are refused by both sides.
+func TestPerformHandshake(t *testing.T) {
+	handshake := func(ours, theirs HandshakeHeader) (HandshakeHeader, error, error) {
+		c1, c2 := net.Pipe()
+		defer c1.Close()
+		defer c2.Close()
+		remoteErr := make(chan error, 1)
+		go func() {
+			_, err := PerformHandshake(c2, theirs)
+			remoteErr <- err
+		}()
+		h, err := PerformHandshake(c1, ours)
+		return h, err, <-remoteErr
+	}
+
+	ours := LocalHandshakeHeader([8]byte{1}, "1.2.3.4:1234")
+	theirs := LocalHandshakeHeader([8]byte{2}, "1.2.3.5:1234")
+	theirs.Features = FeatureHeaderSync
+	h, err, remoteErr := handshake(ours, theirs)
+	if err != nil || remoteErr != nil {
+		t.Fatal("handshake failed:", err, remoteErr)
+	}
+	if h.UniqueID != theirs.UniqueID || h.Features != FeatureHeaderSync {
+		t.Fatal("wrong header received:", h)
+	}
+
+	// A peer on a different network should be refused.
+	theirs.GenesisID = types.BlockID{1}
+	_, err, remoteErr = handshake(ours, theirs)
+	if err != ErrPeerGenesisID {
+		t.Fatal("expected ErrPeerGenesisID, got", err)
+	}
+	if remoteErr == nil {
+		t.Fatal("remote peer should have been told that the handshake was rejected")
+	}
+}
@@ -0,0 +1,411 @@
+package modules
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/wisherd/Pis/build"
+	"github.com/wisherd/Pis/crypto"
+	"github.com/wisherd/Pis/encoding"
+	"github.com/wisherd/Pis/types"
+)
+
+const (
+	// SendHeadersRPC is the name of the RPC used to request a chain of block
+	// headers from a peer. The RPC is only available on peers that advertise
+	// FeatureHeaderSync.
+	SendHeadersRPC = "SendHeaders"
+
+	// SendBlockBodiesRPC is the name of the RPC used to request the full
+	// blocks belonging to a set of previously downloaded headers. The RPC is
+	// only available on peers that advertise FeatureHeaderSync.
+	SendBlockBodiesRPC = "SendBlockBodies"
+
+	// MaxBodyDownloadPeers is the maximum number of peers that block bodies
+	// are downloaded from in parallel.
+	MaxBodyDownloadPeers = 4
+)
+
+var (
+	// MaxCatchUpHeaders is the maximum number of headers that a peer will
+	// send in response to a single SendHeaders call.
+	MaxCatchUpHeaders = build.Select(build.Var{
+		Standard: int(2000),
+		Dev:      int(200),
+		Testing:  int(20),
+	}).(int)
+
+	// MaxBlockBodiesPerRequest is the maximum number of block bodies that
+	// are requested from a peer in a single SendBlockBodies call.
+	MaxBlockBodiesPerRequest = build.Select(build.Var{
+		Standard: int(10),
+		Dev:      int(10),
+		Testing:  int(3),
+	}).(int)
+
+	// ErrBodyMismatch is returned when a downloaded block does not match the
+	// header that it was requested for.
+	ErrBodyMismatch = errors.New("block body does not match its header")
+
+	// ErrNoBodyPeers is returned when every peer failed to provide a set of
+	// block bodies.
+	ErrNoBodyPeers = errors.New("no peers were able to provide the requested block bodies")
+
+	// ErrNoCommonBlock is returned when none of the blocks in a
+	// SendHeaders request are on the current path.
+	ErrNoCommonBlock = errors.New("none of the requested blocks are on the current path")
+
+	// ErrOrphanHeader is returned when a header is received whose parent is
+	// not known.
+	ErrOrphanHeader = errors.New("header has unknown parent")
+)
+
+type (
+	// SyncProgress describes the progress of the initial blockchain download.
+	// Headers are downloaded before the blocks they belong to, so the header
+	// height is always at least as large as the block height.
+	SyncProgress struct {
+		Synced       bool              `json:"synced"`
+		HeaderHeight types.BlockHeight `json:"headerheight"`
+		BlockHeight  types.BlockHeight `json:"blockheight"`
+	}
+
+	// A BodyFetchFunc requests the blocks with the provided IDs from a peer.
+	BodyFetchFunc func(addr NetAddress, ids []types.BlockID) ([]types.Block, error)
+
+	// headerNode is a header in the HeaderChain along with the metadata
+	// needed to determine the heaviest chain.
+	headerNode struct {
+		header types.BlockHeader
+		id     types.BlockID
+		depth  types.Target
+		state  TargetState
+	}
+
+	// A HeaderChain is a tree of block headers that grows from a block that
+	// is already part of the consensus set. Every header has its timestamp
+	// and its proof of work checked before it is added, with targets
+	// computed by ChildTargetState, and the tip of the heaviest header chain
+	// is tracked so that block bodies can be fetched for it afterwards.
+	HeaderChain struct {
+		rootTimestamp TimestampFunc
+		nodes         map[types.BlockID]*headerNode
+		root          *headerNode
+		tip           *headerNode
+		mu            sync.RWMutex
+	}
+)
+
+// NewHeaderChain returns a HeaderChain that is rooted at the provided block.
+// The depth of the root is the cumulative target of the chain up to and
+// including the root, and rootState is the target state of the root, both as
+// tracked by the consensus set. rootTimestamp returns the timestamps of the
+// root and its ancestors, which are needed to validate the first headers.
+func NewHeaderChain(rootID types.BlockID, rootDepth types.Target, rootState TargetState, rootTimestamp TimestampFunc) *HeaderChain {
+	root := &headerNode{
+		id:    rootID,
+		depth: rootDepth,
+		state: rootState,
+	}
+	return &HeaderChain{
+		rootTimestamp: rootTimestamp,
+		nodes:         map[types.BlockID]*headerNode{rootID: root},
+		root:          root,
+		tip:           root,
+	}
+}
+
+// AddHeaders adds a sequence of headers to the chain. Each header must build
+// on a header that is already known, must have a valid timestamp and must
+// meet the target of its parent. Headers that are already known are skipped.
+// Processing stops at the first invalid header; headers before it are kept.
+func (hc *HeaderChain) AddHeaders(headers []types.BlockHeader) error {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	for _, h := range headers {
+		id := h.ID()
+		if _, exists := hc.nodes[id]; exists {
+			continue
+		}
+		parent, exists := hc.nodes[h.ParentID]
+		if !exists {
+			return ErrOrphanHeader
+		}
+		if !h.CheckTarget(parent.state.ChildTarget) {
+			return ErrBlockUnsolved
+		}
+		timestamp := hc.ancestorTimestamp(parent)
+		if err := CheckHeaderTimestamp(h.Timestamp, parent.state.Height, timestamp); err != nil {
+			return err
+		}
+
+		node := &headerNode{
+			header: h,
+			id:     id,
+			depth:  parent.depth.AddDifficulties(parent.state.ChildTarget),
+			state:  ChildTargetState(parent.state, h.Timestamp, timestamp),
+		}
+		hc.nodes[id] = node
+		// A lower depth means that more work went into the chain.
+		if node.depth.Cmp(hc.tip.depth) < 0 {
+			hc.tip = node
+		}
+	}
+	return nil
+}
+
+// ancestorTimestamp returns a TimestampFunc for the ancestors of node.
+// Ancestors that are not part of the header chain are looked up with the
+// rootTimestamp function.
+func (hc *HeaderChain) ancestorTimestamp(node *headerNode) TimestampFunc {
+	return func(height types.BlockHeight) types.Timestamp {
+		if height <= hc.root.state.Height {
+			return hc.rootTimestamp(height)
+		}
+		n := node
+		for n.state.Height > height {
+			n = hc.nodes[n.header.ParentID]
+		}
+		return n.header.Timestamp
+	}
+}
+
+// Header returns the header with the provided ID and its height.
+func (hc *HeaderChain) Header(id types.BlockID) (types.BlockHeader, types.BlockHeight, bool) {
+	hc.mu.RLock()
+	defer hc.mu.RUnlock()
+	node, exists := hc.nodes[id]
+	if !exists || node == hc.root {
+		return types.BlockHeader{}, 0, false
+	}
+	return node.header, node.state.Height, true
+}
+
+// Tip returns the ID and height of the tip of the heaviest known header
+// chain.
+func (hc *HeaderChain) Tip() (types.BlockID, types.BlockHeight) {
+	hc.mu.RLock()
+	defer hc.mu.RUnlock()
+	return hc.tip.id, hc.tip.state.Height
+}
+
+// HeaviestPath returns the headers of the heaviest known chain, starting with
+// the child of the root and ending with the tip.
+func (hc *HeaderChain) HeaviestPath() []types.BlockHeader {
+	hc.mu.RLock()
+	defer hc.mu.RUnlock()
+	path := make([]types.BlockHeader, hc.tip.state.Height-hc.root.state.Height)
+	for node := hc.tip; node != hc.root; node = hc.nodes[node.header.ParentID] {
+		path[node.state.Height-hc.root.state.Height-1] = node.header
+	}
+	return path
+}
+
+// VerifyBlockBody checks that b is the block described by h. Since the
+// header commits to the Merkle root of the block, a matching root guarantees
+// that the payouts and transactions have not been tampered with.
+func VerifyBlockBody(h types.BlockHeader, b types.Block) error {
+	if b.ParentID != h.ParentID || b.Nonce != h.Nonce || b.Timestamp != h.Timestamp {
+		return ErrBodyMismatch
+	}
+	if b.MerkleRoot() != h.MerkleRoot {
+		return ErrBodyMismatch
+	}
+	return nil
+}
+
+// DownloadBlockBodies fetches the blocks for the provided headers from up to
+// MaxBodyDownloadPeers peers in parallel. Each body is checked against its
+// header. A peer that fails to provide a valid batch is dropped and its batch
+// is handed to another peer. The blocks are returned in the same order as
+// the headers.
+func DownloadBlockBodies(peers []NetAddress, headers []types.BlockHeader, fetch BodyFetchFunc) ([]types.Block, error) {
+	if len(headers) == 0 {
+		return nil, nil
+	}
+	if len(peers) > MaxBodyDownloadPeers {
+		peers = peers[:MaxBodyDownloadPeers]
+	}
+
+	// Split the headers into batches. The work channel is large enough to
+	// hold every batch, so that failed batches can always be requeued.
+	type batch struct{ start, end int }
+	var batches []batch
+	for i := 0; i < len(headers); i += MaxBlockBodiesPerRequest {
+		end := i + MaxBlockBodiesPerRequest
+		if end > len(headers) {
+			end = len(headers)
+		}
+		batches = append(batches, batch{i, end})
+	}
+	work := make(chan batch, len(batches))
+	for _, b := range batches {
+		work <- b
+	}
+
+	blocks := make([]types.Block, len(headers))
+	var remaining, workers sync.WaitGroup
+	remaining.Add(len(batches))
+	for _, peer := range peers {
+		workers.Add(1)
+		go func(peer NetAddress) {
+			defer workers.Done()
+			for b := range work {
+				ids := make([]types.BlockID, 0, b.end-b.start)
+				for _, h := range headers[b.start:b.end] {
+					ids = append(ids, h.ID())
+				}
+				fetched, err := fetch(peer, ids)
+				if err == nil && len(fetched) != len(ids) {
+					err = ErrBodyMismatch
+				}
+				for i := 0; err == nil && i < len(fetched); i++ {
+					err = VerifyBlockBody(headers[b.start+i], fetched[i])
+				}
+				if err != nil {
+					// Give the batch to another peer and stop using this
+					// one.
+					work <- b
+					return
+				}
+				copy(blocks[b.start:b.end], fetched)
+				remaining.Done()
+			}
+		}(peer)
+	}
+
+	allDone := make(chan struct{})
+	go func() {
+		remaining.Wait()
+		close(allDone)
+	}()
+	workersDone := make(chan struct{})
+	go func() {
+		workers.Wait()
+		close(workersDone)
+	}()
+	select {
+	case <-allDone:
+		close(work)
+		return blocks, nil
+	case <-workersDone:
+		// Every peer failed. Make sure the batch counter goroutine does not
+		// leak by accounting for the batches that were never downloaded.
+		for len(work) > 0 {
+			<-work
+			remaining.Done()
+		}
+		return nil, ErrNoBodyPeers
+	}
+}
+
+// ServeHeaders handles a call to SendHeadersRPC. pathHeight returns the
+// height of the block with the provided ID if it is on the current path, and
+// pathHeader returns the header at the provided height of the current path.
+// Up to MaxCatchUpHeaders headers are sent, starting after the most recent
+// block of the requester's history that is on the current path.
+func ServeHeaders(conn PeerConn, pathHeight func(types.BlockID) (types.BlockHeight, bool), pathHeader func(types.BlockHeight) (types.BlockHeader, bool)) error {
+	var history [32]types.BlockID
+	if err := encoding.ReadObject(conn, &history, uint64(len(history))*crypto.HashSize); err != nil {
+		return err
+	}
+	start, found := types.BlockHeight(0), false
+	for _, id := range history {
+		if start, found = pathHeight(id); found {
+			break
+		}
+	}
+	if !found {
+		return ErrNoCommonBlock
+	}
+
+	var headers []types.BlockHeader
+	height := start + 1
+	for ; len(headers) < MaxCatchUpHeaders; height++ {
+		h, exists := pathHeader(height)
+		if !exists {
+			break
+		}
+		headers = append(headers, h)
+	}
+	_, moreAvailable := pathHeader(height)
+	if err := encoding.WriteObject(conn, headers); err != nil {
+		return err
+	}
+	return encoding.WriteObject(conn, moreAvailable)
+}
+
+// RequestHeaders calls SendHeadersRPC on conn. The history contains the IDs
+// of recent blocks in the local chain, most recent first, which the peer uses
+// to find the point where the chains diverge. The headers that follow that
+// point are returned, along with whether the peer has more headers to send.
+func RequestHeaders(conn PeerConn, history [32]types.BlockID) ([]types.BlockHeader, bool, error) {
+	if err := encoding.WriteObject(conn, history); err != nil {
+		return nil, false, err
+	}
+	var headers []types.BlockHeader
+	maxLen := uint64(MaxCatchUpHeaders) * uint64(len(encoding.Marshal(types.BlockHeader{})))
+	if err := encoding.ReadObject(conn, &headers, maxLen+8); err != nil {
+		return nil, false, err
+	}
+	if len(headers) > MaxCatchUpHeaders {
+		return nil, false, errors.New("peer sent too many headers")
+	}
+	var moreAvailable bool
+	if err := encoding.ReadObject(conn, &moreAvailable, 1); err != nil {
+		return nil, false, err
+	}
+	return headers, moreAvailable, nil
+}
+
+// RequestBlockBodies calls SendBlockBodiesRPC on conn and returns the blocks
+// with the provided IDs. The caller is responsible for checking the blocks
+// against their headers.
+func RequestBlockBodies(conn PeerConn, ids []types.BlockID) ([]types.Block, error) {
+	if len(ids) > MaxBlockBodiesPerRequest {
+		return nil, errors.New("too many block bodies requested")
+	}
+	if err := encoding.WriteObject(conn, ids); err != nil {
+		return nil, err
+	}
+	var blocks []types.Block
+	maxLen := uint64(len(ids)) * types.BlockSizeLimit
+	if err := encoding.ReadObject(conn, &blocks, maxLen+8); err != nil {
+		return nil, err
+	}
+	return blocks, nil
+}
+
+// ServeBlockBodies handles a call to SendBlockBodiesRPC. The lookup function
+// returns the full block with the requested ID from the current path.
+func ServeBlockBodies(conn PeerConn, lookup func(types.BlockID) (types.Block, bool)) error {
+	var ids []types.BlockID
+	maxLen := uint64(MaxBlockBodiesPerRequest)*crypto.HashSize + 8
+	if err := encoding.ReadObject(conn, &ids, maxLen); err != nil {
+		return err
+	}
+	if len(ids) > MaxBlockBodiesPerRequest {
+		return errors.New("too many block bodies requested")
+	}
+	blocks := make([]types.Block, 0, len(ids))
+	for _, id := range ids {
+		b, exists := lookup(id)
+		if !exists {
+			return ErrUnknownBlock
+		}
+		blocks = append(blocks, b)
+	}
+	return encoding.WriteObject(conn, blocks)
+}
+
+// GatewayBodyFetcher returns a BodyFetchFunc that downloads block bodies over
+// the gateway from peers that advertise FeatureHeaderSync.
+func GatewayBodyFetcher(g Gateway) BodyFetchFunc {
+	return func(addr NetAddress, ids []types.BlockID) (blocks []types.Block, err error) {
+		err = FeatureRPC(g, addr, FeatureHeaderSync, SendBlockBodiesRPC, func(conn PeerConn) error {
+			blocks, err = RequestBlockBodies(conn, ids)
+			return err
+		})
+		return blocks, err
+	}
+}
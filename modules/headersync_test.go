@@ -0,0 +1,286 @@
+package modules
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/wisherd/Pis/types"
+)
+
+// newTestHeaderChain returns a HeaderChain rooted at height 10 whose blocks
+// all have the timestamp ts and whose child target is target.
+func newTestHeaderChain(root types.BlockID, target types.Target, ts types.Timestamp) *HeaderChain {
+	state := TargetState{
+		Height:      10,
+		Timestamp:   ts,
+		ChildTarget: target,
+		TotalTarget: types.RootDepth,
+	}
+	return NewHeaderChain(root, types.RootDepth, state, func(types.BlockHeight) types.Timestamp {
+		return ts
+	})
+}
+
+// buildHeaders returns a chain of n headers that builds on parent. The nonce
+// seed makes sure that different chains have different IDs.
+func buildHeaders(parent types.BlockID, n int, seed byte) []types.BlockHeader {
+	var headers []types.BlockHeader
+	for i := 0; i < n; i++ {
+		h := types.BlockHeader{
+			ParentID:  parent,
+			Nonce:     types.BlockNonce{seed, byte(i)},
+			Timestamp: types.CurrentTimestamp(),
+		}
+		headers = append(headers, h)
+		parent = h.ID()
+	}
+	return headers
+}
+
+// TestHeaderChain checks that the header chain follows the heaviest chain and
+// rejects headers that are orphaned, lack proof of work or have invalid
+// timestamps.
+func TestHeaderChain(t *testing.T) {
+	root := types.BlockID{1}
+	start := types.CurrentTimestamp() - 100
+	hc := newTestHeaderChain(root, types.RootDepth, start)
+
+	short := buildHeaders(root, 3, 1)
+	long := buildHeaders(root, 5, 2)
+	if err := hc.AddHeaders(short); err != nil {
+		t.Fatal(err)
+	}
+	if id, height := hc.Tip(); id != short[2].ID() || height != 13 {
+		t.Fatal("wrong tip after adding the short chain:", id, height)
+	}
+	if err := hc.AddHeaders(long); err != nil {
+		t.Fatal(err)
+	}
+	if id, height := hc.Tip(); id != long[4].ID() || height != 15 {
+		t.Fatal("wrong tip after adding the long chain:", id, height)
+	}
+	path := hc.HeaviestPath()
+	if len(path) != len(long) {
+		t.Fatal("wrong path length:", len(path))
+	}
+	for i := range path {
+		if path[i] != long[i] {
+			t.Fatal("path does not match the long chain at index", i)
+		}
+	}
+	if _, height, exists := hc.Header(short[1].ID()); !exists || height != 12 {
+		t.Fatal("header of the short chain is missing")
+	}
+
+	// Adding known headers is a no-op.
+	if err := hc.AddHeaders(short); err != nil {
+		t.Fatal(err)
+	}
+
+	// Headers with an unknown parent are rejected.
+	if err := hc.AddHeaders(buildHeaders(types.BlockID{2}, 1, 3)); err != ErrOrphanHeader {
+		t.Fatal("expected ErrOrphanHeader, got", err)
+	}
+
+	// Headers that do not meet the target are rejected.
+	hard := newTestHeaderChain(root, types.Target{}, start)
+	if err := hard.AddHeaders(short); err != ErrBlockUnsolved {
+		t.Fatal("expected ErrBlockUnsolved, got", err)
+	}
+
+	// Headers with a timestamp below the median of their ancestors or too
+	// far in the future are rejected.
+	tests := []struct {
+		timestamp types.Timestamp
+		err       error
+	}{
+		{start - 1, ErrEarlyTimestamp},
+		{types.CurrentTimestamp() + types.ExtremeFutureThreshold + 10, ErrExtremeFutureTimestamp},
+		{types.CurrentTimestamp() + types.FutureThreshold + 2, ErrFutureTimestamp},
+	}
+	for _, test := range tests {
+		h := buildHeaders(root, 1, 4)
+		h[0].Timestamp = test.timestamp
+		if err := hc.AddHeaders(h); err != test.err {
+			t.Errorf("expected %v for timestamp %v, got %v", test.err, test.timestamp, err)
+		}
+	}
+}
+
+// TestDownloadBlockBodies checks that bodies are downloaded in order and that
+// batches from a misbehaving peer are retried with another peer.
+func TestDownloadBlockBodies(t *testing.T) {
+	var blocks []types.Block
+	var headers []types.BlockHeader
+	known := make(map[types.BlockID]types.Block)
+	parent := types.BlockID{1}
+	for i := 0; i < 10; i++ {
+		b := types.Block{
+			ParentID:     parent,
+			Nonce:        types.BlockNonce{byte(i)},
+			MinerPayouts: []types.PiscoinOutput{{Value: types.NewCurrency64(uint64(i))}},
+		}
+		blocks = append(blocks, b)
+		headers = append(headers, b.Header())
+		known[b.ID()] = b
+		parent = b.ID()
+	}
+
+	fetch := func(addr NetAddress, ids []types.BlockID) ([]types.Block, error) {
+		var fetched []types.Block
+		for _, id := range ids {
+			b := known[id]
+			if addr == "bad:1" {
+				// Tamper with the payouts, which changes the Merkle root.
+				b.MinerPayouts = nil
+			}
+			fetched = append(fetched, b)
+		}
+		return fetched, nil
+	}
+
+	downloaded, err := DownloadBlockBodies([]NetAddress{"bad:1", "good:1"}, headers, fetch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range blocks {
+		if downloaded[i].ID() != blocks[i].ID() {
+			t.Fatal("wrong block at index", i)
+		}
+	}
+
+	// If every peer misbehaves the download fails.
+	if _, err := DownloadBlockBodies([]NetAddress{"bad:1"}, headers, fetch); err != ErrNoBodyPeers {
+		t.Fatal("expected ErrNoBodyPeers, got", err)
+	}
+	failing := func(NetAddress, []types.BlockID) ([]types.Block, error) {
+		return nil, errors.New("failed")
+	}
+	if _, err := DownloadBlockBodies([]NetAddress{"a:1", "b:1"}, headers, failing); err != ErrNoBodyPeers {
+		t.Fatal("expected ErrNoBodyPeers, got", err)
+	}
+}
+
+// pipeConn is a PeerConn over one end of a net.Pipe.
+type pipeConn struct {
+	net.Conn
+}
+
+// RPCAddr implements PeerConn.
+func (pipeConn) RPCAddr() NetAddress { return "1.2.3.4:1234" }
+
+// callPipe runs request against serve over a pipe and returns the errors of
+// both sides.
+func callPipe(serve, request func(PeerConn) error) (serveErr, requestErr error) {
+	c1, c2 := net.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		err := serve(pipeConn{c2})
+		c2.Close()
+		done <- err
+	}()
+	requestErr = request(pipeConn{c1})
+	c1.Close()
+	return <-done, requestErr
+}
+
+// TestServeHeaders checks that ServeHeaders sends the headers that follow the
+// most recent common block in batches of MaxCatchUpHeaders.
+func TestServeHeaders(t *testing.T) {
+	path := buildHeaders(types.GenesisID, MaxCatchUpHeaders+5, 1)
+	heights := map[types.BlockID]types.BlockHeight{types.GenesisID: 0}
+	for i, h := range path {
+		heights[h.ID()] = types.BlockHeight(i + 1)
+	}
+	pathHeight := func(id types.BlockID) (types.BlockHeight, bool) {
+		height, exists := heights[id]
+		return height, exists
+	}
+	pathHeader := func(height types.BlockHeight) (types.BlockHeader, bool) {
+		if height == 0 || height > types.BlockHeight(len(path)) {
+			return types.BlockHeader{}, false
+		}
+		return path[height-1], true
+	}
+	serve := func(conn PeerConn) error {
+		return ServeHeaders(conn, pathHeight, pathHeader)
+	}
+	request := func(history [32]types.BlockID) (headers []types.BlockHeader, more bool, serveErr, requestErr error) {
+		serveErr, requestErr = callPipe(serve, func(conn PeerConn) (err error) {
+			headers, more, err = RequestHeaders(conn, history)
+			return err
+		})
+		return
+	}
+
+	// The first unknown block of the history is skipped, and the headers
+	// after the second block are sent.
+	history := [32]types.BlockID{{1}, path[1].ID(), types.GenesisID}
+	headers, more, serveErr, requestErr := request(history)
+	if serveErr != nil || requestErr != nil {
+		t.Fatal(serveErr, requestErr)
+	}
+	if len(headers) != MaxCatchUpHeaders || headers[0].ID() != path[2].ID() || !more {
+		t.Fatal("wrong first batch:", len(headers), more)
+	}
+	history = [32]types.BlockID{headers[len(headers)-1].ID()}
+	headers, more, serveErr, requestErr = request(history)
+	if serveErr != nil || requestErr != nil {
+		t.Fatal(serveErr, requestErr)
+	}
+	if len(headers) != 3 || headers[2].ID() != path[len(path)-1].ID() || more {
+		t.Fatal("wrong second batch:", len(headers), more)
+	}
+
+	// A history without any block of the current path is rejected.
+	if serveErr, requestErr := callPipe(serve, func(conn PeerConn) error {
+		_, _, err := RequestHeaders(conn, [32]types.BlockID{{1}})
+		return err
+	}); serveErr != ErrNoCommonBlock || requestErr == nil {
+		t.Fatal("expected ErrNoCommonBlock, got", serveErr, requestErr)
+	}
+}
+
+// TestServeBlockBodies checks that ServeBlockBodies sends the requested
+// blocks in order and refuses unknown blocks.
+func TestServeBlockBodies(t *testing.T) {
+	known := make(map[types.BlockID]types.Block)
+	var ids []types.BlockID
+	for i := 0; i < MaxBlockBodiesPerRequest; i++ {
+		b := types.Block{Nonce: types.BlockNonce{byte(i)}}
+		known[b.ID()] = b
+		ids = append(ids, b.ID())
+	}
+	serve := func(conn PeerConn) error {
+		return ServeBlockBodies(conn, func(id types.BlockID) (types.Block, bool) {
+			b, exists := known[id]
+			return b, exists
+		})
+	}
+
+	var blocks []types.Block
+	serveErr, requestErr := callPipe(serve, func(conn PeerConn) (err error) {
+		blocks, err = RequestBlockBodies(conn, ids)
+		return err
+	})
+	if serveErr != nil || requestErr != nil {
+		t.Fatal(serveErr, requestErr)
+	}
+	if len(blocks) != len(ids) {
+		t.Fatal("wrong number of blocks:", len(blocks))
+	}
+	for i := range blocks {
+		if blocks[i].ID() != ids[i] {
+			t.Fatal("wrong block at index", i)
+		}
+	}
+
+	serveErr, requestErr = callPipe(serve, func(conn PeerConn) error {
+		_, err := RequestBlockBodies(conn, []types.BlockID{{1}})
+		return err
+	})
+	if serveErr != ErrUnknownBlock || requestErr == nil {
+		t.Fatal("expected ErrUnknownBlock, got", serveErr, requestErr)
+	}
+}
@@ -0,0 +1,192 @@
+package modules
+
+import (
+	"encoding/csv"
+	"io"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/wisherd/Pis/types"
+)
+
+// historyCSVHeader is the header row of a CSV history export.
+var historyCSVHeader = []string{"timestamp", "confirmationheight", "transactionid", "fundtype", "net", "fee", "counterparties", "counterpartylabels", "note"}
+
+type (
+	// A HistoryEntry is a row of a transaction history export. It describes
+	// how a transaction changed the balance of the wallet for one fund type.
+	// FundType is types.SpecifierPiscoinOutput or types.SpecifierPisfundOutput
+	// for transfers, and types.SpecifierMinerPayout or
+	// types.SpecifierClaimOutput for block rewards and pisfund claims.
+	//
+	// Net is the signed change of the wallet balance as a decimal string, in
+	// hastings or pisfunds, and already includes Fee. Fee is only set on the
+	// piscoin entry of transactions that the wallet funded. Counterparties
+	// are the addresses outside of the wallet that the transaction sends to
+	// or receives from, and CounterpartyLabels holds their labels in the same
+	// order, with an empty string for counterparties without a label.
+	HistoryEntry struct {
+		Timestamp          types.Timestamp     `json:"timestamp"`
+		ConfirmationHeight types.BlockHeight   `json:"confirmationheight"`
+		TransactionID      types.TransactionID `json:"transactionid"`
+		FundType           types.Specifier     `json:"fundtype"`
+		Net                string              `json:"net"`
+		Fee                types.Currency      `json:"fee"`
+		Counterparties     []types.UnlockHash  `json:"counterparties"`
+		CounterpartyLabels []string            `json:"counterpartylabels"`
+		Note               string              `json:"note,omitempty"`
+	}
+
+	// A HistoryCSVWriter streams history entries as CSV.
+	HistoryCSVWriter struct {
+		w             *csv.Writer
+		headerWritten bool
+	}
+)
+
+// historyFundType returns the fund type of the history entry that a processed
+// input or output belongs to. Miner fees belong to no entry.
+func historyFundType(fundType types.Specifier) (types.Specifier, bool) {
+	switch fundType {
+	case types.SpecifierPiscoinInput, types.SpecifierPiscoinOutput:
+		return types.SpecifierPiscoinOutput, true
+	case types.SpecifierPisfundInput, types.SpecifierPisfundOutput:
+		return types.SpecifierPisfundOutput, true
+	case types.SpecifierMinerPayout, types.SpecifierClaimOutput:
+		return fundType, true
+	}
+	return types.Specifier{}, false
+}
+
+// NewHistoryEntries returns the history entries of pt, one for each fund type
+// whose balance pt changes. Inputs and outputs of watch-only addresses count
+// as part of the wallet.
+func NewHistoryEntries(pt ProcessedTransaction) []HistoryEntry {
+	var entries []HistoryEntry
+	var nets []*big.Int
+	index := make(map[types.Specifier]int)
+	entry := func(fundType types.Specifier) (*HistoryEntry, *big.Int) {
+		i, exists := index[fundType]
+		if !exists {
+			i = len(entries)
+			index[fundType] = i
+			entries = append(entries, HistoryEntry{
+				Timestamp:          pt.ConfirmationTimestamp,
+				ConfirmationHeight: pt.ConfirmationHeight,
+				TransactionID:      pt.TransactionID,
+				FundType:           fundType,
+				Fee:                types.ZeroCurrency,
+				Note:               pt.Note,
+			})
+			nets = append(nets, new(big.Int))
+		}
+		return &entries[i], nets[i]
+	}
+	addCounterparty := func(e *HistoryEntry, uh types.UnlockHash, label string) {
+		for i, c := range e.Counterparties {
+			if c == uh {
+				if e.CounterpartyLabels[i] == "" {
+					e.CounterpartyLabels[i] = label
+				}
+				return
+			}
+		}
+		e.Counterparties = append(e.Counterparties, uh)
+		e.CounterpartyLabels = append(e.CounterpartyLabels, label)
+	}
+
+	var funded bool
+	for _, input := range pt.Inputs {
+		fundType, ok := historyFundType(input.FundType)
+		if !ok {
+			continue
+		}
+		e, net := entry(fundType)
+		if input.WalletAddress || input.WatchOnly {
+			net.Sub(net, input.Value.Big())
+			funded = funded || fundType == types.SpecifierPiscoinOutput
+		} else {
+			addCounterparty(e, input.RelatedAddress, input.Label)
+		}
+	}
+	for _, output := range pt.Outputs {
+		fundType, ok := historyFundType(output.FundType)
+		if !ok {
+			continue
+		}
+		e, net := entry(fundType)
+		if output.WalletAddress || output.WatchOnly {
+			net.Add(net, output.Value.Big())
+		} else {
+			addCounterparty(e, output.RelatedAddress, output.Label)
+		}
+	}
+	if funded {
+		e, _ := entry(types.SpecifierPiscoinOutput)
+		for _, fee := range pt.Transaction.MinerFees {
+			e.Fee = e.Fee.Add(fee)
+		}
+	}
+
+	// Drop entries of fund types whose balance did not change, unless the
+	// wallet paid a fee.
+	filtered := entries[:0]
+	for i, e := range entries {
+		if nets[i].Sign() != 0 || !e.Fee.IsZero() {
+			e.Net = nets[i].String()
+			filtered = append(filtered, e)
+		}
+	}
+	return filtered
+}
+
+// NewHistoryCSVWriter returns a HistoryCSVWriter that writes to w. The header
+// row is written with the first entry.
+func NewHistoryCSVWriter(w io.Writer) *HistoryCSVWriter {
+	return &HistoryCSVWriter{w: csv.NewWriter(w)}
+}
+
+// Write writes an entry. Timestamps are written in RFC 3339 format in UTC,
+// counterparties are separated by spaces, and their labels are separated by
+// semicolons, in the same order as the counterparties.
+func (hw *HistoryCSVWriter) Write(e HistoryEntry) error {
+	if err := hw.writeHeader(); err != nil {
+		return err
+	}
+	counterparties := make([]string, len(e.Counterparties))
+	for i, uh := range e.Counterparties {
+		counterparties[i] = uh.String()
+	}
+	return hw.w.Write([]string{
+		time.Unix(int64(e.Timestamp), 0).UTC().Format(time.RFC3339),
+		strconv.FormatUint(uint64(e.ConfirmationHeight), 10),
+		e.TransactionID.String(),
+		e.FundType.String(),
+		e.Net,
+		e.Fee.String(),
+		strings.Join(counterparties, " "),
+		strings.Join(e.CounterpartyLabels, ";"),
+		e.Note,
+	})
+}
+
+// Flush writes the header row if no entry was written, and flushes the
+// underlying writer.
+func (hw *HistoryCSVWriter) Flush() error {
+	if err := hw.writeHeader(); err != nil {
+		return err
+	}
+	hw.w.Flush()
+	return hw.w.Error()
+}
+
+// writeHeader writes the header row if it has not been written yet.
+func (hw *HistoryCSVWriter) writeHeader() error {
+	if hw.headerWritten {
+		return nil
+	}
+	hw.headerWritten = true
+	return hw.w.Write(historyCSVHeader)
+}
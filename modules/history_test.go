@@ -0,0 +1,76 @@
+package modules
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/wisherd/Pis/types"
+)
+
+// TestHistoryEntries checks the history entries of incoming and outgoing
+// transactions and their CSV encoding.
+func TestHistoryEntries(t *testing.T) {
+	ours, theirs := types.UnlockHash{1}, types.UnlockHash{2}
+
+	// An outgoing payment with change and a fee.
+	outgoing := ProcessedTransaction{
+		Transaction:           types.Transaction{MinerFees: []types.Currency{types.NewCurrency64(2)}},
+		TransactionID:         types.TransactionID{1},
+		ConfirmationHeight:    10,
+		ConfirmationTimestamp: 1500000000,
+		Note:                  "rent, March",
+		Inputs: []ProcessedInput{
+			{FundType: types.SpecifierPiscoinInput, WalletAddress: true, RelatedAddress: ours, Value: types.NewCurrency64(100)},
+		},
+		Outputs: []ProcessedOutput{
+			{FundType: types.SpecifierPiscoinOutput, RelatedAddress: theirs, Label: "landlord", Value: types.NewCurrency64(60)},
+			{FundType: types.SpecifierPiscoinOutput, WalletAddress: true, RelatedAddress: ours, Value: types.NewCurrency64(38)},
+			{FundType: types.SpecifierMinerFee, Value: types.NewCurrency64(2)},
+		},
+	}
+	entries := NewHistoryEntries(outgoing)
+	if len(entries) != 1 {
+		t.Fatal("expected 1 entry, got", len(entries))
+	}
+	e := entries[0]
+	if e.FundType != types.SpecifierPiscoinOutput || e.Net != "-62" || !e.Fee.Equals64(2) || len(e.Counterparties) != 1 || e.Counterparties[0] != theirs || e.CounterpartyLabels[0] != "landlord" {
+		t.Fatal("wrong outgoing entry:", e)
+	}
+
+	// An incoming pisfund transfer has no fee, even though the sender paid
+	// one.
+	incoming := ProcessedTransaction{
+		Transaction:   types.Transaction{MinerFees: []types.Currency{types.NewCurrency64(1)}},
+		TransactionID: types.TransactionID{2},
+		Inputs: []ProcessedInput{
+			{FundType: types.SpecifierPiscoinInput, RelatedAddress: theirs, Value: types.NewCurrency64(5)},
+			{FundType: types.SpecifierPisfundInput, RelatedAddress: theirs, Label: "exchange", Value: types.NewCurrency64(10)},
+		},
+		Outputs: []ProcessedOutput{
+			{FundType: types.SpecifierPiscoinOutput, RelatedAddress: theirs, Value: types.NewCurrency64(4)},
+			{FundType: types.SpecifierPisfundOutput, WatchOnly: true, RelatedAddress: ours, Value: types.NewCurrency64(10)},
+		},
+	}
+	entries = NewHistoryEntries(incoming)
+	if len(entries) != 1 || entries[0].FundType != types.SpecifierPisfundOutput || entries[0].Net != "10" || !entries[0].Fee.IsZero() || entries[0].CounterpartyLabels[0] != "exchange" {
+		t.Fatal("wrong incoming entries:", entries)
+	}
+
+	var buf bytes.Buffer
+	hw := NewHistoryCSVWriter(&buf)
+	if err := hw.Write(e); err != nil {
+		t.Fatal(err)
+	}
+	if err := hw.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 || lines[0] != strings.Join(historyCSVHeader, ",") {
+		t.Fatal("wrong CSV:", buf.String())
+	}
+	want := "2017-07-14T02:40:00Z,10," + e.TransactionID.String() + ",siacoin output,-62,2," + theirs.String() + `,landlord,"rent, March"`
+	if lines[1] != want {
+		t.Fatalf("wrong CSV row:\n%v\nexpected\n%v", lines[1], want)
+	}
+}
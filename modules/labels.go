@@ -0,0 +1,241 @@
+package modules
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"sort"
+	"strings"
+	"unsafe"
+
+	"github.com/wisherd/Pis/encoding"
+	"github.com/wisherd/Pis/types"
+)
+
+const (
+	// MaxLabelLength is the maximum length of an address label or a
+	// transaction note, in bytes.
+	MaxLabelLength = 1 << 10
+)
+
+var (
+	// ErrLabelTooLong is returned when a label or note is longer than
+	// MaxLabelLength.
+	ErrLabelTooLong = errors.New("label is too long")
+)
+
+type (
+	// An AddressLabel is a label that the user assigned to an address.
+	AddressLabel struct {
+		Address types.UnlockHash `json:"address"`
+		Label   string           `json:"label"`
+	}
+
+	// A TransactionNote is a note that the user attached to a transaction.
+	TransactionNote struct {
+		TransactionID types.TransactionID `json:"transactionid"`
+		Note          string              `json:"note"`
+	}
+
+	// An OutputNote is a note that the user attached to a single output of a
+	// transaction, identified by its WalletTransactionID.
+	OutputNote struct {
+		WalletTransactionID WalletTransactionID `json:"wallettransactionid"`
+		Note                string              `json:"note"`
+	}
+
+	// WalletLabels holds the labels and notes of a wallet. The slices are
+	// sorted by address or ID, and labels and notes are never empty.
+	WalletLabels struct {
+		Addresses    []AddressLabel    `json:"addresses"`
+		Transactions []TransactionNote `json:"transactions"`
+		Outputs      []OutputNote      `json:"outputs"`
+	}
+
+	// LabelManager stores labels on addresses and notes on transactions.
+	// Labels are user data: they are kept in the encrypted wallet
+	// persistence, survive rescans and are part of the backups made with
+	// CreateBackup. The wallet must be unlocked to read or change them.
+	LabelManager interface {
+		// SetAddressLabel sets the label of an address. An empty label
+		// removes it.
+		SetAddressLabel(addr types.UnlockHash, label string) error
+
+		// SetTransactionNote sets the note of a transaction. An empty note
+		// removes it.
+		SetTransactionNote(txid types.TransactionID, note string) error
+
+		// SetOutputNote sets the note of an output of a transaction. An
+		// empty note removes it.
+		SetOutputNote(id WalletTransactionID, note string) error
+
+		// Labels returns all labels and notes of the wallet.
+		Labels() (WalletLabels, error)
+
+		// SearchLabels returns the labels and notes that contain query,
+		// ignoring case.
+		SearchLabels(query string) (WalletLabels, error)
+	}
+)
+
+// SetAddressLabel sets the label of addr. An empty label removes it.
+func (wl *WalletLabels) SetAddressLabel(addr types.UnlockHash, label string) error {
+	if len(label) > MaxLabelLength {
+		return ErrLabelTooLong
+	}
+	i := sort.Search(len(wl.Addresses), func(i int) bool {
+		return bytes.Compare(wl.Addresses[i].Address[:], addr[:]) >= 0
+	})
+	exists := i < len(wl.Addresses) && wl.Addresses[i].Address == addr
+	switch {
+	case exists && label == "":
+		wl.Addresses = append(wl.Addresses[:i], wl.Addresses[i+1:]...)
+	case exists:
+		wl.Addresses[i].Label = label
+	case label != "":
+		wl.Addresses = append(wl.Addresses, AddressLabel{})
+		copy(wl.Addresses[i+1:], wl.Addresses[i:])
+		wl.Addresses[i] = AddressLabel{Address: addr, Label: label}
+	}
+	return nil
+}
+
+// SetTransactionNote sets the note of txid. An empty note removes it.
+func (wl *WalletLabels) SetTransactionNote(txid types.TransactionID, note string) error {
+	if len(note) > MaxLabelLength {
+		return ErrLabelTooLong
+	}
+	i := sort.Search(len(wl.Transactions), func(i int) bool {
+		return bytes.Compare(wl.Transactions[i].TransactionID[:], txid[:]) >= 0
+	})
+	exists := i < len(wl.Transactions) && wl.Transactions[i].TransactionID == txid
+	switch {
+	case exists && note == "":
+		wl.Transactions = append(wl.Transactions[:i], wl.Transactions[i+1:]...)
+	case exists:
+		wl.Transactions[i].Note = note
+	case note != "":
+		wl.Transactions = append(wl.Transactions, TransactionNote{})
+		copy(wl.Transactions[i+1:], wl.Transactions[i:])
+		wl.Transactions[i] = TransactionNote{TransactionID: txid, Note: note}
+	}
+	return nil
+}
+
+// SetOutputNote sets the note of the output identified by id. An empty note
+// removes it.
+func (wl *WalletLabels) SetOutputNote(id WalletTransactionID, note string) error {
+	if len(note) > MaxLabelLength {
+		return ErrLabelTooLong
+	}
+	i := sort.Search(len(wl.Outputs), func(i int) bool {
+		return bytes.Compare(wl.Outputs[i].WalletTransactionID[:], id[:]) >= 0
+	})
+	exists := i < len(wl.Outputs) && wl.Outputs[i].WalletTransactionID == id
+	switch {
+	case exists && note == "":
+		wl.Outputs = append(wl.Outputs[:i], wl.Outputs[i+1:]...)
+	case exists:
+		wl.Outputs[i].Note = note
+	case note != "":
+		wl.Outputs = append(wl.Outputs, OutputNote{})
+		copy(wl.Outputs[i+1:], wl.Outputs[i:])
+		wl.Outputs[i] = OutputNote{WalletTransactionID: id, Note: note}
+	}
+	return nil
+}
+
+// Search returns the labels and notes that contain query, ignoring case.
+func (wl WalletLabels) Search(query string) WalletLabels {
+	query = strings.ToLower(query)
+	matches := func(s string) bool {
+		return strings.Contains(strings.ToLower(s), query)
+	}
+	var found WalletLabels
+	for _, al := range wl.Addresses {
+		if matches(al.Label) {
+			found.Addresses = append(found.Addresses, al)
+		}
+	}
+	for _, tn := range wl.Transactions {
+		if matches(tn.Note) {
+			found.Transactions = append(found.Transactions, tn)
+		}
+	}
+	for _, on := range wl.Outputs {
+		if matches(on.Note) {
+			found.Outputs = append(found.Outputs, on)
+		}
+	}
+	return found
+}
+
+// Annotate fills in the labels and notes of pt. The wallet calls Annotate on
+// every ProcessedTransaction it returns.
+func (wl WalletLabels) Annotate(pt *ProcessedTransaction) {
+	labels := make(map[types.UnlockHash]string, len(wl.Addresses))
+	for _, al := range wl.Addresses {
+		labels[al.Address] = al.Label
+	}
+	notes := make(map[WalletTransactionID]string, len(wl.Outputs))
+	for _, on := range wl.Outputs {
+		notes[on.WalletTransactionID] = on.Note
+	}
+
+	pt.Note = ""
+	for _, tn := range wl.Transactions {
+		if tn.TransactionID == pt.TransactionID {
+			pt.Note = tn.Note
+			break
+		}
+	}
+	for i := range pt.Inputs {
+		pt.Inputs[i].Label = labels[pt.Inputs[i].RelatedAddress]
+	}
+	for i := range pt.Outputs {
+		pt.Outputs[i].Label = labels[pt.Outputs[i].RelatedAddress]
+		pt.Outputs[i].Note = notes[CalculateWalletTransactionID(pt.TransactionID, pt.Outputs[i].ID)]
+	}
+}
+
+// MarshalPis implements the encoding.PisMarshaler interface.
+func (wl WalletLabels) MarshalPis(w io.Writer) error {
+	e := encoding.NewEncoder(w)
+	e.WriteInt(len(wl.Addresses))
+	for _, al := range wl.Addresses {
+		e.Write(al.Address[:])
+		e.WritePrefixedBytes([]byte(al.Label))
+	}
+	e.WriteInt(len(wl.Transactions))
+	for _, tn := range wl.Transactions {
+		e.Write(tn.TransactionID[:])
+		e.WritePrefixedBytes([]byte(tn.Note))
+	}
+	e.WriteInt(len(wl.Outputs))
+	for _, on := range wl.Outputs {
+		e.Write(on.WalletTransactionID[:])
+		e.WritePrefixedBytes([]byte(on.Note))
+	}
+	return e.Err()
+}
+
+// UnmarshalPis implements the encoding.PisUnmarshaler interface.
+func (wl *WalletLabels) UnmarshalPis(r io.Reader) error {
+	d := encoding.NewDecoder(r)
+	wl.Addresses = make([]AddressLabel, d.NextPrefix(unsafe.Sizeof(AddressLabel{})))
+	for i := range wl.Addresses {
+		d.ReadFull(wl.Addresses[i].Address[:])
+		wl.Addresses[i].Label = string(d.ReadPrefixedBytes())
+	}
+	wl.Transactions = make([]TransactionNote, d.NextPrefix(unsafe.Sizeof(TransactionNote{})))
+	for i := range wl.Transactions {
+		d.ReadFull(wl.Transactions[i].TransactionID[:])
+		wl.Transactions[i].Note = string(d.ReadPrefixedBytes())
+	}
+	wl.Outputs = make([]OutputNote, d.NextPrefix(unsafe.Sizeof(OutputNote{})))
+	for i := range wl.Outputs {
+		d.ReadFull(wl.Outputs[i].WalletTransactionID[:])
+		wl.Outputs[i].Note = string(d.ReadPrefixedBytes())
+	}
+	return d.Err()
+}
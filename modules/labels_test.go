@@ -0,0 +1,69 @@
+package modules
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/wisherd/Pis/types"
+)
+
+// TestWalletLabels checks that labels and notes are stored, searched, applied
+// to processed transactions and encoded.
+func TestWalletLabels(t *testing.T) {
+	var wl WalletLabels
+	txid := types.TransactionID{1}
+	oid := types.OutputID{2}
+	if err := wl.SetAddressLabel(types.UnlockHash{3}, "Exchange deposit"); err != nil {
+		t.Fatal(err)
+	}
+	if err := wl.SetAddressLabel(types.UnlockHash{1}, "Savings"); err != nil {
+		t.Fatal(err)
+	}
+	if err := wl.SetTransactionNote(txid, "Invoice 17"); err != nil {
+		t.Fatal(err)
+	}
+	if err := wl.SetOutputNote(CalculateWalletTransactionID(txid, oid), "refund"); err != nil {
+		t.Fatal(err)
+	}
+	if err := wl.SetAddressLabel(types.UnlockHash{2}, string(make([]byte, MaxLabelLength+1))); err != ErrLabelTooLong {
+		t.Fatal("expected ErrLabelTooLong, got", err)
+	}
+	if len(wl.Addresses) != 2 || wl.Addresses[0].Label != "Savings" {
+		t.Fatal("address labels are not sorted:", wl.Addresses)
+	}
+
+	found := wl.Search("DEPOSIT")
+	if len(found.Addresses) != 1 || found.Addresses[0].Address != (types.UnlockHash{3}) || len(found.Transactions) != 0 {
+		t.Fatal("wrong search result:", found)
+	}
+
+	pt := ProcessedTransaction{
+		TransactionID: txid,
+		Inputs:        []ProcessedInput{{RelatedAddress: types.UnlockHash{1}}},
+		Outputs:       []ProcessedOutput{{ID: oid, RelatedAddress: types.UnlockHash{3}}, {RelatedAddress: types.UnlockHash{4}}},
+	}
+	wl.Annotate(&pt)
+	if pt.Note != "Invoice 17" || pt.Inputs[0].Label != "Savings" || pt.Outputs[0].Label != "Exchange deposit" || pt.Outputs[0].Note != "refund" || pt.Outputs[1].Label != "" {
+		t.Fatal("wrong annotations:", pt)
+	}
+
+	var buf bytes.Buffer
+	if err := wl.MarshalPis(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var decoded WalletLabels
+	if err := decoded.UnmarshalPis(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, wl) {
+		t.Fatal("labels changed in encoding:", decoded)
+	}
+
+	// Empty labels and notes remove the entry.
+	wl.SetAddressLabel(types.UnlockHash{1}, "")
+	wl.SetTransactionNote(txid, "")
+	if len(wl.Addresses) != 1 || len(wl.Transactions) != 0 {
+		t.Fatal("labels were not removed:", wl)
+	}
+}
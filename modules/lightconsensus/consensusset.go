@@ -0,0 +1,269 @@
+package lightconsensus
+
+import (
+	"github.com/wisherd/Pis/modules"
+	"github.com/wisherd/Pis/types"
+)
+
+// lightBlockAt returns the block at the provided height of the current path.
+func (cs *ConsensusSet) lightBlockAt(height types.BlockHeight) (types.Block, bool) {
+	if height >= types.BlockHeight(len(cs.path)) {
+		return types.Block{}, false
+	}
+	return cs.blocks[cs.path[height].id].Block, true
+}
+
+// inCurrentPath returns true if the node is part of the current path.
+func (cs *ConsensusSet) inCurrentPath(node *headerNode) bool {
+	return node.height < types.BlockHeight(len(cs.path)) && cs.path[node.height] == node
+}
+
+// tryTransactionSet accepts every transaction set. A light client cannot
+// validate transactions that spend outputs it does not watch, so validation
+// is left to the full peers that the transactions are relayed to.
+func (cs *ConsensusSet) tryTransactionSet([]types.Transaction) (modules.ConsensusChange, error) {
+	return modules.ConsensusChange{}, nil
+}
+
+// AcceptBlock adds the header of b to the header tree and relays b to the
+// peers. The transactions of b are not validated.
+func (cs *ConsensusSet) AcceptBlock(b types.Block) error {
+	if err := cs.managedAcceptHeaders([]types.BlockHeader{b.Header()}); err != nil {
+		return err
+	}
+	if cs.g != nil {
+		go modules.RelayBlock(cs.g, b, cs.g.Peers())
+	}
+	return nil
+}
+
+// BlockAtHeight returns the block at the provided height of the current path.
+// Only the transactions that involve watched addresses are included.
+func (cs *ConsensusSet) BlockAtHeight(height types.BlockHeight) (types.Block, bool) {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	return cs.lightBlockAt(height)
+}
+
+// BlockByID returns the block with the provided ID if it is part of the
+// current path. Only the transactions that involve watched addresses are
+// included.
+func (cs *ConsensusSet) BlockByID(id types.BlockID) (types.Block, types.BlockHeight, bool) {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	node, exists := cs.nodes[id]
+	if !exists || !cs.inCurrentPath(node) {
+		return types.Block{}, 0, false
+	}
+	return cs.blocks[id].Block, node.height, true
+}
+
+// BlockHeaderByID returns the header and height of the block with the
+// provided ID if it is part of the current path.
+func (cs *ConsensusSet) BlockHeaderByID(id types.BlockID) (types.BlockHeader, types.BlockHeight, bool) {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	node, exists := cs.nodes[id]
+	if !exists || !cs.inCurrentPath(node) {
+		return types.BlockHeader{}, 0, false
+	}
+	return node.header, node.height, true
+}
+
+// ChildTarget returns the target that a child of the block with the provided
+// ID must meet.
+func (cs *ConsensusSet) ChildTarget(id types.BlockID) (types.Target, bool) {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	node, exists := cs.nodes[id]
+	if !exists {
+		return types.Target{}, false
+	}
+	return node.state.ChildTarget, true
+}
+
+// ConsensusSetSubscribe sends every consensus change since the change with
+// the provided ID to the subscriber and adds it to the list of subscribers.
+func (cs *ConsensusSet) ConsensusSetSubscribe(sub modules.ConsensusSetSubscriber, start modules.ConsensusChangeID, cancel <-chan struct{}) error {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	return cs.subscribe(sub, start, cancel)
+}
+
+// subscribe implements ConsensusSetSubscribe. The caller must hold the lock.
+func (cs *ConsensusSet) subscribe(sub modules.ConsensusSetSubscriber, start modules.ConsensusChangeID, cancel <-chan struct{}) error {
+	var i int
+	switch start {
+	case modules.ConsensusChangeBeginning:
+		i = 0
+	case modules.ConsensusChangeRecent:
+		i = len(cs.changes)
+	default:
+		i = -1
+		for j, entry := range cs.changes {
+			if changeID(entry) == start {
+				i = j + 1
+				break
+			}
+		}
+		if i < 0 {
+			return modules.ErrInvalidConsensusChangeID
+		}
+	}
+	for ; i < len(cs.changes); i++ {
+		select {
+		case <-cancel:
+			return errSubscriptionCancelled
+		default:
+		}
+		sub.ProcessConsensusChange(cs.computeChange(cs.changes[i]))
+	}
+	cs.subscribers = append(cs.subscribers, sub)
+	return nil
+}
+
+// ConsensusSetFilteredSubscribe is like ConsensusSetSubscribe, but only the
+// diffs and transactions matching the filter are sent. The unlock hashes of
+// the filter are added to the watched addresses.
+func (cs *ConsensusSet) ConsensusSetFilteredSubscribe(sub modules.FilteredConsensusSetSubscriber, start modules.ConsensusChangeID, f modules.ConsensusChangeFilter, cancel <-chan struct{}) error {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	adapter := modules.NewFilteredSubscriber(f, sub)
+	if err := cs.subscribe(adapter, start, cancel); err != nil {
+		return err
+	}
+	cs.filtered[sub] = filteredSubscription{
+		filter:  f,
+		adapter: adapter,
+	}
+	return nil
+}
+
+// CurrentBlock returns the last block of the current path. Only the
+// transactions that involve watched addresses are included.
+func (cs *ConsensusSet) CurrentBlock() types.Block {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	return cs.blocks[cs.currentNode().id].Block
+}
+
+// Flush is a no-op, since the light consensus set has no background
+// routines that modify the consensus state.
+func (cs *ConsensusSet) Flush() error {
+	return nil
+}
+
+// Height returns the height of the current path.
+func (cs *ConsensusSet) Height() types.BlockHeight {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	return cs.currentNode().height
+}
+
+// Synced returns true once a peer has reported that it has no more headers
+// to send and the proven blocks of all headers have been processed.
+func (cs *ConsensusSet) Synced() bool {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	return cs.synced && cs.tip == cs.currentNode()
+}
+
+// SyncProgress reports the height of the heaviest known header and the
+// height of the current path.
+func (cs *ConsensusSet) SyncProgress() modules.SyncProgress {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	return modules.SyncProgress{
+		Synced:       cs.synced && cs.tip == cs.currentNode(),
+		HeaderHeight: cs.tip.height,
+		BlockHeight:  cs.currentNode().height,
+	}
+}
+
+// InCurrentPath returns true if the block with the provided ID is part of
+// the current path.
+func (cs *ConsensusSet) InCurrentPath(id types.BlockID) bool {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	node, exists := cs.nodes[id]
+	return exists && cs.inCurrentPath(node)
+}
+
+// MinimumValidChildTimestamp returns the earliest timestamp that a child of
+// the block with the provided ID may have.
+func (cs *ConsensusSet) MinimumValidChildTimestamp(id types.BlockID) (types.Timestamp, bool) {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	node, exists := cs.nodes[id]
+	if !exists {
+		return 0, false
+	}
+	return minimumValidChildTimestamp(node), true
+}
+
+// MinerPayoutProof returns modules.ErrLightMode.
+func (cs *ConsensusSet) MinerPayoutProof(types.BlockID, uint64) (types.MerkleInclusionProof, error) {
+	return types.MerkleInclusionProof{}, modules.ErrLightMode
+}
+
+// PrunedHeight returns zero, since the light consensus set never stores
+// block bodies in the first place.
+func (cs *ConsensusSet) PrunedHeight() types.BlockHeight {
+	return 0
+}
+
+// RebuildState returns modules.ErrLightMode.
+func (cs *ConsensusSet) RebuildState(*modules.ConsensusState) error {
+	return modules.ErrLightMode
+}
+
+// StoredState returns modules.ErrLightMode.
+func (cs *ConsensusSet) StoredState() (*modules.ConsensusState, error) {
+	return nil, modules.ErrLightMode
+}
+
+// StorageProofSegment returns modules.ErrLightMode.
+func (cs *ConsensusSet) StorageProofSegment(types.FileContractID) (uint64, error) {
+	return 0, modules.ErrLightMode
+}
+
+// TransactionProof returns modules.ErrLightMode.
+func (cs *ConsensusSet) TransactionProof(types.BlockID, types.TransactionID) (types.MerkleInclusionProof, error) {
+	return types.MerkleInclusionProof{}, modules.ErrLightMode
+}
+
+// TryTransactionSet accepts every transaction set without returning any
+// diffs, see tryTransactionSet.
+func (cs *ConsensusSet) TryTransactionSet(txns []types.Transaction) (modules.ConsensusChange, error) {
+	return cs.tryTransactionSet(txns)
+}
+
+// Unsubscribe removes a subscriber from the list of subscribers.
+func (cs *ConsensusSet) Unsubscribe(sub modules.ConsensusSetSubscriber) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.unsubscribe(sub)
+}
+
+// unsubscribe implements Unsubscribe. The caller must hold the lock.
+func (cs *ConsensusSet) unsubscribe(sub modules.ConsensusSetSubscriber) {
+	for i := range cs.subscribers {
+		if cs.subscribers[i] == sub {
+			cs.subscribers = append(cs.subscribers[:i], cs.subscribers[i+1:]...)
+			return
+		}
+	}
+}
+
+// UnsubscribeFiltered removes a subscriber that was added with
+// ConsensusSetFilteredSubscribe.
+func (cs *ConsensusSet) UnsubscribeFiltered(sub modules.FilteredConsensusSetSubscriber) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	fs, exists := cs.filtered[sub]
+	if !exists {
+		return
+	}
+	cs.unsubscribe(fs.adapter)
+	delete(cs.filtered, sub)
+}
@@ -0,0 +1,63 @@
+package lightconsensus
+
+import (
+	"github.com/wisherd/Pis/modules"
+	"github.com/wisherd/Pis/types"
+)
+
+// trackSideHeader reports node to the fork tracker if it is not part of the
+// heaviest chain.
+func (cs *ConsensusSet) trackSideHeader(node *headerNode) {
+	if node == cs.tip {
+		return
+	}
+	forkPoint := node.parent
+	for !cs.inCurrentPath(forkPoint) {
+		forkPoint = forkPoint.parent
+	}
+	cs.forks.AddSideBlock(node.id, node.header.ParentID, node.height, node.depth, forkPoint.id, forkPoint.height)
+}
+
+// recordPathChange reports a change of the current path to the fork tracker.
+// It must be called before the current path is changed.
+func (cs *ConsensusSet) recordPathChange(reverted, applied []*headerNode) {
+	appliedIDs := make([]types.BlockID, 0, len(applied))
+	for _, node := range applied {
+		appliedIDs = append(appliedIDs, node.id)
+	}
+	if len(reverted) == 0 {
+		cs.forks.AddReorg(types.BlockID{}, 0, types.Target{}, nil, appliedIDs)
+		return
+	}
+	revertedBlocks := make([]types.Block, 0, len(reverted))
+	for _, node := range reverted {
+		revertedBlocks = append(revertedBlocks, cs.blocks[node.id].Block)
+	}
+	forkPoint := reverted[len(reverted)-1].parent
+	cs.forks.AddReorg(forkPoint.id, forkPoint.height, reverted[0].depth, revertedBlocks, appliedIDs)
+}
+
+// Forks returns the current path followed by the known side chains. Only the
+// side chains that were seen since the consensus set was started are
+// reported.
+func (cs *ConsensusSet) Forks() []modules.ForkInfo {
+	cs.mu.RLock()
+	current := cs.currentNode()
+	cs.mu.RUnlock()
+	active := modules.ForkInfo{
+		TipID:                current.id,
+		TipHeight:            current.height,
+		Depth:                current.depth,
+		CumulativeDifficulty: current.depth.Difficulty(),
+		ForkPointID:          current.id,
+		ForkPointHeight:      current.height,
+		Active:               true,
+	}
+	return append([]modules.ForkInfo{active}, cs.forks.Forks()...)
+}
+
+// Reorgs returns the most recent reorgs of the current path. Only the
+// transactions that involve watched addresses are listed as reverted.
+func (cs *ConsensusSet) Reorgs() []modules.ReorgEvent {
+	return cs.forks.Reorgs()
+}
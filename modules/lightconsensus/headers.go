@@ -0,0 +1,122 @@
+package lightconsensus
+
+import (
+	"github.com/wisherd/Pis/modules"
+	"github.com/wisherd/Pis/types"
+)
+
+// headerNode is a header in the header tree along with the metadata needed
+// to validate its children and to determine the heaviest chain.
+type headerNode struct {
+	header types.BlockHeader
+	id     types.BlockID
+	height types.BlockHeight
+	depth  types.Target
+	state  modules.TargetState
+	parent *headerNode
+}
+
+// newGenesisNode returns the root of the header tree.
+func newGenesisNode() *headerNode {
+	return &headerNode{
+		header: types.GenesisBlock.Header(),
+		id:     types.GenesisID,
+		depth:  types.RootDepth,
+		state:  modules.GenesisTargetState(),
+	}
+}
+
+// ancestorTimestamp returns a modules.TimestampFunc for the ancestors of
+// node.
+func ancestorTimestamp(node *headerNode) modules.TimestampFunc {
+	return func(height types.BlockHeight) types.Timestamp {
+		n := node
+		for n.height > height && n.parent != nil {
+			n = n.parent
+		}
+		return n.header.Timestamp
+	}
+}
+
+// minimumValidChildTimestamp returns the earliest timestamp that a child of
+// node may have.
+func minimumValidChildTimestamp(node *headerNode) types.Timestamp {
+	return modules.MinimumValidChildTimestamp(node.height, ancestorTimestamp(node))
+}
+
+// addHeader validates h and adds it to the header tree. Headers that are
+// already known are ignored.
+func (cs *ConsensusSet) addHeader(h types.BlockHeader) error {
+	id := h.ID()
+	if _, exists := cs.nodes[id]; exists {
+		return nil
+	}
+	parent, exists := cs.nodes[h.ParentID]
+	if !exists {
+		return modules.ErrOrphanHeader
+	}
+	if !h.CheckTarget(parent.state.ChildTarget) {
+		return modules.ErrBlockUnsolved
+	}
+	timestamp := ancestorTimestamp(parent)
+	if err := modules.CheckHeaderTimestamp(h.Timestamp, parent.height, timestamp); err != nil {
+		return err
+	}
+	if err := modules.CheckCheckpoint(parent.height+1, id); err != nil {
+		return err
+	}
+
+	node := &headerNode{
+		header: h,
+		id:     id,
+		height: parent.height + 1,
+		depth:  parent.depth.AddDifficulties(parent.state.ChildTarget),
+		state:  modules.ChildTargetState(parent.state, h.Timestamp, timestamp),
+		parent: parent,
+	}
+	cs.nodes[id] = node
+	cs.unsavedHeaders = append(cs.unsavedHeaders, node)
+	// A lower depth means that more work went into the chain.
+	if node.depth.Cmp(cs.tip.depth) < 0 {
+		cs.tip = node
+	}
+	return nil
+}
+
+// currentNode returns the last node of the current path.
+func (cs *ConsensusSet) currentNode() *headerNode {
+	return cs.path[len(cs.path)-1]
+}
+
+// forkTo returns the nodes that have to be reverted and applied to move the
+// current path to the provided node. The reverted nodes are in the order in
+// which they are reverted, the applied nodes in the order in which they are
+// applied.
+func (cs *ConsensusSet) forkTo(node *headerNode) (reverted, applied []*headerNode) {
+	for ; node.height >= types.BlockHeight(len(cs.path)) || cs.path[node.height] != node; node = node.parent {
+		applied = append([]*headerNode{node}, applied...)
+	}
+	for i := len(cs.path) - 1; i > int(node.height); i-- {
+		reverted = append(reverted, cs.path[i])
+	}
+	return reverted, applied
+}
+
+// blockHistory returns the IDs of the blocks that are sent to a peer to find
+// the point where our chain and the peer's chain diverge. The 12 most recent
+// blocks are included, followed by blocks at exponentially increasing
+// distances. The genesis block is always the last entry.
+func (cs *ConsensusSet) blockHistory() (history [32]types.BlockID) {
+	height := len(cs.path) - 1
+	step := 1
+	i := 0
+	for ; i < len(history)-1 && height > 0; i++ {
+		history[i] = cs.path[height].id
+		if i >= 11 {
+			step *= 2
+		}
+		height -= step
+	}
+	history[i] = types.GenesisID
+	return history
+}
@@ -0,0 +1,375 @@
+// Package lightconsensus implements a light (SPV) consensus set. Only block
+// headers are downloaded and checked for proof of work. The transactions
+// that involve the watched addresses are requested from full peers along
+// with Merkle proofs that tie them to the headers. The package implements
+// enough of modules.ConsensusSet for the wallet and the transaction pool to
+// work; methods that require full blocks return modules.ErrLightMode.
+package lightconsensus
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/coreos/bbolt"
+	"github.com/wisherd/Pis/build"
+	"github.com/wisherd/Pis/crypto"
+	"github.com/wisherd/Pis/modules"
+	"github.com/wisherd/Pis/persist"
+	"github.com/wisherd/Pis/types"
+)
+
+const (
+	// persistFilename is the name of the database that holds the headers,
+	// the processed blocks and the consensus changes.
+	persistFilename = "lightconsensus.db"
+)
+
+var (
+	// errNoProofPeers is returned when no peer was able to provide proven
+	// blocks.
+	errNoProofPeers = errors.New("no peers were able to provide proven blocks")
+
+	// errSubscriptionCancelled is returned when a subscription is cancelled
+	// before all changes have been sent.
+	errSubscriptionCancelled = errors.New("consensus subscription was cancelled")
+
+	// persistMetadata is the header of the database.
+	persistMetadata = persist.Metadata{
+		Header:  "Light Consensus",
+		Version: "1.0",
+	}
+
+	// bucketHeaders holds the headers, keyed by height and ID so that
+	// parents are loaded before their children. bucketBlocks holds the
+	// processed blocks, keyed by ID. bucketChanges holds the consensus
+	// changes, keyed by their index.
+	bucketHeaders = []byte("Headers")
+	bucketBlocks  = []byte("Blocks")
+	bucketChanges = []byte("Changes")
+
+	// syncInterval is how often the light consensus set asks its peers for
+	// new headers.
+	syncInterval = build.Select(build.Var{
+		Standard: 2 * time.Minute,
+		Dev:      20 * time.Second,
+		Testing:  time.Second,
+	}).(time.Duration)
+)
+
+type (
+	// A ProvenBlockFetchFunc requests the proven blocks with the provided
+	// IDs for the provided unlock hashes. The returned blocks must already
+	// be verified against their headers.
+	ProvenBlockFetchFunc func(headers []types.BlockHeader, uhs []types.UnlockHash) ([]modules.ProvenBlock, error)
+
+	// A lightBlock is a block of the current path, or one that was reverted,
+	// reduced to its header fields and the matching transactions, along with
+	// the diffs that it applied to the watched outputs.
+	lightBlock struct {
+		Block types.Block
+		Diffs modules.PrunedBlock
+	}
+
+	// A changeEntry records the blocks that were reverted and applied by a
+	// consensus change.
+	changeEntry struct {
+		RevertedBlocks []types.BlockID
+		AppliedBlocks  []types.BlockID
+	}
+
+	// A filteredSubscription is a filtered subscriber along with the
+	// adapter that is stored in the list of subscribers.
+	filteredSubscription struct {
+		filter  modules.ConsensusChangeFilter
+		adapter modules.ConsensusSetSubscriber
+	}
+
+	// ConsensusSet is a light consensus set.
+	ConsensusSet struct {
+		g          modules.Gateway
+		fetch      ProvenBlockFetchFunc
+		persistDir string
+
+		// db holds the headers, blocks and changes. save only writes the
+		// ones that were added since the last save, so that the cost of
+		// saving does not grow with the length of the chain.
+		db             *persist.BoltDatabase
+		unsavedHeaders []*headerNode
+		unsavedBlocks  []types.BlockID
+		savedChanges   int
+
+		// The header tree, the heaviest known header and the current path.
+		// The current path only lags behind the heaviest header while the
+		// proven blocks of the new headers are being downloaded.
+		nodes map[types.BlockID]*headerNode
+		tip   *headerNode
+		path  []*headerNode
+
+		// blocks contains every block that was applied at some point, and
+		// changes contains every consensus change in order.
+		blocks  map[types.BlockID]lightBlock
+		changes []changeEntry
+
+		// state holds the watched outputs.
+		state *modules.ConsensusState
+
+		addressSource func() ([]types.UnlockHash, error)
+		watched       map[types.UnlockHash]struct{}
+
+		forks *modules.ForkTracker
+
+		subscribers []modules.ConsensusSetSubscriber
+		filtered    map[modules.FilteredConsensusSetSubscriber]filteredSubscription
+		synced      bool
+
+		// syncTrigger wakes up threadedSync when a peer relays a block
+		// whose parent is unknown.
+		syncTrigger chan struct{}
+
+		stop   chan struct{}
+		wg     sync.WaitGroup
+		syncMu sync.Mutex
+		mu     sync.RWMutex
+	}
+)
+
+// New returns a light consensus set that follows the chain through g. If g is
+// nil, the consensus set only changes through AcceptBlock, which is useful
+// for testing.
+func New(g modules.Gateway, persistDir string) (*ConsensusSet, error) {
+	cs := &ConsensusSet{
+		g:           g,
+		persistDir:  persistDir,
+		nodes:       make(map[types.BlockID]*headerNode),
+		blocks:      make(map[types.BlockID]lightBlock),
+		state:       modules.NewConsensusState(),
+		watched:     make(map[types.UnlockHash]struct{}),
+		forks:       modules.NewForkTracker(types.StdClock{}),
+		filtered:    make(map[modules.FilteredConsensusSetSubscriber]filteredSubscription),
+		syncTrigger: make(chan struct{}, 1),
+		stop:        make(chan struct{}),
+	}
+	cs.fetch = cs.gatewayFetch
+	if err := os.MkdirAll(persistDir, 0700); err != nil {
+		return nil, err
+	}
+	db, err := persist.OpenDatabase(persistMetadata, filepath.Join(persistDir, persistFilename))
+	if err != nil {
+		return nil, err
+	}
+	cs.db = db
+	genesis := newGenesisNode()
+	cs.nodes[genesis.id] = genesis
+	cs.tip = genesis
+
+	if err := cs.load(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if len(cs.changes) == 0 {
+		// The genesis block is known locally, so its proven block does not
+		// have to be downloaded.
+		pb := modules.NewProvenBlock(types.GenesisBlock, cs.filter())
+		cs.applyChange(nil, []*headerNode{genesis}, []modules.ProvenBlock{pb})
+	}
+
+	if g != nil {
+		g.RegisterRPC(modules.RelayBlockRPC, cs.rpcRelayBlock)
+		cs.wg.Add(1)
+		go cs.threadedSync()
+	}
+	return cs, nil
+}
+
+// SetFetcher replaces the function used to download proven blocks.
+func (cs *ConsensusSet) SetFetcher(fetch ProvenBlockFetchFunc) {
+	cs.syncMu.Lock()
+	defer cs.syncMu.Unlock()
+	cs.fetch = fetch
+}
+
+// SetAddressSource sets a function that returns the addresses whose
+// transactions should be followed, usually Wallet.AllAddresses. It is called
+// every time new blocks are processed. Transactions are only found for blocks
+// that are processed after an address has been returned by the source, so a
+// wallet that is restored from a seed has to be synced from scratch.
+func (cs *ConsensusSet) SetAddressSource(source func() ([]types.UnlockHash, error)) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.addressSource = source
+}
+
+// Watch adds addresses whose transactions should be followed.
+func (cs *ConsensusSet) Watch(uhs ...types.UnlockHash) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	for _, uh := range uhs {
+		cs.watched[uh] = struct{}{}
+	}
+}
+
+// filter returns a filter that matches every watched address, including the
+// unlock hashes in the filters of the filtered subscribers. Only unlock
+// hashes are watched; output and file contract IDs in the filters of
+// subscribers are not sent to peers.
+func (cs *ConsensusSet) filter() modules.ConsensusChangeFilter {
+	f := modules.NewConsensusChangeFilter()
+	for uh := range cs.watched {
+		f.UnlockHashes[uh] = struct{}{}
+	}
+	for _, fs := range cs.filtered {
+		for uh := range fs.filter.UnlockHashes {
+			f.UnlockHashes[uh] = struct{}{}
+		}
+	}
+	return f
+}
+
+// managedFilter adds the addresses of the address source to the watched
+// addresses and returns the filter. The address source is called without
+// holding the lock, since it usually calls into the wallet, which may call
+// into the consensus set in turn.
+func (cs *ConsensusSet) managedFilter() modules.ConsensusChangeFilter {
+	cs.mu.RLock()
+	source := cs.addressSource
+	cs.mu.RUnlock()
+	if source != nil {
+		if uhs, err := source(); err == nil {
+			cs.Watch(uhs...)
+		}
+	}
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	return cs.filter()
+}
+
+// headerKey returns the database key of the header of node.
+func headerKey(node *headerNode) []byte {
+	key := make([]byte, 8, 8+len(node.id))
+	binary.BigEndian.PutUint64(key, uint64(node.height))
+	return append(key, node.id[:]...)
+}
+
+// changeKey returns the database key of the change at index i.
+func changeKey(i int) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(i))
+	return key
+}
+
+// putJSON stores the JSON encoding of v under key in b.
+func putJSON(b *bolt.Bucket, key []byte, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return b.Put(key, data)
+}
+
+// load restores the consensus set from disk by replaying every consensus
+// change.
+func (cs *ConsensusSet) load() error {
+	err := cs.db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{bucketHeaders, bucketBlocks, bucketChanges} {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+		err := tx.Bucket(bucketHeaders).ForEach(func(_, v []byte) error {
+			var h types.BlockHeader
+			if err := json.Unmarshal(v, &h); err != nil {
+				return err
+			}
+			if err := cs.addHeader(h); err != nil && err != modules.ErrFutureTimestamp {
+				return err
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		err = tx.Bucket(bucketBlocks).ForEach(func(_, v []byte) error {
+			var lb lightBlock
+			if err := json.Unmarshal(v, &lb); err != nil {
+				return err
+			}
+			cs.blocks[lb.Diffs.Header.ID()] = lb
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(bucketChanges).ForEach(func(_, v []byte) error {
+			var entry changeEntry
+			if err := json.Unmarshal(v, &entry); err != nil {
+				return err
+			}
+			return cs.replayChange(entry)
+		})
+	})
+	if err != nil {
+		return err
+	}
+	// Everything that was loaded is already stored.
+	cs.unsavedHeaders, cs.unsavedBlocks = nil, nil
+	cs.savedChanges = len(cs.changes)
+	return nil
+}
+
+// save writes the headers, blocks and changes that were added since the last
+// save to disk.
+func (cs *ConsensusSet) save() error {
+	err := cs.db.Update(func(tx *bolt.Tx) error {
+		headers := tx.Bucket(bucketHeaders)
+		for _, node := range cs.unsavedHeaders {
+			if err := putJSON(headers, headerKey(node), node.header); err != nil {
+				return err
+			}
+		}
+		blocks := tx.Bucket(bucketBlocks)
+		for _, id := range cs.unsavedBlocks {
+			if err := putJSON(blocks, id[:], cs.blocks[id]); err != nil {
+				return err
+			}
+		}
+		changes := tx.Bucket(bucketChanges)
+		for i := cs.savedChanges; i < len(cs.changes); i++ {
+			if err := putJSON(changes, changeKey(i), cs.changes[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	cs.unsavedHeaders, cs.unsavedBlocks = nil, nil
+	cs.savedChanges = len(cs.changes)
+	return nil
+}
+
+// Close saves the consensus set and stops synchronizing.
+func (cs *ConsensusSet) Close() error {
+	close(cs.stop)
+	if cs.g != nil {
+		cs.g.UnregisterRPC(modules.RelayBlockRPC)
+	}
+	cs.wg.Wait()
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	err := cs.save()
+	if closeErr := cs.db.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// changeID returns the ID of a consensus change.
+func changeID(entry changeEntry) modules.ConsensusChangeID {
+	return modules.ConsensusChangeID(crypto.HashAll(entry.RevertedBlocks, entry.AppliedBlocks))
+}
@@ -0,0 +1,385 @@
+package lightconsensus
+
+import (
+	"math/big"
+	"net"
+	"testing"
+
+	"github.com/wisherd/Pis/build"
+	"github.com/wisherd/Pis/encoding"
+	"github.com/wisherd/Pis/modules"
+	"github.com/wisherd/Pis/types"
+)
+
+var _ modules.ConsensusSet = (*ConsensusSet)(nil)
+
+// lightTester is a light consensus set whose proven blocks are produced from
+// the full blocks that were mined by the tester.
+type lightTester struct {
+	cs     *ConsensusSet
+	dir    string
+	blocks map[types.BlockID]types.Block
+	t      *testing.T
+}
+
+// newLightTester returns a light consensus set without a gateway.
+func newLightTester(t *testing.T) *lightTester {
+	lt := &lightTester{
+		dir:    build.TempDir(modules.LightConsensusDir, t.Name()),
+		blocks: make(map[types.BlockID]types.Block),
+		t:      t,
+	}
+	lt.open()
+	return lt
+}
+
+// open (re)loads the consensus set from disk.
+func (lt *lightTester) open() {
+	cs, err := New(nil, lt.dir)
+	if err != nil {
+		lt.t.Fatal(err)
+	}
+	cs.SetFetcher(lt.fetch)
+	lt.cs = cs
+}
+
+// fetch implements ProvenBlockFetchFunc.
+func (lt *lightTester) fetch(headers []types.BlockHeader, uhs []types.UnlockHash) ([]modules.ProvenBlock, error) {
+	f := modules.NewConsensusChangeFilter(uhs...)
+	pbs := make([]modules.ProvenBlock, len(headers))
+	for i, h := range headers {
+		pbs[i] = modules.NewProvenBlock(lt.blocks[h.ID()], f)
+		if err := pbs[i].Verify(h); err != nil {
+			return nil, err
+		}
+	}
+	return pbs, nil
+}
+
+// mine solves a block on top of parent and submits it.
+func (lt *lightTester) mine(parent types.BlockID, payouts []types.PiscoinOutput, txns ...types.Transaction) types.Block {
+	target, ok := lt.cs.ChildTarget(parent)
+	if !ok {
+		lt.t.Fatal("unknown parent")
+	}
+	b := types.Block{
+		ParentID:     parent,
+		Timestamp:    types.CurrentTimestamp(),
+		MinerPayouts: payouts,
+		Transactions: txns,
+	}
+	for i := uint64(0); !b.Header().CheckTarget(target); i++ {
+		for j := range b.Nonce {
+			b.Nonce[j] = byte(i >> (8 * uint(j)))
+		}
+	}
+	lt.blocks[b.ID()] = b
+	if err := lt.cs.AcceptBlock(b); err != nil {
+		lt.t.Fatal(err)
+	}
+	return b
+}
+
+// state returns the watched outputs as seen by a new subscriber.
+func (lt *lightTester) state() *modules.ConsensusState {
+	s := modules.NewConsensusState()
+	if err := lt.cs.ConsensusSetSubscribe(s, modules.ConsensusChangeBeginning, nil); err != nil {
+		lt.t.Fatal(err)
+	}
+	lt.cs.Unsubscribe(s)
+	if err := s.Err(); err != nil {
+		lt.t.Fatal(err)
+	}
+	return s
+}
+
+// TestLightConsensus checks that payouts and transactions to watched
+// addresses are followed, that a heavier fork reverts them, and that the
+// consensus set survives a restart.
+func TestLightConsensus(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	lt := newLightTester(t)
+	ours := types.UnlockHash{1}
+	theirs := types.UnlockHash{2}
+	lt.cs.Watch(ours)
+
+	payouts := []types.PiscoinOutput{
+		{UnlockHash: theirs, Value: types.NewCurrency64(1)},
+		{UnlockHash: ours, Value: types.NewCurrency64(2)},
+	}
+	b1 := lt.mine(types.GenesisID, payouts)
+	txn := types.Transaction{
+		PiscoinOutputs: []types.PiscoinOutput{
+			{UnlockHash: ours, Value: types.NewCurrency64(3)},
+			{UnlockHash: theirs, Value: types.NewCurrency64(4)},
+		},
+	}
+	b2 := lt.mine(b1.ID(), nil, txn)
+	if h := lt.cs.Height(); h != 2 {
+		t.Fatal("wrong height:", h)
+	}
+
+	s := lt.state()
+	payoutID := b1.MinerPayoutID(1)
+	if _, exists := s.DelayedPiscoinOutputs[1+types.MaturityDelay][payoutID]; !exists {
+		t.Fatal("watched miner payout was not added as a delayed output")
+	}
+	if len(s.DelayedPiscoinOutputs[1+types.MaturityDelay]) != 1 {
+		t.Fatal("unwatched miner payout was added")
+	}
+	if _, exists := s.PiscoinOutputs[txn.PiscoinOutputID(0)]; !exists {
+		t.Fatal("watched output was not added")
+	}
+	if _, exists := s.PiscoinOutputs[txn.PiscoinOutputID(1)]; exists {
+		t.Fatal("unwatched output was added")
+	}
+	if cb := lt.cs.CurrentBlock(); len(cb.Transactions) != 1 || cb.Transactions[0].ID() != txn.ID() {
+		t.Fatal("current block does not contain the watched transaction")
+	}
+
+	// Mine until the payout matures.
+	parent := b2.ID()
+	for lt.cs.Height() < 1+types.MaturityDelay {
+		parent = lt.mine(parent, nil).ID()
+	}
+	if _, exists := lt.state().PiscoinOutputs[payoutID]; !exists {
+		t.Fatal("miner payout did not mature")
+	}
+
+	// A heavier fork from the genesis block reverts everything.
+	fork := types.GenesisID
+	for i := types.BlockHeight(0); i < 2+types.MaturityDelay; i++ {
+		fork = lt.mine(fork, []types.PiscoinOutput{{UnlockHash: theirs}}).ID()
+	}
+	if h := lt.cs.Height(); h != 2+types.MaturityDelay {
+		t.Fatal("fork was not applied:", h)
+	}
+	if lt.cs.InCurrentPath(b1.ID()) {
+		t.Fatal("reverted block is still in the current path")
+	}
+	s = lt.state()
+	if len(s.PiscoinOutputs) != 0 || len(s.DelayedPiscoinOutputs) != 0 {
+		t.Fatal("fork did not revert the watched outputs")
+	}
+	reorgs := lt.cs.Reorgs()
+	if len(reorgs) != 1 || reorgs[0].Depth != uint64(1+types.MaturityDelay) || reorgs[0].ForkPointID != types.GenesisID {
+		t.Fatal("reorg was not logged:", reorgs)
+	}
+	if rt := reorgs[0].RevertedTransactions; len(rt) != 1 || rt[0] != txn.ID() {
+		t.Fatal("reverted transaction was not logged:", rt)
+	}
+	forks := lt.cs.Forks()
+	if len(forks) != 2 || !forks[0].Active || forks[1].TipID != reorgs[0].OldTipID {
+		t.Fatal("old path is not tracked as a side chain:", forks)
+	}
+
+	// Changes are saved as they happen, so they survive a crash. Closing the
+	// database without saving simulates the crash.
+	nodes := len(lt.cs.nodes)
+	if err := lt.cs.db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	lt.open()
+	if h := lt.cs.Height(); h != 2+types.MaturityDelay {
+		t.Fatal("path changes were not saved:", h)
+	}
+	if len(lt.cs.nodes) != nodes {
+		t.Fatal("headers were not saved:", len(lt.cs.nodes), nodes)
+	}
+
+	// Reload the consensus set; the replayed changes must lead to the same
+	// state.
+	if err := lt.cs.Close(); err != nil {
+		t.Fatal(err)
+	}
+	lt.open()
+	defer lt.cs.Close()
+	if h := lt.cs.Height(); h != 2+types.MaturityDelay {
+		t.Fatal("wrong height after reload:", h)
+	}
+	if diffs := lt.state().Compare(s); len(diffs) != 0 {
+		t.Fatal("state changed after reload:", diffs)
+	}
+
+	// Extending the reloaded chain still works.
+	lt.mine(fork, nil)
+	if h := lt.cs.Height(); h != 3+types.MaturityDelay {
+		t.Fatal("wrong height:", h)
+	}
+}
+
+// TestLightConsensusInvalidHeaders checks that headers without enough work
+// or without a known parent are rejected.
+func TestLightConsensusInvalidHeaders(t *testing.T) {
+	lt := newLightTester(t)
+	defer lt.cs.Close()
+
+	orphan := types.Block{ParentID: types.BlockID{1}, Timestamp: types.CurrentTimestamp()}
+	if err := lt.cs.AcceptBlock(orphan); err != modules.ErrOrphanHeader {
+		t.Fatal("expected ErrOrphanHeader, got", err)
+	}
+
+	target, _ := lt.cs.ChildTarget(types.GenesisID)
+	unsolved := types.Block{ParentID: types.GenesisID, Timestamp: types.CurrentTimestamp()}
+	for i := byte(0); unsolved.Header().CheckTarget(target); i++ {
+		unsolved.Nonce[0] = i
+	}
+	if err := lt.cs.AcceptBlock(unsolved); err != modules.ErrBlockUnsolved {
+		t.Fatal("expected ErrBlockUnsolved, got", err)
+	}
+	if h := lt.cs.Height(); h != 0 {
+		t.Fatal("invalid headers changed the height:", h)
+	}
+}
+
+// referenceChildTargets returns the child targets of a chain of blocks with
+// the provided timestamps, the first of which is the genesis block. It
+// follows the way the full consensus set computes targets: the Oak totals of
+// every block are stored by height, and the target of a block's children is
+// computed from the totals and the timestamp of the block's parent.
+func referenceChildTargets(timestamps []types.Timestamp) []types.Target {
+	n := len(timestamps)
+	totalTimes := make([]int64, n)
+	totalTargets := make([]types.Target, n)
+	childTargets := make([]types.Target, n)
+	storeBlockTotals := func(height int, prevTotalTime int64, parentTimestamp, timestamp types.Timestamp, prevTotalTarget, target types.Target) {
+		if types.BlockHeight(height) == types.OakHardforkBlock-1 {
+			prevTotalTime = int64(types.BlockFrequency) * int64(height)
+		}
+		totalTimes[height] = prevTotalTime*types.OakDecayNum/types.OakDecayDenom + int64(timestamp) - int64(parentTimestamp)
+		totalTargets[height] = prevTotalTarget.MulDifficulty(big.NewRat(types.OakDecayNum, types.OakDecayDenom)).AddDifficulties(target)
+	}
+	storeBlockTotals(0, 0, timestamps[0], timestamps[0], types.RootDepth, types.RootTarget)
+	childTargets[0] = types.RootTarget
+
+	for height := 1; height < n; height++ {
+		parent := height - 1
+		storeBlockTotals(height, totalTimes[parent], timestamps[parent], timestamps[height], totalTargets[parent], childTargets[parent])
+		if types.BlockHeight(parent) < types.OakHardforkBlock {
+			// With the testing constants, the hardfork happens before the
+			// first window adjustment, so the root target is kept.
+			childTargets[height] = childTargets[parent]
+			continue
+		}
+
+		var delta int64
+		if types.BlockHeight(parent) < types.OakHardforkFixBlock {
+			delta = int64(types.BlockFrequency)*int64(parent) - totalTimes[parent]
+		} else {
+			delta = int64(types.BlockFrequency)*int64(parent) + int64(types.GenesisTimestamp) - int64(timestamps[parent])
+		}
+		square := delta * delta
+		if delta < 0 {
+			square = -square
+		}
+		targetBlockTime := int64(types.BlockFrequency) + square/10e6
+		if min := int64(types.BlockFrequency) / types.OakMaxBlockShift; targetBlockTime < min {
+			targetBlockTime = min
+		}
+		if max := int64(types.BlockFrequency) * types.OakMaxBlockShift; targetBlockTime > max {
+			targetBlockTime = max
+		}
+		if targetBlockTime == 0 {
+			targetBlockTime = 1
+		}
+
+		totalTime := totalTimes[parent]
+		if totalTime < 1 {
+			totalTime = 1
+		}
+		difficulty := new(big.Int).Div(types.RootDepth.Int(), totalTargets[parent].Int())
+		hashrate := new(big.Int).Div(difficulty, big.NewInt(totalTime))
+		if hashrate.Sign() == 0 {
+			hashrate.SetInt64(1)
+		}
+		hashes := new(big.Int).Mul(hashrate, big.NewInt(targetBlockTime))
+		target := types.RatToTarget(new(big.Rat).SetFrac(types.RootDepth.Int(), hashes))
+
+		current := childTargets[parent].Rat()
+		lowest := types.RatToTarget(new(big.Rat).Quo(current, types.OakMaxRise))
+		highest := types.RatToTarget(new(big.Rat).Quo(current, types.OakMaxDrop))
+		if target.Cmp(lowest) < 0 {
+			target = lowest
+		} else if target.Cmp(highest) > 0 {
+			target = highest
+		}
+		childTargets[height] = target
+	}
+	return childTargets
+}
+
+// TestLightConsensusOak checks that the targets of a header chain that
+// crosses the Oak hardfork and the Oak fix match a reference computation.
+func TestLightConsensusOak(t *testing.T) {
+	lt := newLightTester(t)
+	defer lt.cs.Close()
+
+	// Blocks arrive roughly on schedule, then a large gap lowers the
+	// visible hashrate.
+	height := int(types.OakHardforkFixBlock) + 15
+	timestamps := []types.Timestamp{types.GenesisTimestamp}
+	for i := 1; i <= height; i++ {
+		ts := types.GenesisTimestamp + types.Timestamp(i+i%4)
+		if i > height-5 {
+			ts += 5000
+		}
+		timestamps = append(timestamps, ts)
+	}
+	expected := referenceChildTargets(timestamps)
+
+	parent := types.GenesisID
+	for i := 1; i <= height; i++ {
+		b := types.Block{
+			ParentID:  parent,
+			Timestamp: timestamps[i],
+		}
+		for j := uint64(0); !b.Header().CheckTarget(expected[i-1]); j++ {
+			for k := range b.Nonce {
+				b.Nonce[k] = byte(j >> (8 * uint(k)))
+			}
+		}
+		lt.blocks[b.ID()] = b
+		if err := lt.cs.AcceptBlock(b); err != nil {
+			t.Fatal(err)
+		}
+		parent = b.ID()
+		if target, _ := lt.cs.ChildTarget(parent); target != expected[i] {
+			t.Fatalf("wrong child target at height %v: expected %v, got %v", i, expected[i], target)
+		}
+	}
+	if expected[height] == types.RootTarget {
+		t.Fatal("the Oak adjustment never changed the target")
+	}
+}
+
+// pipeConn is a modules.PeerConn over one end of a net.Pipe.
+type pipeConn struct {
+	net.Conn
+}
+
+// RPCAddr implements modules.PeerConn.
+func (pipeConn) RPCAddr() modules.NetAddress { return "1.2.3.4:1234" }
+
+// TestRelayOrphanTriggersSync checks that relayed blocks with an unknown
+// parent trigger a single pending sync, no matter how many are relayed.
+func TestRelayOrphanTriggersSync(t *testing.T) {
+	lt := newLightTester(t)
+	defer lt.cs.Close()
+
+	for i := 0; i < 3; i++ {
+		c1, c2 := net.Pipe()
+		go func() {
+			encoding.WriteObject(c2, types.Block{ParentID: types.BlockID{1}})
+			c2.Close()
+		}()
+		if err := lt.cs.rpcRelayBlock(pipeConn{c1}); err != nil {
+			t.Fatal(err)
+		}
+		c1.Close()
+	}
+	if n := len(lt.cs.syncTrigger); n != 1 {
+		t.Fatal("expected one pending sync, got", n)
+	}
+}
@@ -0,0 +1,174 @@
+package lightconsensus
+
+import (
+	"sort"
+	"time"
+
+	"github.com/wisherd/Pis/encoding"
+	"github.com/wisherd/Pis/modules"
+	"github.com/wisherd/Pis/types"
+)
+
+// threadedSync asks the peers for new headers every syncInterval, or earlier
+// when a sync is triggered, until the consensus set is closed.
+func (cs *ConsensusSet) threadedSync() {
+	defer cs.wg.Done()
+	for {
+		cs.managedSync()
+		select {
+		case <-cs.stop:
+			return
+		case <-cs.syncTrigger:
+		case <-time.After(syncInterval):
+		}
+	}
+}
+
+// triggerSync makes threadedSync start a sync as soon as the current one is
+// done. Triggers that arrive while a sync is already pending are dropped, so
+// at most one sync runs at a time.
+func (cs *ConsensusSet) triggerSync() {
+	select {
+	case cs.syncTrigger <- struct{}{}:
+	default:
+	}
+}
+
+// managedSync downloads headers from every peer that advertises
+// FeatureHeaderSync until the peer has no more headers to send.
+func (cs *ConsensusSet) managedSync() {
+	for _, p := range modules.PeersWithFeature(cs.g.Peers(), modules.FeatureHeaderSync) {
+		for {
+			select {
+			case <-cs.stop:
+				return
+			default:
+			}
+			cs.mu.RLock()
+			history := cs.blockHistory()
+			cs.mu.RUnlock()
+
+			var headers []types.BlockHeader
+			var moreAvailable bool
+			err := modules.FeatureRPC(cs.g, p.NetAddress, modules.FeatureHeaderSync, modules.SendHeadersRPC, func(conn modules.PeerConn) (err error) {
+				headers, moreAvailable, err = modules.RequestHeaders(conn, history)
+				return err
+			})
+			if err != nil {
+				break
+			}
+			if err := cs.managedAcceptHeaders(headers); err != nil {
+				break
+			}
+			if !moreAvailable {
+				cs.mu.Lock()
+				cs.synced = true
+				cs.mu.Unlock()
+				break
+			}
+		}
+	}
+}
+
+// rpcRelayBlock handles a block that was relayed by a peer. Only the header
+// is used. If the parent is unknown, the consensus set has fallen behind and
+// a sync is triggered.
+func (cs *ConsensusSet) rpcRelayBlock(conn modules.PeerConn) error {
+	var b types.Block
+	if err := encoding.ReadObject(conn, &b, types.BlockSizeLimit); err != nil {
+		return err
+	}
+	err := cs.managedAcceptHeaders([]types.BlockHeader{b.Header()})
+	if err == modules.ErrOrphanHeader {
+		cs.triggerSync()
+		return nil
+	}
+	return err
+}
+
+// gatewayFetch implements ProvenBlockFetchFunc by downloading the proven
+// blocks from peers that advertise FeatureProvenBlocks. The headers are
+// requested in batches of MaxBlockBodiesPerRequest, and the unlock hashes in
+// batches of MaxProvenBlockAddresses.
+func (cs *ConsensusSet) gatewayFetch(headers []types.BlockHeader, uhs []types.UnlockHash) ([]modules.ProvenBlock, error) {
+	peers := modules.PeersWithFeature(cs.g.Peers(), modules.FeatureProvenBlocks)
+	pbs := make([]modules.ProvenBlock, len(headers))
+	for start := 0; start < len(headers); start += modules.MaxBlockBodiesPerRequest {
+		end := start + modules.MaxBlockBodiesPerRequest
+		if end > len(headers) {
+			end = len(headers)
+		}
+		req := modules.ProvenBlocksRequest{
+			IDs: make([]types.BlockID, 0, end-start),
+		}
+		for _, h := range headers[start:end] {
+			req.IDs = append(req.IDs, h.ID())
+		}
+		for uhStart := 0; uhStart < len(uhs); uhStart += modules.MaxProvenBlockAddresses {
+			uhEnd := uhStart + modules.MaxProvenBlockAddresses
+			if uhEnd > len(uhs) {
+				uhEnd = len(uhs)
+			}
+			req.UnlockHashes = uhs[uhStart:uhEnd]
+			batch, err := cs.fetchBatch(peers, req, headers[start:end])
+			if err != nil {
+				return nil, err
+			}
+			for i := range batch {
+				mergeProvenBlocks(&pbs[start+i], batch[i])
+			}
+		}
+	}
+	return pbs, nil
+}
+
+// fetchBatch requests a batch of proven blocks from the peers, one at a
+// time, until a peer returns blocks that match the headers.
+func (cs *ConsensusSet) fetchBatch(peers []modules.Peer, req modules.ProvenBlocksRequest, headers []types.BlockHeader) ([]modules.ProvenBlock, error) {
+	for _, p := range peers {
+		var batch []modules.ProvenBlock
+		err := modules.FeatureRPC(cs.g, p.NetAddress, modules.FeatureProvenBlocks, modules.SendProvenBlocksRPC, func(conn modules.PeerConn) (err error) {
+			batch, err = modules.RequestProvenBlocks(conn, req)
+			return err
+		})
+		for i := 0; err == nil && i < len(batch); i++ {
+			err = batch[i].Verify(headers[i])
+		}
+		if err == nil {
+			return batch, nil
+		}
+	}
+	return nil, errNoProofPeers
+}
+
+// mergeProvenBlocks adds the payouts and transactions of src to dst, skipping
+// the ones that dst already contains. Blocks are requested once per batch of
+// unlock hashes, so a transaction that involves addresses from several
+// batches is returned more than once. The result is kept in block order.
+func mergeProvenBlocks(dst *modules.ProvenBlock, src modules.ProvenBlock) {
+	dst.ID = src.ID
+	payouts := make(map[uint64]struct{})
+	for _, mp := range dst.MinerPayouts {
+		payouts[mp.Index] = struct{}{}
+	}
+	for _, mp := range src.MinerPayouts {
+		if _, exists := payouts[mp.Index]; !exists {
+			dst.MinerPayouts = append(dst.MinerPayouts, mp)
+		}
+	}
+	txns := make(map[uint64]struct{})
+	for _, pt := range dst.Transactions {
+		txns[pt.Proof.LeafIndex] = struct{}{}
+	}
+	for _, pt := range src.Transactions {
+		if _, exists := txns[pt.Proof.LeafIndex]; !exists {
+			dst.Transactions = append(dst.Transactions, pt)
+		}
+	}
+	sort.Slice(dst.MinerPayouts, func(i, j int) bool {
+		return dst.MinerPayouts[i].Index < dst.MinerPayouts[j].Index
+	})
+	sort.Slice(dst.Transactions, func(i, j int) bool {
+		return dst.Transactions[i].Proof.LeafIndex < dst.Transactions[j].Proof.LeafIndex
+	})
+}
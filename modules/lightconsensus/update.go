@@ -0,0 +1,294 @@
+package lightconsensus
+
+import (
+	"errors"
+
+	"github.com/wisherd/Pis/build"
+	"github.com/wisherd/Pis/crypto"
+	"github.com/wisherd/Pis/modules"
+	"github.com/wisherd/Pis/types"
+)
+
+const (
+	// maxBlocksPerChange is the maximum number of blocks that are applied
+	// by a single consensus change. Long header chains are split into
+	// several changes so that progress is not lost if a download fails.
+	maxBlocksPerChange = 100
+)
+
+// commitDiffs applies part to the watched outputs and appends its diffs to
+// diffs.
+func (cs *ConsensusSet) commitDiffs(diffs *modules.ConsensusChange, part modules.ConsensusChange) {
+	if err := cs.state.Apply(part); err != nil {
+		build.Critical("light consensus produced inconsistent diffs:", err)
+	}
+	*diffs = diffs.Append(part)
+}
+
+// applyBlock applies the block of node, of which only the parts in pb are
+// known, to the watched outputs. Delayed outputs that mature at the height of
+// the block are moved to the unspent outputs, miner payouts to watched
+// addresses become delayed outputs, and the transactions spend and create
+// watched outputs.
+func (cs *ConsensusSet) applyBlock(node *headerNode, pb modules.ProvenBlock, f modules.ConsensusChangeFilter) lightBlock {
+	b := types.Block{
+		ParentID:  node.header.ParentID,
+		Nonce:     node.header.Nonce,
+		Timestamp: node.header.Timestamp,
+	}
+	var diffs modules.ConsensusChange
+	cs.commitDiffs(&diffs, modules.ConsensusChange{AppliedBlocks: []types.Block{b}})
+
+	var matured modules.ConsensusChange
+	for id, sco := range cs.state.DelayedPiscoinOutputs[node.height] {
+		matured.DelayedPiscoinOutputDiffs = append(matured.DelayedPiscoinOutputDiffs, modules.DelayedPiscoinOutputDiff{
+			Direction:      modules.DiffRevert,
+			ID:             id,
+			PiscoinOutput:  sco,
+			MaturityHeight: node.height,
+		})
+		matured.PiscoinOutputDiffs = append(matured.PiscoinOutputDiffs, modules.PiscoinOutputDiff{
+			Direction:     modules.DiffApply,
+			ID:            id,
+			PiscoinOutput: sco,
+		})
+	}
+	cs.commitDiffs(&diffs, matured)
+
+	var payouts modules.ConsensusChange
+	for _, mp := range pb.MinerPayouts {
+		payouts.DelayedPiscoinOutputDiffs = append(payouts.DelayedPiscoinOutputDiffs, modules.DelayedPiscoinOutputDiff{
+			Direction:      modules.DiffApply,
+			ID:             types.PiscoinOutputID(crypto.HashAll(node.id, mp.Index)),
+			PiscoinOutput:  mp.Output,
+			MaturityHeight: node.height + types.MaturityDelay,
+		})
+	}
+	cs.commitDiffs(&diffs, payouts)
+
+	// Transactions are applied one at a time, since a transaction may spend
+	// an output created earlier in the same block.
+	for _, pt := range pb.Transactions {
+		txn := pt.Transaction
+		b.Transactions = append(b.Transactions, txn)
+		var part modules.ConsensusChange
+		for _, sci := range txn.PiscoinInputs {
+			if sco, exists := cs.state.PiscoinOutputs[sci.ParentID]; exists {
+				part.PiscoinOutputDiffs = append(part.PiscoinOutputDiffs, modules.PiscoinOutputDiff{
+					Direction:     modules.DiffRevert,
+					ID:            sci.ParentID,
+					PiscoinOutput: sco,
+				})
+			}
+		}
+		for i, sco := range txn.PiscoinOutputs {
+			if _, exists := f.UnlockHashes[sco.UnlockHash]; exists {
+				part.PiscoinOutputDiffs = append(part.PiscoinOutputDiffs, modules.PiscoinOutputDiff{
+					Direction:     modules.DiffApply,
+					ID:            txn.PiscoinOutputID(uint64(i)),
+					PiscoinOutput: sco,
+				})
+			}
+		}
+		for _, sfi := range txn.PisfundInputs {
+			if sfo, exists := cs.state.PisfundOutputs[sfi.ParentID]; exists {
+				part.PisfundOutputDiffs = append(part.PisfundOutputDiffs, modules.PisfundOutputDiff{
+					Direction:     modules.DiffRevert,
+					ID:            sfi.ParentID,
+					PisfundOutput: sfo,
+				})
+			}
+		}
+		for i, sfo := range txn.PisfundOutputs {
+			if _, exists := f.UnlockHashes[sfo.UnlockHash]; exists {
+				part.PisfundOutputDiffs = append(part.PisfundOutputDiffs, modules.PisfundOutputDiff{
+					Direction:     modules.DiffApply,
+					ID:            txn.PisfundOutputID(uint64(i)),
+					PisfundOutput: sfo,
+				})
+			}
+		}
+		cs.commitDiffs(&diffs, part)
+	}
+
+	return lightBlock{
+		Block: b,
+		Diffs: modules.PrunedBlock{
+			Header:                    node.header,
+			Height:                    node.height,
+			PiscoinOutputDiffs:        diffs.PiscoinOutputDiffs,
+			PisfundOutputDiffs:        diffs.PisfundOutputDiffs,
+			DelayedPiscoinOutputDiffs: diffs.DelayedPiscoinOutputDiffs,
+		},
+	}
+}
+
+// appliedChange returns the ConsensusChange that applies lb.
+func appliedChange(lb lightBlock) modules.ConsensusChange {
+	return modules.ConsensusChange{
+		AppliedBlocks:             []types.Block{lb.Block},
+		PiscoinOutputDiffs:        lb.Diffs.PiscoinOutputDiffs,
+		PisfundOutputDiffs:        lb.Diffs.PisfundOutputDiffs,
+		DelayedPiscoinOutputDiffs: lb.Diffs.DelayedPiscoinOutputDiffs,
+	}
+}
+
+// revertChange returns the ConsensusChange that reverts lb.
+func revertChange(lb lightBlock) modules.ConsensusChange {
+	cc := lb.Diffs.RevertChange()
+	cc.RevertedPrunedHeaders = nil
+	cc.RevertedBlocks = []types.Block{lb.Block}
+	return cc
+}
+
+// computeChange returns the ConsensusChange described by entry. The blocks in
+// the change only contain the transactions that involve watched addresses,
+// and their miner payouts are omitted, so their IDs differ from the IDs of the
+// real blocks. Subscribers should rely on the diffs instead.
+func (cs *ConsensusSet) computeChange(entry changeEntry) modules.ConsensusChange {
+	var cc modules.ConsensusChange
+	for _, id := range entry.RevertedBlocks {
+		cc = cc.Append(revertChange(cs.blocks[id]))
+	}
+	for _, id := range entry.AppliedBlocks {
+		cc = cc.Append(appliedChange(cs.blocks[id]))
+	}
+	current := cs.currentNode()
+	cc.ID = changeID(entry)
+	cc.ChildTarget = current.state.ChildTarget
+	cc.MinimumValidChildTimestamp = minimumValidChildTimestamp(current)
+	cc.Synced = cs.synced
+	cc.TryTransactionSet = cs.tryTransactionSet
+	return cc
+}
+
+// applyChange moves the current path by reverting and applying the provided
+// nodes, records the change and sends it to the subscribers. pbs contains the
+// proven block of every applied node.
+func (cs *ConsensusSet) applyChange(reverted, applied []*headerNode, pbs []modules.ProvenBlock) {
+	f := cs.filter()
+	var entry changeEntry
+	cs.recordPathChange(reverted, applied)
+	for _, node := range reverted {
+		if err := cs.state.Apply(revertChange(cs.blocks[node.id])); err != nil {
+			build.Critical("unable to revert light block:", err)
+		}
+		cs.path = cs.path[:len(cs.path)-1]
+		entry.RevertedBlocks = append(entry.RevertedBlocks, node.id)
+	}
+	for i, node := range applied {
+		cs.blocks[node.id] = cs.applyBlock(node, pbs[i], f)
+		cs.unsavedBlocks = append(cs.unsavedBlocks, node.id)
+		cs.path = append(cs.path, node)
+		entry.AppliedBlocks = append(entry.AppliedBlocks, node.id)
+	}
+	cs.changes = append(cs.changes, entry)
+
+	cc := cs.computeChange(entry)
+	for _, sub := range cs.subscribers {
+		sub.ProcessConsensusChange(cc)
+	}
+}
+
+// replayChange applies a change that was loaded from disk.
+func (cs *ConsensusSet) replayChange(entry changeEntry) error {
+	for _, id := range entry.RevertedBlocks {
+		lb, exists := cs.blocks[id]
+		if !exists || len(cs.path) == 0 || cs.currentNode().id != id {
+			return errors.New("persisted consensus change does not match the current path")
+		}
+		if err := cs.state.Apply(revertChange(lb)); err != nil {
+			return err
+		}
+		cs.path = cs.path[:len(cs.path)-1]
+	}
+	for _, id := range entry.AppliedBlocks {
+		lb, exists := cs.blocks[id]
+		node, known := cs.nodes[id]
+		if !exists || !known {
+			return errors.New("persisted consensus change references an unknown block")
+		}
+		if err := cs.state.Apply(appliedChange(lb)); err != nil {
+			return err
+		}
+		cs.path = append(cs.path, node)
+	}
+	cs.changes = append(cs.changes, entry)
+	return nil
+}
+
+// managedAcceptHeaders adds headers to the header tree and moves the current
+// path to the heaviest known header, downloading the proven blocks of the
+// new blocks on the way. The consensus set is saved after the headers are
+// added and after every change of the path.
+func (cs *ConsensusSet) managedAcceptHeaders(headers []types.BlockHeader) error {
+	cs.syncMu.Lock()
+	defer cs.syncMu.Unlock()
+
+	cs.mu.Lock()
+	var err error
+	for _, h := range headers {
+		_, known := cs.nodes[h.ID()]
+		if err = cs.addHeader(h); err != nil {
+			break
+		}
+		if !known {
+			cs.trackSideHeader(cs.nodes[h.ID()])
+		}
+	}
+	reverted, applied := cs.forkTo(cs.tip)
+	if len(reverted) == 0 && len(applied) == 0 {
+		// The path does not change, so the new headers are saved here
+		// rather than with the path change.
+		if saveErr := cs.save(); saveErr != nil {
+			cs.mu.Unlock()
+			return saveErr
+		}
+	}
+	cs.mu.Unlock()
+
+	for len(reverted) > 0 || len(applied) > 0 {
+		batch := applied
+		if len(batch) > maxBlocksPerChange {
+			batch = batch[:maxBlocksPerChange]
+		}
+		if updateErr := cs.managedUpdatePath(reverted, batch); updateErr != nil {
+			return updateErr
+		}
+		reverted, applied = nil, applied[len(batch):]
+	}
+	return err
+}
+
+// managedUpdatePath downloads the proven blocks of the applied nodes, applies
+// the change and saves the consensus set. The caller must hold syncMu.
+func (cs *ConsensusSet) managedUpdatePath(reverted, applied []*headerNode) error {
+	f := cs.managedFilter()
+	pbs := make([]modules.ProvenBlock, len(applied))
+	for i, node := range applied {
+		pbs[i].ID = node.id
+	}
+	if len(f.UnlockHashes) > 0 && len(applied) > 0 {
+		headers := make([]types.BlockHeader, len(applied))
+		for i, node := range applied {
+			headers[i] = node.header
+		}
+		uhs := make([]types.UnlockHash, 0, len(f.UnlockHashes))
+		for uh := range f.UnlockHashes {
+			uhs = append(uhs, uh)
+		}
+		fetched, err := cs.fetch(headers, uhs)
+		if err != nil {
+			return err
+		}
+		if len(fetched) != len(applied) {
+			return errors.New("wrong number of proven blocks fetched")
+		}
+		pbs = fetched
+	}
+
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.applyChange(reverted, applied, pbs)
+	return cs.save()
+}
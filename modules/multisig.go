@@ -0,0 +1,208 @@
+package modules
+
+import (
+	"errors"
+
+	"github.com/wisherd/Pis/crypto"
+	"github.com/wisherd/Pis/types"
+)
+
+var (
+	// ErrAlreadySigned is returned when a key has already signed an input of
+	// a transaction.
+	ErrAlreadySigned = errors.New("input has already been signed with this key")
+
+	// ErrKeyNotInUnlockConditions is returned when a key is used to sign an
+	// input whose UnlockConditions do not contain the key.
+	ErrKeyNotInUnlockConditions = errors.New("key is not part of the unlock conditions of the input")
+
+	// ErrTransactionMismatch is returned when signatures of different
+	// transactions are combined.
+	ErrTransactionMismatch = errors.New("transactions do not match")
+
+	// ErrUnknownInput is returned when a signature refers to an input that is
+	// not part of the transaction.
+	ErrUnknownInput = errors.New("transaction has no input with that parent ID")
+
+	// ErrUnknownMultisigAddress is returned when the wallet does not track
+	// the requested multisig address.
+	ErrUnknownMultisigAddress = errors.New("multisig address is not tracked by the wallet")
+)
+
+type (
+	// A MultisigOutput is an unspent output of a multisig address that is
+	// tracked by the wallet.
+	MultisigOutput struct {
+		ID             types.PiscoinOutputID `json:"id"`
+		Value          types.Currency        `json:"value"`
+		UnlockHash     types.UnlockHash      `json:"unlockhash"`
+		MaturityHeight types.BlockHeight     `json:"maturityheight"`
+	}
+
+	// An InputSignatureStatus reports how many signatures an input of a
+	// transaction has and how many it needs.
+	InputSignatureStatus struct {
+		ParentID  crypto.Hash `json:"parentid"`
+		Required  uint64      `json:"required"`
+		Signed    uint64      `json:"signed"`
+		SignedBy  []uint64    `json:"signedby"`
+		Completed bool        `json:"completed"`
+	}
+
+	// MultisigManager creates and tracks M-of-N addresses whose keys belong to
+	// several parties, and builds and signs the transactions that spend from
+	// them. A transaction is passed from co-signer to co-signer, or signed in
+	// parallel and merged with CombineSignatures, until every input has enough
+	// signatures.
+	MultisigManager interface {
+		// AddMultisigAddress starts tracking the outputs of the address of
+		// uc. The wallet does not need to own any of the keys.
+		AddMultisigAddress(uc types.UnlockConditions) error
+
+		// MultisigAddresses returns the UnlockConditions of all tracked
+		// multisig addresses.
+		MultisigAddresses() ([]types.UnlockConditions, error)
+
+		// MultisigOutputs returns the unspent outputs of a tracked multisig
+		// address.
+		MultisigOutputs(uh types.UnlockHash) ([]MultisigOutput, error)
+
+		// BuildMultisigTransaction returns an unsigned transaction that sends
+		// outputs from the multisig address uh, along with its parents. The
+		// change is sent back to uh. The transaction is built with a
+		// TransactionBuilder, so the spent outputs are reserved until the
+		// transaction is confirmed or dropped.
+		BuildMultisigTransaction(uh types.UnlockHash, outputs []types.PiscoinOutput, fee types.Currency) (txn types.Transaction, parents []types.Transaction, err error)
+
+		// SignMultisigTransaction adds a signature for every input of txn
+		// whose UnlockConditions contain a key of the wallet and that still
+		// needs signatures.
+		SignMultisigTransaction(txn types.Transaction) (types.Transaction, error)
+	}
+)
+
+// ed25519KeyIndex returns the index of pk in uc.
+func ed25519KeyIndex(uc types.UnlockConditions, pk crypto.PublicKey) (uint64, bool) {
+	spk := types.Ed25519PublicKey(pk)
+	for i, key := range uc.PublicKeys {
+		if key.Algorithm == spk.Algorithm && string(key.Key) == string(spk.Key) {
+			return uint64(i), true
+		}
+	}
+	return 0, false
+}
+
+// inputUnlockConditions returns the UnlockConditions of the input of txn with
+// the given parent ID.
+func inputUnlockConditions(txn types.Transaction, parentID crypto.Hash) (types.UnlockConditions, bool) {
+	for _, sci := range txn.PiscoinInputs {
+		if crypto.Hash(sci.ParentID) == parentID {
+			return sci.UnlockConditions, true
+		}
+	}
+	for _, sfi := range txn.PisfundInputs {
+		if crypto.Hash(sfi.ParentID) == parentID {
+			return sfi.UnlockConditions, true
+		}
+	}
+	return types.UnlockConditions{}, false
+}
+
+// AddInputSignature signs the input of txn with the given parent ID using sk.
+// The signature covers the whole transaction, so that signatures of different
+// co-signers do not depend on each other and can be combined in any order.
+func AddInputSignature(txn *types.Transaction, parentID crypto.Hash, sk crypto.SecretKey) error {
+	uc, exists := inputUnlockConditions(*txn, parentID)
+	if !exists {
+		return ErrUnknownInput
+	}
+	index, exists := ed25519KeyIndex(uc, sk.PublicKey())
+	if !exists {
+		return ErrKeyNotInUnlockConditions
+	}
+	for _, sig := range txn.TransactionSignatures {
+		if sig.ParentID == parentID && sig.PublicKeyIndex == index {
+			return ErrAlreadySigned
+		}
+	}
+	txn.TransactionSignatures = append(txn.TransactionSignatures, types.TransactionSignature{
+		ParentID:       parentID,
+		PublicKeyIndex: index,
+		CoveredFields:  types.FullCoveredFields,
+	})
+	i := len(txn.TransactionSignatures) - 1
+	sig := crypto.SignHash(txn.SigHash(i), sk)
+	txn.TransactionSignatures[i].Signature = sig[:]
+	return nil
+}
+
+// CombineSignatures merges the signatures of copies of the same transaction
+// that were signed by different co-signers. Signatures that are already
+// present are not duplicated, and signatures for inputs that have enough
+// signatures are dropped, since consensus rejects frivolous signatures.
+func CombineSignatures(txns ...types.Transaction) (types.Transaction, error) {
+	if len(txns) == 0 {
+		return types.Transaction{}, errors.New("no transactions to combine")
+	}
+	combined := txns[0]
+	combined.TransactionSignatures = nil
+	id := combined.ID()
+	required := make(map[crypto.Hash]uint64)
+	for _, status := range SignatureStatus(combined) {
+		required[status.ParentID] = status.Required
+	}
+
+	type sigKey struct {
+		parentID crypto.Hash
+		index    uint64
+	}
+	seen := make(map[sigKey]struct{})
+	for _, txn := range txns {
+		if txn.ID() != id {
+			return types.Transaction{}, ErrTransactionMismatch
+		}
+		for _, sig := range txn.TransactionSignatures {
+			k := sigKey{sig.ParentID, sig.PublicKeyIndex}
+			if _, exists := seen[k]; exists {
+				continue
+			}
+			if remaining, exists := required[sig.ParentID]; exists {
+				if remaining == 0 {
+					continue
+				}
+				required[sig.ParentID]--
+			}
+			seen[k] = struct{}{}
+			combined.TransactionSignatures = append(combined.TransactionSignatures, sig)
+		}
+	}
+	return combined, nil
+}
+
+// SignatureStatus returns the signature status of every piscoin and pisfund
+// input of txn. The signatures themselves are not verified.
+func SignatureStatus(txn types.Transaction) []InputSignatureStatus {
+	var statuses []InputSignatureStatus
+	add := func(parentID crypto.Hash, uc types.UnlockConditions) {
+		status := InputSignatureStatus{
+			ParentID: parentID,
+			Required: uc.SignaturesRequired,
+			SignedBy: make([]uint64, 0),
+		}
+		for _, sig := range txn.TransactionSignatures {
+			if sig.ParentID == parentID {
+				status.Signed++
+				status.SignedBy = append(status.SignedBy, sig.PublicKeyIndex)
+			}
+		}
+		status.Completed = status.Signed >= status.Required
+		statuses = append(statuses, status)
+	}
+	for _, sci := range txn.PiscoinInputs {
+		add(crypto.Hash(sci.ParentID), sci.UnlockConditions)
+	}
+	for _, sfi := range txn.PisfundInputs {
+		add(crypto.Hash(sfi.ParentID), sfi.UnlockConditions)
+	}
+	return statuses
+}
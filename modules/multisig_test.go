@@ -0,0 +1,78 @@
+package modules
+
+import (
+	"testing"
+
+	"github.com/wisherd/Pis/crypto"
+	"github.com/wisherd/Pis/types"
+)
+
+// TestMultisigSigning checks that co-signers that sign a 2-of-3 transaction in
+// parallel produce signatures that combine into a valid transaction.
+func TestMultisigSigning(t *testing.T) {
+	var sks []crypto.SecretKey
+	var keys []types.PisPublicKey
+	for i := 0; i < 3; i++ {
+		sk, pk := crypto.GenerateKeyPair()
+		sks = append(sks, sk)
+		keys = append(keys, types.Ed25519PublicKey(pk))
+	}
+	uc, err := types.NewMultisigUnlockConditions(keys, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reversed, err := types.NewMultisigUnlockConditions([]types.PisPublicKey{keys[2], keys[1], keys[0]}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uc.UnlockHash() != reversed.UnlockHash() {
+		t.Fatal("address depends on the order of the keys")
+	}
+	if _, err := types.NewMultisigUnlockConditions(keys, 4); err != types.ErrInvalidSignaturesRequired {
+		t.Fatal("expected ErrInvalidSignaturesRequired, got", err)
+	}
+	if _, err := types.NewMultisigUnlockConditions([]types.PisPublicKey{keys[0], keys[0]}, 1); err != types.ErrDuplicatePublicKey {
+		t.Fatal("expected ErrDuplicatePublicKey, got", err)
+	}
+
+	txn := types.Transaction{
+		PiscoinInputs:  []types.PiscoinInput{{ParentID: types.PiscoinOutputID{1}, UnlockConditions: uc}},
+		PiscoinOutputs: []types.PiscoinOutput{{Value: types.NewCurrency64(1), UnlockHash: types.UnlockHash{2}}},
+	}
+	parentID := crypto.Hash(txn.PiscoinInputs[0].ParentID)
+	first, second, third := txn, txn, txn
+	for i, signed := range []*types.Transaction{&first, &second, &third} {
+		if err := AddInputSignature(signed, parentID, sks[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := AddInputSignature(&first, parentID, sks[0]); err != ErrAlreadySigned {
+		t.Fatal("expected ErrAlreadySigned, got", err)
+	}
+	if err := first.StandaloneValid(0); err != types.ErrMissingSignatures {
+		t.Fatal("expected ErrMissingSignatures, got", err)
+	}
+
+	// Combining all three copies must keep only the two signatures that are
+	// needed.
+	combined, err := CombineSignatures(first, second, third)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(combined.TransactionSignatures) != 2 {
+		t.Fatal("wrong number of signatures:", len(combined.TransactionSignatures))
+	}
+	if status := SignatureStatus(combined); len(status) != 1 || !status[0].Completed {
+		t.Fatal("wrong signature status:", status)
+	}
+	if err := combined.StandaloneValid(0); err != nil {
+		t.Fatal(err)
+	}
+
+	// A different transaction cannot be combined.
+	other := txn
+	other.MinerFees = []types.Currency{types.NewCurrency64(1)}
+	if _, err := CombineSignatures(first, other); err != ErrTransactionMismatch {
+		t.Fatal("expected ErrTransactionMismatch, got", err)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"strings"
 
 	"github.com/wisherd/Pis/build"
+	"github.com/wisherd/Pis/types"
 )
 
 // MaxEncodedNetAddressLength is the maximum length of a NetAddress encoded
@@ -96,10 +97,11 @@ func (na NetAddress) IsLocal() bool {
 // address. IsValid is being phased out in favor of allowing the loopback
 // address but verifying through other means that the connection is not to
 // yourself (which is the original reason that the loopback address was
-// banned).
+// banned). Loopback addresses are allowed on the regtest network, which is
+// usually run on a single machine.
 func (na NetAddress) IsValid() error {
 	// Check the loopback address.
-	if na.IsLoopback() && build.Release != "testing" {
+	if na.IsLoopback() && build.Release != "testing" && !types.Regtest {
 		return errors.New("host is a loopback address")
 	}
 	return na.IsStdValid()
@@ -0,0 +1,104 @@
+package modules
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/wisherd/Pis/crypto"
+	"github.com/wisherd/Pis/types"
+)
+
+const (
+	// NetworksDir is the name of the directory inside the Pis directory that
+	// holds the data of custom networks. Each network gets a subdirectory
+	// named after its genesis ID, so that networks never share data.
+	NetworksDir = "networks"
+)
+
+var (
+	// ErrInvalidSectorSize is returned when a network parameters file sets a
+	// sector size that is not a power of two multiple of the segment size.
+	ErrInvalidSectorSize = errors.New("sector size must be a power of two and a multiple of the segment size")
+)
+
+type (
+	// NetworkParams are the contents of a network parameters file, which
+	// defines a custom network such as a private consortium network. The
+	// consensus constants are embedded, so they appear at the top level of
+	// the file. Fields that are missing from the file keep the value of the
+	// network the daemon was built for.
+	NetworkParams struct {
+		types.NetworkParams
+
+		Name           string       `json:"name"`
+		BootstrapPeers []NetAddress `json:"bootstrappeers"`
+		SectorSize     uint64       `json:"sectorsize"`
+	}
+)
+
+// CurrentNetworkParams returns the parameters of the network that is
+// currently active.
+func CurrentNetworkParams() NetworkParams {
+	peers := make([]NetAddress, len(BootstrapPeers))
+	copy(peers, BootstrapPeers)
+	return NetworkParams{
+		NetworkParams:  types.CurrentNetworkParams(),
+		BootstrapPeers: peers,
+		SectorSize:     SectorSize,
+	}
+}
+
+// LoadNetworkParams reads a network parameters file. Unknown fields are
+// rejected so that a misspelled parameter does not silently fall back to the
+// default.
+func LoadNetworkParams(filename string) (NetworkParams, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return NetworkParams{}, err
+	}
+	np := CurrentNetworkParams()
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&np); err != nil {
+		return NetworkParams{}, fmt.Errorf("unable to decode network parameters: %v", err)
+	}
+	return np, np.Validate()
+}
+
+// Validate checks that the parameters describe a usable network.
+func (np NetworkParams) Validate() error {
+	if err := np.NetworkParams.Validate(); err != nil {
+		return err
+	}
+	if np.SectorSize < crypto.SegmentSize || np.SectorSize&(np.SectorSize-1) != 0 {
+		return ErrInvalidSectorSize
+	}
+	for _, addr := range np.BootstrapPeers {
+		if err := addr.IsStdValid(); err != nil {
+			return fmt.Errorf("invalid bootstrap peer %v: %v", addr, err)
+		}
+	}
+	return nil
+}
+
+// ActivateNetwork replaces the consensus constants and the network settings
+// of the modules with np. The checkpoints and the assume-valid block belong to
+// the built-in network, so they are cleared. Peers whose genesis ID differs
+// are refused during the handshake. ActivateNetwork must be called before any
+// module is created.
+func ActivateNetwork(np NetworkParams) error {
+	if err := np.Validate(); err != nil {
+		return err
+	}
+	if err := types.ApplyNetworkParams(np.NetworkParams); err != nil {
+		return err
+	}
+	BootstrapPeers = np.BootstrapPeers
+	SectorSize = np.SectorSize
+	Checkpoints = nil
+	AssumeValidBlock = types.BlockID{}
+	return nil
+}
@@ -0,0 +1,85 @@
+package modules
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/wisherd/Pis/build"
+	"github.com/wisherd/Pis/types"
+)
+
+// TestNetworkParams checks that a network parameters file overrides the
+// parameters it contains, that invalid files are rejected, and that peers of
+// the built-in network are refused once a custom network is active.
+func TestNetworkParams(t *testing.T) {
+	dir := build.TempDir("modules", t.Name())
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	load := func(contents string) (NetworkParams, error) {
+		filename := filepath.Join(dir, "network.json")
+		if err := ioutil.WriteFile(filename, []byte(contents), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return LoadNetworkParams(filename)
+	}
+
+	np, err := load(fmt.Sprintf(`{
+		"name": "consortium",
+		"genesistimestamp": 1500000000,
+		"genesispisfundallocation": [{"value": "10000", "unlockhash": "%v"}],
+		"blockfrequency": 60,
+		"oakmaxrise": "1001/1000",
+		"bootstrappeers": ["10.0.0.1:9981"],
+		"sectorsize": 65536
+	}`, types.UnlockHash{1}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if np.Name != "consortium" || np.BlockFrequency != 60 || np.SectorSize != 65536 || len(np.BootstrapPeers) != 1 {
+		t.Fatal("parameters were not loaded:", np)
+	}
+	if np.OakMaxRise.Cmp(types.OakMaxRise) == 0 {
+		t.Fatal("oak parameter was not loaded")
+	}
+	if np.MaturityDelay != types.MaturityDelay || np.RootTarget != types.RootTarget {
+		t.Fatal("missing parameters did not keep their default value")
+	}
+
+	// Invalid files are rejected.
+	for _, contents := range []string{
+		`{"blockfreqency": 60}`,
+		`{"sectorsize": 1000}`,
+		`{"blockfrequency": 0}`,
+		`{"genesispisfundallocation": []}`,
+		`{"bootstrappeers": ["nonsense"]}`,
+	} {
+		if _, err := load(contents); err == nil {
+			t.Fatal("invalid network parameters were accepted:", contents)
+		}
+	}
+
+	// Activate the network and restore the built-in one afterwards.
+	oldParams := CurrentNetworkParams()
+	oldCheckpoints, oldAssumeValid := Checkpoints, AssumeValidBlock
+	defer func() {
+		if err := ActivateNetwork(oldParams); err != nil {
+			t.Fatal(err)
+		}
+		Checkpoints, AssumeValidBlock = oldCheckpoints, oldAssumeValid
+	}()
+	oldHeader := LocalHandshakeHeader([8]byte{1}, "1.2.3.4:1234")
+	if err := ActivateNetwork(np); err != nil {
+		t.Fatal(err)
+	}
+	if types.GenesisID == oldHeader.GenesisID || types.BlockFrequency != 60 || SectorSize != 65536 {
+		t.Fatal("network was not activated")
+	}
+	ours := LocalHandshakeHeader([8]byte{2}, "1.2.3.5:1234")
+	if err := oldHeader.Validate(ours); err != ErrPeerGenesisID {
+		t.Fatal("expected ErrPeerGenesisID, got", err)
+	}
+}
@@ -0,0 +1,304 @@
+package modules
+
+import (
+	"errors"
+	"io"
+	"unsafe"
+
+	"github.com/wisherd/Pis/crypto"
+	"github.com/wisherd/Pis/encoding"
+	"github.com/wisherd/Pis/types"
+)
+
+const (
+	// PartialTransactionVersion is the version of the partially signed
+	// transaction format written by this code.
+	PartialTransactionVersion = 1
+)
+
+var (
+	// ErrPartialTransactionInputs is returned when the input information of a
+	// partially signed transaction does not match the transaction.
+	ErrPartialTransactionInputs = errors.New("input information does not match the transaction")
+
+	// ErrPartialTransactionMagic is returned when decoding data that is not a
+	// partially signed transaction.
+	ErrPartialTransactionMagic = errors.New("data is not a partially signed transaction")
+
+	// ErrPartialTransactionVersion is returned when decoding a partially
+	// signed transaction with an unknown version.
+	ErrPartialTransactionVersion = errors.New("unsupported partially signed transaction version")
+
+	// partialTransactionMagic prefixes the binary encoding of a partially
+	// signed transaction.
+	partialTransactionMagic = types.Specifier{'p', 'a', 'r', 't', 'i', 'a', 'l', ' ', 't', 'x', 'n'}
+)
+
+type (
+	// A PartialInput describes an input of a partially signed transaction.
+	// It carries what a signer needs to know about the output that is spent
+	// without access to the blockchain. FundType is either
+	// types.SpecifierPiscoinInput or types.SpecifierPisfundInput, and Value is
+	// in hastings or pisfunds accordingly.
+	PartialInput struct {
+		ParentID         crypto.Hash            `json:"parentid"`
+		FundType         types.Specifier        `json:"fundtype"`
+		UnlockConditions types.UnlockConditions `json:"unlockconditions"`
+		Value            types.Currency         `json:"value"`
+	}
+
+	// A PartiallySignedTransaction is a portable transaction that is passed
+	// between the machines that build, sign and broadcast it. The signatures
+	// gathered so far are the TransactionSignatures of Transaction. Inputs
+	// lists the piscoin inputs of Transaction followed by its pisfund inputs.
+	PartiallySignedTransaction struct {
+		Version     uint64              `json:"version"`
+		Transaction types.Transaction   `json:"transaction"`
+		Parents     []types.Transaction `json:"parents"`
+		Inputs      []PartialInput      `json:"inputs"`
+	}
+
+	// A PartialTransactionSummary describes what a partially signed
+	// transaction does and how far its signing has progressed.
+	PartialTransactionSummary struct {
+		TransactionID types.TransactionID    `json:"transactionid"`
+		PiscoinInputs types.Currency         `json:"piscoininputs"`
+		PisfundInputs types.Currency         `json:"pisfundinputs"`
+		Outputs       []types.PiscoinOutput  `json:"outputs"`
+		MinerFees     types.Currency         `json:"minerfees"`
+		Status        []InputSignatureStatus `json:"status"`
+		Complete      bool                   `json:"complete"`
+	}
+)
+
+// NewPartiallySignedTransaction returns a partially signed transaction for
+// txn. values contains the value of the output spent by every input, keyed by
+// parent ID.
+func NewPartiallySignedTransaction(txn types.Transaction, parents []types.Transaction, values map[crypto.Hash]types.Currency) (PartiallySignedTransaction, error) {
+	pst := PartiallySignedTransaction{
+		Version:     PartialTransactionVersion,
+		Transaction: txn,
+		Parents:     parents,
+	}
+	for _, sci := range txn.PiscoinInputs {
+		value, exists := values[crypto.Hash(sci.ParentID)]
+		if !exists {
+			return PartiallySignedTransaction{}, ErrPartialTransactionInputs
+		}
+		pst.Inputs = append(pst.Inputs, PartialInput{
+			ParentID:         crypto.Hash(sci.ParentID),
+			FundType:         types.SpecifierPiscoinInput,
+			UnlockConditions: sci.UnlockConditions,
+			Value:            value,
+		})
+	}
+	for _, sfi := range txn.PisfundInputs {
+		value, exists := values[crypto.Hash(sfi.ParentID)]
+		if !exists {
+			return PartiallySignedTransaction{}, ErrPartialTransactionInputs
+		}
+		pst.Inputs = append(pst.Inputs, PartialInput{
+			ParentID:         crypto.Hash(sfi.ParentID),
+			FundType:         types.SpecifierPisfundInput,
+			UnlockConditions: sfi.UnlockConditions,
+			Value:            value,
+		})
+	}
+	return pst, nil
+}
+
+// Validate checks that the input information matches the transaction and
+// that outputs created by the parents match the inputs that spend them.
+func (pst PartiallySignedTransaction) Validate() error {
+	if pst.Version != PartialTransactionVersion {
+		return ErrPartialTransactionVersion
+	}
+	txn := pst.Transaction
+	if len(pst.Inputs) != len(txn.PiscoinInputs)+len(txn.PisfundInputs) {
+		return ErrPartialTransactionInputs
+	}
+	matches := func(pi PartialInput, parentID crypto.Hash, fundType types.Specifier, uc types.UnlockConditions) bool {
+		return pi.ParentID == parentID && pi.FundType == fundType && pi.UnlockConditions.UnlockHash() == uc.UnlockHash()
+	}
+	for i, sci := range txn.PiscoinInputs {
+		if !matches(pst.Inputs[i], crypto.Hash(sci.ParentID), types.SpecifierPiscoinInput, sci.UnlockConditions) {
+			return ErrPartialTransactionInputs
+		}
+	}
+	for i, sfi := range txn.PisfundInputs {
+		if !matches(pst.Inputs[len(txn.PiscoinInputs)+i], crypto.Hash(sfi.ParentID), types.SpecifierPisfundInput, sfi.UnlockConditions) {
+			return ErrPartialTransactionInputs
+		}
+	}
+
+	// The outputs created by the parents can be checked without the
+	// blockchain.
+	inputs := make(map[crypto.Hash]PartialInput)
+	for _, pi := range pst.Inputs {
+		inputs[pi.ParentID] = pi
+	}
+	for _, parent := range pst.Parents {
+		for i, sco := range parent.PiscoinOutputs {
+			pi, exists := inputs[crypto.Hash(parent.PiscoinOutputID(uint64(i)))]
+			if exists && (pi.FundType != types.SpecifierPiscoinInput || !pi.Value.Equals(sco.Value) || pi.UnlockConditions.UnlockHash() != sco.UnlockHash) {
+				return ErrPartialTransactionInputs
+			}
+		}
+		for i, sfo := range parent.PisfundOutputs {
+			pi, exists := inputs[crypto.Hash(parent.PisfundOutputID(uint64(i)))]
+			if exists && (pi.FundType != types.SpecifierPisfundInput || !pi.Value.Equals(sfo.Value) || pi.UnlockConditions.UnlockHash() != sfo.UnlockHash) {
+				return ErrPartialTransactionInputs
+			}
+		}
+	}
+	return nil
+}
+
+// Sign adds a signature made with sk to every input whose UnlockConditions
+// contain the public key of sk and that still needs signatures. It returns
+// the number of signatures that were added. Sign does not need access to the
+// blockchain, so it can be used on an offline machine.
+func (pst *PartiallySignedTransaction) Sign(sk crypto.SecretKey) (int, error) {
+	if err := pst.Validate(); err != nil {
+		return 0, err
+	}
+	var signed int
+	for _, status := range SignatureStatus(pst.Transaction) {
+		if status.Completed {
+			continue
+		}
+		err := AddInputSignature(&pst.Transaction, status.ParentID, sk)
+		if err == ErrKeyNotInUnlockConditions || err == ErrAlreadySigned {
+			continue
+		} else if err != nil {
+			return signed, err
+		}
+		signed++
+	}
+	return signed, nil
+}
+
+// Summary returns a description of the transaction.
+func (pst PartiallySignedTransaction) Summary() PartialTransactionSummary {
+	summary := PartialTransactionSummary{
+		TransactionID: pst.Transaction.ID(),
+		PiscoinInputs: types.ZeroCurrency,
+		PisfundInputs: types.ZeroCurrency,
+		Outputs:       pst.Transaction.PiscoinOutputs,
+		MinerFees:     types.ZeroCurrency,
+		Status:        SignatureStatus(pst.Transaction),
+		Complete:      true,
+	}
+	for _, pi := range pst.Inputs {
+		if pi.FundType == types.SpecifierPisfundInput {
+			summary.PisfundInputs = summary.PisfundInputs.Add(pi.Value)
+		} else {
+			summary.PiscoinInputs = summary.PiscoinInputs.Add(pi.Value)
+		}
+	}
+	for _, fee := range pst.Transaction.MinerFees {
+		summary.MinerFees = summary.MinerFees.Add(fee)
+	}
+	for _, status := range summary.Status {
+		summary.Complete = summary.Complete && status.Completed
+	}
+	return summary
+}
+
+// Finalize returns the transaction set that can be submitted to the
+// transaction pool, with the parents before the transaction. An error is
+// returned if an input does not have enough signatures.
+func (pst PartiallySignedTransaction) Finalize() ([]types.Transaction, error) {
+	if err := pst.Validate(); err != nil {
+		return nil, err
+	}
+	if !pst.Summary().Complete {
+		return nil, types.ErrMissingSignatures
+	}
+	return append(append([]types.Transaction(nil), pst.Parents...), pst.Transaction), nil
+}
+
+// CombinePartiallySigned merges the signatures of copies of the same partially
+// signed transaction that were signed by different parties.
+func CombinePartiallySigned(psts ...PartiallySignedTransaction) (PartiallySignedTransaction, error) {
+	if len(psts) == 0 {
+		return PartiallySignedTransaction{}, errors.New("no transactions to combine")
+	}
+	txns := make([]types.Transaction, len(psts))
+	for i, pst := range psts {
+		if err := pst.Validate(); err != nil {
+			return PartiallySignedTransaction{}, err
+		}
+		txns[i] = pst.Transaction
+	}
+	combined, err := CombineSignatures(txns...)
+	if err != nil {
+		return PartiallySignedTransaction{}, err
+	}
+	pst := psts[0]
+	pst.Transaction = combined
+	return pst, nil
+}
+
+// MarshalPis implements the encoding.PisMarshaler interface.
+func (pi PartialInput) MarshalPis(w io.Writer) error {
+	e := encoding.NewEncoder(w)
+	e.Write(pi.ParentID[:])
+	e.Write(pi.FundType[:])
+	pi.UnlockConditions.MarshalPis(e)
+	pi.Value.MarshalPis(e)
+	return e.Err()
+}
+
+// UnmarshalPis implements the encoding.PisUnmarshaler interface.
+func (pi *PartialInput) UnmarshalPis(r io.Reader) error {
+	d := encoding.NewDecoder(r)
+	d.ReadFull(pi.ParentID[:])
+	d.ReadFull(pi.FundType[:])
+	pi.UnlockConditions.UnmarshalPis(d)
+	pi.Value.UnmarshalPis(d)
+	return d.Err()
+}
+
+// MarshalPis implements the encoding.PisMarshaler interface. The encoding
+// starts with a magic specifier and the version, so that the format can be
+// recognized and extended.
+func (pst PartiallySignedTransaction) MarshalPis(w io.Writer) error {
+	e := encoding.NewEncoder(w)
+	e.Write(partialTransactionMagic[:])
+	e.WriteUint64(pst.Version)
+	pst.Transaction.MarshalPis(e)
+	e.WriteInt(len(pst.Parents))
+	for _, parent := range pst.Parents {
+		parent.MarshalPis(e)
+	}
+	e.WriteInt(len(pst.Inputs))
+	for _, pi := range pst.Inputs {
+		pi.MarshalPis(e)
+	}
+	return e.Err()
+}
+
+// UnmarshalPis implements the encoding.PisUnmarshaler interface.
+func (pst *PartiallySignedTransaction) UnmarshalPis(r io.Reader) error {
+	d := encoding.NewDecoder(r)
+	var magic types.Specifier
+	d.ReadFull(magic[:])
+	if d.Err() == nil && magic != partialTransactionMagic {
+		return ErrPartialTransactionMagic
+	}
+	pst.Version = d.NextUint64()
+	if d.Err() == nil && pst.Version != PartialTransactionVersion {
+		return ErrPartialTransactionVersion
+	}
+	pst.Transaction.UnmarshalPis(d)
+	pst.Parents = make([]types.Transaction, d.NextPrefix(unsafe.Sizeof(types.Transaction{})))
+	for i := range pst.Parents {
+		pst.Parents[i].UnmarshalPis(d)
+	}
+	pst.Inputs = make([]PartialInput, d.NextPrefix(unsafe.Sizeof(PartialInput{})))
+	for i := range pst.Inputs {
+		pst.Inputs[i].UnmarshalPis(d)
+	}
+	return d.Err()
+}
@@ -0,0 +1,98 @@
+package modules
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/wisherd/Pis/crypto"
+	"github.com/wisherd/Pis/types"
+)
+
+// TestPartiallySignedTransaction checks that a partially signed transaction
+// survives both encodings, is signed by each party separately and can only be
+// finalized once it is complete.
+func TestPartiallySignedTransaction(t *testing.T) {
+	sk1, pk1 := crypto.GenerateKeyPair()
+	sk2, pk2 := crypto.GenerateKeyPair()
+	uc, err := types.NewMultisigUnlockConditions([]types.PisPublicKey{types.Ed25519PublicKey(pk1), types.Ed25519PublicKey(pk2)}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parent := types.Transaction{
+		PiscoinOutputs: []types.PiscoinOutput{{Value: types.NewCurrency64(10), UnlockHash: uc.UnlockHash()}},
+	}
+	txn := types.Transaction{
+		PiscoinInputs:  []types.PiscoinInput{{ParentID: parent.PiscoinOutputID(0), UnlockConditions: uc}},
+		PiscoinOutputs: []types.PiscoinOutput{{Value: types.NewCurrency64(9), UnlockHash: types.UnlockHash{1}}},
+		MinerFees:      []types.Currency{types.NewCurrency64(1)},
+	}
+	values := map[crypto.Hash]types.Currency{crypto.Hash(parent.PiscoinOutputID(0)): types.NewCurrency64(10)}
+	pst, err := NewPartiallySignedTransaction(txn, []types.Transaction{parent}, values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pst.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Binary and JSON round trips.
+	var buf bytes.Buffer
+	if err := pst.MarshalPis(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var decoded PartiallySignedTransaction
+	if err := decoded.UnmarshalPis(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Transaction.ID() != txn.ID() || len(decoded.Inputs) != 1 || !decoded.Inputs[0].Value.Equals64(10) {
+		t.Fatal("binary round trip changed the transaction")
+	}
+	js, err := json.Marshal(pst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded = PartiallySignedTransaction{}
+	if err := json.Unmarshal(js, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if err := decoded.Validate(); err != nil || decoded.Transaction.ID() != txn.ID() {
+		t.Fatal("JSON round trip changed the transaction:", err)
+	}
+	if err := decoded.UnmarshalPis(bytes.NewReader(js)); err != ErrPartialTransactionMagic {
+		t.Fatal("expected ErrPartialTransactionMagic, got", err)
+	}
+
+	// Each party signs its own copy.
+	first, second := pst, pst
+	if n, err := first.Sign(sk1); err != nil || n != 1 {
+		t.Fatal("first signature was not added:", n, err)
+	}
+	if n, err := second.Sign(sk2); err != nil || n != 1 {
+		t.Fatal("second signature was not added:", n, err)
+	}
+	if _, err := first.Finalize(); err != types.ErrMissingSignatures {
+		t.Fatal("expected ErrMissingSignatures, got", err)
+	}
+	combined, err := CombinePartiallySigned(first, second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	summary := combined.Summary()
+	if !summary.Complete || !summary.PiscoinInputs.Equals64(10) || !summary.MinerFees.Equals64(1) {
+		t.Fatal("wrong summary:", summary)
+	}
+	txns, err := combined.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(txns) != 2 || txns[1].StandaloneValid(0) != nil {
+		t.Fatal("finalized transaction set is invalid")
+	}
+
+	// Input information that contradicts the parent is rejected.
+	pst.Inputs[0].Value = types.NewCurrency64(100)
+	if err := pst.Validate(); err != ErrPartialTransactionInputs {
+		t.Fatal("expected ErrPartialTransactionInputs, got", err)
+	}
+}
@@ -0,0 +1,88 @@
+package modules
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/wisherd/Pis/crypto"
+)
+
+var (
+	// ErrPasswordKeyUpgrade is returned when the wallet was unlocked but its
+	// password key could not be re-derived with stronger parameters. The
+	// wallet stays unlocked and the upgrade is retried on the next unlock.
+	ErrPasswordKeyUpgrade = errors.New("wallet was unlocked, but its password key could not be upgraded")
+)
+
+// LegacyPasswordKey returns the key that older versions derived from a
+// wallet password with a single hash. It is only used to unlock wallets that
+// have not been migrated to a password key derived with crypto.KDFParams.
+func LegacyPasswordKey(password string) crypto.TwofishKey {
+	return crypto.TwofishKey(crypto.HashObject(password))
+}
+
+// PasswordKey returns the key of em that is derived from password, using the
+// key derivation parameters stored by em or the legacy derivation if there
+// are none. crypto.ErrInvalidKDFParams is returned if the stored parameters
+// are unusable.
+func PasswordKey(em EncryptionManager, password string) (crypto.TwofishKey, error) {
+	params, ok, err := em.KDFParams()
+	if err != nil {
+		return crypto.TwofishKey{}, err
+	}
+	return passwordKey(params, ok, password)
+}
+
+// passwordKey derives the key for password from the stored parameters, or
+// with the legacy derivation if ok is false.
+func passwordKey(params crypto.KDFParams, ok bool, password string) (crypto.TwofishKey, error) {
+	if !ok {
+		return LegacyPasswordKey(password), nil
+	}
+	if err := params.Validate(); err != nil {
+		return crypto.TwofishKey{}, err
+	}
+	return params.DeriveKey(password), nil
+}
+
+// UnlockWithPassword unlocks em with a password. Wallets whose key was
+// derived with the legacy derivation or with parameters weaker than the
+// defaults are migrated to a key derived with fresh default parameters after
+// they have been unlocked. crypto.ErrInvalidKDFParams is returned if the
+// stored parameters are unusable.
+func UnlockWithPassword(em EncryptionManager, password string) error {
+	params, ok, err := em.KDFParams()
+	if err != nil {
+		return err
+	}
+	key, err := passwordKey(params, ok, password)
+	if err != nil {
+		return err
+	}
+	if err := em.Unlock(key); err != nil {
+		return err
+	}
+	if ok && !params.Weaker() {
+		return nil
+	}
+	newParams := crypto.NewKDFParams()
+	if err := em.ChangeKeyWithKDF(key, newParams.DeriveKey(password), newParams); err != nil {
+		return fmt.Errorf("%v: %v", ErrPasswordKeyUpgrade, err)
+	}
+	return nil
+}
+
+// ChangePassword changes the password of em from oldPassword to newPassword.
+// The new key is derived with params, which should usually come from
+// crypto.NewKDFParams; passing stronger parameters upgrades the key
+// derivation of the wallet.
+func ChangePassword(em EncryptionManager, oldPassword, newPassword string, params crypto.KDFParams) error {
+	if err := params.Validate(); err != nil {
+		return err
+	}
+	oldKey, err := PasswordKey(em, oldPassword)
+	if err != nil {
+		return err
+	}
+	return em.ChangeKeyWithKDF(oldKey, params.DeriveKey(newPassword), params)
+}
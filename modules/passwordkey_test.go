@@ -0,0 +1,119 @@
+package modules
+
+import (
+	"math"
+	"testing"
+
+	"github.com/wisherd/Pis/crypto"
+)
+
+// testEncryptionManager is an EncryptionManager that only tracks its master
+// key and key derivation parameters.
+type testEncryptionManager struct {
+	key      crypto.TwofishKey
+	params   crypto.KDFParams
+	hasKDF   bool
+	unlocked bool
+}
+
+func (em *testEncryptionManager) Encrypt(crypto.TwofishKey) (Seed, error) { return Seed{}, nil }
+func (em *testEncryptionManager) Reset() error                            { return nil }
+func (em *testEncryptionManager) Encrypted() (bool, error)                { return true, nil }
+func (em *testEncryptionManager) InitFromSeed(crypto.TwofishKey, Seed) error {
+	return nil
+}
+func (em *testEncryptionManager) Lock() error             { em.unlocked = false; return nil }
+func (em *testEncryptionManager) Unlocked() (bool, error) { return em.unlocked, nil }
+func (em *testEncryptionManager) Unlock(key crypto.TwofishKey) error {
+	if key != em.key {
+		return ErrBadEncryptionKey
+	}
+	em.unlocked = true
+	return nil
+}
+func (em *testEncryptionManager) ChangeKey(masterKey, newKey crypto.TwofishKey) error {
+	if masterKey != em.key {
+		return ErrBadEncryptionKey
+	}
+	em.key, em.hasKDF = newKey, false
+	return nil
+}
+func (em *testEncryptionManager) ChangeKeyWithKDF(masterKey, newKey crypto.TwofishKey, params crypto.KDFParams) error {
+	if err := em.ChangeKey(masterKey, newKey); err != nil {
+		return err
+	}
+	em.params, em.hasKDF = params, true
+	return nil
+}
+func (em *testEncryptionManager) KDFParams() (crypto.KDFParams, bool, error) {
+	return em.params, em.hasKDF, nil
+}
+
+// TestUnlockWithPassword checks that legacy and weak password keys are
+// migrated on unlock and that passwords can be changed.
+func TestUnlockWithPassword(t *testing.T) {
+	em := &testEncryptionManager{key: LegacyPasswordKey("hunter2")}
+	if err := UnlockWithPassword(em, "wrong"); err != ErrBadEncryptionKey {
+		t.Fatal("expected ErrBadEncryptionKey, got", err)
+	}
+	if em.hasKDF {
+		t.Fatal("failed unlock migrated the key")
+	}
+
+	// The legacy key is replaced by an Argon2id key.
+	if err := UnlockWithPassword(em, "hunter2"); err != nil {
+		t.Fatal(err)
+	}
+	if !em.hasKDF || em.params.Weaker() || em.key != em.params.DeriveKey("hunter2") || em.key == LegacyPasswordKey("hunter2") {
+		t.Fatal("legacy key was not migrated")
+	}
+	em.Lock()
+	if err := UnlockWithPassword(em, "hunter2"); err != nil || !em.unlocked {
+		t.Fatal("migrated key does not unlock the wallet:", err)
+	}
+
+	// Weak parameters are upgraded.
+	weak := crypto.NewKDFParams()
+	weak.Time, weak.Memory = 1, 8*1024
+	if err := ChangePassword(em, "hunter2", "correct horse", weak); err != nil {
+		t.Fatal(err)
+	}
+	if em.params != weak {
+		t.Fatal("ChangePassword did not store the parameters")
+	}
+	if err := UnlockWithPassword(em, "correct horse"); err != nil {
+		t.Fatal(err)
+	}
+	if em.params.Weaker() || em.key != em.params.DeriveKey("correct horse") {
+		t.Fatal("weak parameters were not upgraded")
+	}
+
+	weak.Threads = 0
+	if err := ChangePassword(em, "correct horse", "x", weak); err != crypto.ErrInvalidKDFParams {
+		t.Fatal("expected ErrInvalidKDFParams, got", err)
+	}
+}
+
+// TestInvalidStoredKDFParams checks that unusable key derivation parameters
+// read from disk are rejected with an error instead of panicking or
+// exhausting memory.
+func TestInvalidStoredKDFParams(t *testing.T) {
+	valid := crypto.NewKDFParams()
+	noThreads, hugeMemory, noTime, manyThreads := valid, valid, valid, valid
+	noThreads.Threads = 0
+	hugeMemory.Memory = math.MaxUint32
+	noTime.Time = 0
+	manyThreads.Threads = math.MaxUint8
+	for _, params := range []crypto.KDFParams{noThreads, hugeMemory, noTime, manyThreads} {
+		em := &testEncryptionManager{params: params, hasKDF: true}
+		if _, err := PasswordKey(em, "hunter2"); err != crypto.ErrInvalidKDFParams {
+			t.Errorf("%+v: expected ErrInvalidKDFParams from PasswordKey, got %v", params, err)
+		}
+		if err := UnlockWithPassword(em, "hunter2"); err != crypto.ErrInvalidKDFParams {
+			t.Errorf("%+v: expected ErrInvalidKDFParams from UnlockWithPassword, got %v", params, err)
+		}
+		if em.unlocked {
+			t.Errorf("%+v: wallet was unlocked", params)
+		}
+	}
+}
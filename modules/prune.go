@@ -0,0 +1,143 @@
+package modules
+
+import (
+	"errors"
+
+	"github.com/wisherd/Pis/build"
+	"github.com/wisherd/Pis/types"
+)
+
+var (
+	// ErrBlockPruned is returned when the body of a block is requested that
+	// has been pruned. The header of the block is still available.
+	ErrBlockPruned = errors.New("block body has been pruned")
+
+	// ErrInvalidPruneDepth is returned when the prune depth is smaller than
+	// MinPruneDepth.
+	ErrInvalidPruneDepth = errors.New("prune depth is too small")
+
+	// ErrPrunedHistory is returned when a subscriber asks for consensus
+	// changes that include pruned blocks, for example by subscribing with
+	// ConsensusChangeBeginning on a pruned node. The subscriber has to be
+	// initialized from a node that keeps the full history instead.
+	ErrPrunedHistory = errors.New("consensus changes before the prune height are not available on a pruned node")
+
+	// MaxProofWindow is the longest storage proof window, from WindowStart
+	// to WindowEnd, for which a pruned node keeps every block of the window
+	// in full.
+	MaxProofWindow = build.Select(build.Var{
+		Standard: types.BlockHeight(1008), // 1 week.
+		Dev:      types.BlockHeight(40),
+		Testing:  types.BlockHeight(10),
+	}).(types.BlockHeight)
+
+	// PruneDepth is the number of recent blocks whose bodies are kept by the
+	// consensus set. Zero disables pruning. It can be set with the --prune
+	// flag of pisd.
+	PruneDepth types.BlockHeight
+)
+
+type (
+	// A PrunedBlock is what remains of a block after its body has been
+	// pruned: the header, which is still needed to serve header sync and to
+	// look up storage proof trigger blocks, and the diffs that were applied
+	// by the block, which are needed to revert it during a reorg.
+	PrunedBlock struct {
+		Header types.BlockHeader
+		Height types.BlockHeight
+
+		PiscoinOutputDiffs        []PiscoinOutputDiff
+		FileContractDiffs         []FileContractDiff
+		PisfundOutputDiffs        []PisfundOutputDiff
+		DelayedPiscoinOutputDiffs []DelayedPiscoinOutputDiff
+		PisfundPoolDiffs          []PisfundPoolDiff
+	}
+)
+
+// MinPruneDepth returns the smallest allowed prune depth. Bodies must be kept
+// long enough for delayed outputs to mature, for the difficulty and timestamp
+// rules to be evaluated, and for the storage proofs of a contract to be
+// available for the whole proof window, plus the trigger block before it.
+func MinPruneDepth() types.BlockHeight {
+	depth := types.MaturityDelay
+	if types.TargetWindow > depth {
+		depth = types.TargetWindow
+	}
+	if types.BlockHeight(types.MedianTimestampWindow) > depth {
+		depth = types.BlockHeight(types.MedianTimestampWindow)
+	}
+	if MaxProofWindow+1 > depth {
+		depth = MaxProofWindow + 1
+	}
+	return depth
+}
+
+// CheckPruneDepth returns an error if depth is neither zero nor at least
+// MinPruneDepth.
+func CheckPruneDepth(depth types.BlockHeight) error {
+	if depth != 0 && depth < MinPruneDepth() {
+		return ErrInvalidPruneDepth
+	}
+	return nil
+}
+
+// PruneHeight returns the height below which block bodies may be pruned when
+// the current height is height. Zero is returned if pruning is disabled or
+// the chain is not long enough to prune anything yet.
+func PruneHeight(height types.BlockHeight) types.BlockHeight {
+	if PruneDepth == 0 || height < PruneDepth {
+		return 0
+	}
+	return height - PruneDepth
+}
+
+// NewPrunedBlock reduces a block to its header and the diffs that were
+// applied by it. The provided ConsensusChange must contain the diffs of the
+// block and nothing else.
+func NewPrunedBlock(b types.Block, height types.BlockHeight, cc ConsensusChange) PrunedBlock {
+	return PrunedBlock{
+		Header:                    b.Header(),
+		Height:                    height,
+		PiscoinOutputDiffs:        cc.PiscoinOutputDiffs,
+		FileContractDiffs:         cc.FileContractDiffs,
+		PisfundOutputDiffs:        cc.PisfundOutputDiffs,
+		DelayedPiscoinOutputDiffs: cc.DelayedPiscoinOutputDiffs,
+		PisfundPoolDiffs:          cc.PisfundPoolDiffs,
+	}
+}
+
+// RevertChange returns the ConsensusChange that reverts the pruned block.
+// Every diff is inverted and the diffs are returned in reverse order. Since
+// the body of the block is gone, the block is reported through
+// RevertedPrunedHeaders instead of RevertedBlocks.
+func (pb PrunedBlock) RevertChange() ConsensusChange {
+	cc := ConsensusChange{
+		RevertedPrunedHeaders: []types.BlockHeader{pb.Header},
+	}
+	for i := len(pb.PiscoinOutputDiffs) - 1; i >= 0; i-- {
+		diff := pb.PiscoinOutputDiffs[i]
+		diff.Direction = !diff.Direction
+		cc.PiscoinOutputDiffs = append(cc.PiscoinOutputDiffs, diff)
+	}
+	for i := len(pb.FileContractDiffs) - 1; i >= 0; i-- {
+		diff := pb.FileContractDiffs[i]
+		diff.Direction = !diff.Direction
+		cc.FileContractDiffs = append(cc.FileContractDiffs, diff)
+	}
+	for i := len(pb.PisfundOutputDiffs) - 1; i >= 0; i-- {
+		diff := pb.PisfundOutputDiffs[i]
+		diff.Direction = !diff.Direction
+		cc.PisfundOutputDiffs = append(cc.PisfundOutputDiffs, diff)
+	}
+	for i := len(pb.DelayedPiscoinOutputDiffs) - 1; i >= 0; i-- {
+		diff := pb.DelayedPiscoinOutputDiffs[i]
+		diff.Direction = !diff.Direction
+		cc.DelayedPiscoinOutputDiffs = append(cc.DelayedPiscoinOutputDiffs, diff)
+	}
+	for i := len(pb.PisfundPoolDiffs) - 1; i >= 0; i-- {
+		diff := pb.PisfundPoolDiffs[i]
+		diff.Direction = !diff.Direction
+		cc.PisfundPoolDiffs = append(cc.PisfundPoolDiffs, diff)
+	}
+	return cc
+}
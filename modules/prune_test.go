@@ -0,0 +1,81 @@
+package modules
+
+import (
+	"testing"
+
+	"github.com/wisherd/Pis/types"
+)
+
+// TestPrunedBlockRevert checks that the ConsensusChange produced by a pruned
+// block undoes the diffs that were applied by the block.
+func TestPrunedBlockRevert(t *testing.T) {
+	sco := types.PiscoinOutput{Value: types.NewCurrency64(5)}
+	genesis := ConsensusChange{
+		AppliedBlocks: []types.Block{{}},
+		PiscoinOutputDiffs: []PiscoinOutputDiff{
+			{Direction: DiffApply, ID: types.PiscoinOutputID{1}, PiscoinOutput: sco},
+		},
+	}
+	b := types.Block{ParentID: types.BlockID{1}}
+	cc := ConsensusChange{
+		AppliedBlocks: []types.Block{b},
+		PiscoinOutputDiffs: []PiscoinOutputDiff{
+			{Direction: DiffRevert, ID: types.PiscoinOutputID{1}, PiscoinOutput: sco},
+			{Direction: DiffApply, ID: types.PiscoinOutputID{2}, PiscoinOutput: sco},
+		},
+		PisfundPoolDiffs: []PisfundPoolDiff{
+			{Direction: DiffApply, Previous: types.ZeroCurrency, Adjusted: types.NewCurrency64(7)},
+		},
+	}
+
+	s := NewConsensusState()
+	if err := s.Apply(genesis); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Apply(cc); err != nil {
+		t.Fatal(err)
+	}
+	pb := NewPrunedBlock(b, 1, cc)
+	revert := pb.RevertChange()
+	if len(revert.RevertedPrunedHeaders) != 1 || revert.RevertedPrunedHeaders[0].ID() != b.ID() {
+		t.Fatal("pruned header was not reported")
+	}
+	if err := s.Apply(revert); err != nil {
+		t.Fatal(err)
+	}
+	if _, exists := s.PiscoinOutputs[types.PiscoinOutputID{1}]; !exists || len(s.PiscoinOutputs) != 1 {
+		t.Fatal("piscoin outputs were not reverted:", s.PiscoinOutputs)
+	}
+	if !s.PisfundPool.IsZero() || s.Height != 0 {
+		t.Fatal("pisfund pool or height were not reverted")
+	}
+}
+
+// TestPruneDepth probes CheckPruneDepth and PruneHeight.
+func TestPruneDepth(t *testing.T) {
+	if err := CheckPruneDepth(0); err != nil {
+		t.Error("disabling pruning should be allowed:", err)
+	}
+	if err := CheckPruneDepth(MinPruneDepth() - 1); err != ErrInvalidPruneDepth {
+		t.Error("expected ErrInvalidPruneDepth, got", err)
+	}
+	if err := CheckPruneDepth(MinPruneDepth()); err != nil {
+		t.Error("minimum prune depth should be allowed:", err)
+	}
+	if MinPruneDepth() <= MaxProofWindow {
+		t.Error("minimum prune depth does not cover the proof window:", MinPruneDepth())
+	}
+
+	defer func(depth types.BlockHeight) { PruneDepth = depth }(PruneDepth)
+	PruneDepth = 0
+	if h := PruneHeight(1000); h != 0 {
+		t.Error("blocks pruned while pruning is disabled:", h)
+	}
+	PruneDepth = 100
+	if h := PruneHeight(50); h != 0 {
+		t.Error("blocks pruned on a short chain:", h)
+	}
+	if h := PruneHeight(1000); h != 900 {
+		t.Error("wrong prune height:", h)
+	}
+}
@@ -0,0 +1,282 @@
+package modules
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// rateLimitPacketSize is the largest number of bytes that a rate limited
+	// connection will read or write in a single call to the underlying
+	// connection. Splitting large transfers into packets keeps the limiter
+	// from granting a single caller a multi-second burst.
+	rateLimitPacketSize = 1 << 14 // 16 KiB
+)
+
+var (
+	// ErrNegativeRateLimit is returned when a bandwidth limit is set to a
+	// negative value.
+	ErrNegativeRateLimit = errors.New("bandwidth limits cannot be negative")
+
+	// GlobalRateLimits is the bandwidth limit that is shared by every
+	// connection created through the production dependencies. All peers
+	// combined will not exceed this limit.
+	GlobalRateLimits = NewRateLimit(0, 0)
+
+	// PeerRateLimits is the bandwidth limit that is applied to each
+	// connection created through the production dependencies individually.
+	// Every connection receives a fork of PeerRateLimits, meaning that
+	// changing the limits affects all existing connections while each
+	// connection still tracks its own bandwidth.
+	PeerRateLimits = NewRateLimit(0, 0)
+)
+
+type (
+	// BandwidthLimits contains the upload and download limits of the
+	// gateway, in bytes per second. A limit of zero means that the bandwidth
+	// is unlimited.
+	BandwidthLimits struct {
+		MaxDownloadSpeed     int64 `json:"maxdownloadspeed"`
+		MaxUploadSpeed       int64 `json:"maxuploadspeed"`
+		MaxPeerDownloadSpeed int64 `json:"maxpeerdownloadspeed"`
+		MaxPeerUploadSpeed   int64 `json:"maxpeeruploadspeed"`
+	}
+
+	// A RateLimit limits the bandwidth of the connections that share it. The
+	// limits are enforced using a token bucket for each direction, allowing a
+	// burst of up to one second worth of bandwidth.
+	RateLimit struct {
+		settings *rateLimitSettings
+
+		readBucket  bandwidthBucket
+		writeBucket bandwidthBucket
+		mu          sync.Mutex
+	}
+
+	// rateLimitSettings holds the limits of a RateLimit. The settings are
+	// kept separate from the buckets so that forked limits can share them.
+	rateLimitSettings struct {
+		readBPS  int64
+		writeBPS int64
+		mu       sync.RWMutex
+	}
+
+	// bandwidthBucket is a token bucket that tracks how many bytes may still
+	// be transferred. The allowance can become negative, in which case the
+	// caller has to wait until the debt has been paid off.
+	bandwidthBucket struct {
+		allowance int64
+		lastFill  time.Time
+	}
+
+	// RLConn is a net.Conn that is limited by a set of RateLimits and keeps
+	// track of the number of bytes that were transferred over it.
+	RLConn struct {
+		// atomicDownloaded and atomicUploaded are accessed atomically and
+		// therefore need to be the first fields of the struct to guarantee
+		// alignment on 32-bit platforms.
+		atomicDownloaded uint64
+		atomicUploaded   uint64
+
+		net.Conn
+		limits []*RateLimit
+	}
+
+	// rlListener is a net.Listener that wraps every accepted connection in a
+	// RLConn.
+	rlListener struct {
+		net.Listener
+	}
+)
+
+// NewRateLimit creates a new RateLimit with the provided limits in bytes per
+// second. A limit of zero means that the direction is unlimited.
+func NewRateLimit(readBPS, writeBPS int64) *RateLimit {
+	return &RateLimit{
+		settings: &rateLimitSettings{
+			readBPS:  readBPS,
+			writeBPS: writeBPS,
+		},
+	}
+}
+
+// Fork returns a RateLimit that shares the settings of rl but tracks the
+// bandwidth that passes through it separately.
+func (rl *RateLimit) Fork() *RateLimit {
+	return &RateLimit{
+		settings: rl.settings,
+	}
+}
+
+// Limits returns the current read and write limits of rl in bytes per
+// second.
+func (rl *RateLimit) Limits() (readBPS, writeBPS int64) {
+	rl.settings.mu.RLock()
+	defer rl.settings.mu.RUnlock()
+	return rl.settings.readBPS, rl.settings.writeBPS
+}
+
+// SetLimits changes the read and write limits of rl and of every RateLimit
+// that was forked from it.
+func (rl *RateLimit) SetLimits(readBPS, writeBPS int64) error {
+	if readBPS < 0 || writeBPS < 0 {
+		return ErrNegativeRateLimit
+	}
+	rl.settings.mu.Lock()
+	rl.settings.readBPS = readBPS
+	rl.settings.writeBPS = writeBPS
+	rl.settings.mu.Unlock()
+	return nil
+}
+
+// reserveRead records that n bytes were read and returns the amount of time
+// that the reader needs to wait to stay within the limit.
+func (rl *RateLimit) reserveRead(n int) time.Duration {
+	readBPS, _ := rl.Limits()
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	return rl.readBucket.reserve(n, readBPS, time.Now())
+}
+
+// reserveWrite records that n bytes are about to be written and returns the
+// amount of time that the writer needs to wait to stay within the limit.
+func (rl *RateLimit) reserveWrite(n int) time.Duration {
+	_, writeBPS := rl.Limits()
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	return rl.writeBucket.reserve(n, writeBPS, time.Now())
+}
+
+// reserve refills the bucket according to the time that has passed since the
+// last call and then subtracts n bytes from it. If the bucket is in debt
+// afterwards, the time it takes to pay off the debt is returned.
+func (b *bandwidthBucket) reserve(n int, bps int64, now time.Time) time.Duration {
+	// An unlimited bucket never makes the caller wait. The bucket is reset so
+	// that lowering the limit later does not start with a stale allowance.
+	if bps <= 0 {
+		b.allowance = 0
+		b.lastFill = time.Time{}
+		return 0
+	}
+
+	// A fresh bucket starts full.
+	if b.lastFill.IsZero() {
+		b.allowance = bps
+		b.lastFill = now
+	}
+	elapsed := now.Sub(b.lastFill)
+	b.lastFill = now
+	b.allowance += int64(elapsed.Seconds() * float64(bps))
+	if b.allowance > bps {
+		b.allowance = bps
+	}
+
+	b.allowance -= int64(n)
+	if b.allowance >= 0 {
+		return 0
+	}
+	return time.Duration(float64(-b.allowance) / float64(bps) * float64(time.Second))
+}
+
+// NewRLConn wraps conn so that it obeys all of the provided limits.
+func NewRLConn(conn net.Conn, limits ...*RateLimit) *RLConn {
+	return &RLConn{
+		Conn:   conn,
+		limits: limits,
+	}
+}
+
+// Downloaded returns the total number of bytes that were read from the
+// connection.
+func (c *RLConn) Downloaded() uint64 {
+	return atomic.LoadUint64(&c.atomicDownloaded)
+}
+
+// Uploaded returns the total number of bytes that were written to the
+// connection.
+func (c *RLConn) Uploaded() uint64 {
+	return atomic.LoadUint64(&c.atomicUploaded)
+}
+
+// Read reads at most one packet from the underlying connection and then
+// blocks until the read fits within the limits of the connection.
+func (c *RLConn) Read(b []byte) (int, error) {
+	if len(b) > rateLimitPacketSize {
+		b = b[:rateLimitPacketSize]
+	}
+	n, err := c.Conn.Read(b)
+	atomic.AddUint64(&c.atomicDownloaded, uint64(n))
+
+	var wait time.Duration
+	for _, rl := range c.limits {
+		if d := rl.reserveRead(n); d > wait {
+			wait = d
+		}
+	}
+	time.Sleep(wait)
+	return n, err
+}
+
+// Write splits b into packets and writes them to the underlying connection,
+// blocking before each packet until the packet fits within the limits of the
+// connection.
+func (c *RLConn) Write(b []byte) (int, error) {
+	var written int
+	for len(b) > 0 {
+		packet := b
+		if len(packet) > rateLimitPacketSize {
+			packet = packet[:rateLimitPacketSize]
+		}
+
+		var wait time.Duration
+		for _, rl := range c.limits {
+			if d := rl.reserveWrite(len(packet)); d > wait {
+				wait = d
+			}
+		}
+		time.Sleep(wait)
+
+		n, err := c.Conn.Write(packet)
+		written += n
+		atomic.AddUint64(&c.atomicUploaded, uint64(n))
+		if err != nil {
+			return written, err
+		}
+		b = b[n:]
+	}
+	return written, nil
+}
+
+// Accept waits for the next connection and wraps it in a RLConn that obeys
+// the global and per-peer limits.
+func (l rlListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return NewRLConn(conn, GlobalRateLimits, PeerRateLimits.Fork()), nil
+}
+
+// CurrentBandwidthLimits returns the bandwidth limits that are currently
+// applied to the connections created by the production dependencies.
+func CurrentBandwidthLimits() BandwidthLimits {
+	var bl BandwidthLimits
+	bl.MaxDownloadSpeed, bl.MaxUploadSpeed = GlobalRateLimits.Limits()
+	bl.MaxPeerDownloadSpeed, bl.MaxPeerUploadSpeed = PeerRateLimits.Limits()
+	return bl
+}
+
+// SetBandwidthLimits applies bl to the global and per-peer rate limits. The
+// new limits take effect immediately, including for existing connections.
+func SetBandwidthLimits(bl BandwidthLimits) error {
+	if bl.MaxDownloadSpeed < 0 || bl.MaxUploadSpeed < 0 || bl.MaxPeerDownloadSpeed < 0 || bl.MaxPeerUploadSpeed < 0 {
+		return ErrNegativeRateLimit
+	}
+	if err := GlobalRateLimits.SetLimits(bl.MaxDownloadSpeed, bl.MaxUploadSpeed); err != nil {
+		return err
+	}
+	return PeerRateLimits.SetLimits(bl.MaxPeerDownloadSpeed, bl.MaxPeerUploadSpeed)
+}
@@ -0,0 +1,102 @@
+package modules
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// TestBandwidthBucket checks that the token bucket grants an initial burst
+// of one second and then charges the caller for any excess.
+func TestBandwidthBucket(t *testing.T) {
+	var b bandwidthBucket
+	now := time.Now()
+
+	// The first second worth of bandwidth is free.
+	if d := b.reserve(1000, 1000, now); d != 0 {
+		t.Fatal("expected no wait for the initial burst, got", d)
+	}
+	// Another 500 bytes at the same instant should cost half a second.
+	if d := b.reserve(500, 1000, now); d != 500*time.Millisecond {
+		t.Fatal("expected a wait of 500ms, got", d)
+	}
+	// After a second, the debt is paid off and 500 bytes are available.
+	if d := b.reserve(500, 1000, now.Add(time.Second)); d != 0 {
+		t.Fatal("expected no wait after the debt was paid off, got", d)
+	}
+	// The bucket never holds more than one second worth of bandwidth.
+	if d := b.reserve(1500, 1000, now.Add(time.Hour)); d != 500*time.Millisecond {
+		t.Fatal("expected the allowance to be capped, got", d)
+	}
+	// Unlimited buckets never block.
+	if d := b.reserve(1e9, 0, now); d != 0 {
+		t.Fatal("expected no wait for an unlimited bucket, got", d)
+	}
+}
+
+// TestRateLimitFork checks that forked rate limits share their settings.
+func TestRateLimitFork(t *testing.T) {
+	rl := NewRateLimit(10, 20)
+	fork := rl.Fork()
+	if err := rl.SetLimits(30, 40); err != nil {
+		t.Fatal(err)
+	}
+	if r, w := fork.Limits(); r != 30 || w != 40 {
+		t.Fatal("fork did not pick up the new limits:", r, w)
+	}
+	if err := fork.SetLimits(-1, 0); err != ErrNegativeRateLimit {
+		t.Fatal("expected ErrNegativeRateLimit, got", err)
+	}
+}
+
+// TestRLConnCounters checks that a RLConn counts the bytes that pass through
+// it and that a limited write takes roughly as long as the limit dictates.
+func TestRLConnCounters(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+
+	// Allow 32 KiB per second. The first 32 KiB are free, so writing 64 KiB
+	// should take about a second.
+	rl := NewRateLimit(0, 1<<15)
+	conn := NewRLConn(c1, rl)
+	data := bytes.Repeat([]byte{1}, 1<<16)
+
+	start := time.Now()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if _, err := conn.Write(data); err != nil {
+			t.Error(err)
+		}
+	}()
+	buf := make([]byte, len(data))
+	if _, err := io.ReadFull(c2, buf); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+	if elapsed := time.Since(start); elapsed < 900*time.Millisecond {
+		t.Fatal("write was not rate limited, took", elapsed)
+	}
+	if conn.Uploaded() != uint64(len(data)) {
+		t.Fatal("wrong upload count:", conn.Uploaded())
+	}
+
+	// Read the data back through the limited conn.
+	go func() {
+		if _, err := c2.Write(data[:100]); err != nil {
+			t.Error(err)
+		}
+	}()
+	if _, err := io.ReadFull(conn, buf[:100]); err != nil {
+		t.Fatal(err)
+	}
+	if conn.Downloaded() != 100 {
+		t.Fatal("wrong download count:", conn.Downloaded())
+	}
+}
@@ -0,0 +1,28 @@
+package modules
+
+import (
+	"github.com/wisherd/Pis/types"
+)
+
+const (
+	// RegtestDir is the name of the directory inside the Pis directory
+	// that holds the data of the regtest network, so that it is never mixed
+	// with the data of another network.
+	RegtestDir = "regtest"
+
+	// MaxRegtestBlocks is the maximum number of blocks that can be generated
+	// by a single call to /regtest/generate.
+	MaxRegtestBlocks = 1000
+)
+
+// ActivateRegtest activates the regtest constants of the types package and
+// adjusts the network settings of the modules: there are no bootstrap peers,
+// no checkpoints and no assume-valid block, and loopback addresses are valid
+// peer addresses. ActivateRegtest must be called before any module is
+// created.
+func ActivateRegtest() {
+	types.ActivateRegtest()
+	BootstrapPeers = nil
+	Checkpoints = nil
+	AssumeValidBlock = types.BlockID{}
+}
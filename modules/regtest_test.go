@@ -0,0 +1,40 @@
+package modules
+
+import (
+	"testing"
+
+	"github.com/wisherd/Pis/types"
+)
+
+// TestActivateRegtest checks that activating regtest creates a new genesis
+// block with a trivial target and clears the settings of the built-in
+// network.
+func TestActivateRegtest(t *testing.T) {
+	oldParams := CurrentNetworkParams()
+	oldCheckpoints, oldAssumeValid := Checkpoints, AssumeValidBlock
+	oldGenesisID := types.GenesisID
+	defer func() {
+		types.Regtest = false
+		if err := ActivateNetwork(oldParams); err != nil {
+			t.Fatal(err)
+		}
+		Checkpoints, AssumeValidBlock = oldCheckpoints, oldAssumeValid
+	}()
+
+	ActivateRegtest()
+	if !types.Regtest {
+		t.Fatal("regtest was not activated")
+	}
+	if types.GenesisID == oldGenesisID || types.GenesisID != types.GenesisBlock.ID() {
+		t.Fatal("genesis block was not recreated:", types.GenesisID)
+	}
+	if types.GenesisTimestamp != types.RegtestGenesisTimestamp {
+		t.Fatal("wrong genesis timestamp:", types.GenesisTimestamp)
+	}
+	if types.RootTarget != (types.Target{128}) {
+		t.Fatal("root target is not trivial:", types.RootTarget)
+	}
+	if len(BootstrapPeers) != 0 || len(Checkpoints) != 0 || AssumeValidBlock != (types.BlockID{}) {
+		t.Fatal("network settings were not cleared:", BootstrapPeers, Checkpoints, AssumeValidBlock)
+	}
+}
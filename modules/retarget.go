@@ -0,0 +1,185 @@
+package modules
+
+import (
+	"errors"
+	"math/big"
+	"sort"
+
+	"github.com/wisherd/Pis/types"
+)
+
+var (
+	// ErrEarlyTimestamp is returned when a header has a timestamp below the
+	// median timestamp of the previous MedianTimestampWindow blocks.
+	ErrEarlyTimestamp = errors.New("header has a timestamp earlier than the minimum valid timestamp")
+)
+
+type (
+	// A TargetState is the state of the difficulty adjustment after a block.
+	// It holds everything that is needed to compute the target of the
+	// block's descendants from their headers alone, so that header-only
+	// chains follow the same retarget rules as the consensus set.
+	//
+	// TotalTime and TotalTarget are the decayed totals of the Oak difficulty
+	// adjustment, which are tracked from the genesis block on.
+	TargetState struct {
+		Height      types.BlockHeight `json:"height"`
+		Timestamp   types.Timestamp   `json:"timestamp"`
+		ChildTarget types.Target      `json:"childtarget"`
+		TotalTime   int64             `json:"totaltime"`
+		TotalTarget types.Target      `json:"totaltarget"`
+	}
+
+	// A TimestampFunc returns the timestamp of the ancestor at the given
+	// height of the block that is being processed.
+	TimestampFunc func(height types.BlockHeight) types.Timestamp
+)
+
+// GenesisTargetState returns the target state of the genesis block.
+func GenesisTargetState() TargetState {
+	return TargetState{
+		Timestamp:   types.GenesisTimestamp,
+		ChildTarget: types.RootTarget,
+		TotalTime:   0,
+		TotalTarget: types.RootDepth.MulDifficulty(big.NewRat(types.OakDecayNum, types.OakDecayDenom)).AddDifficulties(types.RootTarget),
+	}
+}
+
+// ChildTargetState returns the target state of a child of the block described
+// by parent that has the given timestamp. ancestorTimestamp is only called
+// before the Oak hardfork, with heights up to and including the height of
+// parent.
+func ChildTargetState(parent TargetState, timestamp types.Timestamp, ancestorTimestamp TimestampFunc) TargetState {
+	child := TargetState{
+		Height:    parent.Height + 1,
+		Timestamp: timestamp,
+	}
+
+	// Decay the totals of the parent and add the child. The total time is
+	// reset just before the hardfork; this matches the behaviour of the
+	// network, even though it was not intended.
+	prevTotalTime := parent.TotalTime
+	if child.Height == types.OakHardforkBlock-1 {
+		prevTotalTime = int64(types.BlockFrequency * child.Height)
+	}
+	decay := big.NewRat(types.OakDecayNum, types.OakDecayDenom)
+	child.TotalTime = prevTotalTime*types.OakDecayNum/types.OakDecayDenom + int64(timestamp) - int64(parent.Timestamp)
+	child.TotalTarget = parent.TotalTarget.MulDifficulty(decay).AddDifficulties(parent.ChildTarget)
+
+	if parent.Height < types.OakHardforkBlock {
+		child.ChildTarget = windowChildTarget(parent.ChildTarget, child.Height, timestamp, ancestorTimestamp)
+	} else {
+		child.ChildTarget = oakChildTarget(parent)
+	}
+	return child
+}
+
+// windowChildTarget returns the child target of a block before the Oak
+// hardfork. Every TargetWindow/2 blocks, the target is adjusted by the ratio
+// between the time it took to mine the last TargetWindow blocks and the time
+// it should have taken, clamped to MaxTargetAdjustmentUp and
+// MaxTargetAdjustmentDown. In between, the target does not change.
+func windowChildTarget(target types.Target, height types.BlockHeight, timestamp types.Timestamp, ancestorTimestamp TimestampFunc) types.Target {
+	if height%(types.TargetWindow/2) != 0 {
+		return target
+	}
+	window := types.TargetWindow
+	if height < window {
+		window = height
+	}
+	timePassed := int64(timestamp) - int64(ancestorTimestamp(height-window))
+	expectedTimePassed := int64(types.BlockFrequency * window)
+	adjustment := big.NewRat(timePassed, expectedTimePassed)
+	if adjustment.Cmp(types.MaxTargetAdjustmentUp) > 0 {
+		adjustment = types.MaxTargetAdjustmentUp
+	} else if adjustment.Cmp(types.MaxTargetAdjustmentDown) < 0 {
+		adjustment = types.MaxTargetAdjustmentDown
+	}
+	return types.RatToTarget(new(big.Rat).Mul(target.Rat(), adjustment))
+}
+
+// oakChildTarget returns the target of the grandchildren of the block
+// described by parent under the Oak difficulty adjustment. The target block
+// time is shifted by the square of the distance between the chain and the
+// expected schedule, clamped to OakMaxBlockShift, and the target is chosen
+// so that the visible hashrate mines a block in that time. The result may
+// change the target of the parent's child by at most OakMaxRise and
+// OakMaxDrop.
+func oakChildTarget(parent TargetState) types.Target {
+	// Before the fix, the expected time was compared with the decayed total
+	// time, which made the chain always appear to be ahead of schedule.
+	var delta int64
+	if parent.Height < types.OakHardforkFixBlock {
+		delta = int64(types.BlockFrequency*parent.Height) - parent.TotalTime
+	} else {
+		delta = int64(types.BlockFrequency*parent.Height) + int64(types.GenesisTimestamp) - int64(parent.Timestamp)
+	}
+	shift := delta * delta / 10e6
+	if delta < 0 {
+		shift = -shift
+	}
+	blockFrequency := int64(types.BlockFrequency)
+	targetBlockTime := blockFrequency + shift
+	if targetBlockTime < blockFrequency/types.OakMaxBlockShift {
+		targetBlockTime = blockFrequency / types.OakMaxBlockShift
+	}
+	if targetBlockTime > blockFrequency*types.OakMaxBlockShift {
+		targetBlockTime = blockFrequency * types.OakMaxBlockShift
+	}
+	if targetBlockTime == 0 {
+		targetBlockTime = 1
+	}
+
+	totalTime := parent.TotalTime
+	if totalTime < 1 {
+		totalTime = 1
+	}
+	hashrate := parent.TotalTarget.Difficulty().Div64(uint64(totalTime))
+	if hashrate.IsZero() {
+		hashrate = types.NewCurrency64(1)
+	}
+	target := types.RatToTarget(new(big.Rat).SetFrac(types.RootDepth.Int(), hashrate.Mul64(uint64(targetBlockTime)).Big()))
+
+	maxTarget := parent.ChildTarget.MulDifficulty(types.OakMaxDrop)
+	minTarget := parent.ChildTarget.MulDifficulty(types.OakMaxRise)
+	if target.Cmp(minTarget) < 0 {
+		target = minTarget
+	} else if target.Cmp(maxTarget) > 0 {
+		target = maxTarget
+	}
+	return target
+}
+
+// MinimumValidChildTimestamp returns the earliest timestamp that a child of
+// the block at height may have, which is the median timestamp of the last
+// MedianTimestampWindow blocks. Missing blocks before the genesis block
+// count as having the genesis timestamp.
+func MinimumValidChildTimestamp(height types.BlockHeight, timestamp TimestampFunc) types.Timestamp {
+	timestamps := make(types.TimestampSlice, types.MedianTimestampWindow)
+	for i := range timestamps {
+		timestamps[i] = timestamp(height)
+		if height > 0 {
+			height--
+		}
+	}
+	sort.Sort(timestamps)
+	return timestamps[len(timestamps)/2]
+}
+
+// CheckHeaderTimestamp checks the timestamp of a child of the block at height
+// against the median rule and the current time. It returns ErrEarlyTimestamp,
+// ErrExtremeFutureTimestamp or ErrFutureTimestamp if the timestamp is not
+// valid.
+func CheckHeaderTimestamp(ts types.Timestamp, height types.BlockHeight, timestamp TimestampFunc) error {
+	if ts < MinimumValidChildTimestamp(height, timestamp) {
+		return ErrEarlyTimestamp
+	}
+	now := types.CurrentTimestamp()
+	if ts > now+types.ExtremeFutureThreshold {
+		return ErrExtremeFutureTimestamp
+	}
+	if ts > now+types.FutureThreshold {
+		return ErrFutureTimestamp
+	}
+	return nil
+}
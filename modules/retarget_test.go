@@ -0,0 +1,119 @@
+package modules
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/wisherd/Pis/types"
+)
+
+// scaleTarget returns t multiplied by num/denom.
+func scaleTarget(t types.Target, num, denom int64) types.Target {
+	return types.RatToTarget(new(big.Rat).Mul(t.Rat(), big.NewRat(num, denom)))
+}
+
+// TestChildTargetState checks the decayed totals and the clamps of the Oak
+// difficulty adjustment with the testing constants: a decay of 9999/10000, a
+// block frequency of 1 and a maximum change of 1/10000 per block.
+func TestChildTargetState(t *testing.T) {
+	noAncestors := func(types.BlockHeight) types.Timestamp {
+		t.Fatal("ancestor timestamp requested")
+		return 0
+	}
+	target := types.Target{0, 0, 1}
+
+	// Before the hardfork, the target only changes every TargetWindow/2
+	// blocks.
+	parent := TargetState{
+		Height:      5,
+		Timestamp:   types.GenesisTimestamp + 5,
+		ChildTarget: target,
+		TotalTime:   1e6,
+		TotalTarget: target,
+	}
+	child := ChildTargetState(parent, parent.Timestamp+5, noAncestors)
+	if child.Height != 6 || child.ChildTarget != target {
+		t.Fatal("target changed before the hardfork:", child.Height, child.ChildTarget)
+	}
+	if child.TotalTime != 999905 {
+		t.Fatal("wrong total time:", child.TotalTime)
+	}
+
+	// The total time is reset just before the hardfork.
+	parent.Height = types.OakHardforkBlock - 2
+	if child := ChildTargetState(parent, parent.Timestamp+5, noAncestors); child.TotalTime != 18+5 {
+		t.Fatal("total time was not reset before the hardfork:", child.TotalTime)
+	}
+
+	// After the hardfork, a hashrate far below the target is clamped to the
+	// maximum drop of the difficulty, and a hashrate far above it to the
+	// maximum rise.
+	parent = TargetState{
+		Height:      30,
+		Timestamp:   types.GenesisTimestamp + 30,
+		ChildTarget: target,
+		TotalTime:   1e6,
+		TotalTarget: types.RootDepth,
+	}
+	if child := ChildTargetState(parent, parent.Timestamp+1, noAncestors); child.ChildTarget != scaleTarget(target, 10001, 10000) {
+		t.Fatal("low hashrate was not clamped:", child.ChildTarget)
+	}
+	parent.TotalTime = 1
+	parent.TotalTarget = types.Target{0, 0, 0, 0, 1}
+	if child := ChildTargetState(parent, parent.Timestamp+1, noAncestors); child.ChildTarget != scaleTarget(target, 10000, 10001) {
+		t.Fatal("high hashrate was not clamped:", child.ChildTarget)
+	}
+
+	// A chain that is far ahead of schedule gets a longer target block time
+	// once the Oak fix is active. Before the fix, only the decayed total time
+	// is compared with the schedule, so the target follows the hashrate.
+	lowest, highest := scaleTarget(target, 10000, 10001), scaleTarget(target, 10001, 10000)
+	parent = TargetState{
+		Height:      types.OakHardforkFixBlock + 5,
+		ChildTarget: target,
+		TotalTime:   1,
+		TotalTarget: target,
+	}
+	parent.Timestamp = types.GenesisTimestamp + types.Timestamp(parent.Height) - 5000
+	if child := ChildTargetState(parent, parent.Timestamp+1, noAncestors); child.ChildTarget != lowest {
+		t.Fatal("target block time was not shifted after the fix:", child.ChildTarget)
+	}
+	parent.Height = types.OakHardforkFixBlock - 1
+	parent.Timestamp = types.GenesisTimestamp + types.Timestamp(parent.Height) - 5000
+	if child := ChildTargetState(parent, parent.Timestamp+1, noAncestors); child.ChildTarget.Cmp(lowest) <= 0 || child.ChildTarget.Cmp(highest) >= 0 {
+		t.Fatal("target block time was shifted before the fix:", child.ChildTarget)
+	}
+}
+
+// TestWindowChildTarget checks the adjustment of the target every
+// TargetWindow/2 blocks before the Oak hardfork.
+func TestWindowChildTarget(t *testing.T) {
+	target := types.Target{0, 0, 1}
+	height := types.TargetWindow / 2
+	start := types.GenesisTimestamp
+	ancestor := func(h types.BlockHeight) types.Timestamp {
+		if h != 0 {
+			t.Fatal("wrong ancestor height:", h)
+		}
+		return start
+	}
+	tests := []struct {
+		elapsed types.Timestamp
+		target  types.Target
+	}{
+		// Blocks that took twice as long as expected raise the target by
+		// MaxTargetAdjustmentUp, blocks that came twice as fast lower it by
+		// MaxTargetAdjustmentDown.
+		{types.Timestamp(2 * height), scaleTarget(target, 10001, 10000)},
+		{types.Timestamp(height / 2), scaleTarget(target, 9999, 10000)},
+		{types.Timestamp(height), target},
+	}
+	for _, test := range tests {
+		if got := windowChildTarget(target, height, start+test.elapsed, ancestor); got != test.target {
+			t.Errorf("elapsed %v: expected %v, got %v", test.elapsed, test.target, got)
+		}
+	}
+	if got := windowChildTarget(target, height+1, start, nil); got != target {
+		t.Error("target changed between adjustments:", got)
+	}
+}
@@ -0,0 +1,373 @@
+package modules
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"sort"
+	"unsafe"
+
+	"github.com/wisherd/Pis/crypto"
+	"github.com/wisherd/Pis/encoding"
+	"github.com/wisherd/Pis/types"
+)
+
+const (
+	// Every leaf of the Merkle tree of a StateSnapshot starts with one of
+	// the following specifiers, so that entries of different kinds can never
+	// produce the same leaf.
+	snapshotLeafHeader byte = iota
+	snapshotLeafPiscoinOutput
+	snapshotLeafPisfundOutput
+	snapshotLeafFileContract
+	snapshotLeafDelayedPiscoinOutput
+)
+
+var (
+	// ErrSnapshotUnavailable is returned when the state at the requested
+	// height cannot be rebuilt, because the block at that height was applied
+	// in the same consensus change as its children during a reorg.
+	ErrSnapshotUnavailable = errors.New("the state at the requested height cannot be rebuilt from the consensus changes")
+)
+
+type (
+	// A SnapshotPiscoinOutput is an unspent piscoin output in a StateSnapshot.
+	SnapshotPiscoinOutput struct {
+		ID            types.PiscoinOutputID `json:"id"`
+		PiscoinOutput types.PiscoinOutput   `json:"piscoinoutput"`
+	}
+
+	// A SnapshotPisfundOutput is an unspent pisfund output in a StateSnapshot.
+	SnapshotPisfundOutput struct {
+		ID            types.PisfundOutputID `json:"id"`
+		PisfundOutput types.PisfundOutput   `json:"pisfundoutput"`
+	}
+
+	// A SnapshotFileContract is an open file contract in a StateSnapshot.
+	SnapshotFileContract struct {
+		ID           types.FileContractID `json:"id"`
+		FileContract types.FileContract   `json:"filecontract"`
+	}
+
+	// A SnapshotDelayedPiscoinOutput is a delayed piscoin output in a
+	// StateSnapshot.
+	SnapshotDelayedPiscoinOutput struct {
+		ID             types.PiscoinOutputID `json:"id"`
+		MaturityHeight types.BlockHeight     `json:"maturityheight"`
+		PiscoinOutput  types.PiscoinOutput   `json:"piscoinoutput"`
+	}
+
+	// A StateSnapshot is the full set of unspent outputs and open file
+	// contracts at the block with ID BlockID. The entries are sorted by ID,
+	// and delayed outputs by maturity height first, so two nodes with the
+	// same state produce the same snapshot and the same MerkleRoot.
+	StateSnapshot struct {
+		Height                types.BlockHeight              `json:"height"`
+		BlockID               types.BlockID                  `json:"blockid"`
+		PisfundPool           types.Currency                 `json:"pisfundpool"`
+		PiscoinOutputs        []SnapshotPiscoinOutput        `json:"piscoinoutputs"`
+		PisfundOutputs        []SnapshotPisfundOutput        `json:"pisfundoutputs"`
+		FileContracts         []SnapshotFileContract         `json:"filecontracts"`
+		DelayedPiscoinOutputs []SnapshotDelayedPiscoinOutput `json:"delayedpiscoinoutputs"`
+	}
+
+	// snapshotBuilder is a ConsensusSetSubscriber that rebuilds the state
+	// and takes a snapshot every time the current path passes through the
+	// requested height.
+	snapshotBuilder struct {
+		height    types.BlockHeight
+		state     *ConsensusState
+		path      []types.BlockID
+		snapshots map[types.BlockID]StateSnapshot
+	}
+)
+
+// MarshalPis implements the encoding.PisMarshaler interface.
+func (sco SnapshotPiscoinOutput) MarshalPis(w io.Writer) error {
+	e := encoding.NewEncoder(w)
+	e.Write(sco.ID[:])
+	sco.PiscoinOutput.MarshalPis(e)
+	return e.Err()
+}
+
+// UnmarshalPis implements the encoding.PisUnmarshaler interface.
+func (sco *SnapshotPiscoinOutput) UnmarshalPis(r io.Reader) error {
+	d := encoding.NewDecoder(r)
+	d.ReadFull(sco.ID[:])
+	sco.PiscoinOutput.UnmarshalPis(d)
+	return d.Err()
+}
+
+// MarshalPis implements the encoding.PisMarshaler interface.
+func (sfo SnapshotPisfundOutput) MarshalPis(w io.Writer) error {
+	e := encoding.NewEncoder(w)
+	e.Write(sfo.ID[:])
+	sfo.PisfundOutput.MarshalPis(e)
+	return e.Err()
+}
+
+// UnmarshalPis implements the encoding.PisUnmarshaler interface.
+func (sfo *SnapshotPisfundOutput) UnmarshalPis(r io.Reader) error {
+	d := encoding.NewDecoder(r)
+	d.ReadFull(sfo.ID[:])
+	sfo.PisfundOutput.UnmarshalPis(d)
+	return d.Err()
+}
+
+// MarshalPis implements the encoding.PisMarshaler interface.
+func (fc SnapshotFileContract) MarshalPis(w io.Writer) error {
+	e := encoding.NewEncoder(w)
+	e.Write(fc.ID[:])
+	fc.FileContract.MarshalPis(e)
+	return e.Err()
+}
+
+// UnmarshalPis implements the encoding.PisUnmarshaler interface.
+func (fc *SnapshotFileContract) UnmarshalPis(r io.Reader) error {
+	d := encoding.NewDecoder(r)
+	d.ReadFull(fc.ID[:])
+	fc.FileContract.UnmarshalPis(d)
+	return d.Err()
+}
+
+// MarshalPis implements the encoding.PisMarshaler interface.
+func (dsco SnapshotDelayedPiscoinOutput) MarshalPis(w io.Writer) error {
+	e := encoding.NewEncoder(w)
+	e.Write(dsco.ID[:])
+	e.WriteUint64(uint64(dsco.MaturityHeight))
+	dsco.PiscoinOutput.MarshalPis(e)
+	return e.Err()
+}
+
+// UnmarshalPis implements the encoding.PisUnmarshaler interface.
+func (dsco *SnapshotDelayedPiscoinOutput) UnmarshalPis(r io.Reader) error {
+	d := encoding.NewDecoder(r)
+	d.ReadFull(dsco.ID[:])
+	dsco.MaturityHeight = types.BlockHeight(d.NextUint64())
+	dsco.PiscoinOutput.UnmarshalPis(d)
+	return d.Err()
+}
+
+// marshalHeader writes the fields of the snapshot that are not part of an
+// entry.
+func (ss StateSnapshot) marshalHeader(e *encoding.Encoder) {
+	e.WriteUint64(uint64(ss.Height))
+	e.Write(ss.BlockID[:])
+	ss.PisfundPool.MarshalPis(e)
+}
+
+// MarshalPis implements the encoding.PisMarshaler interface.
+func (ss StateSnapshot) MarshalPis(w io.Writer) error {
+	e := encoding.NewEncoder(w)
+	ss.marshalHeader(e)
+	e.WriteInt(len(ss.PiscoinOutputs))
+	for _, sco := range ss.PiscoinOutputs {
+		sco.MarshalPis(e)
+	}
+	e.WriteInt(len(ss.PisfundOutputs))
+	for _, sfo := range ss.PisfundOutputs {
+		sfo.MarshalPis(e)
+	}
+	e.WriteInt(len(ss.FileContracts))
+	for _, fc := range ss.FileContracts {
+		fc.MarshalPis(e)
+	}
+	e.WriteInt(len(ss.DelayedPiscoinOutputs))
+	for _, dsco := range ss.DelayedPiscoinOutputs {
+		dsco.MarshalPis(e)
+	}
+	return e.Err()
+}
+
+// UnmarshalPis implements the encoding.PisUnmarshaler interface.
+func (ss *StateSnapshot) UnmarshalPis(r io.Reader) error {
+	d := encoding.NewDecoder(r)
+	ss.Height = types.BlockHeight(d.NextUint64())
+	d.ReadFull(ss.BlockID[:])
+	ss.PisfundPool.UnmarshalPis(d)
+	ss.PiscoinOutputs = make([]SnapshotPiscoinOutput, d.NextPrefix(unsafe.Sizeof(SnapshotPiscoinOutput{})))
+	for i := range ss.PiscoinOutputs {
+		ss.PiscoinOutputs[i].UnmarshalPis(d)
+	}
+	ss.PisfundOutputs = make([]SnapshotPisfundOutput, d.NextPrefix(unsafe.Sizeof(SnapshotPisfundOutput{})))
+	for i := range ss.PisfundOutputs {
+		ss.PisfundOutputs[i].UnmarshalPis(d)
+	}
+	ss.FileContracts = make([]SnapshotFileContract, d.NextPrefix(unsafe.Sizeof(SnapshotFileContract{})))
+	for i := range ss.FileContracts {
+		ss.FileContracts[i].UnmarshalPis(d)
+	}
+	ss.DelayedPiscoinOutputs = make([]SnapshotDelayedPiscoinOutput, d.NextPrefix(unsafe.Sizeof(SnapshotDelayedPiscoinOutput{})))
+	for i := range ss.DelayedPiscoinOutputs {
+		ss.DelayedPiscoinOutputs[i].UnmarshalPis(d)
+	}
+	return d.Err()
+}
+
+// NewStateSnapshot returns a snapshot of s, which must be the state at the
+// block with the provided ID.
+func NewStateSnapshot(s *ConsensusState, id types.BlockID) StateSnapshot {
+	ss := StateSnapshot{
+		Height:                s.Height,
+		BlockID:               id,
+		PisfundPool:           s.PisfundPool,
+		PiscoinOutputs:        make([]SnapshotPiscoinOutput, 0, len(s.PiscoinOutputs)),
+		PisfundOutputs:        make([]SnapshotPisfundOutput, 0, len(s.PisfundOutputs)),
+		FileContracts:         make([]SnapshotFileContract, 0, len(s.FileContracts)),
+		DelayedPiscoinOutputs: make([]SnapshotDelayedPiscoinOutput, 0),
+	}
+	for id, sco := range s.PiscoinOutputs {
+		ss.PiscoinOutputs = append(ss.PiscoinOutputs, SnapshotPiscoinOutput{ID: id, PiscoinOutput: sco})
+	}
+	for id, sfo := range s.PisfundOutputs {
+		ss.PisfundOutputs = append(ss.PisfundOutputs, SnapshotPisfundOutput{ID: id, PisfundOutput: sfo})
+	}
+	for id, fc := range s.FileContracts {
+		ss.FileContracts = append(ss.FileContracts, SnapshotFileContract{ID: id, FileContract: fc})
+	}
+	for height, dscos := range s.DelayedPiscoinOutputs {
+		for id, dsco := range dscos {
+			ss.DelayedPiscoinOutputs = append(ss.DelayedPiscoinOutputs, SnapshotDelayedPiscoinOutput{ID: id, MaturityHeight: height, PiscoinOutput: dsco})
+		}
+	}
+
+	sort.Slice(ss.PiscoinOutputs, func(i, j int) bool {
+		return bytes.Compare(ss.PiscoinOutputs[i].ID[:], ss.PiscoinOutputs[j].ID[:]) < 0
+	})
+	sort.Slice(ss.PisfundOutputs, func(i, j int) bool {
+		return bytes.Compare(ss.PisfundOutputs[i].ID[:], ss.PisfundOutputs[j].ID[:]) < 0
+	})
+	sort.Slice(ss.FileContracts, func(i, j int) bool {
+		return bytes.Compare(ss.FileContracts[i].ID[:], ss.FileContracts[j].ID[:]) < 0
+	})
+	sort.Slice(ss.DelayedPiscoinOutputs, func(i, j int) bool {
+		a, b := ss.DelayedPiscoinOutputs[i], ss.DelayedPiscoinOutputs[j]
+		if a.MaturityHeight != b.MaturityHeight {
+			return a.MaturityHeight < b.MaturityHeight
+		}
+		return bytes.Compare(a.ID[:], b.ID[:]) < 0
+	})
+	return ss
+}
+
+// snapshotLeaf returns a leaf of the snapshot's Merkle tree.
+func snapshotLeaf(specifier byte, obj interface {
+	MarshalPis(io.Writer) error
+}) []byte {
+	buf := bytes.NewBuffer([]byte{specifier})
+	obj.MarshalPis(buf)
+	return buf.Bytes()
+}
+
+// MerkleRoot returns the Merkle root of the snapshot. The first leaf holds
+// the height, the block ID and the pisfund pool, followed by one leaf per
+// entry in the order of the snapshot. The entries must be sorted, as they are
+// by NewStateSnapshot.
+func (ss StateSnapshot) MerkleRoot() crypto.Hash {
+	tree := crypto.NewTree()
+	header := bytes.NewBuffer([]byte{snapshotLeafHeader})
+	ss.marshalHeader(encoding.NewEncoder(header))
+	tree.Push(header.Bytes())
+	for _, sco := range ss.PiscoinOutputs {
+		tree.Push(snapshotLeaf(snapshotLeafPiscoinOutput, sco))
+	}
+	for _, sfo := range ss.PisfundOutputs {
+		tree.Push(snapshotLeaf(snapshotLeafPisfundOutput, sfo))
+	}
+	for _, fc := range ss.FileContracts {
+		tree.Push(snapshotLeaf(snapshotLeafFileContract, fc))
+	}
+	for _, dsco := range ss.DelayedPiscoinOutputs {
+		tree.Push(snapshotLeaf(snapshotLeafDelayedPiscoinOutput, dsco))
+	}
+	return tree.Root()
+}
+
+// State returns the ConsensusState described by the snapshot.
+func (ss StateSnapshot) State() *ConsensusState {
+	s := NewConsensusState()
+	s.Height = ss.Height
+	s.PisfundPool = ss.PisfundPool
+	for _, sco := range ss.PiscoinOutputs {
+		s.PiscoinOutputs[sco.ID] = sco.PiscoinOutput
+	}
+	for _, sfo := range ss.PisfundOutputs {
+		s.PisfundOutputs[sfo.ID] = sfo.PisfundOutput
+	}
+	for _, fc := range ss.FileContracts {
+		s.FileContracts[fc.ID] = fc.FileContract
+	}
+	for _, dsco := range ss.DelayedPiscoinOutputs {
+		if s.DelayedPiscoinOutputs[dsco.MaturityHeight] == nil {
+			s.DelayedPiscoinOutputs[dsco.MaturityHeight] = make(map[types.PiscoinOutputID]types.PiscoinOutput)
+		}
+		s.DelayedPiscoinOutputs[dsco.MaturityHeight][dsco.ID] = dsco.PiscoinOutput
+	}
+	return s
+}
+
+// CheckInvariants checks the supply invariants of the snapshot, see
+// ConsensusState.CheckInvariants. Entries that are duplicated or out of order
+// are reported as well, since they would change the Merkle root.
+func (ss StateSnapshot) CheckInvariants() []string {
+	mismatches := ss.State().CheckInvariants()
+	if root := NewStateSnapshot(ss.State(), ss.BlockID).MerkleRoot(); root != ss.MerkleRoot() {
+		mismatches = append(mismatches, "snapshot entries are duplicated or not sorted")
+	}
+	return mismatches
+}
+
+// ProcessConsensusChange implements ConsensusSetSubscriber.
+func (sb *snapshotBuilder) ProcessConsensusChange(cc ConsensusChange) {
+	sb.state.ProcessConsensusChange(cc)
+	sb.path = sb.path[:len(sb.path)-len(cc.RevertedBlocks)-len(cc.RevertedPrunedHeaders)]
+	for _, b := range cc.AppliedBlocks {
+		sb.path = append(sb.path, b.ID())
+	}
+	if sb.state.Err() == nil && types.BlockHeight(len(sb.path)) == sb.height+1 {
+		id := sb.path[sb.height]
+		sb.snapshots[id] = NewStateSnapshot(sb.state, id)
+	}
+}
+
+// SnapshotAtHeight returns a snapshot of the state at the provided height of
+// the current path. The snapshot of the current height is taken from the
+// stored state; older snapshots are rebuilt by replaying every consensus
+// change, which is not possible on a pruned node.
+func SnapshotAtHeight(cs ConsensusSet, height types.BlockHeight) (StateSnapshot, error) {
+	// The light consensus set does not store the full state and returns
+	// ErrLightMode.
+	before := cs.CurrentBlock().ID()
+	stored, err := cs.StoredState()
+	if err != nil {
+		return StateSnapshot{}, err
+	}
+	if height > stored.Height {
+		return StateSnapshot{}, ErrUnknownBlock
+	}
+	if height == stored.Height && cs.CurrentBlock().ID() == before {
+		return NewStateSnapshot(stored, before), nil
+	}
+
+	sb := &snapshotBuilder{
+		height:    height,
+		state:     NewConsensusState(),
+		snapshots: make(map[types.BlockID]StateSnapshot),
+	}
+	err = cs.ConsensusSetSubscribe(sb, ConsensusChangeBeginning, nil)
+	cs.Unsubscribe(sb)
+	if err != nil {
+		return StateSnapshot{}, err
+	}
+	if err := sb.state.Err(); err != nil {
+		return StateSnapshot{}, err
+	}
+	if types.BlockHeight(len(sb.path)) <= height {
+		return StateSnapshot{}, ErrUnknownBlock
+	}
+	ss, exists := sb.snapshots[sb.path[height]]
+	if !exists {
+		return StateSnapshot{}, ErrSnapshotUnavailable
+	}
+	return ss, nil
+}
@@ -0,0 +1,111 @@
+package modules
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/wisherd/Pis/types"
+)
+
+// TestStateSnapshot checks that snapshots are deterministic, survive an
+// encoding round trip and that unsorted entries are detected.
+func TestStateSnapshot(t *testing.T) {
+	s := NewConsensusState()
+	s.Height = 4
+	for i := byte(1); i <= 5; i++ {
+		s.PiscoinOutputs[types.PiscoinOutputID{i}] = types.PiscoinOutput{Value: types.NewCurrency64(uint64(i))}
+	}
+	s.PisfundOutputs[types.PisfundOutputID{1}] = types.PisfundOutput{Value: types.PisfundCount}
+	s.FileContracts[types.FileContractID{1}] = types.FileContract{FileSize: 7}
+	s.DelayedPiscoinOutputs[6] = map[types.PiscoinOutputID]types.PiscoinOutput{{9}: {Value: types.NewCurrency64(9)}}
+	s.DelayedPiscoinOutputs[5] = map[types.PiscoinOutputID]types.PiscoinOutput{{8}: {Value: types.NewCurrency64(8)}}
+
+	ss := NewStateSnapshot(s, types.BlockID{1})
+	for i := 0; i < 10; i++ {
+		if NewStateSnapshot(s, types.BlockID{1}).MerkleRoot() != ss.MerkleRoot() {
+			t.Fatal("snapshot is not deterministic")
+		}
+	}
+	if ss.DelayedPiscoinOutputs[0].MaturityHeight != 5 {
+		t.Fatal("delayed outputs are not sorted by maturity height")
+	}
+	if diffs := ss.State().Compare(s); len(diffs) != 0 {
+		t.Fatal("snapshot does not describe the state:", diffs)
+	}
+
+	var buf bytes.Buffer
+	if err := ss.MarshalPis(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var decoded StateSnapshot
+	if err := decoded.UnmarshalPis(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.MerkleRoot() != ss.MerkleRoot() || decoded.Height != ss.Height || decoded.BlockID != ss.BlockID {
+		t.Fatal("snapshot changed during the encoding round trip")
+	}
+
+	// Changing an output or the block changes the root.
+	s.PiscoinOutputs[types.PiscoinOutputID{1}] = types.PiscoinOutput{Value: types.NewCurrency64(100)}
+	if NewStateSnapshot(s, types.BlockID{1}).MerkleRoot() == ss.MerkleRoot() {
+		t.Fatal("root did not change with the state")
+	}
+	if NewStateSnapshot(s, types.BlockID{2}).MerkleRoot() == NewStateSnapshot(s, types.BlockID{1}).MerkleRoot() {
+		t.Fatal("root did not change with the block")
+	}
+
+	// Unsorted entries are reported.
+	ss.PiscoinOutputs[0], ss.PiscoinOutputs[1] = ss.PiscoinOutputs[1], ss.PiscoinOutputs[0]
+	var found bool
+	for _, m := range ss.CheckInvariants() {
+		found = found || m == "snapshot entries are duplicated or not sorted"
+	}
+	if !found {
+		t.Fatal("unsorted entries were not detected")
+	}
+}
+
+// TestSnapshotBuilder checks that snapshots are taken at the requested height
+// while replaying consensus changes, including reorgs.
+func TestSnapshotBuilder(t *testing.T) {
+	sco := types.PiscoinOutput{Value: types.NewCurrency64(1)}
+	b0 := types.Block{}
+	b1 := types.Block{ParentID: b0.ID(), Timestamp: 1}
+	b1Fork := types.Block{ParentID: b0.ID(), Timestamp: 2}
+	b2Fork := types.Block{ParentID: b1Fork.ID()}
+
+	sb := &snapshotBuilder{
+		height:    1,
+		state:     NewConsensusState(),
+		snapshots: make(map[types.BlockID]StateSnapshot),
+	}
+	sb.ProcessConsensusChange(ConsensusChange{AppliedBlocks: []types.Block{b0}})
+	sb.ProcessConsensusChange(ConsensusChange{
+		AppliedBlocks:      []types.Block{b1},
+		PiscoinOutputDiffs: []PiscoinOutputDiff{{Direction: DiffApply, ID: types.PiscoinOutputID{1}, PiscoinOutput: sco}},
+	})
+	ss, exists := sb.snapshots[b1.ID()]
+	if !exists || len(ss.PiscoinOutputs) != 1 || ss.Height != 1 {
+		t.Fatal("snapshot of b1 was not taken:", ss)
+	}
+
+	// Reorg to a fork whose first block is applied together with its child.
+	// The state at b1Fork is never reached.
+	sb.ProcessConsensusChange(ConsensusChange{
+		RevertedBlocks: []types.Block{b1},
+		AppliedBlocks:  []types.Block{b1Fork, b2Fork},
+		PiscoinOutputDiffs: []PiscoinOutputDiff{
+			{Direction: DiffRevert, ID: types.PiscoinOutputID{1}, PiscoinOutput: sco},
+			{Direction: DiffApply, ID: types.PiscoinOutputID{2}, PiscoinOutput: sco},
+		},
+	})
+	if err := sb.state.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if len(sb.path) != 3 || sb.path[1] != b1Fork.ID() {
+		t.Fatal("path was not updated:", sb.path)
+	}
+	if _, exists := sb.snapshots[b1Fork.ID()]; exists {
+		t.Fatal("snapshot taken at a height that was never the tip")
+	}
+}
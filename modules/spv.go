@@ -0,0 +1,173 @@
+package modules
+
+import (
+	"errors"
+
+	"github.com/wisherd/Pis/build"
+	"github.com/wisherd/Pis/crypto"
+	"github.com/wisherd/Pis/encoding"
+	"github.com/wisherd/Pis/types"
+)
+
+const (
+	// LightConsensusDir is the name of the directory used by the light
+	// consensus set.
+	LightConsensusDir = "lightconsensus"
+
+	// SendProvenBlocksRPC is the name of the RPC used by light clients to
+	// request the transactions of a set of blocks that involve a set of
+	// unlock hashes, along with Merkle proofs that tie them to the block
+	// headers. The RPC is only available on peers that advertise
+	// FeatureProvenBlocks.
+	SendProvenBlocksRPC = "SendProvenBlocks"
+)
+
+var (
+	// ErrInvalidProof is returned when a light client receives a transaction
+	// or miner payout whose Merkle proof does not match the block header.
+	ErrInvalidProof = errors.New("Merkle proof does not match the block header")
+
+	// ErrLightMode is returned by the parts of the ConsensusSet interface
+	// that require full blocks or the full consensus state, which are not
+	// available in light mode.
+	ErrLightMode = errors.New("not available in light mode")
+
+	// MaxProvenBlockAddresses is the maximum number of unlock hashes that a
+	// light client may include in a single SendProvenBlocks call.
+	MaxProvenBlockAddresses = build.Select(build.Var{
+		Standard: int(10e3),
+		Dev:      int(1e3),
+		Testing:  int(100),
+	}).(int)
+)
+
+type (
+	// A ProvenBlocksRequest asks a full peer for the transactions and miner
+	// payouts of the provided blocks that involve any of the unlock hashes.
+	ProvenBlocksRequest struct {
+		IDs          []types.BlockID    `json:"ids"`
+		UnlockHashes []types.UnlockHash `json:"unlockhashes"`
+	}
+
+	// A ProvenTransaction is a transaction along with a proof that it is part
+	// of a block.
+	ProvenTransaction struct {
+		Transaction types.Transaction          `json:"transaction"`
+		Proof       types.MerkleInclusionProof `json:"proof"`
+	}
+
+	// A ProvenMinerPayout is a miner payout along with its index in the block
+	// and a proof that it is part of the block.
+	ProvenMinerPayout struct {
+		Index  uint64                     `json:"index"`
+		Output types.PiscoinOutput        `json:"output"`
+		Proof  types.MerkleInclusionProof `json:"proof"`
+	}
+
+	// A ProvenBlock contains the parts of a block that are relevant to a
+	// light client. Everything in it can be checked against the header of
+	// the block without downloading the rest of the block.
+	ProvenBlock struct {
+		ID           types.BlockID       `json:"id"`
+		MinerPayouts []ProvenMinerPayout `json:"minerpayouts"`
+		Transactions []ProvenTransaction `json:"transactions"`
+	}
+)
+
+// NewProvenBlock returns the miner payouts and transactions of b that match
+// the filter, each with a proof of inclusion.
+func NewProvenBlock(b types.Block, f ConsensusChangeFilter) ProvenBlock {
+	pb := ProvenBlock{ID: b.ID()}
+	for i, sco := range b.MinerPayouts {
+		if !f.hasPiscoinOutput(b.MinerPayoutID(uint64(i)), sco) {
+			continue
+		}
+		proof, err := b.MinerPayoutProof(uint64(i))
+		if err != nil {
+			build.Critical("unable to prove miner payout:", err)
+		}
+		pb.MinerPayouts = append(pb.MinerPayouts, ProvenMinerPayout{
+			Index:  uint64(i),
+			Output: sco,
+			Proof:  proof,
+		})
+	}
+	for _, txn := range b.Transactions {
+		if !f.MatchTransaction(txn) {
+			continue
+		}
+		proof, err := b.TransactionProof(txn.ID())
+		if err != nil {
+			build.Critical("unable to prove transaction:", err)
+		}
+		pb.Transactions = append(pb.Transactions, ProvenTransaction{
+			Transaction: txn,
+			Proof:       proof,
+		})
+	}
+	return pb
+}
+
+// Verify checks every proof in the block against h. A full peer could still
+// withhold matching transactions, but it cannot make up transactions that are
+// not part of the chain.
+func (pb ProvenBlock) Verify(h types.BlockHeader) error {
+	if pb.ID != h.ID() {
+		return ErrInvalidProof
+	}
+	for _, mp := range pb.MinerPayouts {
+		if mp.Proof.LeafIndex != mp.Index || !types.VerifyMinerPayoutProof(mp.Output, h, mp.Proof) {
+			return ErrInvalidProof
+		}
+	}
+	for _, pt := range pb.Transactions {
+		if !types.VerifyTransactionProof(pt.Transaction, h, pt.Proof) {
+			return ErrInvalidProof
+		}
+	}
+	return nil
+}
+
+// ServeProvenBlocks handles a call to SendProvenBlocksRPC. The lookup
+// function returns the full block with the requested ID from the current
+// path.
+func ServeProvenBlocks(conn PeerConn, lookup func(types.BlockID) (types.Block, bool)) error {
+	var req ProvenBlocksRequest
+	maxLen := uint64(MaxBlockBodiesPerRequest+MaxProvenBlockAddresses)*crypto.HashSize + 16
+	if err := encoding.ReadObject(conn, &req, maxLen); err != nil {
+		return err
+	}
+	if len(req.IDs) > MaxBlockBodiesPerRequest || len(req.UnlockHashes) > MaxProvenBlockAddresses {
+		return errors.New("too many blocks or addresses requested")
+	}
+	f := NewConsensusChangeFilter(req.UnlockHashes...)
+	pbs := make([]ProvenBlock, 0, len(req.IDs))
+	for _, id := range req.IDs {
+		b, exists := lookup(id)
+		if !exists {
+			return ErrUnknownBlock
+		}
+		pbs = append(pbs, NewProvenBlock(b, f))
+	}
+	return encoding.WriteObject(conn, pbs)
+}
+
+// RequestProvenBlocks calls SendProvenBlocksRPC on conn. The caller is
+// responsible for verifying the returned blocks against their headers.
+func RequestProvenBlocks(conn PeerConn, req ProvenBlocksRequest) ([]ProvenBlock, error) {
+	if len(req.IDs) > MaxBlockBodiesPerRequest || len(req.UnlockHashes) > MaxProvenBlockAddresses {
+		return nil, errors.New("too many blocks or addresses requested")
+	}
+	if err := encoding.WriteObject(conn, req); err != nil {
+		return nil, err
+	}
+	var pbs []ProvenBlock
+	maxLen := uint64(len(req.IDs)) * types.BlockSizeLimit
+	if err := encoding.ReadObject(conn, &pbs, maxLen+8); err != nil {
+		return nil, err
+	}
+	if len(pbs) != len(req.IDs) {
+		return nil, errors.New("peer sent the wrong number of blocks")
+	}
+	return pbs, nil
+}
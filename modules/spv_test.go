@@ -0,0 +1,66 @@
+package modules
+
+import (
+	"testing"
+
+	"github.com/wisherd/Pis/types"
+)
+
+// TestProvenBlock checks that a proven block contains the matching payouts
+// and transactions and that tampering is detected by Verify.
+func TestProvenBlock(t *testing.T) {
+	ours := types.UnlockHash{1}
+	theirs := types.UnlockHash{2}
+	incoming := types.Transaction{
+		PiscoinOutputs: []types.PiscoinOutput{{UnlockHash: ours, Value: types.NewCurrency64(1)}},
+	}
+	unrelated := types.Transaction{
+		PiscoinOutputs: []types.PiscoinOutput{{UnlockHash: theirs, Value: types.NewCurrency64(2)}},
+	}
+	b := types.Block{
+		MinerPayouts: []types.PiscoinOutput{
+			{UnlockHash: theirs, Value: types.NewCurrency64(3)},
+			{UnlockHash: ours, Value: types.NewCurrency64(4)},
+		},
+		Transactions: []types.Transaction{unrelated, incoming},
+	}
+
+	pb := NewProvenBlock(b, NewConsensusChangeFilter(ours))
+	if len(pb.MinerPayouts) != 1 || pb.MinerPayouts[0].Index != 1 {
+		t.Fatal("wrong miner payouts:", pb.MinerPayouts)
+	}
+	if len(pb.Transactions) != 1 || pb.Transactions[0].Transaction.ID() != incoming.ID() {
+		t.Fatal("wrong transactions:", pb.Transactions)
+	}
+	if err := pb.Verify(b.Header()); err != nil {
+		t.Fatal(err)
+	}
+
+	// A different header must be rejected.
+	other := b.Header()
+	other.Nonce[0]++
+	if err := pb.Verify(other); err != ErrInvalidProof {
+		t.Fatal("expected ErrInvalidProof, got", err)
+	}
+
+	// A modified payout must be rejected.
+	tampered := NewProvenBlock(b, NewConsensusChangeFilter(ours))
+	tampered.MinerPayouts[0].Output.Value = types.NewCurrency64(400)
+	if err := tampered.Verify(b.Header()); err != ErrInvalidProof {
+		t.Fatal("expected ErrInvalidProof, got", err)
+	}
+
+	// A payout that claims a different index must be rejected.
+	tampered = NewProvenBlock(b, NewConsensusChangeFilter(ours))
+	tampered.MinerPayouts[0].Index = 0
+	if err := tampered.Verify(b.Header()); err != ErrInvalidProof {
+		t.Fatal("expected ErrInvalidProof, got", err)
+	}
+
+	// A transaction that is not part of the block must be rejected.
+	tampered = NewProvenBlock(b, NewConsensusChangeFilter(ours))
+	tampered.Transactions[0].Transaction = unrelated
+	if err := tampered.Verify(b.Header()); err != ErrInvalidProof {
+		t.Fatal("expected ErrInvalidProof, got", err)
+	}
+}
@@ -45,6 +45,10 @@ var (
 	// ErrWalletShutdown is returned when a method can't continue execution due
 	// to the wallet shutting down.
 	ErrWalletShutdown = errors.New("wallet is shutting down")
+
+	// ErrWatchAddressSpendable is returned when adding a watch-only address
+	// that the wallet already holds the keys for.
+	ErrWatchAddressSpendable = errors.New("address is already spendable by the wallet")
 )
 
 type (
@@ -58,11 +62,17 @@ type (
 	// A ProcessedInput represents funding to a transaction. The input is
 	// coming from an address and going to the outputs. The fund types are
 	// 'PiscoinInput', 'PisfundInput'.
+	//
+	// WatchOnly is set if RelatedAddress is a watch-only address of the
+	// wallet. WalletAddress is only set for addresses the wallet can spend
+	// from. Label is the label of RelatedAddress, if any.
 	ProcessedInput struct {
 		ParentID       types.OutputID   `json:"parentid"`
 		FundType       types.Specifier  `json:"fundtype"`
 		WalletAddress  bool             `json:"walletaddress"`
+		WatchOnly      bool             `json:"watchonly"`
 		RelatedAddress types.UnlockHash `json:"relatedaddress"`
+		Label          string           `json:"label,omitempty"`
 		Value          types.Currency   `json:"value"`
 	}
 
@@ -78,12 +88,18 @@ type (
 	// MaturityHeight indicates at what block height the output becomes
 	// available. PiscoinInputs and PisfundInputs become available immediately.
 	// ClaimInputs and MinerPayouts become available after 144 confirmations.
+	//
+	// WalletAddress, WatchOnly and Label have the same meaning as for a
+	// ProcessedInput. Note is the note of the output, if any.
 	ProcessedOutput struct {
 		ID             types.OutputID    `json:"id"`
 		FundType       types.Specifier   `json:"fundtype"`
 		MaturityHeight types.BlockHeight `json:"maturityheight"`
 		WalletAddress  bool              `json:"walletaddress"`
+		WatchOnly      bool              `json:"watchonly"`
 		RelatedAddress types.UnlockHash  `json:"relatedaddress"`
+		Label          string            `json:"label,omitempty"`
+		Note           string            `json:"note,omitempty"`
 		Value          types.Currency    `json:"value"`
 	}
 
@@ -94,16 +110,28 @@ type (
 	// Because of the block subsidy, a block is considered as a transaction.
 	// Since there is technically no transaction id for the block subsidy, the
 	// block id is used instead.
+	//
+	// Note is the note of the transaction, if any.
 	ProcessedTransaction struct {
 		Transaction           types.Transaction   `json:"transaction"`
 		TransactionID         types.TransactionID `json:"transactionid"`
 		ConfirmationHeight    types.BlockHeight   `json:"confirmationheight"`
 		ConfirmationTimestamp types.Timestamp     `json:"confirmationtimestamp"`
+		Note                  string              `json:"note,omitempty"`
 
 		Inputs  []ProcessedInput  `json:"inputs"`
 		Outputs []ProcessedOutput `json:"outputs"`
 	}
 
+	// A WatchAddress is an address that the wallet tracks without holding its
+	// keys. UnlockConditions is only set if the address was added with its
+	// unlock conditions, and only such addresses can fund partially signed
+	// transactions.
+	WatchAddress struct {
+		UnlockHash       types.UnlockHash       `json:"unlockhash"`
+		UnlockConditions types.UnlockConditions `json:"unlockconditions"`
+	}
+
 	// TransactionBuilder is used to construct custom transactions. A transaction
 	// builder is initialized via 'RegisterTransaction' and then can be modified by
 	// adding funds or other fields. The transaction is completed by calling
@@ -121,9 +149,18 @@ type (
 		// 'Sign' is called on the transaction builder. The expectation is that
 		// the transaction will be completed and broadcast within a few hours.
 		// Longer risks double-spends, as the wallet will assume that the
-		// transaction failed.
+		// transaction failed. Outputs are chosen largest-first; outputs that
+		// are locked or used by another transaction builder are never
+		// chosen, and the chosen outputs stay locked until the builder is
+		// dropped.
 		FundPiscoins(amount types.Currency) error
 
+		// FundPiscoinsWithSelection is like FundPiscoins, but the spent
+		// outputs are chosen according to cs. If cs lists outputs
+		// explicitly, their total value may exceed amount and the difference
+		// is returned to the wallet as change.
+		FundPiscoinsWithSelection(amount types.Currency, cs CoinSelection) error
+
 		// FundPisfunds will add a siafund input of exactly 'amount' to the
 		// transaction. A parent transaction may be needed to achieve an input
 		// with the correct value. The siafund input will not be signed until
@@ -261,13 +298,29 @@ type (
 		// called.
 		//
 		// All items in the wallet are encrypted using different keys which are
-		// derived from the master key.
+		// derived from the master key. Items that are still encrypted in the
+		// legacy Twofish format are re-encrypted in the current
+		// crypto.Ciphertext format the next time they are saved.
 		Unlock(masterKey crypto.TwofishKey) error
 
 		// ChangeKey changes the wallet's materKey from masterKey to newKey,
-		// re-encrypting the wallet with the provided key.
+		// re-encrypting the wallet with the provided key. Any stored key
+		// derivation parameters are removed, as newKey is not derived with
+		// them.
 		ChangeKey(masterKey crypto.TwofishKey, newKey crypto.TwofishKey) error
 
+		// ChangeKeyWithKDF is like ChangeKey, but newKey was derived from a
+		// password with params, and params are stored with the wallet. The
+		// parameters are not secret and are stored unencrypted, as they are
+		// needed to derive the key before the wallet can be unlocked.
+		ChangeKeyWithKDF(masterKey crypto.TwofishKey, newKey crypto.TwofishKey, params crypto.KDFParams) error
+
+		// KDFParams returns the parameters with which the master key of the
+		// wallet was derived from a password. The bool is false if no
+		// parameters are stored, in which case a password key is derived
+		// with LegacyPasswordKey.
+		KDFParams() (crypto.KDFParams, bool, error)
+
 		// Unlocked returns true if the wallet is currently unlocked, false
 		// otherwise.
 		Unlocked() (bool, error)
@@ -277,6 +330,22 @@ type (
 	// loading backups, and providing a layer of compatibility for older wallet
 	// files.
 	KeyManager interface {
+		// AddWatchAddresses adds addresses whose keys are held elsewhere, for
+		// example the addresses of a seed that is kept on an offline machine.
+		// The wallet tracks their outputs and uses them to fund partially
+		// signed transactions, but it cannot sign for them. Watch-only
+		// addresses are persisted and survive rescans; adding an address
+		// that is not yet known triggers a rescan of the blockchain.
+		// Addresses that the wallet can spend from are refused with
+		// ErrWatchAddressSpendable.
+		AddWatchAddresses([]types.UnlockConditions) error
+
+		// AddWatchUnlockHashes adds watch-only addresses whose unlock
+		// conditions are unknown. Their transactions and balance are tracked
+		// like those of AddWatchAddresses, but their outputs cannot be used
+		// to fund transactions.
+		AddWatchUnlockHashes([]types.UnlockHash) error
+
 		// AllAddresses returns all addresses that the wallet is able to spend
 		// from, including unseeded addresses. Addresses are returned sorted in
 		// byte-order.
@@ -289,7 +358,8 @@ type (
 		AllSeeds() ([]Seed, error)
 
 		// CreateBackup will create a backup of the wallet at the provided
-		// filepath. The backup will have all seeds and keys.
+		// filepath. The backup will have all seeds and keys, the watch-only
+		// addresses, and the address labels and transaction notes.
 		CreateBackup(string) error
 
 		// LoadBackup will load a backup of the wallet from the provided
@@ -325,6 +395,10 @@ type (
 		// generated from the seed.
 		PrimarySeed() (Seed, uint64, error)
 
+		// WatchAddresses returns the watch-only addresses of the wallet,
+		// sorted in byte-order.
+		WatchAddresses() ([]WatchAddress, error)
+
 		// SweepSeed scans the blockchain for outputs generated from seed and
 		// creates a transaction that transfers them to the wallet. Note that
 		// this incurs a transaction fee. It returns the total value of the
@@ -337,17 +411,26 @@ type (
 	// encrypted using a user-specified password. Common addresses are all
 	// derived from a single address seed.
 	Wallet interface {
+		CoinController
 		EncryptionManager
 		KeyManager
+		LabelManager
+		MultisigManager
 
 		// Close permits clean shutdown during testing and serving.
 		Close() error
 
 		// ConfirmedBalance returns the confirmed balance of the wallet, minus
 		// any outgoing transactions. ConfirmedBalance will include unconfirmed
-		// refund transactions.
+		// refund transactions. Only funds the wallet can spend are included;
+		// the balance of watch-only addresses is reported by
+		// WatchOnlyBalance.
 		ConfirmedBalance() (siacoinBalance types.Currency, siafundBalance types.Currency, siacoinClaimBalance types.Currency, err error)
 
+		// WatchOnlyBalance returns the confirmed balance of the watch-only
+		// addresses of the wallet, computed like ConfirmedBalance.
+		WatchOnlyBalance() (siacoinBalance types.Currency, siafundBalance types.Currency, siacoinClaimBalance types.Currency, err error)
+
 		// UnconfirmedBalance returns the unconfirmed balance of the wallet.
 		// Outgoing funds and incoming funds are reported separately. Refund
 		// outputs are included, meaning that sending a single coin to
@@ -359,7 +442,7 @@ type (
 		Height() (types.BlockHeight, error)
 
 		// AddressTransactions returns all of the transactions that are related
-		// to a given address.
+		// to a given address. Watch-only addresses are supported.
 		AddressTransactions(types.UnlockHash) ([]ProcessedTransaction, error)
 
 		// AddressUnconfirmedHistory returns all of the unconfirmed
@@ -377,13 +460,25 @@ type (
 		Transactions(startHeight types.BlockHeight, endHeight types.BlockHeight) ([]ProcessedTransaction, error)
 
 		// UnconfirmedTransactions returns all unconfirmed transactions
-		// relative to the wallet.
+		// relative to the wallet, including those that only involve
+		// watch-only addresses.
 		UnconfirmedTransactions() ([]ProcessedTransaction, error)
 
 		// RegisterTransaction takes a transaction and its parents and returns
 		// a TransactionBuilder which can be used to expand the transaction.
 		RegisterTransaction(t types.Transaction, parents []types.Transaction) (TransactionBuilder, error)
 
+		// CreatePartialTransaction funds a transaction that sends outputs and
+		// pays fee, and returns it unsigned as a partially signed transaction,
+		// so that it can be signed on another machine. The spent outputs are
+		// chosen according to cs and reserved as with a TransactionBuilder.
+		CreatePartialTransaction(outputs []types.PiscoinOutput, fee types.Currency, cs CoinSelection) (PartiallySignedTransaction, error)
+
+		// SignPartialTransaction signs every input of pst whose
+		// UnlockConditions contain a key of the wallet and that still needs
+		// signatures.
+		SignPartialTransaction(pst PartiallySignedTransaction) (PartiallySignedTransaction, error)
+
 		// Rescanning reports whether the wallet is currently rescanning the
 		// blockchain.
 		Rescanning() (bool, error)
@@ -430,6 +525,21 @@ func CalculateWalletTransactionID(tid types.TransactionID, oid types.OutputID) W
 	return WalletTransactionID(crypto.HashAll(tid, oid))
 }
 
+// MarshalJSON marshals an id as a hex string.
+func (id WalletTransactionID) MarshalJSON() ([]byte, error) {
+	return crypto.Hash(id).MarshalJSON()
+}
+
+// String prints the id in hex.
+func (id WalletTransactionID) String() string {
+	return crypto.Hash(id).String()
+}
+
+// UnmarshalJSON decodes the json hex string of the id.
+func (id *WalletTransactionID) UnmarshalJSON(b []byte) error {
+	return (*crypto.Hash)(id).UnmarshalJSON(b)
+}
+
 // SeedToString converts a wallet seed to a human friendly string.
 func SeedToString(seed Seed, did mnemonics.DictionaryID) (string, error) {
 	fullChecksum := crypto.HashObject(seed)
@@ -0,0 +1,76 @@
+package modules
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/wisherd/Pis/types"
+)
+
+// An OutputBalance is the total value of a set of piscoin and pisfund
+// outputs.
+type OutputBalance struct {
+	Piscoins types.Currency `json:"piscoins"`
+	Pisfunds types.Currency `json:"pisfunds"`
+}
+
+// CheckWatchAddresses returns ErrWatchAddressSpendable if the wallet can
+// spend from any of the addresses, as reported by spendable. Wallets call it
+// before adding watch-only addresses.
+func CheckWatchAddresses(uhs []types.UnlockHash, spendable func(types.UnlockHash) bool) error {
+	for _, uh := range uhs {
+		if spendable(uh) {
+			return ErrWatchAddressSpendable
+		}
+	}
+	return nil
+}
+
+// MergeWatchAddresses adds the addresses in added to the watch-only addresses
+// in existing and returns the result sorted in byte-order. An address that is
+// added with its unlock conditions replaces one that was added without them.
+// rescan is set if any of the addresses was not yet known, in which case the
+// blockchain has to be rescanned for its outputs. Wallets persist the result
+// separately from their seeds so that it survives rescans.
+func MergeWatchAddresses(existing, added []WatchAddress) (merged []WatchAddress, rescan bool) {
+	index := make(map[types.UnlockHash]int)
+	for _, wa := range existing {
+		index[wa.UnlockHash] = len(merged)
+		merged = append(merged, wa)
+	}
+	for _, wa := range added {
+		i, exists := index[wa.UnlockHash]
+		if !exists {
+			index[wa.UnlockHash] = len(merged)
+			merged = append(merged, wa)
+			rescan = true
+		} else if len(merged[i].UnlockConditions.PublicKeys) == 0 {
+			merged[i] = wa
+		}
+	}
+	sort.Slice(merged, func(i, j int) bool {
+		return bytes.Compare(merged[i].UnlockHash[:], merged[j].UnlockHash[:]) < 0
+	})
+	return merged, rescan
+}
+
+// SplitWatchOnlyBalance returns the balance of the outputs that the wallet
+// can spend and the balance of its watch-only outputs. Locked and immature
+// outputs are included in the balances.
+func SplitWatchOnlyBalance(outputs []UnspentOutput) (spendable, watchOnly OutputBalance) {
+	spendable = OutputBalance{Piscoins: types.ZeroCurrency, Pisfunds: types.ZeroCurrency}
+	watchOnly = spendable
+	for _, uo := range outputs {
+		balance := &spendable
+		if uo.WatchOnly {
+			balance = &watchOnly
+		}
+		switch uo.FundType {
+		case types.SpecifierPiscoinOutput:
+			balance.Piscoins = balance.Piscoins.Add(uo.Value)
+		case types.SpecifierPisfundOutput:
+			balance.Pisfunds = balance.Pisfunds.Add(uo.Value)
+		}
+	}
+	return spendable, watchOnly
+}
@@ -0,0 +1,81 @@
+package modules
+
+import (
+	"testing"
+
+	"github.com/wisherd/Pis/types"
+)
+
+// TestCheckWatchAddresses checks that addresses the wallet can spend from are
+// refused as watch-only addresses.
+func TestCheckWatchAddresses(t *testing.T) {
+	ours := types.UnlockHash{1}
+	spendable := func(uh types.UnlockHash) bool { return uh == ours }
+	if err := CheckWatchAddresses([]types.UnlockHash{{2}, {3}}, spendable); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckWatchAddresses([]types.UnlockHash{{2}, ours}, spendable); err != ErrWatchAddressSpendable {
+		t.Fatal("expected ErrWatchAddressSpendable, got", err)
+	}
+}
+
+// TestMergeWatchAddresses checks that known addresses are kept, that only new
+// addresses require a rescan and that unlock conditions are filled in.
+func TestMergeWatchAddresses(t *testing.T) {
+	uc := types.UnlockConditions{
+		PublicKeys:         []types.PisPublicKey{{Algorithm: types.SignatureEd25519, Key: make([]byte, 32)}},
+		SignaturesRequired: 1,
+	}
+	withConditions := WatchAddress{UnlockHash: uc.UnlockHash(), UnlockConditions: uc}
+	bare := WatchAddress{UnlockHash: uc.UnlockHash()}
+	other := WatchAddress{UnlockHash: types.UnlockHash{1}}
+
+	merged, rescan := MergeWatchAddresses(nil, []WatchAddress{bare, other})
+	if !rescan || len(merged) != 2 {
+		t.Fatal("new addresses were not added:", merged, rescan)
+	}
+	for i := 1; i < len(merged); i++ {
+		if string(merged[i-1].UnlockHash[:]) >= string(merged[i].UnlockHash[:]) {
+			t.Fatal("addresses are not sorted:", merged)
+		}
+	}
+
+	// Adding a known address again does not require a rescan, but fills in
+	// its unlock conditions.
+	merged, rescan = MergeWatchAddresses(merged, []WatchAddress{withConditions})
+	if rescan || len(merged) != 2 {
+		t.Fatal("known address triggered a rescan:", merged, rescan)
+	}
+	for _, wa := range merged {
+		if wa.UnlockHash == uc.UnlockHash() && len(wa.UnlockConditions.PublicKeys) != 1 {
+			t.Fatal("unlock conditions were not filled in")
+		}
+	}
+
+	// A bare address does not replace known unlock conditions.
+	merged, _ = MergeWatchAddresses(merged, []WatchAddress{bare})
+	for _, wa := range merged {
+		if wa.UnlockHash == uc.UnlockHash() && len(wa.UnlockConditions.PublicKeys) != 1 {
+			t.Fatal("unlock conditions were replaced by a bare address")
+		}
+	}
+}
+
+// TestSplitWatchOnlyBalance checks that watch-only outputs are counted
+// separately from the outputs the wallet can spend.
+func TestSplitWatchOnlyBalance(t *testing.T) {
+	outputs := []UnspentOutput{
+		{FundType: types.SpecifierPiscoinOutput, Value: types.NewCurrency64(5)},
+		{FundType: types.SpecifierPiscoinOutput, Value: types.NewCurrency64(7), Locked: true},
+		{FundType: types.SpecifierPisfundOutput, Value: types.NewCurrency64(2)},
+		{FundType: types.SpecifierPiscoinOutput, Value: types.NewCurrency64(100), WatchOnly: true},
+		{FundType: types.SpecifierPisfundOutput, Value: types.NewCurrency64(30), WatchOnly: true},
+	}
+	spendable, watchOnly := SplitWatchOnlyBalance(outputs)
+	if !spendable.Piscoins.Equals64(12) || !spendable.Pisfunds.Equals64(2) {
+		t.Error("wrong spendable balance:", spendable)
+	}
+	if !watchOnly.Piscoins.Equals64(100) || !watchOnly.Pisfunds.Equals64(30) {
+		t.Error("wrong watch-only balance:", watchOnly)
+	}
+}
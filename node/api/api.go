@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"net/http"
 	"strings"
+	"sync"
 
 	"github.com/wisherd/Pis/modules"
 	"github.com/wisherd/Pis/build"
@@ -95,6 +96,11 @@ type API struct {
 	tpool    modules.TransactionPool
 	wallet   modules.Wallet
 
+	// tipSnapshot caches the state snapshot at the current block until the
+	// current block changes.
+	tipSnapshot   modules.StateSnapshot
+	tipSnapshotMu sync.Mutex
+
 	router http.Handler
 }
 
@@ -0,0 +1,206 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/wisherd/Pis/crypto"
+	"github.com/wisherd/Pis/modules"
+	"github.com/wisherd/Pis/types"
+)
+
+type (
+	// ConsensusProofGET contains the fields returned by a GET call to
+	// "/consensus/proof/:id". The proof can be checked against the header
+	// with types.VerifyTransactionProof or types.VerifyMinerPayoutProof.
+	ConsensusProofGET struct {
+		Header types.BlockHeader          `json:"header"`
+		Height types.BlockHeight          `json:"height"`
+		Proof  types.MerkleInclusionProof `json:"proof"`
+	}
+
+	// ConsensusForksGET contains the fields returned by a GET call to
+	// "/consensus/forks". The first entry is the current path.
+	ConsensusForksGET struct {
+		Forks []modules.ForkInfo `json:"forks"`
+	}
+
+	// ConsensusReorgsGET contains the fields returned by a GET call to
+	// "/consensus/reorgs".
+	ConsensusReorgsGET struct {
+		Reorgs []modules.ReorgEvent `json:"reorgs"`
+	}
+
+	// ConsensusSnapshotGET contains the fields returned by a GET call to
+	// "/consensus/snapshot". The full snapshot can be downloaded from
+	// "/consensus/snapshot/export".
+	ConsensusSnapshotGET struct {
+		Height                types.BlockHeight `json:"height"`
+		BlockID               types.BlockID     `json:"blockid"`
+		MerkleRoot            crypto.Hash       `json:"merkleroot"`
+		PiscoinOutputs        int               `json:"piscoinoutputs"`
+		PisfundOutputs        int               `json:"pisfundoutputs"`
+		FileContracts         int               `json:"filecontracts"`
+		DelayedPiscoinOutputs int               `json:"delayedpiscoinoutputs"`
+		Mismatches            []string          `json:"mismatches"`
+	}
+)
+
+// consensusSyncHandlerGET handles the API call asking for the progress of the
+// blockchain synchronization.
+func (api *API) consensusSyncHandlerGET(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	WriteJSON(w, api.cs.SyncProgress())
+}
+
+// consensusForksHandlerGET handles the API call asking for the current path
+// and the side chains that compete with it.
+func (api *API) consensusForksHandlerGET(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	WriteJSON(w, ConsensusForksGET{
+		Forks: api.cs.Forks(),
+	})
+}
+
+// consensusReorgsHandlerGET handles the API call asking for the log of recent
+// reorgs.
+func (api *API) consensusReorgsHandlerGET(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	WriteJSON(w, ConsensusReorgsGET{
+		Reorgs: api.cs.Reorgs(),
+	})
+}
+
+// consensusCheckHandlerGET handles the API call that performs a light
+// consistency check of the stored consensus state. The full check, which
+// replays every block, is only available offline through 'pisd consensus
+// check'.
+func (api *API) consensusCheckHandlerGET(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	report, err := modules.CheckConsensusConsistency(api.cs, false, false)
+	if err != nil {
+		WriteError(w, Error{"consistency check failed: " + err.Error()}, http.StatusInternalServerError)
+		return
+	}
+	WriteJSON(w, report)
+}
+
+// consensusProofHandlerGET handles the API call asking for a Merkle proof that
+// a transaction, selected with the txid parameter, or a miner payout,
+// selected with the payout parameter, is part of a block.
+func (api *API) consensusProofHandlerGET(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	var bid types.BlockID
+	if err := bid.LoadString(ps.ByName("id")); err != nil {
+		WriteError(w, Error{"unable to parse block id: " + err.Error()}, http.StatusBadRequest)
+		return
+	}
+	header, height, exists := api.cs.BlockHeaderByID(bid)
+	if !exists {
+		WriteError(w, Error{modules.ErrUnknownBlock.Error()}, http.StatusBadRequest)
+		return
+	}
+
+	var proof types.MerkleInclusionProof
+	var err error
+	if txidStr := req.FormValue("txid"); txidStr != "" {
+		var txid types.TransactionID
+		if err := (*crypto.Hash)(&txid).LoadString(txidStr); err != nil {
+			WriteError(w, Error{"unable to parse txid: " + err.Error()}, http.StatusBadRequest)
+			return
+		}
+		proof, err = api.cs.TransactionProof(bid, txid)
+	} else if payoutStr := req.FormValue("payout"); payoutStr != "" {
+		index, parseErr := strconv.ParseUint(payoutStr, 10, 64)
+		if parseErr != nil {
+			WriteError(w, Error{"unable to parse payout: " + parseErr.Error()}, http.StatusBadRequest)
+			return
+		}
+		proof, err = api.cs.MinerPayoutProof(bid, index)
+	} else {
+		WriteError(w, Error{"either txid or payout must be provided"}, http.StatusBadRequest)
+		return
+	}
+	if err == modules.ErrUnknownBlock || err == modules.ErrNotInBlock || err == modules.ErrBlockPruned || err == modules.ErrLightMode {
+		WriteError(w, Error{"unable to create proof: " + err.Error()}, http.StatusBadRequest)
+		return
+	} else if err != nil {
+		WriteError(w, Error{"unable to create proof: " + err.Error()}, http.StatusInternalServerError)
+		return
+	}
+	WriteJSON(w, ConsensusProofGET{
+		Header: header,
+		Height: height,
+		Proof:  proof,
+	})
+}
+
+// snapshotAtRequestedHeight returns the snapshot at the height given by the
+// height parameter, or at the current height if the parameter is missing.
+func (api *API) snapshotAtRequestedHeight(req *http.Request) (modules.StateSnapshot, error) {
+	height := api.cs.Height()
+	if heightStr := req.FormValue("height"); heightStr != "" {
+		h, err := strconv.ParseUint(heightStr, 10, 64)
+		if err != nil {
+			return modules.StateSnapshot{}, errors.New("unable to parse height: " + err.Error())
+		}
+		if types.BlockHeight(h) != height {
+			return modules.SnapshotAtHeight(api.cs, types.BlockHeight(h))
+		}
+	}
+	return api.managedTipSnapshot(height)
+}
+
+// managedTipSnapshot returns the snapshot at the current block, which is at
+// the provided height. The snapshot is cached until the current block
+// changes, and concurrent calls wait for the same snapshot instead of each
+// building their own.
+func (api *API) managedTipSnapshot(height types.BlockHeight) (modules.StateSnapshot, error) {
+	api.tipSnapshotMu.Lock()
+	defer api.tipSnapshotMu.Unlock()
+	tip := api.cs.CurrentBlock().ID()
+	if api.tipSnapshot.BlockID == tip && api.tipSnapshot.Height == height {
+		return api.tipSnapshot, nil
+	}
+	ss, err := modules.SnapshotAtHeight(api.cs, height)
+	if err != nil {
+		return modules.StateSnapshot{}, err
+	}
+	if ss.BlockID == tip {
+		api.tipSnapshot = ss
+	}
+	return ss, nil
+}
+
+// consensusSnapshotHandlerGET handles the API call asking for the Merkle root
+// of the state at a height, along with the result of the supply checks.
+func (api *API) consensusSnapshotHandlerGET(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	ss, err := api.snapshotAtRequestedHeight(req)
+	if err != nil {
+		WriteError(w, Error{"unable to create snapshot: " + err.Error()}, http.StatusBadRequest)
+		return
+	}
+	mismatches := ss.CheckInvariants()
+	if mismatches == nil {
+		mismatches = make([]string, 0)
+	}
+	WriteJSON(w, ConsensusSnapshotGET{
+		Height:                ss.Height,
+		BlockID:               ss.BlockID,
+		MerkleRoot:            ss.MerkleRoot(),
+		PiscoinOutputs:        len(ss.PiscoinOutputs),
+		PisfundOutputs:        len(ss.PisfundOutputs),
+		FileContracts:         len(ss.FileContracts),
+		DelayedPiscoinOutputs: len(ss.DelayedPiscoinOutputs),
+		Mismatches:            mismatches,
+	})
+}
+
+// consensusSnapshotExportHandlerGET handles the API call that downloads the
+// state at a height in the Pis encoding.
+func (api *API) consensusSnapshotExportHandlerGET(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	ss, err := api.snapshotAtRequestedHeight(req)
+	if err != nil {
+		WriteError(w, Error{"unable to create snapshot: " + err.Error()}, http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	ss.MarshalPis(w)
+}
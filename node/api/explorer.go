@@ -0,0 +1,41 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/wisherd/Pis/crypto"
+	"github.com/wisherd/Pis/modules"
+	"github.com/wisherd/Pis/types"
+)
+
+type (
+	// ExplorerProofGET contains the fields returned by a GET call to
+	// "/explorer/proof/:id".
+	ExplorerProofGET struct {
+		Height types.BlockHeight          `json:"height"`
+		Proof  types.MerkleInclusionProof `json:"proof"`
+	}
+)
+
+// explorerProofHandlerGET handles the API call asking for a Merkle proof that
+// a transaction is part of the block that contains it.
+func (api *API) explorerProofHandlerGET(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	var txid types.TransactionID
+	if err := (*crypto.Hash)(&txid).LoadString(ps.ByName("id")); err != nil {
+		WriteError(w, Error{"unable to parse txid: " + err.Error()}, http.StatusBadRequest)
+		return
+	}
+	proof, height, err := api.explorer.TransactionProof(txid)
+	if err == modules.ErrUnknownTransaction {
+		WriteError(w, Error{err.Error()}, http.StatusBadRequest)
+		return
+	} else if err != nil {
+		WriteError(w, Error{"unable to create proof: " + err.Error()}, http.StatusInternalServerError)
+		return
+	}
+	WriteJSON(w, ExplorerProofGET{
+		Height: height,
+		Proof:  proof,
+	})
+}
@@ -0,0 +1,67 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/wisherd/Pis/modules"
+)
+
+type (
+	// GatewayGET contains the fields returned by a GET call to "/gateway".
+	GatewayGET struct {
+		NetAddress modules.NetAddress `json:"netaddress"`
+		Peers      []modules.Peer     `json:"peers"`
+
+		modules.BandwidthLimits
+	}
+)
+
+// gatewayHandlerGET handles the API call asking for the gateway status.
+func (api *API) gatewayHandlerGET(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	peers := api.gateway.Peers()
+	// nil slices are marshalled as 'null' in JSON, whereas 0-length slices are
+	// marshalled as '[]'. The latter is preferred, indicating that the value
+	// exists but contains no elements.
+	if peers == nil {
+		peers = make([]modules.Peer, 0)
+	}
+	WriteJSON(w, GatewayGET{
+		NetAddress:      api.gateway.Address(),
+		Peers:           peers,
+		BandwidthLimits: api.gateway.RateLimits(),
+	})
+}
+
+// gatewayHandlerPOST handles the API call changing the bandwidth limits of the
+// gateway. Limits that are not supplied keep their current value.
+func (api *API) gatewayHandlerPOST(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	bl := api.gateway.RateLimits()
+	params := []struct {
+		name  string
+		limit *int64
+	}{
+		{"maxdownloadspeed", &bl.MaxDownloadSpeed},
+		{"maxuploadspeed", &bl.MaxUploadSpeed},
+		{"maxpeerdownloadspeed", &bl.MaxPeerDownloadSpeed},
+		{"maxpeeruploadspeed", &bl.MaxPeerUploadSpeed},
+	}
+	for _, p := range params {
+		value := req.FormValue(p.name)
+		if value == "" {
+			continue
+		}
+		limit, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			WriteError(w, Error{"unable to parse " + p.name + ": " + err.Error()}, http.StatusBadRequest)
+			return
+		}
+		*p.limit = limit
+	}
+	if err := api.gateway.SetRateLimits(bl); err != nil {
+		WriteError(w, Error{"failed to set bandwidth limits: " + err.Error()}, http.StatusBadRequest)
+		return
+	}
+	WriteSuccess(w)
+}
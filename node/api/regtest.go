@@ -0,0 +1,105 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/wisherd/Pis/modules"
+	"github.com/wisherd/Pis/types"
+)
+
+const (
+	// regtestSolveAttempts is the number of times SolveBlock is called before
+	// giving up on a block. With the regtest RootTarget, the first attempt
+	// practically always succeeds.
+	regtestSolveAttempts = 100
+)
+
+type (
+	// RegtestGeneratePOST contains the fields returned by a POST call to
+	// "/regtest/generate".
+	RegtestGeneratePOST struct {
+		Blocks []types.BlockID   `json:"blocks"`
+		Height types.BlockHeight `json:"height"`
+	}
+)
+
+// generateBlockTo mines a block whose miner payouts go to uh and submits it
+// to the consensus set.
+func (api *API) generateBlockTo(tm modules.TestMiner, uh types.UnlockHash) (types.Block, error) {
+	b, target, err := tm.BlockForWork()
+	if err != nil {
+		return types.Block{}, err
+	}
+	for i := range b.MinerPayouts {
+		b.MinerPayouts[i].UnlockHash = uh
+	}
+	for i := 0; i < regtestSolveAttempts; i++ {
+		solved, ok := tm.SolveBlock(b, target)
+		if ok {
+			return solved, api.cs.AcceptBlock(solved)
+		}
+		b = solved
+	}
+	return types.Block{}, errors.New("unable to solve block")
+}
+
+// parseRegtestGenerate returns the number of blocks and the payout address of
+// a call to /regtest/generate. n defaults to 1 and must not exceed
+// MaxRegtestBlocks. toAddress is false if no address was provided.
+func parseRegtestGenerate(req *http.Request) (n uint64, uh types.UnlockHash, toAddress bool, err error) {
+	n = 1
+	if nStr := req.FormValue("n"); nStr != "" {
+		n, err = strconv.ParseUint(nStr, 10, 64)
+		if err != nil {
+			return 0, types.UnlockHash{}, false, errors.New("unable to parse n: " + err.Error())
+		}
+	}
+	if n == 0 || n > modules.MaxRegtestBlocks {
+		return 0, types.UnlockHash{}, false, errors.New("n must be between 1 and " + strconv.Itoa(modules.MaxRegtestBlocks))
+	}
+	if addrStr := req.FormValue("address"); addrStr != "" {
+		if err := uh.LoadString(addrStr); err != nil {
+			return 0, types.UnlockHash{}, false, errors.New("unable to parse address: " + err.Error())
+		}
+		toAddress = true
+	}
+	return n, uh, toAddress, nil
+}
+
+// regtestGenerateHandlerPOST handles the API call that mines n blocks on the
+// regtest network. The miner payouts go to the address parameter, or to the
+// wallet of the miner if no address is provided.
+func (api *API) regtestGenerateHandlerPOST(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	tm, ok := api.miner.(modules.TestMiner)
+	if !ok {
+		WriteError(w, Error{"the miner does not support generating blocks"}, http.StatusBadRequest)
+		return
+	}
+	n, uh, toAddress, err := parseRegtestGenerate(req)
+	if err != nil {
+		WriteError(w, Error{err.Error()}, http.StatusBadRequest)
+		return
+	}
+
+	resp := RegtestGeneratePOST{
+		Blocks: make([]types.BlockID, 0, n),
+	}
+	for i := uint64(0); i < n; i++ {
+		var b types.Block
+		if !toAddress {
+			b, err = tm.AddBlock()
+		} else {
+			b, err = api.generateBlockTo(tm, uh)
+		}
+		if err != nil {
+			WriteError(w, Error{"unable to generate block: " + err.Error()}, http.StatusInternalServerError)
+			return
+		}
+		resp.Blocks = append(resp.Blocks, b.ID())
+	}
+	resp.Height = api.cs.Height()
+	WriteJSON(w, resp)
+}
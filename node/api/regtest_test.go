@@ -0,0 +1,45 @@
+package api
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"github.com/wisherd/Pis/modules"
+	"github.com/wisherd/Pis/types"
+)
+
+// TestParseRegtestGenerate checks the bounds on n and the parsing of the
+// payout address of /regtest/generate.
+func TestParseRegtestGenerate(t *testing.T) {
+	parse := func(values url.Values) (uint64, types.UnlockHash, bool, error) {
+		req, err := http.NewRequest("POST", "/regtest/generate?"+values.Encode(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parseRegtestGenerate(req)
+	}
+
+	if n, _, toAddress, err := parse(url.Values{}); err != nil || n != 1 || toAddress {
+		t.Fatal("wrong defaults:", n, toAddress, err)
+	}
+	max := strconv.Itoa(modules.MaxRegtestBlocks)
+	if n, _, _, err := parse(url.Values{"n": {max}}); err != nil || n != modules.MaxRegtestBlocks {
+		t.Fatal("maximum n was refused:", n, err)
+	}
+	for _, n := range []string{"0", strconv.Itoa(modules.MaxRegtestBlocks + 1), "-1", "ten"} {
+		if _, _, _, err := parse(url.Values{"n": {n}}); err == nil {
+			t.Error("invalid n was accepted:", n)
+		}
+	}
+
+	uh := types.UnlockConditions{}.UnlockHash()
+	_, parsed, toAddress, err := parse(url.Values{"address": {uh.String()}})
+	if err != nil || !toAddress || parsed != uh {
+		t.Fatal("address was not parsed:", parsed, toAddress, err)
+	}
+	if _, _, _, err := parse(url.Values{"address": {"nonsense"}}); err == nil {
+		t.Fatal("invalid address was accepted")
+	}
+}
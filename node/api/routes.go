@@ -7,6 +7,7 @@ import (
 
 	"github.com/julienschmidt/httprouter"
 	"github.com/wisherd/Pis/build"
+	"github.com/wisherd/Pis/types"
 )
 
 // buildHttpRoutes sets up and returns an * httprouter.Router.
@@ -18,6 +19,60 @@ func (api *API) buildHTTPRoutes(requiredUserAgent string, requiredPassword strin
 	router.NotFound = http.HandlerFunc(UnrecognizedCallHandler)
 	router.RedirectTrailingSlash = false
 
+	// Consensus API Calls
+	if api.cs != nil {
+		router.GET("/consensus/check", RequirePassword(api.consensusCheckHandlerGET, requiredPassword))
+		router.GET("/consensus/forks", api.consensusForksHandlerGET)
+		router.GET("/consensus/proof/:id", api.consensusProofHandlerGET)
+		router.GET("/consensus/reorgs", api.consensusReorgsHandlerGET)
+		router.GET("/consensus/snapshot", RequirePassword(api.consensusSnapshotHandlerGET, requiredPassword))
+		router.GET("/consensus/snapshot/export", RequirePassword(api.consensusSnapshotExportHandlerGET, requiredPassword))
+		router.GET("/consensus/sync", api.consensusSyncHandlerGET)
+	}
+
+	// Explorer API Calls
+	if api.explorer != nil {
+		router.GET("/explorer/proof/:id", api.explorerProofHandlerGET)
+	}
+
+	// Gateway API Calls
+	if api.gateway != nil {
+		router.GET("/gateway", api.gatewayHandlerGET)
+		router.POST("/gateway", RequirePassword(api.gatewayHandlerPOST, requiredPassword))
+	}
+
+	// Regtest API Calls
+	if types.Regtest && api.cs != nil && api.miner != nil {
+		router.POST("/regtest/generate", RequirePassword(api.regtestGenerateHandlerPOST, requiredPassword))
+	}
+
+	// Wallet API Calls
+	if api.wallet != nil {
+		router.GET("/wallet/history", RequirePassword(api.walletHistoryHandlerGET, requiredPassword))
+		router.GET("/wallet/labels", RequirePassword(api.walletLabelsHandlerGET, requiredPassword))
+		router.POST("/wallet/labels/address", RequirePassword(api.walletLabelsAddressHandlerPOST, requiredPassword))
+		router.POST("/wallet/labels/output", RequirePassword(api.walletLabelsOutputHandlerPOST, requiredPassword))
+		router.POST("/wallet/labels/transaction", RequirePassword(api.walletLabelsTransactionHandlerPOST, requiredPassword))
+		router.GET("/wallet/multisig", RequirePassword(api.walletMultisigHandlerGET, requiredPassword))
+		router.POST("/wallet/multisig", RequirePassword(api.walletMultisigHandlerPOST, requiredPassword))
+		router.GET("/wallet/multisig/outputs/:address", RequirePassword(api.walletMultisigOutputsHandlerGET, requiredPassword))
+		router.POST("/wallet/multisig/build", RequirePassword(api.walletMultisigBuildHandlerPOST, requiredPassword))
+		router.POST("/wallet/multisig/combine", RequirePassword(api.walletMultisigCombineHandlerPOST, requiredPassword))
+		router.POST("/wallet/multisig/sign", RequirePassword(api.walletMultisigSignHandlerPOST, requiredPassword))
+		if api.tpool != nil {
+			router.POST("/wallet/multisig/broadcast", RequirePassword(api.walletMultisigBroadcastHandlerPOST, requiredPassword))
+		}
+		router.POST("/wallet/pst/combine", RequirePassword(api.walletPartialTransactionCombineHandlerPOST, requiredPassword))
+		router.POST("/wallet/pst/create", RequirePassword(api.walletPartialTransactionCreateHandlerPOST, requiredPassword))
+		router.POST("/wallet/pst/finalize", RequirePassword(api.walletPartialTransactionFinalizeHandlerPOST, requiredPassword))
+		router.POST("/wallet/pst/inspect", api.walletPartialTransactionInspectHandlerPOST)
+		router.POST("/wallet/pst/sign", RequirePassword(api.walletPartialTransactionSignHandlerPOST, requiredPassword))
+		router.GET("/wallet/unspent", RequirePassword(api.walletUnspentHandlerGET, requiredPassword))
+		router.POST("/wallet/unspent/lock", RequirePassword(api.walletUnspentLockHandlerPOST, requiredPassword))
+		router.POST("/wallet/unspent/unlock", RequirePassword(api.walletUnspentUnlockHandlerPOST, requiredPassword))
+		router.GET("/wallet/watch", RequirePassword(api.walletWatchHandlerGET, requiredPassword))
+		router.POST("/wallet/watch", RequirePassword(api.walletWatchHandlerPOST, requiredPassword))
+	}
 
 	// Apply UserAgent middleware and return the Router
 	api.router = cleanCloseHandler(RequireUserAgent(router, requiredUserAgent))
@@ -0,0 +1,723 @@
+package api
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/wisherd/Pis/crypto"
+	"github.com/wisherd/Pis/modules"
+	"github.com/wisherd/Pis/types"
+)
+
+type (
+	// WalletHistoryGET contains a page of the transaction history export of
+	// the wallet. Total is the number of entries in the requested height
+	// range.
+	WalletHistoryGET struct {
+		Entries []modules.HistoryEntry `json:"entries"`
+		Total   int                    `json:"total"`
+	}
+
+	// WalletMultisigAddress describes a multisig address tracked by the
+	// wallet.
+	WalletMultisigAddress struct {
+		Address          types.UnlockHash       `json:"address"`
+		UnlockConditions types.UnlockConditions `json:"unlockconditions"`
+	}
+
+	// WalletMultisigGET contains the multisig addresses tracked by the
+	// wallet.
+	WalletMultisigGET struct {
+		Addresses []WalletMultisigAddress `json:"addresses"`
+	}
+
+	// WalletMultisigOutputsGET contains the unspent outputs of a multisig
+	// address.
+	WalletMultisigOutputsGET struct {
+		Outputs []modules.MultisigOutput `json:"outputs"`
+	}
+
+	// WalletMultisigTransaction contains a multisig transaction, its parents
+	// and the signature status of its inputs.
+	WalletMultisigTransaction struct {
+		Transaction types.Transaction              `json:"transaction"`
+		Parents     []types.Transaction            `json:"parents"`
+		Status      []modules.InputSignatureStatus `json:"status"`
+		Complete    bool                           `json:"complete"`
+	}
+
+	// WalletPartialTransaction contains a partially signed transaction, its
+	// base64-encoded binary encoding and its summary.
+	WalletPartialTransaction struct {
+		PartialTransaction modules.PartiallySignedTransaction `json:"pst"`
+		Encoded            string                             `json:"encoded"`
+		Summary            modules.PartialTransactionSummary  `json:"summary"`
+	}
+
+	// WalletPartialTransactionFinalizePOST contains the transaction set of a
+	// finalized partially signed transaction.
+	WalletPartialTransactionFinalizePOST struct {
+		Transactions []types.Transaction `json:"transactions"`
+		Broadcast    bool                `json:"broadcast"`
+	}
+
+	// WalletUnspentGET contains the unspent outputs of the wallet.
+	WalletUnspentGET struct {
+		Outputs []modules.UnspentOutput `json:"outputs"`
+	}
+
+	// WalletWatchGET contains the watch-only addresses of the wallet and
+	// their balance. The balance is not part of the spendable balance of the
+	// wallet.
+	WalletWatchGET struct {
+		Addresses               []modules.WatchAddress `json:"addresses"`
+		ConfirmedPiscoinBalance types.Currency         `json:"confirmedpiscoinbalance"`
+		PisfundBalance          types.Currency         `json:"pisfundbalance"`
+		PiscoinClaimBalance     types.Currency         `json:"piscoinclaimbalance"`
+	}
+)
+
+// scanCurrency parses a base-10 amount of hastings.
+func scanCurrency(s string) (types.Currency, error) {
+	i, ok := new(big.Int).SetString(s, 10)
+	if !ok {
+		return types.Currency{}, errors.New("could not parse amount")
+	}
+	if i.Sign() < 0 {
+		return types.Currency{}, errors.New("amount cannot be negative")
+	}
+	return types.NewCurrency(i), nil
+}
+
+// scanUnlockHash parses an address with its checksum.
+func scanUnlockHash(s string) (types.UnlockHash, error) {
+	var uh types.UnlockHash
+	if err := uh.LoadString(s); err != nil {
+		return types.UnlockHash{}, err
+	}
+	return uh, nil
+}
+
+// scanOutputIDs parses a JSON array of output IDs.
+func scanOutputIDs(s string) ([]types.OutputID, error) {
+	var ids []types.OutputID
+	if err := json.Unmarshal([]byte(s), &ids); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// scanCoinSelection parses the optional "inputs" and "strategy" form values
+// that control which outputs fund a transaction.
+func scanCoinSelection(req *http.Request) (modules.CoinSelection, error) {
+	cs := modules.CoinSelection{
+		Strategy: modules.CoinSelectionStrategy(req.FormValue("strategy")),
+	}
+	if s := req.FormValue("inputs"); s != "" {
+		ids, err := scanOutputIDs(s)
+		if err != nil {
+			return modules.CoinSelection{}, errors.New("unable to parse inputs: " + err.Error())
+		}
+		cs.Outputs = ids
+	}
+	switch cs.Strategy {
+	case "", modules.CoinSelectionLargestFirst, modules.CoinSelectionSmallestFirst, modules.CoinSelectionPrivacy, modules.CoinSelectionMinimalChange:
+	default:
+		return modules.CoinSelection{}, modules.ErrUnknownCoinSelectionStrategy
+	}
+	return cs, nil
+}
+
+// newWalletMultisigTransaction returns txn together with its signature
+// status.
+func newWalletMultisigTransaction(txn types.Transaction, parents []types.Transaction) WalletMultisigTransaction {
+	status := modules.SignatureStatus(txn)
+	complete := true
+	for _, s := range status {
+		complete = complete && s.Completed
+	}
+	return WalletMultisigTransaction{
+		Transaction: txn,
+		Parents:     parents,
+		Status:      status,
+		Complete:    complete,
+	}
+}
+
+// walletMultisigHandlerGET handles the API call that lists the multisig
+// addresses tracked by the wallet.
+func (api *API) walletMultisigHandlerGET(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	ucs, err := api.wallet.MultisigAddresses()
+	if err != nil {
+		WriteError(w, Error{"error after call to /wallet/multisig: " + err.Error()}, http.StatusBadRequest)
+		return
+	}
+	addrs := make([]WalletMultisigAddress, 0, len(ucs))
+	for _, uc := range ucs {
+		addrs = append(addrs, WalletMultisigAddress{
+			Address:          uc.UnlockHash(),
+			UnlockConditions: uc,
+		})
+	}
+	WriteJSON(w, WalletMultisigGET{Addresses: addrs})
+}
+
+// walletMultisigHandlerPOST handles the API call that creates an M-of-N
+// address from a comma-separated list of public keys and starts tracking it.
+func (api *API) walletMultisigHandlerPOST(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	var keys []types.PisPublicKey
+	for _, s := range strings.Split(req.FormValue("publickeys"), ",") {
+		var spk types.PisPublicKey
+		spk.LoadString(strings.TrimSpace(s))
+		if spk.Key == nil {
+			WriteError(w, Error{"unable to parse public key: " + s}, http.StatusBadRequest)
+			return
+		}
+		keys = append(keys, spk)
+	}
+	required, err := strconv.ParseUint(req.FormValue("required"), 10, 64)
+	if err != nil {
+		WriteError(w, Error{"unable to parse required: " + err.Error()}, http.StatusBadRequest)
+		return
+	}
+	uc, err := types.NewMultisigUnlockConditions(keys, required)
+	if err != nil {
+		WriteError(w, Error{"unable to create multisig address: " + err.Error()}, http.StatusBadRequest)
+		return
+	}
+	if err := api.wallet.AddMultisigAddress(uc); err != nil {
+		WriteError(w, Error{"error after call to /wallet/multisig: " + err.Error()}, http.StatusBadRequest)
+		return
+	}
+	WriteJSON(w, WalletMultisigAddress{
+		Address:          uc.UnlockHash(),
+		UnlockConditions: uc,
+	})
+}
+
+// walletMultisigOutputsHandlerGET handles the API call that lists the unspent
+// outputs of a multisig address.
+func (api *API) walletMultisigOutputsHandlerGET(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	uh, err := scanUnlockHash(ps.ByName("address"))
+	if err != nil {
+		WriteError(w, Error{"unable to parse address: " + err.Error()}, http.StatusBadRequest)
+		return
+	}
+	outputs, err := api.wallet.MultisigOutputs(uh)
+	if err != nil {
+		WriteError(w, Error{"error after call to /wallet/multisig/outputs: " + err.Error()}, http.StatusBadRequest)
+		return
+	}
+	WriteJSON(w, WalletMultisigOutputsGET{Outputs: outputs})
+}
+
+// walletMultisigBuildHandlerPOST handles the API call that builds an unsigned
+// transaction spending from a multisig address. The outputs are passed as a
+// JSON array of piscoin outputs.
+func (api *API) walletMultisigBuildHandlerPOST(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	uh, err := scanUnlockHash(req.FormValue("address"))
+	if err != nil {
+		WriteError(w, Error{"unable to parse address: " + err.Error()}, http.StatusBadRequest)
+		return
+	}
+	var outputs []types.PiscoinOutput
+	if err := json.Unmarshal([]byte(req.FormValue("outputs")), &outputs); err != nil {
+		WriteError(w, Error{"unable to parse outputs: " + err.Error()}, http.StatusBadRequest)
+		return
+	}
+	fee := types.ZeroCurrency
+	if feeStr := req.FormValue("fee"); feeStr != "" {
+		fee, err = scanCurrency(feeStr)
+		if err != nil {
+			WriteError(w, Error{"unable to parse fee: " + err.Error()}, http.StatusBadRequest)
+			return
+		}
+	}
+	txn, parents, err := api.wallet.BuildMultisigTransaction(uh, outputs, fee)
+	if err != nil {
+		WriteError(w, Error{"error after call to /wallet/multisig/build: " + err.Error()}, http.StatusBadRequest)
+		return
+	}
+	WriteJSON(w, newWalletMultisigTransaction(txn, parents))
+}
+
+// walletMultisigSignHandlerPOST handles the API call that adds the
+// signatures of the wallet to a multisig transaction.
+func (api *API) walletMultisigSignHandlerPOST(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	var txn types.Transaction
+	if err := json.Unmarshal([]byte(req.FormValue("transaction")), &txn); err != nil {
+		WriteError(w, Error{"unable to parse transaction: " + err.Error()}, http.StatusBadRequest)
+		return
+	}
+	signed, err := api.wallet.SignMultisigTransaction(txn)
+	if err != nil {
+		WriteError(w, Error{"error after call to /wallet/multisig/sign: " + err.Error()}, http.StatusBadRequest)
+		return
+	}
+	WriteJSON(w, newWalletMultisigTransaction(signed, nil))
+}
+
+// walletMultisigCombineHandlerPOST handles the API call that merges the
+// signatures of copies of a transaction signed by different co-signers.
+func (api *API) walletMultisigCombineHandlerPOST(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	var txns []types.Transaction
+	if err := json.Unmarshal([]byte(req.FormValue("transactions")), &txns); err != nil {
+		WriteError(w, Error{"unable to parse transactions: " + err.Error()}, http.StatusBadRequest)
+		return
+	}
+	combined, err := modules.CombineSignatures(txns...)
+	if err != nil {
+		WriteError(w, Error{"unable to combine signatures: " + err.Error()}, http.StatusBadRequest)
+		return
+	}
+	WriteJSON(w, newWalletMultisigTransaction(combined, nil))
+}
+
+// walletMultisigBroadcastHandlerPOST handles the API call that submits a
+// fully signed multisig transaction and its parents to the transaction pool.
+func (api *API) walletMultisigBroadcastHandlerPOST(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	var txn types.Transaction
+	if err := json.Unmarshal([]byte(req.FormValue("transaction")), &txn); err != nil {
+		WriteError(w, Error{"unable to parse transaction: " + err.Error()}, http.StatusBadRequest)
+		return
+	}
+	var parents []types.Transaction
+	if parentsStr := req.FormValue("parents"); parentsStr != "" {
+		if err := json.Unmarshal([]byte(parentsStr), &parents); err != nil {
+			WriteError(w, Error{"unable to parse parents: " + err.Error()}, http.StatusBadRequest)
+			return
+		}
+	}
+	if !newWalletMultisigTransaction(txn, nil).Complete {
+		WriteError(w, Error{"transaction does not have enough signatures"}, http.StatusBadRequest)
+		return
+	}
+	if err := api.tpool.AcceptTransactionSet(append(parents, txn)); err != nil {
+		WriteError(w, Error{"error after call to /wallet/multisig/broadcast: " + err.Error()}, http.StatusBadRequest)
+		return
+	}
+	WriteSuccess(w)
+}
+
+// scanPartialTransaction parses a partially signed transaction that is either
+// JSON or the base64 encoding of the binary format.
+func scanPartialTransaction(s string) (modules.PartiallySignedTransaction, error) {
+	var pst modules.PartiallySignedTransaction
+	if strings.HasPrefix(strings.TrimSpace(s), "{") {
+		if err := json.Unmarshal([]byte(s), &pst); err != nil {
+			return modules.PartiallySignedTransaction{}, err
+		}
+	} else {
+		b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+		if err != nil {
+			return modules.PartiallySignedTransaction{}, err
+		}
+		if err := pst.UnmarshalPis(bytes.NewReader(b)); err != nil {
+			return modules.PartiallySignedTransaction{}, err
+		}
+	}
+	return pst, pst.Validate()
+}
+
+// writePartialTransaction writes pst in both encodings along with its
+// summary.
+func writePartialTransaction(w http.ResponseWriter, pst modules.PartiallySignedTransaction) {
+	var buf bytes.Buffer
+	if err := pst.MarshalPis(&buf); err != nil {
+		WriteError(w, Error{"unable to encode transaction: " + err.Error()}, http.StatusInternalServerError)
+		return
+	}
+	WriteJSON(w, WalletPartialTransaction{
+		PartialTransaction: pst,
+		Encoded:            base64.StdEncoding.EncodeToString(buf.Bytes()),
+		Summary:            pst.Summary(),
+	})
+}
+
+// walletPartialTransactionCreateHandlerPOST handles the API call that funds an
+// unsigned partially signed transaction from the wallet. The outputs are
+// passed as a JSON array of piscoin outputs. The spent outputs can be chosen
+// with "inputs" or "strategy".
+func (api *API) walletPartialTransactionCreateHandlerPOST(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	var outputs []types.PiscoinOutput
+	if err := json.Unmarshal([]byte(req.FormValue("outputs")), &outputs); err != nil {
+		WriteError(w, Error{"unable to parse outputs: " + err.Error()}, http.StatusBadRequest)
+		return
+	}
+	fee := types.ZeroCurrency
+	if feeStr := req.FormValue("fee"); feeStr != "" {
+		var err error
+		fee, err = scanCurrency(feeStr)
+		if err != nil {
+			WriteError(w, Error{"unable to parse fee: " + err.Error()}, http.StatusBadRequest)
+			return
+		}
+	}
+	cs, err := scanCoinSelection(req)
+	if err != nil {
+		WriteError(w, Error{err.Error()}, http.StatusBadRequest)
+		return
+	}
+	pst, err := api.wallet.CreatePartialTransaction(outputs, fee, cs)
+	if err != nil {
+		WriteError(w, Error{"error after call to /wallet/pst/create: " + err.Error()}, http.StatusBadRequest)
+		return
+	}
+	writePartialTransaction(w, pst)
+}
+
+// walletPartialTransactionSignHandlerPOST handles the API call that adds the
+// signatures of the wallet to a partially signed transaction.
+func (api *API) walletPartialTransactionSignHandlerPOST(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	pst, err := scanPartialTransaction(req.FormValue("pst"))
+	if err != nil {
+		WriteError(w, Error{"unable to parse pst: " + err.Error()}, http.StatusBadRequest)
+		return
+	}
+	pst, err = api.wallet.SignPartialTransaction(pst)
+	if err != nil {
+		WriteError(w, Error{"error after call to /wallet/pst/sign: " + err.Error()}, http.StatusBadRequest)
+		return
+	}
+	writePartialTransaction(w, pst)
+}
+
+// walletPartialTransactionCombineHandlerPOST handles the API call that merges
+// the signatures of several copies of a partially signed transaction, each
+// passed as a separate pst value.
+func (api *API) walletPartialTransactionCombineHandlerPOST(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	if err := req.ParseForm(); err != nil {
+		WriteError(w, Error{"unable to parse form: " + err.Error()}, http.StatusBadRequest)
+		return
+	}
+	var psts []modules.PartiallySignedTransaction
+	for _, s := range req.PostForm["pst"] {
+		pst, err := scanPartialTransaction(s)
+		if err != nil {
+			WriteError(w, Error{"unable to parse pst: " + err.Error()}, http.StatusBadRequest)
+			return
+		}
+		psts = append(psts, pst)
+	}
+	combined, err := modules.CombinePartiallySigned(psts...)
+	if err != nil {
+		WriteError(w, Error{"unable to combine signatures: " + err.Error()}, http.StatusBadRequest)
+		return
+	}
+	writePartialTransaction(w, combined)
+}
+
+// walletPartialTransactionInspectHandlerPOST handles the API call that
+// decodes a partially signed transaction and summarizes it.
+func (api *API) walletPartialTransactionInspectHandlerPOST(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	pst, err := scanPartialTransaction(req.FormValue("pst"))
+	if err != nil {
+		WriteError(w, Error{"unable to parse pst: " + err.Error()}, http.StatusBadRequest)
+		return
+	}
+	writePartialTransaction(w, pst)
+}
+
+// walletPartialTransactionFinalizeHandlerPOST handles the API call that turns
+// a fully signed partially signed transaction into a transaction set, and
+// submits it to the transaction pool if broadcast is true.
+func (api *API) walletPartialTransactionFinalizeHandlerPOST(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	pst, err := scanPartialTransaction(req.FormValue("pst"))
+	if err != nil {
+		WriteError(w, Error{"unable to parse pst: " + err.Error()}, http.StatusBadRequest)
+		return
+	}
+	var broadcast bool
+	if b := req.FormValue("broadcast"); b != "" {
+		broadcast, err = strconv.ParseBool(b)
+		if err != nil {
+			WriteError(w, Error{"unable to parse broadcast: " + err.Error()}, http.StatusBadRequest)
+			return
+		}
+	}
+	txns, err := pst.Finalize()
+	if err != nil {
+		WriteError(w, Error{"unable to finalize transaction: " + err.Error()}, http.StatusBadRequest)
+		return
+	}
+	if broadcast {
+		if api.tpool == nil {
+			WriteError(w, Error{"cannot broadcast without a transaction pool"}, http.StatusBadRequest)
+			return
+		}
+		if err := api.tpool.AcceptTransactionSet(txns); err != nil {
+			WriteError(w, Error{"error after call to /wallet/pst/finalize: " + err.Error()}, http.StatusBadRequest)
+			return
+		}
+	}
+	WriteJSON(w, WalletPartialTransactionFinalizePOST{
+		Transactions: txns,
+		Broadcast:    broadcast,
+	})
+}
+
+// walletHistoryHandlerGET handles the API call that exports the transaction
+// history of the wallet. The confirmed transactions at heights [start, end]
+// are exported as JSON, or streamed as CSV if format is "csv". By default
+// the whole history is exported; offset and limit select a page of entries.
+func (api *API) walletHistoryHandlerGET(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	parseUint := func(name string, def uint64) (uint64, bool) {
+		str := req.FormValue(name)
+		if str == "" {
+			return def, true
+		}
+		u, err := strconv.ParseUint(str, 10, 64)
+		if err != nil {
+			WriteError(w, Error{"unable to parse " + name + ": " + err.Error()}, http.StatusBadRequest)
+			return 0, false
+		}
+		return u, true
+	}
+	height, err := api.wallet.Height()
+	if err != nil {
+		WriteError(w, Error{"error after call to /wallet/history: " + err.Error()}, http.StatusBadRequest)
+		return
+	}
+	start, ok := parseUint("start", 0)
+	if !ok {
+		return
+	}
+	end, ok := parseUint("end", uint64(height))
+	if !ok {
+		return
+	}
+	offset, ok := parseUint("offset", 0)
+	if !ok {
+		return
+	}
+	limit, ok := parseUint("limit", 0)
+	if !ok {
+		return
+	}
+	format := req.FormValue("format")
+	if format != "" && format != "json" && format != "csv" {
+		WriteError(w, Error{"format must be json or csv"}, http.StatusBadRequest)
+		return
+	}
+
+	txns, err := api.wallet.Transactions(types.BlockHeight(start), types.BlockHeight(end))
+	if err != nil {
+		WriteError(w, Error{"error after call to /wallet/history: " + err.Error()}, http.StatusBadRequest)
+		return
+	}
+	var entries []modules.HistoryEntry
+	for _, pt := range txns {
+		entries = append(entries, modules.NewHistoryEntries(pt)...)
+	}
+	total := len(entries)
+	if offset > uint64(len(entries)) {
+		offset = uint64(len(entries))
+	}
+	entries = entries[offset:]
+	if limit > 0 && limit < uint64(len(entries)) {
+		entries = entries[:limit]
+	}
+
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", `attachment; filename="history.csv"`)
+		hw := modules.NewHistoryCSVWriter(w)
+		for _, e := range entries {
+			if err := hw.Write(e); err != nil {
+				return
+			}
+		}
+		hw.Flush()
+		return
+	}
+	WriteJSON(w, WalletHistoryGET{
+		Entries: entries,
+		Total:   total,
+	})
+}
+
+// walletLabelsHandlerGET handles the API call that lists the labels and notes
+// of the wallet. If "search" is set, only labels and notes that contain it are
+// returned.
+func (api *API) walletLabelsHandlerGET(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	var labels modules.WalletLabels
+	var err error
+	if query := req.FormValue("search"); query != "" {
+		labels, err = api.wallet.SearchLabels(query)
+	} else {
+		labels, err = api.wallet.Labels()
+	}
+	if err != nil {
+		WriteError(w, Error{"error after call to /wallet/labels: " + err.Error()}, http.StatusBadRequest)
+		return
+	}
+	WriteJSON(w, labels)
+}
+
+// walletLabelsAddressHandlerPOST handles the API call that sets the label of
+// an address. An empty label removes it.
+func (api *API) walletLabelsAddressHandlerPOST(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	addr, err := scanUnlockHash(req.FormValue("address"))
+	if err != nil {
+		WriteError(w, Error{"unable to parse address: " + err.Error()}, http.StatusBadRequest)
+		return
+	}
+	if err := api.wallet.SetAddressLabel(addr, req.FormValue("label")); err != nil {
+		WriteError(w, Error{"error after call to /wallet/labels/address: " + err.Error()}, http.StatusBadRequest)
+		return
+	}
+	WriteSuccess(w)
+}
+
+// walletLabelsTransactionHandlerPOST handles the API call that sets the note
+// of a transaction. An empty note removes it.
+func (api *API) walletLabelsTransactionHandlerPOST(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	var txid crypto.Hash
+	if err := txid.LoadString(req.FormValue("id")); err != nil {
+		WriteError(w, Error{"unable to parse id: " + err.Error()}, http.StatusBadRequest)
+		return
+	}
+	if err := api.wallet.SetTransactionNote(types.TransactionID(txid), req.FormValue("note")); err != nil {
+		WriteError(w, Error{"error after call to /wallet/labels/transaction: " + err.Error()}, http.StatusBadRequest)
+		return
+	}
+	WriteSuccess(w)
+}
+
+// walletLabelsOutputHandlerPOST handles the API call that sets the note of an
+// output of a transaction. The output is identified by its wallet
+// transaction ID, or by the transaction ID and the output ID. An empty note
+// removes the note.
+func (api *API) walletLabelsOutputHandlerPOST(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	var id crypto.Hash
+	if idStr := req.FormValue("id"); idStr != "" {
+		if err := id.LoadString(idStr); err != nil {
+			WriteError(w, Error{"unable to parse id: " + err.Error()}, http.StatusBadRequest)
+			return
+		}
+	} else {
+		var txid, oid crypto.Hash
+		if err := txid.LoadString(req.FormValue("transactionid")); err != nil {
+			WriteError(w, Error{"unable to parse transactionid: " + err.Error()}, http.StatusBadRequest)
+			return
+		}
+		if err := oid.LoadString(req.FormValue("outputid")); err != nil {
+			WriteError(w, Error{"unable to parse outputid: " + err.Error()}, http.StatusBadRequest)
+			return
+		}
+		id = crypto.Hash(modules.CalculateWalletTransactionID(types.TransactionID(txid), types.OutputID(oid)))
+	}
+	if err := api.wallet.SetOutputNote(modules.WalletTransactionID(id), req.FormValue("note")); err != nil {
+		WriteError(w, Error{"error after call to /wallet/labels/output: " + err.Error()}, http.StatusBadRequest)
+		return
+	}
+	WriteSuccess(w)
+}
+
+// walletUnspentHandlerGET handles the API call that lists the unspent outputs
+// of the wallet.
+func (api *API) walletUnspentHandlerGET(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	outputs, err := api.wallet.UnspentOutputs()
+	if err != nil {
+		WriteError(w, Error{"error after call to /wallet/unspent: " + err.Error()}, http.StatusBadRequest)
+		return
+	}
+	WriteJSON(w, WalletUnspentGET{Outputs: outputs})
+}
+
+// walletUnspentLockHandlerPOST handles the API call that locks unspent
+// outputs. The outputs are passed as a JSON array in "ids".
+func (api *API) walletUnspentLockHandlerPOST(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	ids, err := scanOutputIDs(req.FormValue("ids"))
+	if err != nil {
+		WriteError(w, Error{"unable to parse ids: " + err.Error()}, http.StatusBadRequest)
+		return
+	}
+	if err := api.wallet.LockOutputs(ids); err != nil {
+		WriteError(w, Error{"error after call to /wallet/unspent/lock: " + err.Error()}, http.StatusBadRequest)
+		return
+	}
+	WriteSuccess(w)
+}
+
+// walletUnspentUnlockHandlerPOST handles the API call that unlocks unspent
+// outputs. The outputs are passed as a JSON array in "ids".
+func (api *API) walletUnspentUnlockHandlerPOST(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	ids, err := scanOutputIDs(req.FormValue("ids"))
+	if err != nil {
+		WriteError(w, Error{"unable to parse ids: " + err.Error()}, http.StatusBadRequest)
+		return
+	}
+	if err := api.wallet.UnlockOutputs(ids); err != nil {
+		WriteError(w, Error{"error after call to /wallet/unspent/unlock: " + err.Error()}, http.StatusBadRequest)
+		return
+	}
+	WriteSuccess(w)
+}
+
+// walletWatchHandlerGET handles the API call that lists the watch-only
+// addresses of the wallet and their balance.
+func (api *API) walletWatchHandlerGET(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	addrs, err := api.wallet.WatchAddresses()
+	if err != nil {
+		WriteError(w, Error{"error after call to /wallet/watch: " + err.Error()}, http.StatusBadRequest)
+		return
+	}
+	siacoinBal, siafundBal, siaclaimBal, err := api.wallet.WatchOnlyBalance()
+	if err != nil {
+		WriteError(w, Error{"error after call to /wallet/watch: " + err.Error()}, http.StatusBadRequest)
+		return
+	}
+	WriteJSON(w, WalletWatchGET{
+		Addresses:               addrs,
+		ConfirmedPiscoinBalance: siacoinBal,
+		PisfundBalance:          siafundBal,
+		PiscoinClaimBalance:     siaclaimBal,
+	})
+}
+
+// walletWatchHandlerPOST handles the API call that adds watch-only addresses
+// to the wallet. Addresses with known unlock conditions are passed as a JSON
+// array in "unlockconditions", e.g. the output of 'pisc wallet
+// export-addresses'. Bare addresses are passed as a JSON array in
+// "addresses".
+func (api *API) walletWatchHandlerPOST(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	var ucs []types.UnlockConditions
+	if s := req.FormValue("unlockconditions"); s != "" {
+		if err := json.Unmarshal([]byte(s), &ucs); err != nil {
+			WriteError(w, Error{"unable to parse unlockconditions: " + err.Error()}, http.StatusBadRequest)
+			return
+		}
+	}
+	var uhs []types.UnlockHash
+	if s := req.FormValue("addresses"); s != "" {
+		if err := json.Unmarshal([]byte(s), &uhs); err != nil {
+			WriteError(w, Error{"unable to parse addresses: " + err.Error()}, http.StatusBadRequest)
+			return
+		}
+	}
+	if len(ucs) == 0 && len(uhs) == 0 {
+		WriteError(w, Error{"no addresses specified"}, http.StatusBadRequest)
+		return
+	}
+	if len(ucs) > 0 {
+		if err := api.wallet.AddWatchAddresses(ucs); err != nil {
+			WriteError(w, Error{"error after call to /wallet/watch: " + err.Error()}, http.StatusBadRequest)
+			return
+		}
+	}
+	if len(uhs) > 0 {
+		if err := api.wallet.AddWatchUnlockHashes(uhs); err != nil {
+			WriteError(w, Error{"error after call to /wallet/watch: " + err.Error()}, http.StatusBadRequest)
+			return
+		}
+	}
+	WriteSuccess(w)
+}
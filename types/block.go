@@ -84,6 +84,13 @@ func (h BlockHeader) ID() BlockID {
 	return BlockID(crypto.HashObject(h))
 }
 
+// CheckTarget returns true if the ID of the header meets the provided
+// target, i.e. if the header carries enough proof of work.
+func (h BlockHeader) CheckTarget(target Target) bool {
+	id := h.ID()
+	return bytes.Compare(target[:], id[:]) >= 0
+}
+
 // CalculateSubsidy takes a block and a height and determines the block
 // subsidy.
 func (b Block) CalculateSubsidy(height BlockHeight) Currency {
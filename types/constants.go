@@ -520,6 +520,12 @@ func init() {
 		}
 	}
 
+	setGenesisBlock()
+}
+
+// setGenesisBlock creates the genesis block from GenesisTimestamp and
+// GenesisPisfundAllocation.
+func setGenesisBlock() {
 	// Create the genesis block.
 	GenesisBlock = Block{
 		Timestamp: GenesisTimestamp,
@@ -0,0 +1,120 @@
+package types
+
+// merkleproof.go contains functions for proving that a transaction or a miner
+// payout is part of a block. The proof is checked against the Merkle root in
+// the header of the block, so a client that only tracks headers can verify it
+// without downloading the block.
+
+import (
+	"bytes"
+	"errors"
+	"io"
+
+	"github.com/wisherd/Pis/crypto"
+)
+
+var (
+	// ErrNotInBlock is returned when a Merkle proof is requested for a
+	// transaction or miner payout that is not part of the block.
+	ErrNotInBlock = errors.New("block does not contain the requested transaction or payout")
+)
+
+type (
+	// A MerkleInclusionProof proves that a leaf is part of the Merkle tree of
+	// a block. The leaves of the tree are the miner payouts followed by the
+	// transactions, so the leaf index of transaction i is
+	// len(MinerPayouts)+i.
+	MerkleInclusionProof struct {
+		BlockID   BlockID       `json:"blockid"`
+		LeafIndex uint64        `json:"leafindex"`
+		NumLeaves uint64        `json:"numleaves"`
+		HashSet   []crypto.Hash `json:"hashset"`
+	}
+)
+
+// merkleLeaf returns the encoding of a leaf of a block's Merkle tree, using
+// the same encoding as Block.MerkleRoot.
+func merkleLeaf(obj interface {
+	MarshalPis(io.Writer) error
+}) []byte {
+	var buf bytes.Buffer
+	obj.MarshalPis(&buf)
+	return buf.Bytes()
+}
+
+// leafProof returns a proof for the leaf at the provided index of the block's
+// Merkle tree. ErrNotInBlock is returned if the index is not smaller than the
+// number of leaves.
+func (b Block) leafProof(index uint64) (MerkleInclusionProof, error) {
+	if index >= uint64(len(b.MinerPayouts)+len(b.Transactions)) {
+		return MerkleInclusionProof{}, ErrNotInBlock
+	}
+	tree := crypto.NewTree()
+	if err := tree.SetIndex(index); err != nil {
+		return MerkleInclusionProof{}, err
+	}
+	for _, payout := range b.MinerPayouts {
+		tree.Push(merkleLeaf(payout))
+	}
+	for _, txn := range b.Transactions {
+		tree.Push(merkleLeaf(txn))
+	}
+	_, proofSet, _, numLeaves := tree.Prove()
+
+	// The first element of the proof set is the leaf itself, which the
+	// verifier has to provide.
+	hashSet := make([]crypto.Hash, len(proofSet)-1)
+	for i, p := range proofSet[1:] {
+		copy(hashSet[i][:], p)
+	}
+	return MerkleInclusionProof{
+		BlockID:   b.ID(),
+		LeafIndex: index,
+		NumLeaves: numLeaves,
+		HashSet:   hashSet,
+	}, nil
+}
+
+// MinerPayoutProof returns a proof that the miner payout at index i is part
+// of the block. ErrNotInBlock is returned if the block has no such payout.
+func (b Block) MinerPayoutProof(i uint64) (MerkleInclusionProof, error) {
+	if i >= uint64(len(b.MinerPayouts)) {
+		return MerkleInclusionProof{}, ErrNotInBlock
+	}
+	return b.leafProof(i)
+}
+
+// TransactionProof returns a proof that the transaction with the provided ID
+// is part of the block. ErrNotInBlock is returned if the block does not
+// contain the transaction.
+func (b Block) TransactionProof(id TransactionID) (MerkleInclusionProof, error) {
+	for i, txn := range b.Transactions {
+		if txn.ID() == id {
+			return b.leafProof(uint64(len(b.MinerPayouts) + i))
+		}
+	}
+	return MerkleInclusionProof{}, ErrNotInBlock
+}
+
+// verifyLeaf checks that the encoded leaf is part of the block described by
+// h.
+func (p MerkleInclusionProof) verifyLeaf(leaf []byte, h BlockHeader) bool {
+	if p.BlockID != h.ID() || p.LeafIndex >= p.NumLeaves {
+		return false
+	}
+	return crypto.VerifySegment(leaf, p.HashSet, p.NumLeaves, p.LeafIndex, h.MerkleRoot)
+}
+
+// VerifyTransactionProof returns true if the proof shows that txn is part of
+// the block described by h. The caller is responsible for checking that h is
+// part of the chain it trusts.
+func VerifyTransactionProof(txn Transaction, h BlockHeader, p MerkleInclusionProof) bool {
+	return p.verifyLeaf(merkleLeaf(txn), h)
+}
+
+// VerifyMinerPayoutProof returns true if the proof shows that sco is a miner
+// payout of the block described by h. The caller is responsible for checking
+// that h is part of the chain it trusts.
+func VerifyMinerPayoutProof(sco PiscoinOutput, h BlockHeader, p MerkleInclusionProof) bool {
+	return p.verifyLeaf(merkleLeaf(sco), h)
+}
@@ -0,0 +1,68 @@
+package types
+
+import (
+	"testing"
+)
+
+// TestMerkleInclusionProof checks that proofs for every transaction and miner
+// payout of a block verify against its header, and that proofs are rejected
+// for the wrong leaf or header.
+func TestMerkleInclusionProof(t *testing.T) {
+	b := Block{
+		Timestamp: 5,
+		MinerPayouts: []PiscoinOutput{
+			{Value: NewCurrency64(1), UnlockHash: UnlockHash{1}},
+			{Value: NewCurrency64(2), UnlockHash: UnlockHash{2}},
+		},
+	}
+	for i := 0; i < 5; i++ {
+		b.Transactions = append(b.Transactions, Transaction{
+			ArbitraryData: [][]byte{{byte(i)}},
+		})
+	}
+	h := b.Header()
+
+	for i, sco := range b.MinerPayouts {
+		proof, err := b.MinerPayoutProof(uint64(i))
+		if err != nil {
+			t.Fatal("no proof for payout", i, err)
+		}
+		if !VerifyMinerPayoutProof(sco, h, proof) {
+			t.Error("valid payout proof rejected", i)
+		}
+	}
+	for i, txn := range b.Transactions {
+		proof, err := b.TransactionProof(txn.ID())
+		if err != nil {
+			t.Fatal("no proof for transaction", i, err)
+		}
+		if proof.LeafIndex != uint64(len(b.MinerPayouts)+i) || proof.NumLeaves != 7 {
+			t.Error("wrong leaf position", proof.LeafIndex, proof.NumLeaves)
+		}
+		if !VerifyTransactionProof(txn, h, proof) {
+			t.Error("valid transaction proof rejected", i)
+		}
+		other := b.Transactions[(i+1)%len(b.Transactions)]
+		if VerifyTransactionProof(other, h, proof) {
+			t.Error("proof accepted for the wrong transaction", i)
+		}
+	}
+
+	// A proof must not verify against a different header.
+	proof, _ := b.TransactionProof(b.Transactions[0].ID())
+	other := h
+	other.Nonce[0]++
+	if VerifyTransactionProof(b.Transactions[0], other, proof) {
+		t.Error("proof accepted for the wrong header")
+	}
+
+	if _, err := b.TransactionProof(TransactionID{}); err != ErrNotInBlock {
+		t.Error("expected ErrNotInBlock for a missing transaction, got", err)
+	}
+	if _, err := b.MinerPayoutProof(2); err != ErrNotInBlock {
+		t.Error("expected ErrNotInBlock for a missing payout, got", err)
+	}
+	if _, err := b.leafProof(7); err != ErrNotInBlock {
+		t.Error("expected ErrNotInBlock for a leaf past the end, got", err)
+	}
+}
@@ -0,0 +1,59 @@
+package types
+
+// multisig.go contains the helpers for creating M-of-N UnlockConditions from
+// a set of public keys that belong to different parties.
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+
+	"github.com/wisherd/Pis/crypto"
+)
+
+var (
+	// ErrDuplicatePublicKey is returned when the same public key is used more
+	// than once in a set of multisig UnlockConditions.
+	ErrDuplicatePublicKey = errors.New("public key is used more than once")
+
+	// ErrInvalidPublicKey is returned when a public key cannot be used for
+	// signing transactions.
+	ErrInvalidPublicKey = errors.New("public key is not a valid ed25519 key")
+
+	// ErrInvalidSignaturesRequired is returned when the number of required
+	// signatures of a multisig address is zero or exceeds the number of keys.
+	ErrInvalidSignaturesRequired = errors.New("number of required signatures must be between 1 and the number of public keys")
+)
+
+// NewMultisigUnlockConditions returns UnlockConditions that require
+// signaturesRequired signatures from the provided keys. The keys are sorted,
+// so that every co-signer derives the same UnlockHash regardless of the
+// order in which the keys were exchanged.
+func NewMultisigUnlockConditions(keys []PisPublicKey, signaturesRequired uint64) (UnlockConditions, error) {
+	if signaturesRequired == 0 || signaturesRequired > uint64(len(keys)) {
+		return UnlockConditions{}, ErrInvalidSignaturesRequired
+	}
+	sorted := make([]PisPublicKey, len(keys))
+	copy(sorted, keys)
+	sort.Slice(sorted, func(i, j int) bool {
+		return bytes.Compare(sorted[i].Key, sorted[j].Key) < 0
+	})
+	for i, key := range sorted {
+		if key.Algorithm != SignatureEd25519 || len(key.Key) != crypto.PublicKeySize {
+			return UnlockConditions{}, ErrInvalidPublicKey
+		}
+		if i > 0 && bytes.Equal(key.Key, sorted[i-1].Key) {
+			return UnlockConditions{}, ErrDuplicatePublicKey
+		}
+	}
+	return UnlockConditions{
+		PublicKeys:         sorted,
+		SignaturesRequired: signaturesRequired,
+	}, nil
+}
+
+// IsMultisig returns true if the UnlockConditions have more than one public
+// key.
+func (uc UnlockConditions) IsMultisig() bool {
+	return len(uc.PublicKeys) > 1
+}
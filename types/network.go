@@ -0,0 +1,167 @@
+package types
+
+// network.go contains the consensus parameters that can be replaced at
+// runtime to run a custom network, e.g. a private consortium network, without
+// changing the code.
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+var (
+	// ErrInvalidNetworkParams is returned when a set of network parameters
+	// is not usable.
+	ErrInvalidNetworkParams = errors.New("invalid network parameters")
+)
+
+type (
+	// NetworkParams contains the consensus constants that define a network.
+	// The genesis block is derived from GenesisTimestamp and
+	// GenesisPisfundAllocation.
+	NetworkParams struct {
+		GenesisTimestamp         Timestamp       `json:"genesistimestamp"`
+		GenesisPisfundAllocation []PisfundOutput `json:"genesispisfundallocation"`
+
+		BlockFrequency          BlockHeight `json:"blockfrequency"`
+		MaturityDelay           BlockHeight `json:"maturitydelay"`
+		RootTarget              Target      `json:"roottarget"`
+		TargetWindow            BlockHeight `json:"targetwindow"`
+		MaxTargetAdjustmentUp   *big.Rat    `json:"maxtargetadjustmentup"`
+		MaxTargetAdjustmentDown *big.Rat    `json:"maxtargetadjustmentdown"`
+		FutureThreshold         Timestamp   `json:"futurethreshold"`
+		ExtremeFutureThreshold  Timestamp   `json:"extremefuturethreshold"`
+		MinimumCoinbase         uint64      `json:"minimumcoinbase"`
+		TaxHardforkHeight       BlockHeight `json:"taxhardforkheight"`
+
+		OakHardforkBlock    BlockHeight `json:"oakhardforkblock"`
+		OakHardforkFixBlock BlockHeight `json:"oakhardforkfixblock"`
+		OakDecayNum         int64       `json:"oakdecaynum"`
+		OakDecayDenom       int64       `json:"oakdecaydenom"`
+		OakMaxBlockShift    int64       `json:"oakmaxblockshift"`
+		OakMaxRise          *big.Rat    `json:"oakmaxrise"`
+		OakMaxDrop          *big.Rat    `json:"oakmaxdrop"`
+	}
+)
+
+// CurrentNetworkParams returns the parameters of the network that is
+// currently active. The returned value does not share memory with the
+// constants, so it can be modified and applied with ApplyNetworkParams.
+func CurrentNetworkParams() NetworkParams {
+	// Currency values share their memory when copied, so the allocation is
+	// copied value by value.
+	allocation := make([]PisfundOutput, len(GenesisPisfundAllocation))
+	for i, sfo := range GenesisPisfundAllocation {
+		allocation[i] = PisfundOutput{
+			Value:      NewCurrency(sfo.Value.Big()),
+			UnlockHash: sfo.UnlockHash,
+			ClaimStart: NewCurrency(sfo.ClaimStart.Big()),
+		}
+	}
+	return NetworkParams{
+		GenesisTimestamp:         GenesisTimestamp,
+		GenesisPisfundAllocation: allocation,
+
+		BlockFrequency:          BlockFrequency,
+		MaturityDelay:           MaturityDelay,
+		RootTarget:              RootTarget,
+		TargetWindow:            TargetWindow,
+		MaxTargetAdjustmentUp:   new(big.Rat).Set(MaxTargetAdjustmentUp),
+		MaxTargetAdjustmentDown: new(big.Rat).Set(MaxTargetAdjustmentDown),
+		FutureThreshold:         FutureThreshold,
+		ExtremeFutureThreshold:  ExtremeFutureThreshold,
+		MinimumCoinbase:         MinimumCoinbase,
+		TaxHardforkHeight:       TaxHardforkHeight,
+
+		OakHardforkBlock:    OakHardforkBlock,
+		OakHardforkFixBlock: OakHardforkFixBlock,
+		OakDecayNum:         OakDecayNum,
+		OakDecayDenom:       OakDecayDenom,
+		OakMaxBlockShift:    OakMaxBlockShift,
+		OakMaxRise:          new(big.Rat).Set(OakMaxRise),
+		OakMaxDrop:          new(big.Rat).Set(OakMaxDrop),
+	}
+}
+
+// Validate checks that the parameters describe a network that the consensus
+// code can run.
+func (np NetworkParams) Validate() error {
+	invalid := func(reason string) error {
+		return fmt.Errorf("%v: %v", ErrInvalidNetworkParams, reason)
+	}
+	if np.BlockFrequency == 0 {
+		return invalid("block frequency must be positive")
+	}
+	if np.TargetWindow == 0 {
+		return invalid("target window must be positive")
+	}
+	if np.RootTarget == (Target{}) {
+		return invalid("root target must not be zero")
+	}
+	if np.FutureThreshold > np.ExtremeFutureThreshold {
+		return invalid("future threshold exceeds the extreme future threshold")
+	}
+	if np.MinimumCoinbase > InitialCoinbase {
+		return invalid("minimum coinbase exceeds the initial coinbase")
+	}
+	for _, r := range []*big.Rat{np.MaxTargetAdjustmentUp, np.MaxTargetAdjustmentDown, np.OakMaxRise, np.OakMaxDrop} {
+		if r == nil || r.Sign() <= 0 {
+			return invalid("target adjustment limits must be positive")
+		}
+	}
+	if np.MaxTargetAdjustmentUp.Cmp(big.NewRat(1, 1)) < 0 || np.MaxTargetAdjustmentDown.Cmp(big.NewRat(1, 1)) > 0 {
+		return invalid("target adjustment limits are inverted")
+	}
+	if np.OakMaxRise.Cmp(big.NewRat(1, 1)) < 0 || np.OakMaxDrop.Cmp(big.NewRat(1, 1)) > 0 {
+		return invalid("oak adjustment limits are inverted")
+	}
+	if np.OakDecayDenom <= 0 || np.OakDecayNum <= 0 || np.OakDecayNum > np.OakDecayDenom {
+		return invalid("oak decay must be a fraction between 0 and 1")
+	}
+	if np.OakHardforkFixBlock < np.OakHardforkBlock {
+		return invalid("oak fix hardfork is before the oak hardfork")
+	}
+	total := ZeroCurrency
+	for _, sfo := range np.GenesisPisfundAllocation {
+		total = total.Add(sfo.Value)
+	}
+	if total.Cmp(PisfundCount) != 0 {
+		return invalid("genesis pisfund allocation must add up to " + PisfundCount.String() + " pisfunds")
+	}
+	return nil
+}
+
+// ApplyNetworkParams replaces the consensus constants with np and recreates
+// the genesis block. Peers compare genesis IDs during the handshake, so a
+// node that applied custom parameters only connects to nodes of the same
+// network. ApplyNetworkParams must be called before any module is created.
+func ApplyNetworkParams(np NetworkParams) error {
+	if err := np.Validate(); err != nil {
+		return err
+	}
+	GenesisTimestamp = np.GenesisTimestamp
+	GenesisPisfundAllocation = np.GenesisPisfundAllocation
+
+	BlockFrequency = np.BlockFrequency
+	MaturityDelay = np.MaturityDelay
+	RootTarget = np.RootTarget
+	TargetWindow = np.TargetWindow
+	MaxTargetAdjustmentUp = np.MaxTargetAdjustmentUp
+	MaxTargetAdjustmentDown = np.MaxTargetAdjustmentDown
+	FutureThreshold = np.FutureThreshold
+	ExtremeFutureThreshold = np.ExtremeFutureThreshold
+	MinimumCoinbase = np.MinimumCoinbase
+	TaxHardforkHeight = np.TaxHardforkHeight
+
+	OakHardforkBlock = np.OakHardforkBlock
+	OakHardforkFixBlock = np.OakHardforkFixBlock
+	OakDecayNum = np.OakDecayNum
+	OakDecayDenom = np.OakDecayDenom
+	OakMaxBlockShift = np.OakMaxBlockShift
+	OakMaxRise = np.OakMaxRise
+	OakMaxDrop = np.OakMaxDrop
+
+	setGenesisBlock()
+	return nil
+}
@@ -0,0 +1,60 @@
+package types
+
+// regtest.go contains the constants of the regtest network. Unlike the dev,
+// standard and testing constants, which are selected with build tags, the
+// regtest constants are activated at runtime, so that application developers
+// can run a local chain that they control without rebuilding the daemon.
+
+import (
+	"math/big"
+)
+
+var (
+	// Regtest is true if the regtest constants have been activated.
+	Regtest bool
+
+	// RegtestGenesisTimestamp is the timestamp of the regtest genesis block.
+	RegtestGenesisTimestamp = Timestamp(1546300800) // January 1st, 2019 @ 0:00 UTC.
+)
+
+// ActivateRegtest replaces the consensus constants with the regtest
+// constants and recreates the genesis block. The regtest network has its own
+// genesis block, a trivial RootTarget so that blocks can be mined instantly
+// on demand, and gives every pisfund to the unlock hash of the empty unlock
+// conditions, so that anyone can spend them. ActivateRegtest must be called
+// before any module is created.
+func ActivateRegtest() {
+	Regtest = true
+
+	BlockFrequency = 12
+	MaturityDelay = 10
+	GenesisTimestamp = RegtestGenesisTimestamp
+	RootTarget = Target{128} // Takes an expected 2 hashes.
+
+	// Blocks are mined on demand, so the difficulty must not react to the
+	// time between blocks.
+	TargetWindow = 200
+	MaxTargetAdjustmentUp = big.NewRat(10001, 10000)
+	MaxTargetAdjustmentDown = big.NewRat(9999, 10000)
+	FutureThreshold = 2 * 60        // 2 minutes.
+	ExtremeFutureThreshold = 4 * 60 // 4 minutes.
+
+	MinimumCoinbase = 30e3
+	TaxHardforkHeight = 10
+
+	OakHardforkBlock = 20
+	OakHardforkFixBlock = 23
+	OakDecayNum = 9999
+	OakDecayDenom = 10e3
+	OakMaxBlockShift = 3
+	OakMaxRise = big.NewRat(10001, 10e3)
+	OakMaxDrop = big.NewRat(10e3, 10001)
+
+	GenesisPisfundAllocation = []PisfundOutput{
+		{
+			Value:      PisfundCount,
+			UnlockHash: UnlockConditions{}.UnlockHash(),
+		},
+	}
+	setGenesisBlock()
+}
@@ -0,0 +1,83 @@
+package types
+
+// validblock.go contains functions for validating all of the transactions of
+// a block at once. Signature verification dominates the cost of validating a
+// transaction, and since the standalone checks of a transaction do not depend
+// on any other transaction, they can be performed in parallel.
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// minParallelTransactions is the smallest number of transactions for
+	// which the standalone checks are spread over multiple goroutines. For
+	// smaller sets, the overhead of the goroutines outweighs the benefit.
+	minParallelTransactions = 4
+)
+
+// StandaloneValidTransactions runs StandaloneValid on every transaction in
+// txns, spreading the work over one goroutine per CPU. If skipSignatures is
+// set, StandaloneValidWithoutSignatures is used instead. If multiple
+// transactions are invalid, the error of the transaction with the lowest
+// index is returned, so the result does not depend on scheduling.
+//
+// Only the standalone checks are performed in parallel. Checks against the
+// consensus state, and the application of the resulting diffs, must still be
+// performed in order by the caller.
+func StandaloneValidTransactions(txns []Transaction, currentHeight BlockHeight, skipSignatures bool) error {
+	check := func(t Transaction) error {
+		if skipSignatures {
+			return t.StandaloneValidWithoutSignatures(currentHeight)
+		}
+		return t.StandaloneValid(currentHeight)
+	}
+
+	workers := runtime.NumCPU()
+	if workers > len(txns) {
+		workers = len(txns)
+	}
+	if len(txns) < minParallelTransactions || workers < 2 {
+		for _, t := range txns {
+			if err := check(t); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// Workers claim transactions by incrementing a shared index. Once a
+	// transaction is found to be invalid, transactions with a higher index
+	// no longer need to be checked.
+	errs := make([]error, len(txns))
+	var next, firstInvalid int64 = -1, int64(len(txns))
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				i := atomic.AddInt64(&next, 1)
+				if i >= int64(len(txns)) || i > atomic.LoadInt64(&firstInvalid) {
+					return
+				}
+				if errs[i] = check(txns[i]); errs[i] != nil {
+					for {
+						invalid := atomic.LoadInt64(&firstInvalid)
+						if i >= invalid || atomic.CompareAndSwapInt64(&firstInvalid, invalid, i) {
+							break
+						}
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if firstInvalid < int64(len(txns)) {
+		return errs[firstInvalid]
+	}
+	return nil
+}
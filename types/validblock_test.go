@@ -0,0 +1,121 @@
+package types
+
+import (
+	"testing"
+
+	"github.com/wisherd/Pis/crypto"
+)
+
+// signedTransactionGraph uses TransactionGraph to create a chain of n
+// transactions and then gives every input a single ed25519 key and a valid
+// signature. The inputs no longer spend the outputs of the graph, but every
+// transaction passes the standalone checks, which makes the set suitable for
+// benchmarking signature verification.
+func signedTransactionGraph(tb testing.TB, n int) []Transaction {
+	var edges []TransactionGraphEdge
+	for i := 0; i < n; i++ {
+		edges = append(edges, TransactionGraphEdge{
+			Dest:   i + 1,
+			Fee:    NewCurrency64(1),
+			Source: i,
+			Value:  NewCurrency64(uint64(1e6 - i)),
+		})
+	}
+	txns, err := TransactionGraph(PiscoinOutputID{1}, edges)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	sk, pk := crypto.GenerateKeyPair()
+	uc := UnlockConditions{
+		PublicKeys:         []PisPublicKey{Ed25519PublicKey(pk)},
+		SignaturesRequired: 1,
+	}
+	for i := range txns {
+		for j := range txns[i].PiscoinInputs {
+			txns[i].PiscoinInputs[j].UnlockConditions = uc
+		}
+		for _, input := range txns[i].PiscoinInputs {
+			txns[i].TransactionSignatures = append(txns[i].TransactionSignatures, TransactionSignature{
+				ParentID:      crypto.Hash(input.ParentID),
+				CoveredFields: FullCoveredFields,
+			})
+		}
+		for j := range txns[i].TransactionSignatures {
+			sig := crypto.SignHash(txns[i].SigHash(j), sk)
+			txns[i].TransactionSignatures[j].Signature = sig[:]
+		}
+	}
+	return txns
+}
+
+// TestStandaloneValidTransactions checks that the parallel validation
+// accepts valid sets and reports the error of the first invalid transaction.
+func TestStandaloneValidTransactions(t *testing.T) {
+	txns := signedTransactionGraph(t, 20)
+	if err := StandaloneValidTransactions(txns, 0, false); err != nil {
+		t.Fatal(err)
+	}
+
+	// Corrupt the signatures of two transactions; the error of the earlier
+	// one should be returned every time.
+	txns[7].TransactionSignatures[0].Signature[0] ^= 1
+	txns[12].TransactionSignatures[0].PublicKeyIndex = 5
+	for i := 0; i < 10; i++ {
+		if err := StandaloneValidTransactions(txns, 0, false); err == nil || err == ErrInvalidPubKeyIndex {
+			t.Fatal("expected the signature error of the earlier transaction, got", err)
+		}
+	}
+	if err := StandaloneValidTransactions(txns[8:], 0, false); err != ErrInvalidPubKeyIndex {
+		t.Fatal("expected ErrInvalidPubKeyIndex, got", err)
+	}
+
+	// Skipping signatures ignores the bad signature but still enforces the
+	// covered fields rules.
+	txns[12].TransactionSignatures[0].PublicKeyIndex = 0
+	if err := StandaloneValidTransactions(txns, 0, true); err != nil {
+		t.Fatal("invalid signature was not skipped:", err)
+	}
+	txns[3].TransactionSignatures[0].CoveredFields.MinerFees = []uint64{0}
+	if err := StandaloneValidTransactions(txns, 0, true); err != ErrWholeTransactionViolation {
+		t.Fatal("expected ErrWholeTransactionViolation, got", err)
+	}
+}
+
+// BenchmarkStandaloneValidSequential benchmarks validating a block's worth of
+// transactions one at a time.
+func BenchmarkStandaloneValidSequential(b *testing.B) {
+	txns := signedTransactionGraph(b, 500)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, t := range txns {
+			if err := t.StandaloneValid(0); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+// BenchmarkStandaloneValidParallel benchmarks validating a block's worth of
+// transactions with StandaloneValidTransactions.
+func BenchmarkStandaloneValidParallel(b *testing.B) {
+	txns := signedTransactionGraph(b, 500)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := StandaloneValidTransactions(txns, 0, false); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkStandaloneValidAssumeValid benchmarks validating a block's worth
+// of transactions beneath an assume-valid block.
+func BenchmarkStandaloneValidAssumeValid(b *testing.B) {
+	txns := signedTransactionGraph(b, 500)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := StandaloneValidTransactions(txns, 0, true); err != nil {
+			b.Fatal(err)
+		}
+	}
+}